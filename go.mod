@@ -1,15 +1,17 @@
 module github.com/rancher/fleet
 
-go 1.14
+go 1.16
 
 replace (
 	github.com/Azure/go-autorest => github.com/Azure/go-autorest v14.0.0+incompatible
 	helm.sh/helm/v3 => github.com/ibuildthecloud/helm/v3 v3.1.0-rc.1.0.20200811161532-4cb1fb31bcea
+	vbom.ml/util => github.com/fvbommel/util v0.0.0-20160121211510-db5cfe13f5cc
 )
 
 require (
 	github.com/cheggaaa/pb v1.0.27
 	github.com/hashicorp/go-getter v1.4.1
+	github.com/klauspost/compress v1.11.1
 	github.com/pkg/errors v0.9.1
 	github.com/rancher/gitjob v0.0.1-rc4
 	github.com/rancher/lasso v0.0.0-20200820172840-0e4cc0ef5cb0
@@ -20,6 +22,7 @@ require (
 	golang.org/x/sync v0.0.0-20200317015054-43a5402ce75a
 	helm.sh/helm/v3 v3.0.0
 	k8s.io/api v0.18.8
+	k8s.io/apiextensions-apiserver v0.18.4
 	k8s.io/apimachinery v0.18.8
 	k8s.io/cli-runtime v0.18.4
 	k8s.io/client-go v0.18.8
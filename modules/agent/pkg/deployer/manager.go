@@ -88,13 +88,20 @@ func (m *Manager) Resources(bd *fleet.BundleDeployment) (*Resources, error) {
 	return resources, nil
 }
 
+func (m *Manager) getManifest(manifestID string, options fleet.BundleDeploymentOptions) (*manifest.Manifest, error) {
+	if options.ContentURL != "" {
+		return manifest.Download(options.ContentURL, manifestID)
+	}
+	return m.lookup.Get(manifestID)
+}
+
 func (m *Manager) Deploy(bd *fleet.BundleDeployment) (string, error) {
 	if bd.Spec.DeploymentID == bd.Status.AppliedDeploymentID {
 		return bd.Status.Release, nil
 	}
 
 	manifestID, _ := kv.Split(bd.Spec.DeploymentID, ":")
-	manifest, err := m.lookup.Get(manifestID)
+	manifest, err := m.getManifest(manifestID, bd.Spec.Options)
 	if err != nil {
 		return "", err
 	}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"path/filepath"
 	"regexp"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 
@@ -15,8 +16,10 @@ import (
 	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
 	"github.com/rancher/fleet/pkg/bundle"
 	"github.com/rancher/wrangler/pkg/yaml"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
 )
 
 var (
@@ -26,12 +29,32 @@ var (
 )
 
 type Options struct {
-	BundleFile     string
-	Compress       bool
-	BundleReader   io.Reader
-	Output         io.Writer
-	ServiceAccount string
-	Labels         map[string]string
+	BundleFile      string
+	Compress        bool
+	Compression     string
+	PreserveOrder   bool
+	BundleReader    io.Reader
+	Output          io.Writer
+	ServiceAccount  string
+	TargetNamespace string
+	DryRun          bool
+	Labels          map[string]string
+	HTTPAuthHeader  string
+	// Plan if true prints whether each bundle would be created, updated or is unchanged instead of saving it
+	Plan bool
+	// PlanRepo if set is the GitRepo the planned changes are recorded in the status of
+	PlanRepo string
+	planned  []string
+	// DisabledOverlays are overlays left out of the bundles
+	DisabledOverlays []string
+	// StrictOverlays makes references to overlays that are not defined an error instead of a warning
+	StrictOverlays bool
+	// Paths if set, existing bundles are only updated if the content under these paths has changed
+	Paths       []string
+	pathsHashes map[string]string
+	// PathsRepo if set is the GitRepo the bundles skipped because of Paths are recorded in the status of
+	PathsRepo string
+	skipped   []string
 }
 
 func Apply(ctx context.Context, client *client.Getter, name string, baseDirs []string, opts *Options) error {
@@ -43,30 +66,62 @@ func Apply(ctx context.Context, client *client.Getter, name string, baseDirs []s
 		baseDirs = []string{"."}
 	}
 
-	foundBundle := false
+	var (
+		dirs      []string
+		separated = map[int]bool{}
+	)
 	for i, baseDir := range baseDirs {
 		matches, err := filepath.Glob(baseDir)
 		if err != nil {
 			return fmt.Errorf("invalid path glob %s: %w", baseDir, err)
 		}
-		for _, baseDir := range matches {
-			if i > 0 && opts.Output != nil {
-				if _, err := opts.Output.Write([]byte("\n---\n")); err != nil {
-					return err
-				}
-			}
-			if err := Dir(ctx, client, name, baseDir, opts); err == ErrNoResources {
-				logrus.Warnf("%s: %v", baseDir, err)
-				continue
-			} else if err != nil {
+		for _, match := range matches {
+			separated[len(dirs)] = i > 0
+			dirs = append(dirs, match)
+		}
+	}
+
+	if len(opts.Paths) > 0 {
+		hashes, err := pathsHashes(opts, dirs)
+		if err != nil {
+			return err
+		}
+		opts.pathsHashes = hashes
+	}
+
+	foundBundle := false
+	for i, baseDir := range dirs {
+		if separated[i] && opts.Output != nil {
+			if _, err := opts.Output.Write([]byte("\n---\n")); err != nil {
 				return err
 			}
-			foundBundle = true
 		}
+		if err := Dir(ctx, client, name, baseDir, opts); err == ErrNoResources {
+			logrus.Warnf("%s: %v", baseDir, err)
+			continue
+		} else if errors.Is(err, bundle.ErrNoBundleFile) {
+			logrus.Warn(err)
+			continue
+		} else if err != nil {
+			return err
+		}
+		foundBundle = true
 	}
 
 	if !foundBundle {
-		return fmt.Errorf("no fleet.yaml or bundle.yaml found at the following paths: %v", baseDirs)
+		return fmt.Errorf("no %s found at the following paths: %v", strings.Join(bundle.DefaultBundleFiles, " or "), baseDirs)
+	}
+
+	if opts.Plan && opts.PlanRepo != "" {
+		return updateRepoStatus(client, opts.PlanRepo, func(status *fleet.GitRepoStatus) {
+			status.Plan = opts.planned
+		})
+	}
+
+	if opts.PathsRepo != "" && !opts.Plan && !opts.DryRun && opts.Output == nil {
+		return updateRepoStatus(client, opts.PathsRepo, func(status *fleet.GitRepoStatus) {
+			status.SkippedBundles = opts.skipped
+		})
 	}
 
 	return nil
@@ -81,9 +136,23 @@ func readBundle(ctx context.Context, baseDir string, opts *Options) (*bundle.Bun
 		return bundle.New(&bundleResource)
 	}
 
-	return bundle.Open(ctx, baseDir, opts.BundleFile, &bundle.Options{
-		Compress: opts.Compress,
+	b, err := bundle.Open(ctx, baseDir, opts.BundleFile, &bundle.Options{
+		Compress:             opts.Compress,
+		CompressionAlgorithm: opts.Compression,
+		PreserveOrder:        opts.PreserveOrder,
+		HTTPAuthHeader:       opts.HTTPAuthHeader,
+		DisabledOverlays:     opts.DisabledOverlays,
+		StrictOverlays:       opts.StrictOverlays,
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, warning := range bundle.Lint(b) {
+		logrus.Warnf("%s: %s", baseDir, warning)
+	}
+
+	return b, nil
 }
 
 func createName(name, baseDir string) string {
@@ -116,6 +185,20 @@ func Dir(ctx context.Context, client *client.Getter, name, baseDir string, opts
 		def.Spec.ServiceAccount = opts.ServiceAccount
 	}
 
+	if hash := opts.pathsHashes[baseDir]; hash != "" {
+		if def.Annotations == nil {
+			def.Annotations = map[string]string{}
+		}
+		def.Annotations[PathsHashAnnotation] = hash
+	}
+
+	if opts.TargetNamespace != "" {
+		if err := checkTargetNamespace(def, opts.TargetNamespace); err != nil {
+			return fmt.Errorf("%s: %w", baseDir, err)
+		}
+		def.Spec.DefaultNamespace = opts.TargetNamespace
+	}
+
 	if len(def.Spec.Targets) == 0 {
 		def.Spec.Targets = []fleet.BundleTarget{
 			{
@@ -134,8 +217,12 @@ func Dir(ctx context.Context, client *client.Getter, name, baseDir string, opts
 		return err
 	}
 
-	if opts.Output == nil {
-		err = save(client, def)
+	if opts.DryRun {
+		fmt.Printf("%s/%s: valid\n", def.Namespace, def.Name)
+	} else if opts.Plan {
+		err = plan(client, def, opts)
+	} else if opts.Output == nil {
+		err = save(client, def, opts)
 	} else {
 		_, err = opts.Output.Write(b)
 	}
@@ -143,12 +230,21 @@ func Dir(ctx context.Context, client *client.Getter, name, baseDir string, opts
 	return err
 }
 
-func save(client *client.Getter, bundle *fleet.Bundle) error {
+func save(client *client.Getter, bundle *fleet.Bundle, opts *Options) error {
 	c, err := client.Get()
 	if err != nil {
 		return err
 	}
 
+	applied, err := specHash(bundle)
+	if err != nil {
+		return err
+	}
+	if bundle.Annotations == nil {
+		bundle.Annotations = map[string]string{}
+	}
+	bundle.Annotations[AppliedSpecHashAnnotation] = applied
+
 	obj, err := c.Fleet.Bundle().Get(bundle.Namespace, bundle.Name, metav1.GetOptions{})
 	if apierrors.IsNotFound(err) {
 		_, err = c.Fleet.Bundle().Create(bundle)
@@ -160,6 +256,14 @@ func save(client *client.Getter, bundle *fleet.Bundle) error {
 		return err
 	}
 
+	if unchanged, err := unchangedPaths(obj, bundle); err != nil {
+		return err
+	} else if unchanged {
+		fmt.Printf("%s/%s: no changes under watched paths, skipped\n", obj.Namespace, obj.Name)
+		opts.skipped = append(opts.skipped, obj.Name)
+		return nil
+	}
+
 	obj.Spec = bundle.Spec
 	obj.Annotations = mergeMap(obj.Annotations, bundle.Annotations)
 	obj.Labels = mergeMap(obj.Labels, bundle.Labels)
@@ -170,6 +274,46 @@ func save(client *client.Getter, bundle *fleet.Bundle) error {
 	return err
 }
 
+// plan prints and records whether saving the bundle would create it, update it or leave it unchanged
+func plan(client *client.Getter, bundle *fleet.Bundle, opts *Options) error {
+	c, err := client.Get()
+	if err != nil {
+		return err
+	}
+
+	change := "unchanged"
+	obj, err := c.Fleet.Bundle().Get(bundle.Namespace, bundle.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		change = "create"
+	} else if err != nil {
+		return err
+	} else if !equality.Semantic.DeepEqual(obj.Spec, bundle.Spec) {
+		change = "update"
+	}
+
+	fmt.Printf("%s/%s: %s\n", bundle.Namespace, bundle.Name, change)
+	opts.planned = append(opts.planned, bundle.Name+": "+change)
+	return nil
+}
+
+// updateRepoStatus applies update to the status of the GitRepo
+func updateRepoStatus(client *client.Getter, name string, update func(status *fleet.GitRepoStatus)) error {
+	c, err := client.Get()
+	if err != nil {
+		return err
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		gitrepo, err := c.Fleet.GitRepo().Get(c.Namespace, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		update(&gitrepo.Status)
+		_, err = c.Fleet.GitRepo().UpdateStatus(gitrepo)
+		return err
+	})
+}
+
 func mergeMap(a, b map[string]string) map[string]string {
 	result := map[string]string{}
 	for k, v := range a {
@@ -0,0 +1,86 @@
+package apply
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/bundle"
+	"github.com/rancher/fleet/pkg/content"
+	"github.com/rancher/wrangler/pkg/yaml"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// checkTargetNamespace ensures nothing in the bundle deploys outside of the given namespace. Only
+// namespaces that can be known at apply time are checked, being the bundle options and plain
+// manifests, which must not contain cluster-scoped resources either. Chart and kustomize output is
+// rendered on the downstream cluster and is not checked: the forced default namespace only applies to
+// resources that don't set one, so a chart or kustomization may still deploy to other namespaces or
+// create cluster-scoped resources, which must be prevented by the RBAC of the service account.
+func checkTargetNamespace(def *fleet.Bundle, namespace string) error {
+	if err := checkOptionsNamespace("bundle", def.Spec.BundleDeploymentOptions, namespace); err != nil {
+		return err
+	}
+	for _, overlay := range def.Spec.Overlays {
+		if err := checkOptionsNamespace("overlay "+overlay.Name, overlay.BundleDeploymentOptions, namespace); err != nil {
+			return err
+		}
+		if err := checkResourcesNamespace(overlay.Resources, namespace); err != nil {
+			return err
+		}
+	}
+	for _, target := range def.Spec.Targets {
+		if err := checkOptionsNamespace("target "+target.Name, target.BundleDeploymentOptions, namespace); err != nil {
+			return err
+		}
+	}
+	return checkResourcesNamespace(def.Spec.Resources, namespace)
+}
+
+func checkOptionsNamespace(source string, opts fleet.BundleDeploymentOptions, namespace string) error {
+	if opts.DefaultNamespace != "" && opts.DefaultNamespace != namespace {
+		return fmt.Errorf("%s namespace %s does not match target namespace %s", source, opts.DefaultNamespace, namespace)
+	}
+	return nil
+}
+
+func checkResourcesNamespace(resources []fleet.BundleResource, namespace string) error {
+	for _, resource := range resources {
+		if !strings.HasPrefix(resource.Name, bundle.ManifestsDir+"/") {
+			continue
+		}
+		switch filepath.Ext(resource.Name) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+
+		data, err := content.Decode(resource.Content, resource.Encoding)
+		if err != nil {
+			return err
+		}
+
+		objs, err := yaml.ToObjects(bytes.NewBuffer(data))
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", resource.Name, err)
+		}
+
+		for _, obj := range objs {
+			m, err := meta.Accessor(obj)
+			if err != nil {
+				return err
+			}
+			if kind := obj.GetObjectKind().GroupVersionKind().Kind; bundle.IsClusterScoped(kind) {
+				return fmt.Errorf("%s: %s is a cluster-scoped %s, which is not allowed with target namespace %s",
+					resource.Name, m.GetName(), kind, namespace)
+			}
+			if m.GetNamespace() != "" && m.GetNamespace() != namespace {
+				return fmt.Errorf("%s: %s namespace %s does not match target namespace %s",
+					resource.Name, m.GetName(), m.GetNamespace(), namespace)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package apply
+
+import (
+	"testing"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+)
+
+func manifestBundle(manifest string) *fleet.Bundle {
+	return &fleet.Bundle{
+		Spec: fleet.BundleSpec{
+			Resources: []fleet.BundleResource{
+				{
+					Name:    "manifests/resources.yaml",
+					Content: manifest,
+				},
+			},
+		},
+	}
+}
+
+func TestCheckTargetNamespace(t *testing.T) {
+	tests := []struct {
+		name   string
+		bundle *fleet.Bundle
+		err    bool
+	}{
+		{
+			name: "namespace matches",
+			bundle: manifestBundle(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: tenant
+`),
+		},
+		{
+			name: "no namespace",
+			bundle: manifestBundle(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+`),
+		},
+		{
+			name: "other namespace",
+			bundle: manifestBundle(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: kube-system
+`),
+			err: true,
+		},
+		{
+			name: "namespace",
+			bundle: manifestBundle(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: other
+`),
+			err: true,
+		},
+		{
+			name: "cluster role binding",
+			bundle: manifestBundle(`apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: admin
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: cluster-admin
+`),
+			err: true,
+		},
+		{
+			name: "custom resource definition",
+			bundle: manifestBundle(`apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: apps.example.com
+`),
+			err: true,
+		},
+		{
+			name: "other default namespace",
+			bundle: &fleet.Bundle{
+				Spec: fleet.BundleSpec{
+					BundleDeploymentOptions: fleet.BundleDeploymentOptions{
+						DefaultNamespace: "other",
+					},
+				},
+			},
+			err: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkTargetNamespace(test.bundle, "tenant")
+			if (err != nil) != test.err {
+				t.Errorf("expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+}
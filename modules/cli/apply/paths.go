@@ -0,0 +1,179 @@
+package apply
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/version"
+)
+
+const (
+	// PathsHashAnnotation is the hash of the content under the watched paths when the bundle was last applied
+	PathsHashAnnotation = "fleet.cattle.io/paths-hash"
+	// AppliedSpecHashAnnotation is the hash of the spec of the bundle when it was last applied, so a bundle
+	// modified in the cluster since is restored even if nothing changed under the watched paths
+	AppliedSpecHashAnnotation = "fleet.cattle.io/applied-spec-hash"
+)
+
+// pathsHashes returns the hash of each of the bundle dirs. The hash of a bundle covers the options it is
+// applied with, its dir, the watched files in its dir and the watched files outside of every bundle dir,
+// as those may be read by any bundle. Watched files are the files under the path globs of the options.
+func pathsHashes(opts *Options, dirs []string) (map[string]string, error) {
+	files, err := watchedFiles(opts.Paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var shared []string
+	for _, file := range files {
+		below := false
+		for _, dir := range dirs {
+			if isBelow(dir, file) {
+				below = true
+				break
+			}
+		}
+		if !below {
+			shared = append(shared, file)
+		}
+	}
+
+	result := map[string]string{}
+	for _, dir := range dirs {
+		var bundleFiles []string
+		for _, file := range files {
+			if isBelow(dir, file) {
+				bundleFiles = append(bundleFiles, file)
+			}
+		}
+
+		hash, err := pathsHash(opts, dir, append(bundleFiles, shared...))
+		if err != nil {
+			return nil, err
+		}
+		result[dir] = hash
+	}
+
+	return result, nil
+}
+
+// pathsHash returns a hash of the options, the bundle dir and the names and content of the files. The
+// options are included so that changing how bundles are applied still updates them.
+func pathsHash(opts *Options, dir string, files []string) (string, error) {
+	h := sha256.New()
+	if err := json.NewEncoder(h).Encode([]interface{}{
+		filepath.ToSlash(dir),
+		opts.BundleFile,
+		opts.ServiceAccount,
+		opts.TargetNamespace,
+		opts.Labels,
+		opts.Compress,
+		opts.Compression,
+		opts.PreserveOrder,
+		opts.HTTPAuthHeader,
+		opts.DisabledOverlays,
+		opts.StrictOverlays,
+		opts.Paths,
+		version.Version,
+	}); err != nil {
+		return "", err
+	}
+
+	sort.Strings(files)
+	for _, file := range files {
+		if err := hashFile(h, file); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// watchedFiles returns the files under the path globs, sorted. Files of .git directories are ignored.
+func watchedFiles(paths []string) ([]string, error) {
+	files := map[string]bool{}
+	for _, path := range paths {
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path glob %s: %w", path, err)
+		}
+		for _, match := range matches {
+			err := filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.IsDir() {
+					if info.Name() == ".git" {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				files[filepath.Clean(path)] = true
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var result []string
+	for file := range files {
+		result = append(result, file)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// isBelow returns true if path is dir or below it
+func isBelow(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func hashFile(w io.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintf(w, "%s\x00", filepath.ToSlash(name)); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// specHash returns a hash of the spec of the bundle
+func specHash(bundle *fleet.Bundle) (string, error) {
+	data, err := json.Marshal(bundle.Spec)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// unchangedPaths returns true if the existing bundle does not need to be updated because nothing changed
+// under the watched paths since it was last applied, and it has not been modified in the cluster since
+func unchangedPaths(existing, bundle *fleet.Bundle) (bool, error) {
+	hash := bundle.Annotations[PathsHashAnnotation]
+	if hash == "" || existing.Annotations[PathsHashAnnotation] != hash {
+		return false, nil
+	}
+
+	applied, err := specHash(existing)
+	if err != nil {
+		return false, err
+	}
+	return existing.Annotations[AppliedSpecHashAnnotation] == applied, nil
+}
@@ -0,0 +1,181 @@
+package apply
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testPathsHashes(t *testing.T, opts *Options, dirs []string) map[string]string {
+	t.Helper()
+	hashes, err := pathsHashes(opts, dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hashes
+}
+
+func TestPathsHashes(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"a/fleet.yaml":         "namespace: a",
+		"a/cm.yaml":            "kind: ConfigMap",
+		"b/fleet.yaml":         "namespace: b",
+		"b/cm.yaml":            "kind: ConfigMap",
+		"shared/values.yaml":   "replicas: 1",
+		"a/.git/objects/value": "ignored",
+	})
+
+	a, b := filepath.Join(root, "a"), filepath.Join(root, "b")
+	dirs := []string{a, b}
+	opts := &Options{Paths: []string{root}}
+	before := testPathsHashes(t, opts, dirs)
+	if before[a] == "" || before[b] == "" || before[a] == before[b] {
+		t.Fatalf("expected a distinct hash for each bundle, got %v", before)
+	}
+
+	writeFiles(t, root, map[string]string{"a/.git/objects/value": "changed"})
+	if after := testPathsHashes(t, opts, dirs); after[a] != before[a] {
+		t.Errorf("expected files of .git directories to be ignored")
+	}
+
+	writeFiles(t, root, map[string]string{"a/cm.yaml": "kind: Secret"})
+	after := testPathsHashes(t, opts, dirs)
+	if after[a] == before[a] {
+		t.Errorf("expected a change in bundle a to change its hash")
+	}
+	if after[b] != before[b] {
+		t.Errorf("expected a change in bundle a not to change the hash of bundle b")
+	}
+
+	before = after
+	writeFiles(t, root, map[string]string{"shared/values.yaml": "replicas: 2"})
+	after = testPathsHashes(t, opts, dirs)
+	if after[a] == before[a] || after[b] == before[b] {
+		t.Errorf("expected a change to a shared file to change the hash of every bundle")
+	}
+
+	before = after
+	after = testPathsHashes(t, &Options{Paths: []string{filepath.Join(root, "b")}}, dirs)
+	if after[a] == before[a] {
+		t.Errorf("expected a bundle without watched files to still be hashed with the options")
+	}
+}
+
+func TestPathsHashOptions(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"fleet.yaml": "namespace: a"})
+
+	hash := func(opts *Options) string {
+		opts.Paths = []string{root}
+		return testPathsHashes(t, opts, []string{root})[root]
+	}
+
+	base := hash(&Options{})
+	tests := []struct {
+		name string
+		opts *Options
+	}{
+		{name: "disabled overlays", opts: &Options{DisabledOverlays: []string{"debug"}}},
+		{name: "http auth header", opts: &Options{HTTPAuthHeader: "Basic dXNlcjpwYXNz"}},
+		{name: "bundle file", opts: &Options{BundleFile: "bundle.yaml"}},
+		{name: "target namespace", opts: &Options{TargetNamespace: "apps"}},
+		{name: "service account", opts: &Options{ServiceAccount: "deployer"}},
+		{name: "labels", opts: &Options{Labels: map[string]string{"env": "prod"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if hash(test.opts) == base {
+				t.Errorf("expected changing the %s to change the hash", test.name)
+			}
+		})
+	}
+}
+
+func TestUnchangedPaths(t *testing.T) {
+	newBundle := func(pathsHash string, spec fleet.BundleSpec) *fleet.Bundle {
+		bundle := &fleet.Bundle{Spec: spec}
+		bundle.Annotations = map[string]string{PathsHashAnnotation: pathsHash}
+		applied, err := specHash(bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle.Annotations[AppliedSpecHashAnnotation] = applied
+		return bundle
+	}
+
+	newSpec := func(namespace string) fleet.BundleSpec {
+		return fleet.BundleSpec{BundleDeploymentOptions: fleet.BundleDeploymentOptions{DefaultNamespace: namespace}}
+	}
+
+	spec := newSpec("apps")
+	modified := newBundle("h1", spec)
+	modified.Spec.DefaultNamespace = "other"
+
+	tests := []struct {
+		name     string
+		existing *fleet.Bundle
+		bundle   *fleet.Bundle
+		expected bool
+	}{
+		{
+			name:     "unchanged",
+			existing: newBundle("h1", spec),
+			bundle:   newBundle("h1", newSpec("new")),
+			expected: true,
+		},
+		{
+			name:     "paths changed",
+			existing: newBundle("h1", spec),
+			bundle:   newBundle("h2", spec),
+		},
+		{
+			name:     "no paths",
+			existing: newBundle("", spec),
+			bundle:   newBundle("", spec),
+		},
+		{
+			name:     "modified in the cluster",
+			existing: modified,
+			bundle:   newBundle("h1", spec),
+		},
+		{
+			name: "applied before the spec was hashed",
+			existing: &fleet.Bundle{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{PathsHashAnnotation: "h1"}},
+				Spec:       spec,
+			},
+			bundle: newBundle("h1", spec),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			unchanged, err := unchangedPaths(test.existing, test.bundle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if unchanged != test.expected {
+				t.Errorf("expected unchanged %v, got %v", test.expected, unchanged)
+			}
+		})
+	}
+}
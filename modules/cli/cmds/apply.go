@@ -1,6 +1,7 @@
 package cmds
 
 import (
+	"encoding/base64"
 	"fmt"
 	"os"
 
@@ -20,20 +21,47 @@ func NewApply() *cobra.Command {
 type Apply struct {
 	BundleInputArgs
 	OutputArgsNoDefault
-	Label          map[string]string `usage:"Labels to apply to created bundles" short:"l"`
-	File           string            `usage:"Read full bundle contents from file" short:"f"`
-	Compress       bool              `usage:"Force all resources to be compress" short:"c"`
-	ServiceAccount string            `usage:"Service account to assign to bundle created" short:"a"`
+	Label           map[string]string `usage:"Labels to apply to created bundles" short:"l"`
+	File            string            `usage:"Read full bundle contents from file" short:"f"`
+	Compress        bool              `usage:"Force all resources to be compress" short:"c"`
+	Compression     string            `usage:"Algorithm used to compress resources, gzip or zstd, defaults to gzip"`
+	PreserveOrder   bool              `usage:"Order resources by their numeric filename prefix (01-, 02-) instead of lexically"`
+	ServiceAccount  string            `usage:"Service account to assign to bundle created" short:"a"`
+	TargetNamespace string            `usage:"Ensure all resources of the bundle are deployed to this namespace"`
+	DryRun          bool              `usage:"Validate the bundles without applying them"`
+	Plan            bool              `usage:"Print the changes to bundles without applying them"`
+	PlanRepo        string            `usage:"GitRepo the planned changes are recorded in the status of, implies --plan"`
+	Paths           []string          `usage:"Only update existing bundles if files under these paths changed"`
+	PathsRepo       string            `usage:"GitRepo the bundles skipped by --paths are recorded in the status of"`
+	DisableOverlay  []string          `usage:"Overlays to leave out of the bundles, references to them are ignored"`
+	StrictOverlays  bool              `usage:"Fail if a target or overlay references an overlay that is not defined in the bundle or found on disk"`
+	HelmUsername    string            `usage:"Username to authenticate to Helm repositories and http(s) URLs" env:"HELM_USERNAME"`
+	HelmPassword    string            `usage:"Password to authenticate to Helm repositories and http(s) URLs" env:"HELM_PASSWORD"`
 }
 
 func (a *Apply) Run(cmd *cobra.Command, args []string) error {
 	name := ""
 	opts := &apply.Options{
-		BundleFile:     a.BundleFile,
-		Output:         writer.NewDefaultNone(a.Output),
-		Compress:       a.Compress,
-		ServiceAccount: a.ServiceAccount,
-		Labels:         a.Label,
+		BundleFile:       a.BundleFile,
+		Output:           writer.NewDefaultNone(a.Output),
+		Compress:         a.Compress,
+		Compression:      a.Compression,
+		PreserveOrder:    a.PreserveOrder,
+		ServiceAccount:   a.ServiceAccount,
+		TargetNamespace:  a.TargetNamespace,
+		DryRun:           a.DryRun,
+		Plan:             a.Plan || a.PlanRepo != "",
+		PlanRepo:         a.PlanRepo,
+		Paths:            a.Paths,
+		PathsRepo:        a.PathsRepo,
+		DisabledOverlays: a.DisableOverlay,
+		StrictOverlays:   a.StrictOverlays,
+		Labels:           a.Label,
+	}
+
+	if a.HelmUsername != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(a.HelmUsername + ":" + a.HelmPassword))
+		opts.HTTPAuthHeader = "Basic " + auth
 	}
 
 	if a.File == "-" {
@@ -19,6 +19,7 @@ func NewTest() *cobra.Command {
 type Test struct {
 	BundleInputArgs
 	Quiet       bool              `usage:"Just print the match and don't print the resources" short:"q"`
+	Name        string            `usage:"Cluster name to match against" short:"c"`
 	Group       string            `usage:"Cluster group to match against" short:"g"`
 	Label       map[string]string `usage:"Cluster labels to match against" short:"l"`
 	GroupLabel  map[string]string `usage:"Cluster group labels to match against" short:"L"`
@@ -43,6 +44,7 @@ func (m *Test) Run(cmd *cobra.Command, args []string) error {
 		Output:             os.Stdout,
 		BaseDir:            baseDir,
 		BundleFile:         m.BundleFile,
+		ClusterName:        m.Name,
 		ClusterGroup:       m.Group,
 		ClusterLabels:      m.Label,
 		ClusterGroupLabels: m.GroupLabel,
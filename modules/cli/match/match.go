@@ -17,6 +17,7 @@ type Options struct {
 	Output             io.Writer
 	BaseDir            string
 	BundleFile         string
+	ClusterName        string
 	ClusterGroup       string
 	ClusterLabels      map[string]string
 	ClusterGroupLabels map[string]string
@@ -34,7 +35,7 @@ func Match(ctx context.Context, opts *Options) error {
 	}
 
 	if opts.Target == "" {
-		m := bundle.Match(map[string]map[string]string{
+		m := bundle.Match(opts.ClusterName, map[string]map[string]string{
 			opts.ClusterGroup: opts.ClusterGroupLabels,
 		}, opts.ClusterLabels)
 		return printMatch(m, opts.Output)
@@ -19,6 +19,8 @@ var (
 	OutOfSync  BundleState = "OutOfSync"
 	Pending    BundleState = "Pending"
 	Modified   BundleState = "Modified"
+	// Updating is a target whose current deployment has been released to the cluster but not yet applied
+	Updating BundleState = "Updating"
 )
 
 type BundleState string
@@ -37,24 +39,87 @@ type Bundle struct {
 type BundleSpec struct {
 	BundleDeploymentOptions
 
-	Paused          bool             `json:"paused,omitempty"`
+	Paused bool `json:"paused,omitempty"`
+	// PausedUntil pauses the bundle until the given time, after which the rollout resumes
+	PausedUntil     *metav1.Time     `json:"pausedUntil,omitempty"`
 	RolloutStrategy *RolloutStrategy `json:"rolloutStrategy,omitempty"`
 	Resources       []BundleResource `json:"resources,omitempty"`
 	Overlays        []BundleOverlay  `json:"overlays,omitempty"`
 	Targets         []BundleTarget   `json:"targets,omitempty"`
+	// DependsOn is the names of bundles in the same namespace that must be ready on a cluster before
+	// this bundle is deployed to it
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// AutoAdopt deploys the bundle to clusters that start matching its targets, defaults to true. If false
+	// the bundle is only deployed to the clusters that matched when the bundle last changed.
+	AutoAdopt *bool `json:"autoAdopt,omitempty"`
 }
 
 type BundleResource struct {
 	Name     string `json:"name,omitempty"`
 	Content  string `json:"content,omitempty"`
 	Encoding string `json:"encoding,omitempty"`
+	// Mode is the permission bits of the file the resource was read from, only set for executable files
+	Mode int32 `json:"mode,omitempty"`
 }
 
 type RolloutStrategy struct {
-	MaxUnavailable           *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	// MinMaxUnavailable and MaxMaxUnavailable clamp the number of targets computed from MaxUnavailable,
+	// for example 10% but at least 2 and at most 20. Zero is unset.
+	MinMaxUnavailable        int                 `json:"minMaxUnavailable,omitempty"`
+	MaxMaxUnavailable        int                 `json:"maxMaxUnavailable,omitempty"`
 	MaxUnavailablePartitions *intstr.IntOrString `json:"maxUnavailablePartitions,omitempty"`
-	AutoPartitionSize        *intstr.IntOrString `json:"autoPartitionSize,omitempty"`
-	Partitions               []Partition         `json:"partitions,omitempty"`
+	// MaxUnavailablePerGroup is the max unavailable targets of each cluster group as a count or percentage
+	// of the targets in the group. MaxUnavailable still applies to all targets.
+	MaxUnavailablePerGroup *intstr.IntOrString `json:"maxUnavailablePerGroup,omitempty"`
+	// AutoPartitionSize is the size of each automatically created partition as a count or percentage of
+	// all targets, defaults to 25%. A size of 0 puts all targets in a single partition.
+	AutoPartitionSize *intstr.IntOrString `json:"autoPartitionSize,omitempty"`
+	// AutoPartitionThreshold is the number of targets up to which all targets are put in a single
+	// partition, so small fleets are not split. Zero is unset.
+	AutoPartitionThreshold int `json:"autoPartitionThreshold,omitempty"`
+	// MaxAutoPartitionSize is the max number of targets in each automatically created partition, so
+	// partitions of large fleets stay small. Zero is unset.
+	MaxAutoPartitionSize int         `json:"maxAutoPartitionSize,omitempty"`
+	Partitions           []Partition `json:"partitions,omitempty"`
+	// PartitionOrder is the names of partitions to roll out first, in order. Partitions not listed are
+	// rolled out after them in their usual order.
+	PartitionOrder []string `json:"partitionOrder,omitempty"`
+	// PartitionSoakSeconds is how long to wait after a partition becomes up to date and available before
+	// the next partition is rolled out, so failures that take a while to show stop the rollout
+	PartitionSoakSeconds int `json:"partitionSoakSeconds,omitempty"`
+	// Steps is the cumulative size of each rollout batch as a count or percentage of all targets,
+	// for example ["1", "25%", "100%"]. A batch is rolled out once the previous one is available.
+	// Steps are ignored if Partitions are defined.
+	Steps []intstr.IntOrString `json:"steps,omitempty"`
+	// PartitionByLabel creates a partition for each value of a cluster label. Ignored if Partitions are defined.
+	PartitionByLabel *LabelPartitioning `json:"partitionByLabel,omitempty"`
+	// SkipOfflineClusters excludes clusters whose agent has not checked in for 15 minutes from the
+	// unavailable counts, so offline clusters do not block the rollout to the rest
+	SkipOfflineClusters bool `json:"skipOfflineClusters,omitempty"`
+	// Windows are the maintenance windows during which targets may be updated. If empty targets may
+	// be updated at any time.
+	Windows []MaintenanceWindow `json:"windows,omitempty"`
+	// ContinueOnRenderError if true a cluster whose target fails to render is reported as ErrApplied and
+	// keeps its current deployment, instead of the error stopping the rollout to all clusters
+	ContinueOnRenderError bool `json:"continueOnRenderError,omitempty"`
+}
+
+type MaintenanceWindow struct {
+	// Days the window applies to, such as ["Sat", "Sun"]. Applies to every day if empty.
+	Days []string `json:"days,omitempty"`
+	// Start is the UTC time of day the window opens, such as "02:00"
+	Start string `json:"start,omitempty"`
+	// End is the UTC time of day the window closes. If before Start the window ends on the next day.
+	End string `json:"end,omitempty"`
+}
+
+type LabelPartitioning struct {
+	// Key is the cluster label key to partition by
+	Key string `json:"key,omitempty"`
+	// Order is the order label values are rolled out in. Values not listed are rolled out after in
+	// alphabetical order, followed by clusters that do not have the label.
+	Order []string `json:"order,omitempty"`
 }
 
 type Partition struct {
@@ -75,23 +140,75 @@ type BundleOverlay struct {
 
 type BundleTarget struct {
 	BundleDeploymentOptions
-	Name                 string                `json:"name,omitempty"`
-	ClusterSelector      *metav1.LabelSelector `json:"clusterSelector,omitempty"`
-	ClusterGroup         string                `json:"clusterGroup,omitempty"`
+	Name string `json:"name,omitempty"`
+	// ClusterSelector matches the labels of the cluster
+	ClusterSelector *metav1.LabelSelector `json:"clusterSelector,omitempty"`
+	// ClusterGroup matches clusters that are a member of the cluster group of this name
+	ClusterGroup string `json:"clusterGroup,omitempty"`
+	// ClusterGroupSelector matches clusters that are a member of a cluster group with matching labels.
+	// ClusterSelector, ClusterGroup and ClusterGroupSelector are combined, the cluster must match all that
+	// are set, evaluated for each cluster group of the cluster.
 	ClusterGroupSelector *metav1.LabelSelector `json:"clusterGroupSelector,omitempty"`
 	Overlays             []string              `json:"overlays,omitempty"`
+	// All matches every cluster regardless of the other criteria of the target
+	All bool `json:"all,omitempty"`
+	// Priority is used to choose a target when more than one matches a cluster, the highest wins
+	Priority int `json:"priority,omitempty"`
+	// ClusterGroups is a list of cluster groups the cluster must be a member of. The cluster must also
+	// match all other criteria of the target.
+	ClusterGroups []string `json:"clusterGroups,omitempty"`
+	// MinClusterGroups is how many of ClusterGroups the cluster must be a member of, defaults to all of them
+	MinClusterGroups int `json:"minClusterGroups,omitempty"`
+	// ClusterNameRegex is a regular expression the cluster name must match, such as ^prod-.*. The cluster
+	// must also match all other criteria of the target.
+	ClusterNameRegex string `json:"clusterNameRegex,omitempty"`
+	// ClusterConditions are the status conditions a matched cluster must have before the target is deployed
+	// to or updated on it. A cluster without the condition does not satisfy it.
+	ClusterConditions []ClusterCondition `json:"clusterConditions,omitempty"`
+	// ReadyTimeoutSeconds is how long the deployment of a matched cluster may be not ready before the
+	// target is reported as failed instead of waiting to become ready, defaults to no timeout
+	ReadyTimeoutSeconds int `json:"readyTimeoutSeconds,omitempty"`
+	// ClusterLabels are the cluster labels that values may reference as $(cluster.label:<key>). Each
+	// distinct combination of their values results in a separate deployment, so only labels with a
+	// small number of values, such as region, should be listed.
+	ClusterLabels []string `json:"clusterLabels,omitempty"`
+	// ClusterLabelValues maps dotted Helm value paths, such as global.region, to the cluster label whose
+	// value they are set to. The labels must be listed in ClusterLabels.
+	ClusterLabelValues map[string]string `json:"clusterLabelValues,omitempty"`
+}
+
+// ClusterCondition is a status condition of a cluster required by a target
+type ClusterCondition struct {
+	// Type is the type of the condition, such as Ready
+	Type string `json:"type,omitempty"`
+	// Status is the status the condition must have, True, False or Unknown. Defaults to True.
+	Status string `json:"status,omitempty"`
 }
 
 type BundleSummary struct {
-	NotReady          int                `json:"notReady,omitempty"`
-	NotApplied        int                `json:"notApplied,omitempty"`
-	ErrApplied        int                `json:"errApplied,omitempty"`
-	OutOfSync         int                `json:"outOfSync,omitempty"`
-	Modified          int                `json:"modified,omitempty"`
-	Ready             int                `json:"ready"`
-	Pending           int                `json:"pending,omitempty"`
-	DesiredReady      int                `json:"desiredReady"`
-	NonReadyResources []NonReadyResource `json:"nonReadyResources,omitempty"`
+	NotReady     int `json:"notReady,omitempty"`
+	NotApplied   int `json:"notApplied,omitempty"`
+	ErrApplied   int `json:"errApplied,omitempty"`
+	OutOfSync    int `json:"outOfSync,omitempty"`
+	Modified     int `json:"modified,omitempty"`
+	Ready        int `json:"ready"`
+	Pending      int `json:"pending,omitempty"`
+	Updating     int `json:"updating,omitempty"`
+	DesiredReady int `json:"desiredReady"`
+	// Offline is the number of targets on offline clusters that were excluded from unavailable accounting
+	Offline int `json:"offline,omitempty"`
+	// WaitingToSchedule is the number of Pending targets whose deployment has not been created yet, to tell
+	// targets that have not started from deployments that started but are not progressing
+	WaitingToSchedule int `json:"waitingToSchedule,omitempty"`
+	// ReadyPercent is the percentage of desired ready that are ready, 100 if nothing is desired
+	ReadyPercent int `json:"readyPercent"`
+	// ModifiedResources and NotReadyResources are the number of resources reported modified and not ready
+	// by the deployments, showing the progress of deployments that are only partially ready
+	ModifiedResources int `json:"modifiedResources,omitempty"`
+	NotReadyResources int `json:"notReadyResources,omitempty"`
+	// LastPartitionCompleted is the most recent time a rollout partition became fully up to date and available
+	LastPartitionCompleted *metav1.Time       `json:"lastPartitionCompleted,omitempty"`
+	NonReadyResources      []NonReadyResource `json:"nonReadyResources,omitempty"`
 }
 
 type NonReadyResource struct {
@@ -117,6 +234,16 @@ type BundleStatus struct {
 	MaxUnavailablePartitions int               `json:"maxUnavailablePartitions,omitempty"`
 	MaxNew                   int               `json:"maxNew,omitempty"`
 	PartitionStatus          []PartitionStatus `json:"partitions,omitempty"`
+	// ResourceCount is the number of resources in the bundle including overlays
+	ResourceCount int `json:"resourceCount,omitempty"`
+	// ResourcesSize is the total size in bytes of the resources once decoded
+	ResourcesSize int `json:"resourcesSize,omitempty"`
+	// ResourcesStoredSize is the total size in bytes of the resources as stored, after any compression
+	ResourcesStoredSize int `json:"resourcesStoredSize,omitempty"`
+	// AdoptedClusters are the names of the clusters the bundle is deployed to if AutoAdopt is false,
+	// recorded when the bundle was at AdoptedGeneration
+	AdoptedClusters   []string `json:"adoptedClusters,omitempty"`
+	AdoptedGeneration int64    `json:"adoptedGeneration,omitempty"`
 }
 
 type PartitionStatus struct {
@@ -125,6 +252,10 @@ type PartitionStatus struct {
 	MaxUnavailable int           `json:"maxUnavailable,omitempty"`
 	Unavailable    int           `json:"unavailable,omitempty"`
 	Summary        BundleSummary `json:"summary,omitempty"`
+	// LastCompleted is when all targets of the partition last became up to date and available
+	LastCompleted *metav1.Time `json:"lastCompleted,omitempty"`
+	// SoakingUntil is when the next partition is rolled out if the partition is soaking
+	SoakingUntil *metav1.Time `json:"soakingUntil,omitempty"`
 }
 
 // +genclient
@@ -139,12 +270,46 @@ type BundleDeployment struct {
 }
 
 type BundleDeploymentOptions struct {
+	// DefaultNamespace is the namespace of resources that do not specify one. Values set on a target take
+	// precedence over overlays, which take precedence over the bundle. "-" clears a value set at a lower level.
 	DefaultNamespace string      `json:"namespace,omitempty"`
 	KustomizeDir     string      `json:"kustomizeDir,omitempty"`
 	TimeoutSeconds   int         `json:"timeoutSeconds,omitempty"`
 	Values           *GenericMap `json:"values,omitempty"`
-	ServiceAccount   string      `json:"serviceAccount,omitempty"`
-	Force            bool        `json:"force,omitempty"`
+	// ServiceAccount is the service account in the downstream cluster the resources are deployed with. It
+	// has the same precedence as DefaultNamespace. The service account of a GitRepo replaces the one set on
+	// the bundle, but targets and overlays of the bundle can still override it.
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+	Force          bool   `json:"force,omitempty"`
+	// KeepResources if true the deployed resources are not deleted when the bundle is removed from the cluster
+	KeepResources bool `json:"keepResources,omitempty"`
+	// NoPruneResources are resources that are never deleted, when they are removed from the bundle or the
+	// bundle is removed from the cluster, such as PersistentVolumeClaims holding data. Each entry is a kind,
+	// such as PersistentVolumeClaim, or a kind and name, such as PersistentVolumeClaim/data.
+	NoPruneResources []string `json:"noPruneResources,omitempty"`
+	// RequiredConditions are condition types that must be True on the bundle deployment, in addition to
+	// it being ready, for the target to be considered available during a rollout
+	RequiredConditions []string `json:"requiredConditions,omitempty"`
+	// ContentURL if set is the http(s) URL agents download the gzipped manifest of the deployment from,
+	// instead of the content being stored in the cluster. The downloaded manifest must match the deployment ID.
+	ContentURL string `json:"contentURL,omitempty"`
+	// Deletions are previously deployed resources that are deleted when the deployment is applied, such as
+	// resources kept by NoPruneResources or created outside of the bundle. Resources that do not exist are
+	// ignored.
+	Deletions []ResourceDeletion `json:"deletions,omitempty"`
+	// ApplyConcurrency is a hint of how many resources the agent may apply in parallel, for bundles with many
+	// independent resources. Agents that cannot apply in parallel ignore it. Has the same precedence as
+	// TimeoutSeconds, 0 uses the default of the agent.
+	ApplyConcurrency int `json:"applyConcurrency,omitempty"`
+}
+
+// ResourceDeletion identifies a resource to delete. Namespace defaults to the default namespace of the
+// deployment and is ignored for cluster scoped kinds.
+type ResourceDeletion struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
 }
 
 type BundleDeploymentSpec struct {
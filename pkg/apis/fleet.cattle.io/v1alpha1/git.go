@@ -30,16 +30,99 @@ type GitRepoSpec struct {
 	// It is expected the secret be of type "kubernetes.io/basic-auth" or "kubernetes.io/ssh-auth".
 	ClientSecretName string `json:"clientSecretName,omitempty"`
 
+	// HelmSecretName is the secret used to authenticate to Helm repositories and http(s) URLs referenced by
+	// bundles. It is kept separate from the client secret used to clone the repo.
+	// It is expected the secret be of type "kubernetes.io/basic-auth".
+	HelmSecretName string `json:"helmSecretName,omitempty"`
+
 	// BundleDirs is the directories relative to the git repo root that contain bundles to be applied.
 	// Path globbing is support, for example ["bundles/*"] will match all folders as a subdirectory of bundles/
 	// If empty, "/" is the default
 	BundleDirs []string `json:"bundleDirs,omitempty"`
 
+	// Paths is the paths relative to the git repo root that trigger a redeploy when changed. Path globbing is
+	// supported. A new commit that doesn't change any file under these paths does not update the bundles.
+	// If empty, BundleDirs is used.
+	Paths []string `json:"paths,omitempty"`
+
 	// ServiceAccount used in the downstream cluster for deployment
 	ServiceAccount string `json:"serviceAccount,omitempty"`
+
+	// TargetNamespace if set all bundles of this repo must deploy only to this namespace. Bundles
+	// that specify any other namespace, or contain cluster-scoped manifests, will fail to apply and the
+	// failure is reported in the status conditions. The output of charts and kustomize is rendered on
+	// the downstream cluster and is not checked, only the default namespace is forced for it.
+	TargetNamespace string `json:"targetNamespace,omitempty"`
+
+	// DryRun if true the bundles of the repo are only validated and never deployed
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Plan if true the bundles of the repo are compared to the deployed bundles and the planned changes
+	// are recorded in the status instead of being applied
+	Plan bool `json:"plan,omitempty"`
+
+	// Provider is how the git job is notified of changes to the repo, polling, github, gitlab or webhook.
+	// Providers other than polling sync the repo when a push is received by the webhook receiver. They
+	// require the webhook receiver URL to be configured and fall back to polling otherwise. Defaults to polling.
+	Provider string `json:"provider,omitempty"`
+
+	// WebhookSecretName is the secret in the namespace of the GitRepo whose "token" key is used to verify the
+	// signature of webhook requests. If not set the token generated by gitjob when the webhook is created is used.
+	WebhookSecretName string `json:"webhookSecretName,omitempty"`
+
+	// WebhookFallbackSeconds is how long a repo synced by webhook waits for a webhook to be received before
+	// falling back to polling. Defaults to 3600, a negative value never falls back.
+	WebhookFallbackSeconds int `json:"webhookFallbackSeconds,omitempty"`
+
+	// WorkingDir is the absolute path fleet apply is run from in the git job, for gitjob images that check
+	// out the repo elsewhere. BundleDirs and Paths are relative to it. Defaults to /workspace/source.
+	WorkingDir string `json:"workingDir,omitempty"`
+
+	// Paused if true the git job of the repo is not created or updated, an existing git job is left as is
+	Paused bool `json:"paused,omitempty"`
+
+	// DeployCommitOnly if true the branch is resolved to its current commit and the git job is pinned to
+	// that commit, so new commits on the branch are not deployed. The branch is resolved again when the
+	// spec of the GitRepo changes. Ignored if Revision is set.
+	DeployCommitOnly bool `json:"deployCommitOnly,omitempty"`
+
+	// ImagePullSecrets are the names of the secrets in the namespace of the GitRepo used to pull the image
+	// of the git job. Defaults to the gitJobImagePullSecrets of the fleet config.
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+
+	// JobActiveDeadlineSeconds is how long the git job may run before it is terminated, unlimited if not set
+	JobActiveDeadlineSeconds *int64 `json:"jobActiveDeadlineSeconds,omitempty"`
+
+	// JobRestartPolicy is the restart policy of the pod of the git job, Never or OnFailure. Defaults to Never.
+	JobRestartPolicy string `json:"jobRestartPolicy,omitempty"`
+
+	// JobMetadata is additional labels and annotations added to the resources created to sync this repo
+	JobMetadata GitJobMetadata `json:"jobMetadata,omitempty"`
+}
+
+type GitJobMetadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
 type GitRepoStatus struct {
-	Commit     string                              `json:"commit,omitempty"`
+	// ObservedGeneration is the generation of the GitRepo last applied to the git job
+	ObservedGeneration int64  `json:"observedGeneration"`
+	Commit             string `json:"commit,omitempty"`
+	// PinnedCommit is the commit the git job is pinned to if DeployCommitOnly is set
+	PinnedCommit string `json:"pinnedCommit,omitempty"`
+	// PinnedGeneration is the generation of the GitRepo the commit was pinned for
+	PinnedGeneration int64 `json:"pinnedGeneration,omitempty"`
+	// WebhookSince is when the repo started waiting for a webhook, it falls back to polling if none is
+	// received within WebhookFallbackSeconds
+	WebhookSince *metav1.Time `json:"webhookSince,omitempty"`
+	// Plan is each bundle of the repo and the change applying it would make, such as "name: update", if
+	// Plan is set
+	Plan []string `json:"plan,omitempty"`
+	// SkippedBundles are the bundles that were not updated for the last commit because no files under
+	// Paths changed
+	SkippedBundles []string `json:"skippedBundles,omitempty"`
+	// Bundles are the names of the bundles created from the repo
+	Bundles    []string                            `json:"bundles,omitempty"`
 	Conditions []genericcondition.GenericCondition `json:"conditions,omitempty"`
 }
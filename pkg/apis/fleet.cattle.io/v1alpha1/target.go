@@ -14,6 +14,11 @@ var (
 	TTLSecondsAnnotation            = "fleet.cattle.io/ttl-seconds"
 	ManagedAnnotation               = "fleet.cattle.io/managed"
 	AnnotationGroup                 = "fleet.cattle.io/"
+	// DeploymentAnnotationGroup is the prefix of bundle annotations that are copied to its bundle deployments
+	DeploymentAnnotationGroup = "deployment.fleet.cattle.io/"
+	// MaintenanceWindowLabel on a cluster restricts rollouts to the cluster to a daily UTC window, for
+	// example "0200-0600"
+	MaintenanceWindowLabel = "fleet.cattle.io/maintenance-window"
 
 	BootstrapToken = "fleet.cattle.io/bootstrap-token"
 )
@@ -150,6 +150,21 @@ func (in *BundleDeploymentOptions) DeepCopyInto(out *BundleDeploymentOptions) {
 		in, out := &in.Values, &out.Values
 		*out = (*in).DeepCopy()
 	}
+	if in.NoPruneResources != nil {
+		in, out := &in.NoPruneResources, &out.NoPruneResources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RequiredConditions != nil {
+		in, out := &in.RequiredConditions, &out.RequiredConditions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Deletions != nil {
+		in, out := &in.Deletions, &out.Deletions
+		*out = make([]ResourceDeletion, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -294,6 +309,10 @@ func (in *BundleResource) DeepCopy() *BundleResource {
 func (in *BundleSpec) DeepCopyInto(out *BundleSpec) {
 	*out = *in
 	in.BundleDeploymentOptions.DeepCopyInto(&out.BundleDeploymentOptions)
+	if in.PausedUntil != nil {
+		in, out := &in.PausedUntil, &out.PausedUntil
+		*out = (*in).DeepCopy()
+	}
 	if in.RolloutStrategy != nil {
 		in, out := &in.RolloutStrategy, &out.RolloutStrategy
 		*out = new(RolloutStrategy)
@@ -318,6 +337,16 @@ func (in *BundleSpec) DeepCopyInto(out *BundleSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.DependsOn != nil {
+		in, out := &in.DependsOn, &out.DependsOn
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AutoAdopt != nil {
+		in, out := &in.AutoAdopt, &out.AutoAdopt
+		*out = new(bool)
+		**out = **in
+	}
 	return
 }
 
@@ -347,6 +376,11 @@ func (in *BundleStatus) DeepCopyInto(out *BundleStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.AdoptedClusters != nil {
+		in, out := &in.AdoptedClusters, &out.AdoptedClusters
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -363,6 +397,10 @@ func (in *BundleStatus) DeepCopy() *BundleStatus {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BundleSummary) DeepCopyInto(out *BundleSummary) {
 	*out = *in
+	if in.LastPartitionCompleted != nil {
+		in, out := &in.LastPartitionCompleted, &out.LastPartitionCompleted
+		*out = (*in).DeepCopy()
+	}
 	if in.NonReadyResources != nil {
 		in, out := &in.NonReadyResources, &out.NonReadyResources
 		*out = make([]NonReadyResource, len(*in))
@@ -400,6 +438,28 @@ func (in *BundleTarget) DeepCopyInto(out *BundleTarget) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.ClusterGroups != nil {
+		in, out := &in.ClusterGroups, &out.ClusterGroups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ClusterConditions != nil {
+		in, out := &in.ClusterConditions, &out.ClusterConditions
+		*out = make([]ClusterCondition, len(*in))
+		copy(*out, *in)
+	}
+	if in.ClusterLabels != nil {
+		in, out := &in.ClusterLabels, &out.ClusterLabels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ClusterLabelValues != nil {
+		in, out := &in.ClusterLabelValues, &out.ClusterLabelValues
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	return
 }
 
@@ -441,6 +501,22 @@ func (in *Cluster) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterCondition) DeepCopyInto(out *ClusterCondition) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterCondition.
+func (in *ClusterCondition) DeepCopy() *ClusterCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClusterDisplay) DeepCopyInto(out *ClusterDisplay) {
 	*out = *in
@@ -912,6 +988,36 @@ func (in *GenericMap) DeepCopy() *GenericMap {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GitJobMetadata) DeepCopyInto(out *GitJobMetadata) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitJobMetadata.
+func (in *GitJobMetadata) DeepCopy() *GitJobMetadata {
+	if in == nil {
+		return nil
+	}
+	out := new(GitJobMetadata)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GitRepo) DeepCopyInto(out *GitRepo) {
 	*out = *in
@@ -981,6 +1087,22 @@ func (in *GitRepoSpec) DeepCopyInto(out *GitRepoSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Paths != nil {
+		in, out := &in.Paths, &out.Paths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.JobActiveDeadlineSeconds != nil {
+		in, out := &in.JobActiveDeadlineSeconds, &out.JobActiveDeadlineSeconds
+		*out = new(int64)
+		**out = **in
+	}
+	in.JobMetadata.DeepCopyInto(&out.JobMetadata)
 	return
 }
 
@@ -997,6 +1119,25 @@ func (in *GitRepoSpec) DeepCopy() *GitRepoSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GitRepoStatus) DeepCopyInto(out *GitRepoStatus) {
 	*out = *in
+	if in.WebhookSince != nil {
+		in, out := &in.WebhookSince, &out.WebhookSince
+		*out = (*in).DeepCopy()
+	}
+	if in.Plan != nil {
+		in, out := &in.Plan, &out.Plan
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SkippedBundles != nil {
+		in, out := &in.SkippedBundles, &out.SkippedBundles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Bundles != nil {
+		in, out := &in.Bundles, &out.Bundles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make([]genericcondition.GenericCondition, len(*in))
@@ -1015,6 +1156,48 @@ func (in *GitRepoStatus) DeepCopy() *GitRepoStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LabelPartitioning) DeepCopyInto(out *LabelPartitioning) {
+	*out = *in
+	if in.Order != nil {
+		in, out := &in.Order, &out.Order
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LabelPartitioning.
+func (in *LabelPartitioning) DeepCopy() *LabelPartitioning {
+	if in == nil {
+		return nil
+	}
+	out := new(LabelPartitioning)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MaintenanceWindow) DeepCopyInto(out *MaintenanceWindow) {
+	*out = *in
+	if in.Days != nil {
+		in, out := &in.Days, &out.Days
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MaintenanceWindow.
+func (in *MaintenanceWindow) DeepCopy() *MaintenanceWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(MaintenanceWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ModifiedStatus) DeepCopyInto(out *ModifiedStatus) {
 	*out = *in
@@ -1099,6 +1282,14 @@ func (in *Partition) DeepCopy() *Partition {
 func (in *PartitionStatus) DeepCopyInto(out *PartitionStatus) {
 	*out = *in
 	in.Summary.DeepCopyInto(&out.Summary)
+	if in.LastCompleted != nil {
+		in, out := &in.LastCompleted, &out.LastCompleted
+		*out = (*in).DeepCopy()
+	}
+	if in.SoakingUntil != nil {
+		in, out := &in.SoakingUntil, &out.SoakingUntil
+		*out = (*in).DeepCopy()
+	}
 	return
 }
 
@@ -1112,6 +1303,22 @@ func (in *PartitionStatus) DeepCopy() *PartitionStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourceDeletion) DeepCopyInto(out *ResourceDeletion) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceDeletion.
+func (in *ResourceDeletion) DeepCopy() *ResourceDeletion {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceDeletion)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RolloutStrategy) DeepCopyInto(out *RolloutStrategy) {
 	*out = *in
@@ -1125,6 +1332,11 @@ func (in *RolloutStrategy) DeepCopyInto(out *RolloutStrategy) {
 		*out = new(intstr.IntOrString)
 		**out = **in
 	}
+	if in.MaxUnavailablePerGroup != nil {
+		in, out := &in.MaxUnavailablePerGroup, &out.MaxUnavailablePerGroup
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
 	if in.AutoPartitionSize != nil {
 		in, out := &in.AutoPartitionSize, &out.AutoPartitionSize
 		*out = new(intstr.IntOrString)
@@ -1137,6 +1349,28 @@ func (in *RolloutStrategy) DeepCopyInto(out *RolloutStrategy) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.PartitionOrder != nil {
+		in, out := &in.PartitionOrder, &out.PartitionOrder
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = make([]intstr.IntOrString, len(*in))
+		copy(*out, *in)
+	}
+	if in.PartitionByLabel != nil {
+		in, out := &in.PartitionByLabel, &out.PartitionByLabel
+		*out = new(LabelPartitioning)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Windows != nil {
+		in, out := &in.Windows, &out.Windows
+		*out = make([]MaintenanceWindow, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -1,12 +1,17 @@
 package bundle
 
 import (
+	"github.com/pkg/errors"
 	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/content"
 )
 
 type Bundle struct {
 	Definition *fleet.Bundle
 	matcher    *matcher
+	// undefinedOverlays are the referenced overlays that were neither defined nor found on disk when the
+	// bundle was read, they are empty in the definition
+	undefinedOverlays []string
 }
 
 func New(bundle *fleet.Bundle) (*Bundle, error) {
@@ -15,3 +20,42 @@ func New(bundle *fleet.Bundle) (*Bundle, error) {
 	}
 	return a, a.initMatcher()
 }
+
+// Stats returns the number of resources in the bundle including overlays, their total size once decoded,
+// and their total size as stored in the bundle.
+func (a *Bundle) Stats() (resourceCount int, uncompressedBytes int, compressedBytes int, err error) {
+	var resources []fleet.BundleResource
+	resources = append(resources, a.Definition.Spec.Resources...)
+	for _, overlay := range a.Definition.Spec.Overlays {
+		resources = append(resources, overlay.Resources...)
+	}
+
+	for _, resource := range resources {
+		data, err := content.Decode(resource.Content, resource.Encoding)
+		if err != nil {
+			return 0, 0, 0, errors.Wrapf(err, "failed to decode %s", resource.Name)
+		}
+		resourceCount++
+		uncompressedBytes += len(data)
+		compressedBytes += len(resource.Content)
+	}
+
+	return
+}
+
+// UnresolvedOverlays returns the names of the overlays referenced by targets or other overlays that are
+// not defined in the bundle. Bundles returned by Read never have unresolved overlays since referenced
+// overlays that are not defined are either an error, with StrictOverlays, or added empty.
+func (a *Bundle) UnresolvedOverlays() (result []string) {
+	spec := &a.Definition.Spec
+	defined := map[string]bool{}
+	for _, overlay := range spec.Overlays {
+		defined[overlay.Name] = true
+	}
+	for _, name := range overlays(spec) {
+		if !defined[name] {
+			result = append(result, name)
+		}
+	}
+	return
+}
@@ -0,0 +1,113 @@
+package bundle
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/content"
+)
+
+func TestStats(t *testing.T) {
+	compressed, encoding, err := content.Encode([]byte("kind: Secret\n"), content.GzipAlgorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := New(&fleet.Bundle{
+		Spec: fleet.BundleSpec{
+			Resources: []fleet.BundleResource{
+				{Name: "manifests/configmap.yaml", Content: "kind: ConfigMap\n"},
+				{Name: "manifests/secret.yaml", Content: compressed, Encoding: encoding},
+			},
+			Overlays: []fleet.BundleOverlay{
+				{
+					Name:      "prod",
+					Resources: []fleet.BundleResource{{Name: "values.yaml", Content: "replicas: 3\n"}},
+				},
+				{
+					Name: "empty",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, uncompressed, stored, err := b.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 resources including the overlay, got %d", count)
+	}
+	if expected := len("kind: ConfigMap\n") + len("kind: Secret\n") + len("replicas: 3\n"); uncompressed != expected {
+		t.Errorf("expected %d bytes uncompressed, got %d", expected, uncompressed)
+	}
+	if expected := len("kind: ConfigMap\n") + len(compressed) + len("replicas: 3\n"); stored != expected {
+		t.Errorf("expected %d bytes stored, got %d", expected, stored)
+	}
+}
+
+func TestStatsOfCompressedBundle(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml":           "targets:\n- clusterGroup: prod\n  overlays: [prod]\n",
+		"manifests/data.yaml":  manifestLines(1000),
+		"overlays/prod/a.yaml": "kind: ConfigMap\n",
+	})
+
+	plain, err := Open(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := Open(context.Background(), dir, "", &Options{Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plainCount, plainSize, plainStored, err := plain.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, size, stored, err := compressed.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || plainCount != 2 {
+		t.Errorf("expected 2 resources, got %d and %d", plainCount, count)
+	}
+	if expected := len(manifestLines(1000)) + len("kind: ConfigMap\n"); plainSize != expected || size != expected {
+		t.Errorf("expected %d bytes uncompressed, got %d and %d", expected, plainSize, size)
+	}
+	if plainStored != plainSize {
+		t.Errorf("expected the stored size of an uncompressed bundle to equal its size, got %d", plainStored)
+	}
+	if stored >= size {
+		t.Errorf("expected the stored size of a compressed bundle to be smaller than %d, got %d", size, stored)
+	}
+}
+
+func TestUnresolvedOverlays(t *testing.T) {
+	b, err := New(&fleet.Bundle{
+		Spec: fleet.BundleSpec{
+			Overlays: []fleet.BundleOverlay{
+				{Name: "prod", Overlays: []string{"common", "shared"}},
+				{Name: "common"},
+			},
+			Targets: []fleet.BundleTarget{
+				{Name: "prod", ClusterGroup: "prod", Overlays: []string{"prod", "prodution"}},
+				{Name: "dev", ClusterGroup: "dev", Overlays: []string{"common"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if unresolved := b.UnresolvedOverlays(); !reflect.DeepEqual(unresolved, []string{"prodution", "shared"}) {
+		t.Errorf("expected the overlays referenced by the target and the overlay that are not defined, got %v", unresolved)
+	}
+}
@@ -0,0 +1,91 @@
+package bundle
+
+import (
+	"bytes"
+	"path/filepath"
+	"sort"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/content"
+)
+
+type ChangeType string
+
+const (
+	Added    ChangeType = "Added"
+	Removed  ChangeType = "Removed"
+	Modified ChangeType = "Modified"
+)
+
+// ResourceChange is a resource that differs between two bundles. Overlay resources are named
+// overlays/<overlay name>/<resource name>.
+type ResourceChange struct {
+	Name string
+	Type ChangeType
+}
+
+// Diff returns the resources that were added, removed or modified from one bundle to the other, sorted by name.
+// Content is compared after decoding so a change in compression alone is not reported.
+func Diff(from, to *Bundle) ([]ResourceChange, error) {
+	oldResources, err := resourceContent(from)
+	if err != nil {
+		return nil, err
+	}
+
+	newResources, err := resourceContent(to)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []ResourceChange
+	for name, newData := range newResources {
+		oldData, ok := oldResources[name]
+		if !ok {
+			result = append(result, ResourceChange{Name: name, Type: Added})
+		} else if !bytes.Equal(oldData, newData) {
+			result = append(result, ResourceChange{Name: name, Type: Modified})
+		}
+	}
+
+	for name := range oldResources {
+		if _, ok := newResources[name]; !ok {
+			result = append(result, ResourceChange{Name: name, Type: Removed})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
+
+func resourceContent(bundle *Bundle) (map[string][]byte, error) {
+	result := map[string][]byte{}
+	if bundle == nil || bundle.Definition == nil {
+		return result, nil
+	}
+
+	add := func(prefix string, resources []fleet.BundleResource) error {
+		for _, resource := range resources {
+			data, err := content.Decode(resource.Content, resource.Encoding)
+			if err != nil {
+				return err
+			}
+			result[filepath.Join(prefix, resource.Name)] = data
+		}
+		return nil
+	}
+
+	if err := add("", bundle.Definition.Spec.Resources); err != nil {
+		return nil, err
+	}
+
+	for _, overlay := range bundle.Definition.Spec.Overlays {
+		if err := add(filepath.Join(Overlays, overlay.Name), overlay.Resources); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
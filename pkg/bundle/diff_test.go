@@ -0,0 +1,106 @@
+package bundle
+
+import (
+	"reflect"
+	"testing"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/content"
+)
+
+func newDiffBundle(t *testing.T, resources []fleet.BundleResource, overlays ...fleet.BundleOverlay) *Bundle {
+	t.Helper()
+
+	b, err := New(&fleet.Bundle{
+		Spec: fleet.BundleSpec{
+			Resources: resources,
+			Overlays:  overlays,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDiff(t *testing.T) {
+	compressed, encoding, err := content.Encode([]byte("kind: Service\n"), content.ZstdAlgorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := newDiffBundle(t,
+		[]fleet.BundleResource{
+			{Name: "manifests/configmap.yaml", Content: "kind: ConfigMap\n"},
+			{Name: "manifests/deployment.yaml", Content: "kind: Deployment\nreplicas: 1\n"},
+			{Name: "manifests/service.yaml", Content: "kind: Service\n"},
+			{Name: "manifests/secret.yaml", Content: "kind: Secret\n"},
+		},
+		fleet.BundleOverlay{
+			Name:      "prod",
+			Resources: []fleet.BundleResource{{Name: "deployment.yaml", Content: "replicas: 3\n"}},
+		},
+	)
+	to := newDiffBundle(t,
+		[]fleet.BundleResource{
+			{Name: "manifests/configmap.yaml", Content: "kind: ConfigMap\n"},
+			{Name: "manifests/deployment.yaml", Content: "kind: Deployment\nreplicas: 2\n"},
+			// only the compression changed
+			{Name: "manifests/service.yaml", Content: compressed, Encoding: encoding},
+			{Name: "manifests/ingress.yaml", Content: "kind: Ingress\n"},
+		},
+		fleet.BundleOverlay{
+			Name:      "prod",
+			Resources: []fleet.BundleResource{{Name: "deployment.yaml", Content: "replicas: 5\n"}},
+		},
+		fleet.BundleOverlay{
+			Name:      "dev",
+			Resources: []fleet.BundleResource{{Name: "configmap.yaml", Content: "kind: ConfigMap\n"}},
+		},
+	)
+
+	changes, err := Diff(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ResourceChange{
+		{Name: "manifests/deployment.yaml", Type: Modified},
+		{Name: "manifests/ingress.yaml", Type: Added},
+		{Name: "manifests/secret.yaml", Type: Removed},
+		{Name: "overlays/dev/configmap.yaml", Type: Added},
+		{Name: "overlays/prod/deployment.yaml", Type: Modified},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %v, got %v", expected, changes)
+	}
+
+	changes, err = Diff(to, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, change := range changes {
+		if change.Name == "manifests/ingress.yaml" && change.Type != Removed {
+			t.Errorf("expected the reverse diff to remove manifests/ingress.yaml, got %v", change.Type)
+		}
+	}
+}
+
+func TestDiffNil(t *testing.T) {
+	b := newDiffBundle(t, []fleet.BundleResource{{Name: "manifests/configmap.yaml", Content: "kind: ConfigMap\n"}})
+
+	changes, err := Diff(nil, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []ResourceChange{{Name: "manifests/configmap.yaml", Type: Added}}; !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %v, got %v", expected, changes)
+	}
+
+	changes, err = Diff(b, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes between the same bundle, got %v", changes)
+	}
+}
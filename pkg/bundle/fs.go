@@ -0,0 +1,115 @@
+package bundle
+
+import (
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// osFS is the default filesystem bundles are read from. Unlike os.DirFS it accepts any path the os
+// package does, including absolute paths and paths outside of the working directory.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func fileSystem(opts *Options) fs.FS {
+	if opts != nil && opts.FS != nil {
+		return opts.FS
+	}
+	return osFS{}
+}
+
+func isOSFS(opts *Options) bool {
+	_, ok := fileSystem(opts).(osFS)
+	return ok
+}
+
+// fsPath converts a path built with the filepath package to the slash separated, unrooted form
+// expected by fs.FS. Paths of the OS filesystem are used as is.
+func fsPath(opts *Options, name string) string {
+	if isOSFS(opts) {
+		return name
+	}
+	return strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "/")
+}
+
+func openFile(opts *Options, name string) (fs.File, error) {
+	return fileSystem(opts).Open(fsPath(opts, name))
+}
+
+func readFile(opts *Options, name string) ([]byte, error) {
+	return fs.ReadFile(fileSystem(opts), fsPath(opts, name))
+}
+
+func readDir(opts *Options, name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(fileSystem(opts), fsPath(opts, name))
+}
+
+func stat(opts *Options, name string) (fs.FileInfo, error) {
+	return fs.Stat(fileSystem(opts), fsPath(opts, name))
+}
+
+// absPath returns the absolute path of name on the OS filesystem, or the cleaned path in any other
+// filesystem, where paths are always relative to its root
+func absPath(opts *Options, name string) (string, error) {
+	if isOSFS(opts) {
+		return filepath.Abs(name)
+	}
+	return fsPath(opts, name), nil
+}
+
+// isFSPath returns true if name is read from a filesystem set in the options rather than downloaded.
+// Names with a URL scheme or a forced go-getter such as git:: are always downloaded.
+func isFSPath(opts *Options, name string) bool {
+	if isOSFS(opts) || strings.Contains(name, "::") {
+		return false
+	}
+	u, err := url.Parse(name)
+	return err == nil && u.Scheme == ""
+}
+
+// readFS reads the files under base/name of the filesystem set in the options, in the same way local
+// paths are read with go-getter. A path that does not exist is ignored.
+func readFS(opts *Options, base, name string, handle func(name string, mode os.FileMode, data []byte) error) error {
+	fsys := fileSystem(opts)
+	root := fsPath(opts, filepath.Join(base, name))
+
+	if _, err := fs.Stat(fsys, root); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if isExcluded(opts, p) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		content, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+
+		rel := path.Base(p)
+		if p != root {
+			rel = strings.TrimPrefix(p, root+"/")
+		}
+		return handle(filepath.FromSlash(rel), info.Mode(), content)
+	})
+}
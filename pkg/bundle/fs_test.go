@@ -0,0 +1,88 @@
+package bundle
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOpenFS(t *testing.T) {
+	files := map[string]string{
+		"app/fleet.yaml": `include:
+- targets.yaml
+namespace: app
+overlays:
+- name: prod
+  namespace: app-prod
+`,
+		"app/targets.yaml": `targets:
+- name: prod
+  clusterGroup: prod
+  overlays: [prod]
+`,
+		"app/manifests/configmap.yaml":      "kind: ConfigMap\n",
+		"app/manifests/nested/secret.yaml":  "kind: Secret\n",
+		"app/overlays/prod/configmap.yaml":  "kind: ConfigMap\nmetadata:\n  name: prod\n",
+		"app/overlays/prod/deployment.yaml": "kind: Deployment\n",
+	}
+
+	fsys := fstest.MapFS{}
+	for name, data := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(data), Mode: 0644}
+	}
+	fromFS, err := OpenFS(context.Background(), fsys, "app", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, files)
+	fromOS, err := Open(context.Background(), dir+"/app", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(fromFS.Definition.Spec, fromOS.Definition.Spec) {
+		t.Errorf("expected the bundle read from the filesystem to be the same as read from disk, got %+v and %+v",
+			fromFS.Definition.Spec, fromOS.Definition.Spec)
+	}
+	if len(fromFS.Definition.Spec.Resources) != 2 {
+		t.Errorf("expected the resources of the manifests directory, got %v", fromFS.Definition.Spec.Resources)
+	}
+	if len(fromFS.Definition.Spec.Targets) != 1 || fromFS.Definition.Spec.Targets[0].Name != "prod" {
+		t.Errorf("expected the included targets, got %v", fromFS.Definition.Spec.Targets)
+	}
+}
+
+func TestOpenFSMissingBundle(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app/manifests/configmap.yaml": &fstest.MapFile{Data: []byte("kind: ConfigMap\n")},
+	}
+	if _, err := OpenFS(context.Background(), fsys, "app", "fleet.yaml", nil); err == nil {
+		t.Error("expected a bundle file that does not exist in the filesystem to fail")
+	}
+}
+
+func TestIsFSPath(t *testing.T) {
+	opts := &Options{FS: fstest.MapFS{}}
+
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{name: "manifests", expected: true},
+		{name: "charts/app", expected: true},
+		{name: "https://example.com/chart.tgz"},
+		{name: "git::https://example.com/repo.git"},
+	}
+
+	for _, test := range tests {
+		if actual := isFSPath(opts, test.name); actual != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+		if isFSPath(nil, test.name) {
+			t.Errorf("%s: expected paths of the OS filesystem to be downloaded", test.name)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package bundle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHTTPTimeout = 30 * time.Second
+	defaultHTTPMaxSize = 50 * 1024 * 1024
+)
+
+// manifestExtensions are the extensions of the single manifests read from http(s) URLs without go-getter
+var manifestExtensions = map[string]bool{
+	".yaml": true,
+	".yml":  true,
+	".json": true,
+}
+
+// isHTTPManifest returns true if name is an http(s) URL of a single manifest or a directory index read with
+// readHTTP. Any other URL, such as an archive, a URL with a go-getter //subdir or one with archive or
+// checksum query parameters, is downloaded with go-getter.
+func isHTTPManifest(name string) bool {
+	u, err := url.Parse(name)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	if strings.Contains(u.Path, "//") {
+		return false
+	}
+	query := u.Query()
+	if _, ok := query["archive"]; ok {
+		return false
+	}
+	if _, ok := query["checksum"]; ok {
+		return false
+	}
+	return strings.HasSuffix(u.Path, "/") || manifestExtensions[strings.ToLower(path.Ext(u.Path))]
+}
+
+// readHTTP reads a single manifest from the URL. If the URL path ends with a "/" it is treated as a
+// directory index, which is a list of file URLs relative to the index, one per line.
+func readHTTP(ctx context.Context, opts *Options, name string, handle func(name string, mode os.FileMode, data []byte) error) error {
+	u, err := url.Parse(name)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{
+		Timeout: opts.HTTPTimeout,
+	}
+	if client.Timeout <= 0 {
+		client.Timeout = defaultHTTPTimeout
+	}
+
+	if !strings.HasSuffix(u.Path, "/") {
+		data, err := httpGet(ctx, client, opts, u)
+		if err != nil {
+			return err
+		}
+		return handle(path.Base(u.Path), 0, data)
+	}
+
+	index, err := httpGet(ctx, client, opts, u)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(index), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		ref, err := url.Parse(line)
+		if err != nil {
+			return fmt.Errorf("invalid entry %s in index %s: %w", line, name, err)
+		}
+
+		fileURL := u.ResolveReference(ref)
+		data, err := httpGet(ctx, client, opts, fileURL)
+		if err != nil {
+			return err
+		}
+
+		fileName := strings.TrimPrefix(fileURL.Path, u.Path)
+		if fileName == fileURL.Path {
+			fileName = path.Base(fileURL.Path)
+		}
+		if err := handle(fileName, 0, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func httpGet(ctx context.Context, client *http.Client, opts *Options, u *url.URL) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if opts.HTTPAuthHeader != "" {
+		req.Header.Set("Authorization", opts.HTTPAuthHeader)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to read %s: %s", u, resp.Status)
+	}
+
+	maxSize := opts.HTTPMaxSize
+	if maxSize <= 0 {
+		maxSize = defaultHTTPMaxSize
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("failed to read %s: content exceeds max size of %d bytes", u, maxSize)
+	}
+
+	return data, nil
+}
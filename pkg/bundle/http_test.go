@@ -0,0 +1,167 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/content"
+)
+
+// resourceContents returns the decoded content of each resource by name
+func resourceContents(t *testing.T, resources []fleet.BundleResource) map[string]string {
+	t.Helper()
+
+	result := map[string]string{}
+	for _, resource := range resources {
+		data, err := content.Decode(resource.Content, resource.Encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result[resource.Name] = string(data)
+	}
+	return result
+}
+
+// tgz returns a gzipped tar of the files, keyed by their path in the archive
+func tgz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, data := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestIsHTTPManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{name: "https://example.com/app/configmap.yaml", expected: true},
+		{name: "http://example.com/app/configmap.yml", expected: true},
+		{name: "https://example.com/app/object.JSON", expected: true},
+		{name: "https://example.com/app/", expected: true},
+		{name: "https://example.com/chart.tgz"},
+		{name: "https://example.com/charts.zip"},
+		{name: "https://example.com/repo.tar.gz//app"},
+		{name: "https://example.com/app/configmap.yaml?archive=zip"},
+		{name: "https://example.com/app/configmap.yaml?checksum=sha256:abc"},
+		{name: "https://example.com/download"},
+		{name: "git::https://example.com/repo.git"},
+		{name: "manifests/configmap.yaml"},
+	}
+
+	for _, test := range tests {
+		if actual := isHTTPManifest(test.name); actual != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestReadHTTPManifests(t *testing.T) {
+	files := map[string]string{
+		"/app/":                       "# manifests of the app\nconfigmap.yaml\n\nnested/deployment.yaml\n",
+		"/app/configmap.yaml":         "kind: ConfigMap\n",
+		"/app/nested/deployment.yaml": "kind: Deployment\n",
+		"/large.yaml":                 strings.Repeat("a", 2048),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		data, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(data))
+	}))
+	defer server.Close()
+
+	open := func(manifests string, opts *Options) (*Bundle, error) {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{"fleet.yaml": "manifestsDir: " + manifests + "\n"})
+		return Open(context.Background(), dir, "", opts)
+	}
+
+	b, err := open(server.URL+"/app/configmap.yaml", &Options{HTTPAuthHeader: "Bearer token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := resourceContents(t, b.Definition.Spec.Resources); actual["manifests/configmap.yaml"] != "kind: ConfigMap\n" {
+		t.Errorf("expected the manifest to be read from the URL, got %v", actual)
+	}
+
+	b, err = open(server.URL+"/app/", &Options{HTTPAuthHeader: "Bearer token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := resourceContents(t, b.Definition.Spec.Resources)
+	if actual["manifests/configmap.yaml"] != "kind: ConfigMap\n" || actual["manifests/nested/deployment.yaml"] != "kind: Deployment\n" {
+		t.Errorf("expected every file of the index to be read, got %v", actual)
+	}
+
+	if _, err := open(server.URL+"/app/configmap.yaml", nil); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected reading without the auth header to fail, got %v", err)
+	}
+
+	_, err = open(server.URL+"/large.yaml", &Options{HTTPAuthHeader: "Bearer token", HTTPMaxSize: 1024})
+	if err == nil || !strings.Contains(err.Error(), "content exceeds max size of 1024 bytes") {
+		t.Errorf("expected the size cap to be enforced, got %v", err)
+	}
+}
+
+func TestReadHTTPChartArchive(t *testing.T) {
+	archive := tgz(t, map[string]string{
+		"app/Chart.yaml":          "name: app\nversion: 0.1.0\n",
+		"app/templates/cm.yaml":   "kind: ConfigMap\n",
+		"app/templates/_help.tpl": "{{- define \"app.name\" -}}app{{- end -}}\n",
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chart.tgz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/gzip")
+		_, _ = w.Write(archive)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"fleet.yaml": "chart: " + server.URL + "/chart.tgz\n"})
+
+	b, err := Open(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual := resourceContents(t, b.Definition.Spec.Resources)
+	if actual["chart/Chart.yaml"] != "name: app\nversion: 0.1.0\n" || actual["chart/templates/cm.yaml"] != "kind: ConfigMap\n" {
+		t.Errorf("expected the chart archive to be unpacked, got %v", actual)
+	}
+	if _, ok := actual["chart/chart.tgz"]; ok {
+		t.Errorf("expected the chart archive not to be read as a manifest")
+	}
+}
@@ -0,0 +1,100 @@
+package bundle
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const includeKey = "include"
+
+// resolveIncludes merges the files listed under include into the bundle definition. Paths are relative
+// to baseDir and included files may include other files. Lists, such as targets and overlays, are
+// concatenated with the content of included files first. Maps are merged and for any other value the
+// including file takes precedence over the files it includes.
+func resolveIncludes(opts *Options, baseDir string, data []byte) ([]byte, error) {
+	obj := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	if _, ok := obj[includeKey]; !ok {
+		return data, nil
+	}
+
+	obj, err := readFragment(opts, baseDir, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(obj)
+}
+
+func readFragment(opts *Options, baseDir string, data []byte, stack []string) (map[string]interface{}, error) {
+	obj := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+
+	includes, ok := obj[includeKey]
+	if !ok {
+		return obj, nil
+	}
+	delete(obj, includeKey)
+
+	files, ok := includes.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a list of files", includeKey)
+	}
+
+	result := map[string]interface{}{}
+	for _, file := range files {
+		name, ok := file.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a list of files, found %v", includeKey, file)
+		}
+
+		path, err := absPath(opts, filepath.Join(baseDir, name))
+		if err != nil {
+			return nil, err
+		}
+		for _, parent := range stack {
+			if parent == path {
+				return nil, fmt.Errorf("include cycle detected: %s -> %s", strings.Join(stack, " -> "), path)
+			}
+		}
+
+		content, err := readFile(opts, path)
+		if err != nil {
+			return nil, err
+		}
+
+		fragmentStack := append(append([]string{}, stack...), path)
+		fragment, err := readFragment(opts, filepath.Dir(path), content, fragmentStack)
+		if err != nil {
+			return nil, fmt.Errorf("failed to include %s: %w", name, err)
+		}
+		result = mergeFragment(result, fragment)
+	}
+
+	return mergeFragment(result, obj), nil
+}
+
+func mergeFragment(base, next map[string]interface{}) map[string]interface{} {
+	for k, nextValue := range next {
+		switch nextValue := nextValue.(type) {
+		case []interface{}:
+			if baseValue, ok := base[k].([]interface{}); ok {
+				base[k] = append(baseValue, nextValue...)
+				continue
+			}
+		case map[string]interface{}:
+			if baseValue, ok := base[k].(map[string]interface{}); ok {
+				base[k] = mergeFragment(baseValue, nextValue)
+				continue
+			}
+		}
+		base[k] = nextValue
+	}
+	return base
+}
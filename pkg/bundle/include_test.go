@@ -0,0 +1,119 @@
+package bundle
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIncludeFragments(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml": `include:
+- fragments/targets.yaml
+- fragments/overlays.yaml
+namespace: app
+labels:
+  team: platform
+targets:
+- name: local
+  clusterGroup: local
+`,
+		"fragments/targets.yaml": `include:
+- defaults.yaml
+targets:
+- name: prod
+  clusterGroup: prod
+  overlays: [prod]
+`,
+		"fragments/defaults.yaml": `namespace: ignored
+labels:
+  owner: ops
+  team: ignored
+`,
+		"fragments/overlays.yaml": `overlays:
+- name: prod
+  namespace: app-prod
+`,
+		"manifests/configmap.yaml": "kind: ConfigMap\n",
+	})
+
+	b, err := Open(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := b.Definition.Spec
+
+	var targets []string
+	for _, target := range spec.Targets {
+		targets = append(targets, target.Name)
+	}
+	// lists are concatenated with the included content first
+	if expected := []string{"prod", "local"}; !reflect.DeepEqual(targets, expected) {
+		t.Errorf("expected targets %v, got %v", expected, targets)
+	}
+	if len(spec.Overlays) != 1 || spec.Overlays[0].Name != "prod" || spec.Overlays[0].DefaultNamespace != "app-prod" {
+		t.Errorf("expected the overlay of the included file, got %+v", spec.Overlays)
+	}
+	// scalars of the including file take precedence
+	if spec.DefaultNamespace != "app" {
+		t.Errorf("expected the namespace of the including file, got %q", spec.DefaultNamespace)
+	}
+	// maps are merged
+	if expected := map[string]string{"owner": "ops", "team": "platform"}; !reflect.DeepEqual(b.Definition.Labels, expected) {
+		t.Errorf("expected labels %v, got %v", expected, b.Definition.Labels)
+	}
+}
+
+func TestIncludeError(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		message string
+	}{
+		{
+			name: "cycle",
+			files: map[string]string{
+				"fleet.yaml": "include: [a.yaml]\n",
+				"a.yaml":     "include: [b.yaml]\n",
+				"b.yaml":     "include: [a.yaml]\n",
+			},
+			message: "include cycle detected",
+		},
+		{
+			name: "self",
+			files: map[string]string{
+				"fleet.yaml": "include: [a.yaml]\n",
+				"a.yaml":     "include: [a.yaml]\n",
+			},
+			message: "include cycle detected",
+		},
+		{
+			name: "missing",
+			files: map[string]string{
+				"fleet.yaml": "include: [missing.yaml]\n",
+			},
+			message: "missing.yaml",
+		},
+		{
+			name: "not a list",
+			files: map[string]string{
+				"fleet.yaml": "include: a.yaml\n",
+			},
+			message: "include must be a list of files",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, test.files)
+
+			_, err := Open(context.Background(), dir, "", nil)
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Errorf("expected an error containing %q, got %v", test.message, err)
+			}
+		})
+	}
+}
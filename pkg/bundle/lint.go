@@ -0,0 +1,201 @@
+package bundle
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/content"
+	"github.com/rancher/wrangler/pkg/yaml"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// clusterScopedKinds are the common kinds that do not need a namespace
+var clusterScopedKinds = map[string]bool{
+	"APIService":                     true,
+	"ClusterRole":                    true,
+	"ClusterRoleBinding":             true,
+	"CustomResourceDefinition":       true,
+	"MutatingWebhookConfiguration":   true,
+	"Namespace":                      true,
+	"PersistentVolume":               true,
+	"PodSecurityPolicy":              true,
+	"PriorityClass":                  true,
+	"StorageClass":                   true,
+	"ValidatingWebhookConfiguration": true,
+}
+
+// IsClusterScoped returns true if kind is one of the common kinds that are not namespaced
+func IsClusterScoped(kind string) bool {
+	return clusterScopedKinds[kind]
+}
+
+// LintWarning is a problem found in a bundle that does not prevent it from being deployed
+type LintWarning struct {
+	// Name is the resource, overlay or target the warning is about
+	Name    string
+	Message string
+}
+
+func (l LintWarning) String() string {
+	return l.Name + ": " + l.Message
+}
+
+// Lint returns warnings for common mistakes in the bundle. Unlike the validation done when reading a
+// bundle these are never fatal.
+func Lint(b *Bundle) []LintWarning {
+	var (
+		warnings []LintWarning
+		spec     = &b.Definition.Spec
+	)
+
+	warnings = append(warnings, lintManifests(spec)...)
+
+	referenced := map[string]bool{}
+	for _, name := range overlays(spec) {
+		referenced[name] = true
+	}
+
+	undefined := map[string]bool{}
+	for _, name := range b.undefinedOverlays {
+		undefined[name] = true
+		warnings = append(warnings, LintWarning{
+			Name:    "overlay " + name,
+			Message: "is referenced but not defined in the bundle or found on disk, it is empty",
+		})
+	}
+
+	defined := map[string]bool{}
+	for _, overlay := range spec.Overlays {
+		defined[overlay.Name] = true
+	}
+	for _, resource := range spec.Resources {
+		if _, overlay, ok := overlayTag(resource.Name); ok && !defined[overlay] {
+			warnings = append(warnings, LintWarning{
+				Name:    resource.Name,
+				Message: "is tagged with overlay " + overlay + ", which is not defined, it is deployed to every target",
+			})
+		}
+	}
+
+	for _, overlay := range spec.Overlays {
+		if undefined[overlay.Name] {
+			continue
+		}
+		if !referenced[overlay.Name] && !isLabelOverlay(overlay.Name) {
+			warnings = append(warnings, LintWarning{
+				Name:    "overlay " + overlay.Name,
+				Message: "is not referenced by any target or overlay",
+			})
+		}
+		if len(overlay.Resources) == 0 && len(overlay.Overlays) == 0 &&
+			reflect.DeepEqual(overlay.BundleDeploymentOptions, fleet.BundleDeploymentOptions{}) {
+			warnings = append(warnings, LintWarning{
+				Name:    "overlay " + overlay.Name,
+				Message: "overrides nothing",
+			})
+		}
+	}
+
+	var matchesAll []string
+	for _, target := range spec.Targets {
+		switch {
+		case target.All:
+			matchesAll = append(matchesAll, target.Name)
+		case isEmptySelector(target.ClusterSelector) && !hasGroupOrNameCriteria(target):
+			matchesAll = append(matchesAll, target.Name)
+			warnings = append(warnings, LintWarning{
+				Name:    "target " + target.Name,
+				Message: "has an empty clusterSelector that matches every cluster, set all: true if this is intended",
+			})
+		case target.ClusterSelector == nil && !hasGroupOrNameCriteria(target):
+			warnings = append(warnings, LintWarning{
+				Name:    "target " + target.Name,
+				Message: "has no clusterSelector, clusterGroup, clusterGroupSelector, clusterGroups or clusterNameRegex and matches no clusters",
+			})
+		}
+	}
+	if len(matchesAll) > 1 {
+		warnings = append(warnings, LintWarning{
+			Name:    "targets " + strings.Join(matchesAll, ", "),
+			Message: "all match every cluster, only the first with the highest priority is used",
+		})
+	}
+
+	return warnings
+}
+
+// hasGroupOrNameCriteria returns true if the target selects clusters by anything other than its clusterSelector
+func hasGroupOrNameCriteria(target fleet.BundleTarget) bool {
+	return target.ClusterGroup != "" || target.ClusterGroupSelector != nil || len(target.ClusterGroups) > 0 ||
+		target.ClusterNameRegex != ""
+}
+
+func isEmptySelector(selector *metav1.LabelSelector) bool {
+	return selector != nil && len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0
+}
+
+// lintManifests checks the plain yaml files under the manifests directory for objects missing a
+// namespace and objects defined in more than one file
+func lintManifests(spec *fleet.BundleSpec) []LintWarning {
+	var (
+		warnings []LintWarning
+		seen     = map[string]string{}
+	)
+
+	for _, resource := range spec.Resources {
+		if !strings.HasPrefix(resource.Name, ManifestsDir+"/") {
+			continue
+		}
+		switch filepath.Ext(resource.Name) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+
+		data, err := content.Decode(resource.Content, resource.Encoding)
+		if err != nil {
+			continue
+		}
+
+		objs, err := yaml.ToObjects(bytes.NewBuffer(data))
+		if err != nil {
+			warnings = append(warnings, LintWarning{
+				Name:    resource.Name,
+				Message: fmt.Sprintf("failed to parse: %v", err),
+			})
+			continue
+		}
+
+		for _, obj := range objs {
+			m, err := meta.Accessor(obj)
+			if err != nil {
+				continue
+			}
+
+			gvk := obj.GetObjectKind().GroupVersionKind()
+			key := fmt.Sprintf("%s %s/%s", gvk.String(), m.GetNamespace(), m.GetName())
+			if previous, ok := seen[key]; ok {
+				warnings = append(warnings, LintWarning{
+					Name:    resource.Name,
+					Message: fmt.Sprintf("%s %s is also defined in %s", gvk.Kind, m.GetName(), previous),
+				})
+			} else {
+				seen[key] = resource.Name
+			}
+
+			if m.GetNamespace() == "" && spec.DefaultNamespace == "" && !clusterScopedKinds[gvk.Kind] {
+				warnings = append(warnings, LintWarning{
+					Name:    resource.Name,
+					Message: fmt.Sprintf("%s %s has no namespace and the bundle has no default namespace", gvk.Kind, m.GetName()),
+				})
+			}
+		}
+	}
+
+	return warnings
+}
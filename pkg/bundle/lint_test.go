@@ -0,0 +1,223 @@
+package bundle
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// hasWarning returns true if the warnings contain one about name whose message contains message
+func hasWarning(warnings []LintWarning, name, message string) bool {
+	for _, warning := range warnings {
+		if warning.Name == name && strings.Contains(warning.Message, message) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLintTargets(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    fleet.BundleTarget
+		matchAll  bool
+		matchNone bool
+	}{
+		{
+			name: "selector",
+			target: fleet.BundleTarget{
+				ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+			},
+		},
+		{
+			name:      "no criteria",
+			matchNone: true,
+		},
+		{
+			name: "empty selector",
+			target: fleet.BundleTarget{
+				ClusterSelector: &metav1.LabelSelector{},
+			},
+			matchAll: true,
+		},
+		{
+			name: "cluster name regex",
+			target: fleet.BundleTarget{
+				ClusterNameRegex: "^prod-",
+			},
+		},
+		{
+			name: "empty selector and cluster name regex",
+			target: fleet.BundleTarget{
+				ClusterSelector:  &metav1.LabelSelector{},
+				ClusterNameRegex: "^prod-",
+			},
+		},
+		{
+			name: "cluster groups",
+			target: fleet.BundleTarget{
+				ClusterGroups: []string{"prod"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.target.Name = "target"
+			warnings := Lint(&Bundle{
+				Definition: &fleet.Bundle{
+					Spec: fleet.BundleSpec{
+						Targets: []fleet.BundleTarget{test.target},
+					},
+				},
+			})
+
+			if matchAll := hasWarning(warnings, "target target", "matches every cluster"); matchAll != test.matchAll {
+				t.Errorf("expected matches every cluster warning %v, got %v", test.matchAll, warnings)
+			}
+			if matchNone := hasWarning(warnings, "target target", "matches no clusters"); matchNone != test.matchNone {
+				t.Errorf("expected matches no clusters warning %v, got %v", test.matchNone, warnings)
+			}
+		})
+	}
+}
+
+func TestLintMatchEveryCluster(t *testing.T) {
+	warnings := Lint(&Bundle{
+		Definition: &fleet.Bundle{
+			Spec: fleet.BundleSpec{
+				Targets: []fleet.BundleTarget{
+					{Name: "all", All: true},
+					{Name: "empty", ClusterSelector: &metav1.LabelSelector{}},
+				},
+			},
+		},
+	})
+	if !hasWarning(warnings, "targets all, empty", "all match every cluster") {
+		t.Errorf("expected a warning that more than one target matches every cluster, got %v", warnings)
+	}
+	if hasWarning(warnings, "target all", "matches every cluster") {
+		t.Errorf("expected no warning for a target with all: true, got %v", warnings)
+	}
+}
+
+func TestLintManifests(t *testing.T) {
+	tests := []struct {
+		name             string
+		defaultNamespace string
+		resources        map[string]string
+		warnings         map[string]string
+	}{
+		{
+			name: "missing namespace",
+			resources: map[string]string{
+				"manifests/configmap.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\n",
+				"manifests/ns.yaml":        "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: app\n",
+			},
+			warnings: map[string]string{
+				"manifests/configmap.yaml": "ConfigMap app has no namespace",
+			},
+		},
+		{
+			name:             "default namespace",
+			defaultNamespace: "app",
+			resources: map[string]string{
+				"manifests/configmap.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\n",
+			},
+		},
+		{
+			name:             "duplicate object",
+			defaultNamespace: "app",
+			resources: map[string]string{
+				"manifests/a.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\n",
+				"manifests/b.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\n---\napiVersion: v1\nkind: Secret\nmetadata:\n  name: app\n",
+			},
+			warnings: map[string]string{
+				"manifests/b.yaml": "ConfigMap app is also defined in manifests/a.yaml",
+			},
+		},
+		{
+			name:             "same name in other namespaces",
+			defaultNamespace: "app",
+			resources: map[string]string{
+				"manifests/a.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\n  namespace: a\n",
+				"manifests/b.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\n  namespace: b\n",
+			},
+		},
+		{
+			name: "invalid yaml",
+			resources: map[string]string{
+				"manifests/invalid.yaml": "kind: [ConfigMap\n",
+				"chart/values.yaml":      "kind: [ignored\n",
+			},
+			warnings: map[string]string{
+				"manifests/invalid.yaml": "failed to parse",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec := fleet.BundleSpec{}
+			spec.DefaultNamespace = test.defaultNamespace
+			for name, data := range test.resources {
+				spec.Resources = append(spec.Resources, fleet.BundleResource{Name: name, Content: data})
+			}
+			sort.Slice(spec.Resources, func(i, j int) bool {
+				return spec.Resources[i].Name < spec.Resources[j].Name
+			})
+
+			warnings := Lint(&Bundle{Definition: &fleet.Bundle{Spec: spec}})
+			for name, message := range test.warnings {
+				if !hasWarning(warnings, name, message) {
+					t.Errorf("expected a warning about %s containing %q, got %v", name, message, warnings)
+				}
+			}
+			if len(warnings) != len(test.warnings) {
+				t.Errorf("expected %d warnings, got %v", len(test.warnings), warnings)
+			}
+		})
+	}
+}
+
+func TestLintOverlays(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml": `overlays:
+- name: unused
+  namespace: unused
+- name: nothing
+- name: region=eu
+  namespace: eu
+targets:
+- clusterGroup: prod
+  overlays: [nothing, missing, prod]
+`,
+		"manifests/configmap.yaml":     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\n  namespace: app\n",
+		"overlays/prod/configmap.yaml": "kind: ConfigMap\n",
+	})
+
+	b, err := Open(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings := Lint(b)
+
+	expected := map[string]string{
+		"overlay unused":  "is not referenced by any target or overlay",
+		"overlay nothing": "overrides nothing",
+		"overlay missing": "is referenced but not defined in the bundle or found on disk",
+	}
+	for name, message := range expected {
+		if !hasWarning(warnings, name, message) {
+			t.Errorf("expected a warning about %s containing %q, got %v", name, message, warnings)
+		}
+	}
+	if len(warnings) != len(expected) {
+		t.Errorf("expected %d warnings, got %v", len(expected), warnings)
+	}
+}
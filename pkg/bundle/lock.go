@@ -0,0 +1,114 @@
+package bundle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/content"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/yaml"
+)
+
+// LockFile is the name of the lock file in the base dir of a bundle
+const LockFile = "bundle.lock"
+
+// Lock is the content of a lock file, the sha256 of each resource of the bundle as it is deployed. The
+// resources of overlays are keyed as overlays/<overlay>/<resource name>.
+type Lock struct {
+	Resources map[string]string `json:"resources"`
+}
+
+// NewLock returns the lock of the resources of the bundle
+func NewLock(b *Bundle) (*Lock, error) {
+	lock := &Lock{
+		Resources: map[string]string{},
+	}
+
+	add := func(prefix string, resources []fleet.BundleResource) error {
+		for _, resource := range resources {
+			data, err := content.Decode(resource.Content, resource.Encoding)
+			if err != nil {
+				return errors.Wrapf(err, "failed to decode %s", resource.Name)
+			}
+			lock.Resources[filepath.ToSlash(filepath.Join(prefix, resource.Name))] = resourceHash(data)
+		}
+		return nil
+	}
+
+	if err := add("", b.Definition.Spec.Resources); err != nil {
+		return nil, err
+	}
+	for _, overlay := range b.Definition.Spec.Overlays {
+		if err := add(filepath.Join(Overlays, overlay.Name), overlay.Resources); err != nil {
+			return nil, err
+		}
+	}
+
+	return lock, nil
+}
+
+// WriteLock writes the lock file of the bundle to baseDir. Reading the bundle from baseDir afterwards fails
+// if any of its resources no longer match the lock. The bundle must be read with IgnoreLock to replace an
+// existing lock file.
+func WriteLock(baseDir string, b *Bundle) error {
+	lock, err := NewLock(b)
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(lock)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(baseDir, LockFile), data, 0644)
+}
+
+// verifyLock checks the resources of the bundle against the lock file in baseDir, if there is one, so a
+// bundle is only read if it is exactly the bundle the lock was generated from
+func verifyLock(b *Bundle, baseDir string, opts *Options) error {
+	if baseDir == "" {
+		baseDir = "."
+	}
+
+	data, err := readFile(opts, filepath.Join(baseDir, LockFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	expected := &Lock{}
+	if err := yaml.Unmarshal(data, expected); err != nil {
+		return errors.Wrapf(err, "failed to parse %s", LockFile)
+	}
+
+	actual, err := NewLock(b)
+	if err != nil {
+		return err
+	}
+
+	var drift []string
+	for _, name := range sets.StringKeySet(expected.Resources).Union(sets.StringKeySet(actual.Resources)).List() {
+		expectedHash, locked := expected.Resources[name]
+		actualHash, found := actual.Resources[name]
+		switch {
+		case !locked:
+			drift = append(drift, name+" is not locked")
+		case !found:
+			drift = append(drift, name+" is missing")
+		case expectedHash != actualHash:
+			drift = append(drift, name+" changed")
+		}
+	}
+	if len(drift) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("bundle in %s does not match %s: %s", baseDir, LockFile, strings.Join(drift, ", "))
+}
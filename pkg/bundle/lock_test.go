@@ -0,0 +1,144 @@
+package bundle
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var lockFiles = map[string]string{
+	"fleet.yaml": `overlays:
+- name: prod
+targets:
+- name: prod
+  clusterGroup: prod
+  overlays: [prod]
+`,
+	"manifests/configmap.yaml":    "kind: ConfigMap\n",
+	"overlays/prod/secret.yaml":   "kind: Secret\n",
+	"manifests/deployment.yaml":   "kind: Deployment\n",
+	"overlays/prod/service.yaml":  "kind: Service\n",
+	"manifests/nested/route.yaml": "kind: Route\n",
+}
+
+func sha256Hex(data string) string {
+	hash := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestOpenMatchingLock(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		LockFile: `resources:
+  manifests/configmap.yaml: ` + sha256Hex("kind: ConfigMap\n") + `
+  overlays/prod/secret.yaml: ` + sha256Hex("kind: Secret\n") + `
+`,
+		"fleet.yaml":                "overlays:\n- name: prod\ntargets:\n- clusterGroup: prod\n  overlays: [prod]\n",
+		"manifests/configmap.yaml":  "kind: ConfigMap\n",
+		"overlays/prod/secret.yaml": "kind: Secret\n",
+	}
+	writeFiles(t, dir, files)
+
+	if _, err := Open(context.Background(), dir, "", nil); err != nil {
+		t.Errorf("expected a bundle matching its lock to be read, got %v", err)
+	}
+}
+
+func TestOpenDriftedLock(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, dir string)
+		drift  string
+	}{
+		{
+			name: "changed",
+			change: func(t *testing.T, dir string) {
+				writeFiles(t, dir, map[string]string{"manifests/configmap.yaml": "kind: ConfigMap\nmetadata:\n  name: changed\n"})
+			},
+			drift: "manifests/configmap.yaml changed",
+		},
+		{
+			name: "added",
+			change: func(t *testing.T, dir string) {
+				writeFiles(t, dir, map[string]string{"overlays/prod/extra.yaml": "kind: ConfigMap\n"})
+			},
+			drift: "overlays/prod/extra.yaml is not locked",
+		},
+		{
+			name: "removed",
+			change: func(t *testing.T, dir string) {
+				if err := os.Remove(filepath.Join(dir, "manifests", "nested", "route.yaml")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			drift: "manifests/nested/route.yaml is missing",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, lockFiles)
+
+			b, err := Open(context.Background(), dir, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := WriteLock(dir, b); err != nil {
+				t.Fatal(err)
+			}
+			test.change(t, dir)
+
+			_, err = Open(context.Background(), dir, "", nil)
+			if err == nil || !strings.Contains(err.Error(), "does not match "+LockFile) || !strings.Contains(err.Error(), test.drift) {
+				t.Errorf("expected an error that %s, got %v", test.drift, err)
+			}
+
+			if _, err := Open(context.Background(), dir, "", &Options{IgnoreLock: true}); err != nil {
+				t.Errorf("expected the lock to be ignored, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWriteLockRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, lockFiles)
+
+	b, err := Open(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteLock(dir, b); err != nil {
+		t.Fatal(err)
+	}
+
+	reread, err := Open(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatalf("expected the bundle to match the lock written from it, got %v", err)
+	}
+	lock, err := NewLock(reread)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{}
+	for name, data := range lockFiles {
+		if name != "fleet.yaml" {
+			expected[name] = sha256Hex(data)
+		}
+	}
+	if !reflect.DeepEqual(lock.Resources, expected) {
+		t.Errorf("expected the lock %v, got %v", expected, lock.Resources)
+	}
+
+	// compressing the resources does not change the lock
+	if _, err := Open(context.Background(), dir, "", &Options{Compress: true}); err != nil {
+		t.Errorf("expected a compressed bundle to match the lock, got %v", err)
+	}
+}
@@ -1,11 +1,18 @@
 package bundle
 
 import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
 	"github.com/rancher/fleet/pkg/match"
 	"github.com/rancher/fleet/pkg/render"
 
 	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
 	manifest "github.com/rancher/fleet/pkg/manifest"
+	"github.com/rancher/wrangler/pkg/kv"
 )
 
 type Match struct {
@@ -46,30 +53,201 @@ func (a *Bundle) MatchForTarget(name string) *Match {
 	return nil
 }
 
-func (a *Bundle) Match(clusterGroups map[string]map[string]string, clusterLabels map[string]string) *Match {
-	for clusterGroup, clusterGroupLabels := range clusterGroups {
-		if m := a.matcher.Match(clusterGroup, clusterGroupLabels, clusterLabels); m != nil {
-			return m
+// Match returns the target of the bundle for the cluster. If more than one target matches, the
+// target with the highest priority is chosen, and targets of the same priority are chosen in the
+// order they are defined.
+//
+// The result is cached by the cluster labels and cluster groups, so clusters with the same labels and
+// groups only evaluate the selectors of the targets once for the lifetime of the Bundle. If any target
+// matches by cluster name the name is part of the cache key.
+func (a *Bundle) Match(clusterName string, clusterGroups map[string]map[string]string, clusterLabels map[string]string) *Match {
+	key := matchKey(clusterGroups, clusterLabels)
+	if a.matcher.matchNames {
+		key = clusterName + "/" + key
+	}
+
+	a.matcher.lock.Lock()
+	m, ok := a.matcher.matchCache[key]
+	a.matcher.lock.Unlock()
+	if ok {
+		return m
+	}
+
+	m, _ = a.Matches(clusterName, clusterGroups, clusterLabels)
+
+	a.matcher.lock.Lock()
+	a.matcher.matchCache[key] = m
+	a.matcher.lock.Unlock()
+	return m
+}
+
+// matchKey returns a key of the cluster groups and their labels and the cluster labels. Label keys and
+// values can not contain a NUL byte, so it is used to separate them.
+func matchKey(clusterGroups map[string]map[string]string, clusterLabels map[string]string) string {
+	var b strings.Builder
+	writeLabels(&b, clusterLabels)
+
+	if len(clusterGroups) > 0 {
+		groups := make([]string, 0, len(clusterGroups))
+		for group := range clusterGroups {
+			groups = append(groups, group)
 		}
+		sort.Strings(groups)
+		for _, group := range groups {
+			b.WriteString(group)
+			b.WriteByte(0)
+			writeLabels(&b, clusterGroups[group])
+		}
+	}
+
+	return b.String()
+}
+
+func writeLabels(b *strings.Builder, labels map[string]string) {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte(0)
+		b.WriteString(labels[k])
+		b.WriteByte(0)
+	}
+	b.WriteByte(1)
+}
+
+// Matches returns the chosen target as Match does, and all targets matching the cluster in the
+// order they are defined.
+func (a *Bundle) Matches(clusterName string, clusterGroups map[string]map[string]string, clusterLabels map[string]string) (*Match, []*Match) {
+	matched := map[int]bool{}
+	for clusterGroup, clusterGroupLabels := range clusterGroups {
+		a.matcher.matchAll(matched, clusterGroup, clusterGroupLabels, clusterLabels)
 	}
 	if len(clusterGroups) == 0 {
-		return a.matcher.Match("", nil, clusterLabels)
+		a.matcher.matchAll(matched, "", nil, clusterLabels)
 	}
-	return nil
+
+	var (
+		chosen *Match
+		all    []*Match
+	)
+	for i, targetMatch := range a.matcher.matches {
+		if !targetMatch.matchesAll && (!targetMatch.matchGroups(clusterGroups) || !targetMatch.matchName(clusterName)) {
+			continue
+		}
+		// a target with only cluster groups or a cluster name regex has no other criteria to match
+		if !matched[i] && !targetMatch.matchesAll && !targetMatch.onlyGroupsOrName() {
+			continue
+		}
+		all = append(all, targetMatch.targetBundle)
+		if chosen == nil || targetMatch.targetBundle.Target.Priority > chosen.Target.Priority {
+			chosen = targetMatch.targetBundle
+		}
+	}
+
+	if chosen != nil {
+		chosen = a.withLabelOverlays(chosen, clusterLabels)
+	}
+
+	return chosen, all
+}
+
+// withLabelOverlays returns the match with the overlays named key=value that match the cluster labels
+// added before the overlays of the target, so overlays listed by the target take precedence. The same
+// Match is returned for the same target and set of label overlays.
+func (a *Bundle) withLabelOverlays(m *Match, clusterLabels map[string]string) *Match {
+	var names []string
+	for _, overlay := range a.Definition.Spec.Overlays {
+		if !isLabelOverlay(overlay.Name) {
+			continue
+		}
+		k, v := kv.Split(overlay.Name, "=")
+		if value, ok := clusterLabels[k]; ok && value == v && !contains(m.Target.Overlays, overlay.Name) {
+			names = append(names, overlay.Name)
+		}
+	}
+	if len(names) == 0 {
+		return m
+	}
+
+	key := m.Target.Name + "/" + strings.Join(names, ",")
+
+	a.matcher.lock.Lock()
+	defer a.matcher.lock.Unlock()
+
+	if result, ok := a.matcher.labelMatches[key]; ok {
+		return result
+	}
+
+	target := m.Target.DeepCopy()
+	target.Overlays = append(names, m.Target.Overlays...)
+	result := &Match{
+		Target: target,
+		Bundle: a,
+	}
+	a.matcher.labelMatches[key] = result
+	return result
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 type targetMatch struct {
 	targetBundle *Match
 	criteria     *match.ClusterMatcher
+	groups       []string
+	minGroups    int
+	clusterName  *regexp.Regexp
+	matchesAll   bool
+}
+
+// matchName returns true if the target has no cluster name regex or the cluster name matches it
+func (t *targetMatch) matchName(clusterName string) bool {
+	return t.clusterName == nil || t.clusterName.MatchString(clusterName)
+}
+
+// onlyGroupsOrName returns true if the target has no selectors and is matched by cluster groups or the
+// cluster name alone
+func (t *targetMatch) onlyGroupsOrName() bool {
+	return t.criteria.Empty() && (len(t.groups) > 0 || t.clusterName != nil)
+}
+
+// matchGroups returns true if the cluster is a member of at least minGroups of the target cluster groups
+func (t *targetMatch) matchGroups(clusterGroups map[string]map[string]string) bool {
+	if len(t.groups) == 0 {
+		return true
+	}
+	count := 0
+	for _, group := range t.groups {
+		if _, ok := clusterGroups[group]; ok {
+			count++
+		}
+	}
+	return count >= t.minGroups
 }
 
 type matcher struct {
-	matches []targetMatch
+	matches      []targetMatch
+	lock         sync.Mutex
+	labelMatches map[string]*Match
+	matchCache   map[string]*Match
+	matchNames   bool
 }
 
 func (a *Bundle) initMatcher() error {
 	var (
-		m = &matcher{}
+		m = &matcher{
+			labelMatches: map[string]*Match{},
+			matchCache:   map[string]*Match{},
+		}
 	)
 
 	for i, target := range a.Definition.Spec.Targets {
@@ -82,7 +260,20 @@ func (a *Bundle) initMatcher() error {
 				Target: &a.Definition.Spec.Targets[i],
 				Bundle: a,
 			},
-			criteria: clusterMatcher,
+			criteria:   clusterMatcher,
+			groups:     target.ClusterGroups,
+			minGroups:  target.MinClusterGroups,
+			matchesAll: target.All,
+		}
+		if target.ClusterNameRegex != "" {
+			t.clusterName, err = regexp.Compile(target.ClusterNameRegex)
+			if err != nil {
+				return fmt.Errorf("target %s has an invalid clusterNameRegex: %w", target.Name, err)
+			}
+			m.matchNames = true
+		}
+		if t.minGroups <= 0 || t.minGroups > len(t.groups) {
+			t.minGroups = len(t.groups)
 		}
 
 		m.matches = append(m.matches, t)
@@ -92,12 +283,10 @@ func (a *Bundle) initMatcher() error {
 	return nil
 }
 
-func (m *matcher) Match(clusterGroup string, clusterGroupLabels, clusterLabels map[string]string) *Match {
-	for _, targetMatch := range m.matches {
-		if targetMatch.criteria.Match(clusterGroup, clusterGroupLabels, clusterLabels) {
-			return targetMatch.targetBundle
+func (m *matcher) matchAll(matched map[int]bool, clusterGroup string, clusterGroupLabels, clusterLabels map[string]string) {
+	for i, targetMatch := range m.matches {
+		if !matched[i] && targetMatch.criteria.Match(clusterGroup, clusterGroupLabels, clusterLabels) {
+			matched[i] = true
 		}
 	}
-
-	return nil
 }
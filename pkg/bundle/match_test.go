@@ -0,0 +1,573 @@
+package bundle
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestBundle(t *testing.T, targets ...fleet.BundleTarget) *Bundle {
+	t.Helper()
+
+	b, err := New(&fleet.Bundle{
+		Spec: fleet.BundleSpec{
+			Targets: targets,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestMatchClusterNameRegex(t *testing.T) {
+	b := newTestBundle(t,
+		fleet.BundleTarget{
+			Name:             "prod",
+			ClusterNameRegex: "^prod-",
+		},
+		fleet.BundleTarget{
+			Name:             "prod-eu",
+			ClusterNameRegex: "-eu$",
+			ClusterSelector:  &metav1.LabelSelector{MatchLabels: map[string]string{"env": "eu"}},
+			Priority:         1,
+		},
+	)
+
+	tests := []struct {
+		clusterName string
+		labels      map[string]string
+		expected    string
+	}{
+		{
+			clusterName: "prod-us",
+			expected:    "prod",
+		},
+		{
+			clusterName: "prod-eu",
+			labels:      map[string]string{"env": "eu"},
+			expected:    "prod-eu",
+		},
+		{
+			// the regex and the selector of the target must both match
+			clusterName: "prod-eu",
+			expected:    "prod",
+		},
+		{
+			clusterName: "dev-us",
+		},
+	}
+
+	for _, test := range tests {
+		match := b.Match(test.clusterName, nil, test.labels)
+		name := ""
+		if match != nil {
+			name = match.Target.Name
+		}
+		if name != test.expected {
+			t.Errorf("%s %v: expected target %q, got %q", test.clusterName, test.labels, test.expected, name)
+		}
+	}
+}
+
+func TestInvalidClusterNameRegex(t *testing.T) {
+	_, err := New(&fleet.Bundle{
+		Spec: fleet.BundleSpec{
+			Targets: []fleet.BundleTarget{
+				{
+					Name:             "prod",
+					ClusterNameRegex: "^prod-(",
+				},
+			},
+		},
+	})
+	if err == nil {
+		t.Fatal("expected an invalid clusterNameRegex to fail")
+	}
+}
+
+func TestMatchPriority(t *testing.T) {
+	selector := func(key, value string) *metav1.LabelSelector {
+		return &metav1.LabelSelector{MatchLabels: map[string]string{key: value}}
+	}
+
+	tests := []struct {
+		name     string
+		targets  []fleet.BundleTarget
+		labels   map[string]string
+		expected string
+		matches  []string
+	}{
+		{
+			name: "first defined wins",
+			targets: []fleet.BundleTarget{
+				{Name: "env", ClusterSelector: selector("env", "prod")},
+				{Name: "region", ClusterSelector: selector("region", "eu")},
+			},
+			labels:   map[string]string{"env": "prod", "region": "eu"},
+			expected: "env",
+			matches:  []string{"env", "region"},
+		},
+		{
+			name: "highest priority wins",
+			targets: []fleet.BundleTarget{
+				{Name: "env", ClusterSelector: selector("env", "prod")},
+				{Name: "region", ClusterSelector: selector("region", "eu"), Priority: 10},
+				{Name: "all", ClusterSelector: &metav1.LabelSelector{}, Priority: 5},
+			},
+			labels:   map[string]string{"env": "prod", "region": "eu"},
+			expected: "region",
+			matches:  []string{"env", "region", "all"},
+		},
+		{
+			name: "first defined of the same priority wins",
+			targets: []fleet.BundleTarget{
+				{Name: "env", ClusterSelector: selector("env", "prod"), Priority: -1},
+				{Name: "region", ClusterSelector: selector("region", "eu"), Priority: 5},
+				{Name: "all", ClusterSelector: &metav1.LabelSelector{}, Priority: 5},
+			},
+			labels:   map[string]string{"env": "prod", "region": "eu"},
+			expected: "region",
+			matches:  []string{"env", "region", "all"},
+		},
+		{
+			name: "only matching targets",
+			targets: []fleet.BundleTarget{
+				{Name: "env", ClusterSelector: selector("env", "prod"), Priority: 10},
+				{Name: "region", ClusterSelector: selector("region", "eu")},
+			},
+			labels:   map[string]string{"env": "dev", "region": "eu"},
+			expected: "region",
+			matches:  []string{"region"},
+		},
+		{
+			name: "no match",
+			targets: []fleet.BundleTarget{
+				{Name: "env", ClusterSelector: selector("env", "prod")},
+			},
+			labels: map[string]string{"env": "dev"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestBundle(t, test.targets...)
+
+			match, matches := b.Matches("cluster", nil, test.labels)
+			name := ""
+			if match != nil {
+				name = match.Target.Name
+			}
+			if name != test.expected {
+				t.Errorf("expected target %q, got %q", test.expected, name)
+			}
+			var names []string
+			for _, m := range matches {
+				names = append(names, m.Target.Name)
+			}
+			if !reflect.DeepEqual(names, test.matches) {
+				t.Errorf("expected all matching targets %v, got %v", test.matches, names)
+			}
+
+			if match := b.Match("cluster", nil, test.labels); match != nil && match.Target.Name != test.expected {
+				t.Errorf("expected Match to choose target %q, got %q", test.expected, match.Target.Name)
+			}
+		})
+	}
+}
+
+func TestMatchClusterGroups(t *testing.T) {
+	b := newTestBundle(t,
+		fleet.BundleTarget{
+			Name:          "prod-monitored",
+			ClusterGroups: []string{"prod", "monitored"},
+		},
+		fleet.BundleTarget{
+			// the groups and the selector of the target must both match
+			Name:             "two-of-three",
+			ClusterGroups:    []string{"prod", "monitored", "eu"},
+			MinClusterGroups: 2,
+			ClusterSelector:  &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "edge"}},
+		},
+	)
+
+	groups := func(names ...string) map[string]map[string]string {
+		result := map[string]map[string]string{}
+		for _, name := range names {
+			result[name] = map[string]string{}
+		}
+		return result
+	}
+
+	tests := []struct {
+		name     string
+		groups   map[string]map[string]string
+		labels   map[string]string
+		expected string
+	}{
+		{
+			name:     "all groups",
+			groups:   groups("prod", "monitored"),
+			expected: "prod-monitored",
+		},
+		{
+			name:     "extra groups",
+			groups:   groups("prod", "monitored", "dev"),
+			expected: "prod-monitored",
+		},
+		{
+			name:   "missing a group",
+			groups: groups("prod", "eu"),
+		},
+		{
+			name:     "two of three with the selector",
+			groups:   groups("prod", "eu"),
+			labels:   map[string]string{"tier": "edge"},
+			expected: "two-of-three",
+		},
+		{
+			name:   "one of three with the selector",
+			groups: groups("eu"),
+			labels: map[string]string{"tier": "edge"},
+		},
+		{
+			name: "no groups",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			match := b.Match("cluster", test.groups, test.labels)
+			name := ""
+			if match != nil {
+				name = match.Target.Name
+			}
+			if name != test.expected {
+				t.Errorf("expected target %q, got %q", test.expected, name)
+			}
+		})
+	}
+}
+
+func TestMatchLabelOverlays(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml": `targets:
+- name: prod
+  clusterSelector:
+    matchLabels:
+      tier: prod
+  overlays: [explicit]
+- name: listed
+  clusterSelector:
+    matchLabels:
+      tier: listed
+  overlays: [env=prod]
+`,
+		"manifests/configmap.yaml":                 "kind: ConfigMap\ndata: base\n",
+		"manifests/service.yaml":                   "kind: Service\n",
+		"overlays/env=prod/configmap.yaml":         "kind: ConfigMap\ndata: env\n",
+		"overlays/env=prod/manifests/service.yaml": "kind: Service\nenv: prod\n",
+		"overlays/region=eu/extra.yaml":            "kind: Secret\n",
+		"overlays/explicit/configmap.yaml":         "kind: ConfigMap\ndata: explicit\n",
+	})
+
+	b, err := Open(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		overlays []string
+	}{
+		{
+			name:     "explicit only",
+			labels:   map[string]string{"tier": "prod"},
+			overlays: []string{"explicit"},
+		},
+		{
+			name:     "label and explicit",
+			labels:   map[string]string{"tier": "prod", "env": "prod"},
+			overlays: []string{"env=prod", "explicit"},
+		},
+		{
+			name:     "all matching labels",
+			labels:   map[string]string{"tier": "prod", "env": "prod", "region": "eu"},
+			overlays: []string{"env=prod", "region=eu", "explicit"},
+		},
+		{
+			name:     "other label value",
+			labels:   map[string]string{"tier": "prod", "env": "dev"},
+			overlays: []string{"explicit"},
+		},
+		{
+			name:     "listed by the target",
+			labels:   map[string]string{"tier": "listed", "env": "prod"},
+			overlays: []string{"env=prod"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			match := b.Match("cluster", nil, test.labels)
+			if match == nil {
+				t.Fatal("expected a target to match")
+			}
+			if !reflect.DeepEqual(match.Target.Overlays, test.overlays) {
+				t.Errorf("expected overlays %v, got %v", test.overlays, match.Target.Overlays)
+			}
+			if again := b.Match("cluster", nil, test.labels); again != match {
+				t.Errorf("expected the same match for the same labels")
+			}
+		})
+	}
+
+	// the overlays of the target take precedence over the label overlays
+	m, err := b.Match("cluster", nil, map[string]string{"tier": "prod", "env": "prod"}).Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources := resourceContents(t, m.Resources)
+	if resources["configmap.yaml"] != "kind: ConfigMap\ndata: explicit\n" {
+		t.Errorf("expected the explicit overlay to take precedence, got %v", resources)
+	}
+	if resources["manifests/service.yaml"] != "kind: Service\nenv: prod\n" {
+		t.Errorf("expected the label overlay to be applied, got %v", resources)
+	}
+}
+
+func TestMatchAll(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  fleet.BundleTarget
+		matches bool
+	}{
+		{
+			name:    "all",
+			target:  fleet.BundleTarget{All: true},
+			matches: true,
+		},
+		{
+			name:   "no criteria",
+			target: fleet.BundleTarget{},
+		},
+		{
+			name:   "cluster group",
+			target: fleet.BundleTarget{ClusterGroup: "prod"},
+		},
+		{
+			name:    "all ignores other criteria",
+			target:  fleet.BundleTarget{All: true, ClusterGroups: []string{"prod"}},
+			matches: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.target.Name = "target"
+			b := newTestBundle(t, test.target)
+
+			for _, labels := range []map[string]string{nil, {"env": "dev"}} {
+				if match := b.Match("dev-1", nil, labels); (match != nil) != test.matches {
+					t.Errorf("%v: expected match %v, got %v", labels, test.matches, match)
+				}
+			}
+		})
+	}
+
+	// an explicit all target is not warned about, an empty selector that matches everything is
+	b := &Bundle{
+		Definition: &fleet.Bundle{
+			Spec: fleet.BundleSpec{
+				Targets: []fleet.BundleTarget{
+					{Name: "explicit", All: true},
+					{Name: "accidental", ClusterSelector: &metav1.LabelSelector{}},
+				},
+			},
+		},
+	}
+	warnings := Lint(b)
+	if hasWarning(warnings, "target explicit", "matches every cluster") {
+		t.Errorf("expected no warning for the explicit all target, got %v", warnings)
+	}
+	if !hasWarning(warnings, "target accidental", "matches every cluster") {
+		t.Errorf("expected a warning for the empty selector, got %v", warnings)
+	}
+}
+
+// fleetClusters returns the labels of count clusters spread over the given number of regions
+func fleetClusters(count, regions int) (result []map[string]string) {
+	for i := 0; i < count; i++ {
+		result = append(result, map[string]string{
+			"env":    "prod",
+			"region": fmt.Sprintf("region-%d", i%regions),
+		})
+	}
+	return result
+}
+
+func TestMatchCache(t *testing.T) {
+	b := newTestBundle(t,
+		fleet.BundleTarget{Name: "eu", ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"region": "region-0"}}},
+		fleet.BundleTarget{Name: "prod", ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}},
+	)
+
+	for _, clusterLabels := range fleetClusters(100, 4) {
+		expected := "prod"
+		if clusterLabels["region"] == "region-0" {
+			expected = "eu"
+		}
+		if match := b.Match("cluster", nil, clusterLabels); match == nil || match.Target.Name != expected {
+			t.Errorf("%v: expected target %s, got %v", clusterLabels, expected, match)
+		}
+	}
+
+	// the selectors are only evaluated once for each distinct set of labels
+	if len(b.matcher.matchCache) != 4 {
+		t.Errorf("expected the selectors to be evaluated for 4 distinct clusters, got %d", len(b.matcher.matchCache))
+	}
+
+	// the cluster groups are part of the key
+	groups := map[string]map[string]string{"prod": {"tier": "1"}}
+	b.Match("cluster", groups, fleetClusters(1, 1)[0])
+	if len(b.matcher.matchCache) != 5 {
+		t.Errorf("expected a cluster in a group to be evaluated separately, got %d", len(b.matcher.matchCache))
+	}
+}
+
+func TestMatchCacheByClusterName(t *testing.T) {
+	b := newTestBundle(t,
+		fleet.BundleTarget{Name: "canary", ClusterNameRegex: "^canary-"},
+		fleet.BundleTarget{Name: "prod", ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}},
+	)
+
+	clusterLabels := map[string]string{"env": "prod"}
+	for name, expected := range map[string]string{"canary-1": "canary", "prod-1": "prod", "canary-2": "canary"} {
+		if match := b.Match(name, nil, clusterLabels); match == nil || match.Target.Name != expected {
+			t.Errorf("%s: expected target %s, got %v", name, expected, match)
+		}
+	}
+}
+
+func benchmarkMatch(b *testing.B, cached bool) {
+	var targets []fleet.BundleTarget
+	for i := 0; i < 10; i++ {
+		targets = append(targets, fleet.BundleTarget{
+			Name: fmt.Sprintf("region-%d", i),
+			ClusterSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"region": fmt.Sprintf("region-%d", i)},
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "env", Operator: metav1.LabelSelectorOpIn, Values: []string{"prod", "staging"}},
+				},
+			},
+			ClusterGroupSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "1"}},
+		})
+	}
+	bundle, err := New(&fleet.Bundle{Spec: fleet.BundleSpec{Targets: targets}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	clusters := fleetClusters(5000, 10)
+	clusterGroups := map[string]map[string]string{
+		"prod":  {"tier": "1"},
+		"fleet": {"tier": "2"},
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// a new cache for each run, as each call to Targets uses a new bundle
+		bundle.matcher.matchCache = map[string]*Match{}
+		for _, clusterLabels := range clusters {
+			if cached {
+				bundle.Match("cluster", clusterGroups, clusterLabels)
+			} else {
+				bundle.Matches("cluster", clusterGroups, clusterLabels)
+			}
+		}
+	}
+}
+
+func BenchmarkMatchCached(b *testing.B) {
+	benchmarkMatch(b, true)
+}
+
+func BenchmarkMatchUncached(b *testing.B) {
+	benchmarkMatch(b, false)
+}
+
+func TestMatchClusterGroup(t *testing.T) {
+	b := newTestBundle(t,
+		fleet.BundleTarget{
+			Name:            "prod-edge",
+			ClusterGroup:    "prod",
+			ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "edge"}},
+		},
+		fleet.BundleTarget{
+			Name:         "prod",
+			ClusterGroup: "prod",
+		},
+		fleet.BundleTarget{
+			Name:                 "eu",
+			ClusterGroupSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"region": "eu"}},
+		},
+	)
+
+	tests := []struct {
+		name     string
+		groups   map[string]map[string]string
+		labels   map[string]string
+		expected string
+	}{
+		{
+			name:     "member of the group",
+			groups:   map[string]map[string]string{"prod": {}},
+			expected: "prod",
+		},
+		{
+			name:     "member of the group with the selector",
+			groups:   map[string]map[string]string{"prod": {}},
+			labels:   map[string]string{"tier": "edge"},
+			expected: "prod-edge",
+		},
+		{
+			name:     "member of a group with matching labels",
+			groups:   map[string]map[string]string{"eu-clusters": {"region": "eu"}},
+			expected: "eu",
+		},
+		{
+			// the group name and group selector are evaluated for each group of the cluster
+			name:     "member of both",
+			groups:   map[string]map[string]string{"eu-clusters": {"region": "eu"}, "prod": {}},
+			expected: "prod",
+		},
+		{
+			name:   "member of another group",
+			groups: map[string]map[string]string{"dev": {"region": "us"}},
+			labels: map[string]string{"tier": "edge"},
+		},
+		{
+			name:   "not a member of any group",
+			labels: map[string]string{"tier": "edge"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			match := b.Match("cluster", test.groups, test.labels)
+			name := ""
+			if match != nil {
+				name = match.Target.Name
+			}
+			if name != test.expected {
+				t.Errorf("expected target %q, got %q", test.expected, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package bundle
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// OpenAll reads a bundle from each directory under rootDir, including rootDir itself, that contains a
+// bundle file. The bundles are returned in the order of BundleDirs. The files of a bundle nested in the
+// directory of another bundle are only read for the nested bundle, never for the bundle containing it.
+func OpenAll(ctx context.Context, rootDir string, opts *Options) ([]*Bundle, error) {
+	dirs, err := BundleDirs(rootDir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*Bundle
+	for _, dir := range dirs {
+		dirOpts := Options{}
+		if opts != nil {
+			dirOpts = *opts
+		}
+		dirOpts.excludeDirs, err = nestedDirs(&dirOpts, dir, dirs)
+		if err != nil {
+			return nil, err
+		}
+
+		bundle, err := Open(ctx, dir, "", &dirOpts)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, bundle)
+	}
+
+	return result, nil
+}
+
+// BundleDirs returns the directories under rootDir, including rootDir itself, that contain one of the
+// bundle files of the options, in lexical order. Hidden directories, such as .git, are not searched.
+func BundleDirs(rootDir string, opts *Options) ([]string, error) {
+	if rootDir == "" {
+		rootDir = "."
+	}
+
+	names := DefaultBundleFiles
+	if opts != nil && len(opts.BundleFiles) > 0 {
+		names = opts.BundleFiles
+	}
+
+	var (
+		result []string
+		root   = fsPath(opts, rootDir)
+	)
+	err := fs.WalkDir(fileSystem(opts), root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			return fs.SkipDir
+		}
+
+		for _, name := range names {
+			if _, err := stat(opts, filepath.Join(path, name)); err == nil {
+				result = append(result, path)
+				break
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// nestedDirs returns the paths of the dirs below dir, as compared by isExcluded
+func nestedDirs(opts *Options, dir string, dirs []string) ([]string, error) {
+	var result []string
+	for _, other := range dirs {
+		if other == dir || !isBelow(dir, other) {
+			continue
+		}
+		path, err := absPath(opts, other)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, path)
+	}
+	return result, nil
+}
+
+func isBelow(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// isExcluded returns true if path is the directory of a nested bundle, whose files belong to that bundle
+func isExcluded(opts *Options, path string) bool {
+	for _, dir := range opts.excludeDirs {
+		if path == dir {
+			return true
+		}
+	}
+	return false
+}
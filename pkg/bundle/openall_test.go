@@ -0,0 +1,80 @@
+package bundle
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+var openAllFiles = map[string]string{
+	"a/fleet.yaml":                             "namespace: a\n",
+	"a/manifests/configmap.yaml":               "kind: ConfigMap\n",
+	"a/manifests/nested/fleet.yaml":            "namespace: nested\n",
+	"a/manifests/nested/manifests/secret.yaml": "kind: Secret\n",
+	"b/fleet.yaml":                             "namespace: b\n",
+	"b/manifests/deployment.yaml":              "kind: Deployment\n",
+	".git/fleet.yaml":                          "namespace: git\n",
+	"docs/readme.yaml":                         "kind: ConfigMap\n",
+}
+
+// bundleResources returns the names of the resources of each bundle by its default namespace
+func bundleResources(bundles []*Bundle) map[string][]string {
+	result := map[string][]string{}
+	for _, bundle := range bundles {
+		names := []string{}
+		for _, resource := range bundle.Definition.Spec.Resources {
+			names = append(names, resource.Name)
+		}
+		result[bundle.Definition.Spec.DefaultNamespace] = names
+	}
+	return result
+}
+
+var openAllExpected = map[string][]string{
+	"a":      {"manifests/configmap.yaml"},
+	"nested": {"manifests/secret.yaml"},
+	"b":      {"manifests/deployment.yaml"},
+}
+
+func TestOpenAll(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, openAllFiles)
+
+	dirs, err := BundleDirs(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedDirs := []string{
+		filepath.Join(dir, "a"),
+		filepath.Join(dir, "a", "manifests", "nested"),
+		filepath.Join(dir, "b"),
+	}
+	if !reflect.DeepEqual(dirs, expectedDirs) {
+		t.Errorf("expected bundle dirs %v, got %v", expectedDirs, dirs)
+	}
+
+	bundles, err := OpenAll(context.Background(), dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resources := bundleResources(bundles); !reflect.DeepEqual(resources, openAllExpected) {
+		t.Errorf("expected resources %v, got %v", openAllExpected, resources)
+	}
+}
+
+func TestOpenAllFS(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for name, data := range openAllFiles {
+		fsys["repo/"+name] = &fstest.MapFile{Data: []byte(data), Mode: 0644}
+	}
+
+	bundles, err := OpenAll(context.Background(), "repo", &Options{FS: fsys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resources := bundleResources(bundles); !reflect.DeepEqual(resources, openAllExpected) {
+		t.Errorf("expected resources %v, got %v", openAllExpected, resources)
+	}
+}
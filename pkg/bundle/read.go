@@ -3,24 +3,91 @@ package bundle
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/pkg/errors"
 	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/content"
+	"github.com/rancher/fleet/pkg/overlay"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"sigs.k8s.io/yaml"
 )
 
+const (
+	// DefaultMaxBundleSize is just under the etcd limit on the size of an object. Manifests downloaded from
+	// a ContentURL are limited to the same size, see manifest.MaxDownloadSize.
+	DefaultMaxBundleSize = 1400000
+)
+
 type Options struct {
 	Compress bool
+	// CompressionAlgorithm is the algorithm used to compress resources, gzip or zstd, defaults to gzip
+	CompressionAlgorithm string
+	// PreserveOrder orders files by their numeric filename prefix, such as 00-namespace.yaml and 10-deployment.yaml,
+	// instead of lexically
+	PreserveOrder bool
+	// HTTPAuthHeader is the value of the Authorization header sent when reading resources from http(s) URLs
+	HTTPAuthHeader string
+	// HTTPTimeout is the timeout of each http(s) request, defaults to 30 seconds
+	HTTPTimeout time.Duration
+	// HTTPMaxSize is the max size in bytes of each resource read over http(s), defaults to 50MB
+	HTTPMaxSize int64
+	// MaxBundleSize is the max size in bytes of the compressed bundle, defaults to DefaultMaxBundleSize
+	MaxBundleSize int
+	// MaxResources is the max number of resources in the bundle, including the resources of overlays,
+	// defaults to unlimited
+	MaxResources int
+	// NamePrefix and NameSuffix are added to the name of every object in the manifests directory, so the
+	// same bundle can be deployed more than once side by side
+	NamePrefix string
+	NameSuffix string
+	// StrictResourceNames makes a resource defined both inline in the bundle file and on disk an error,
+	// otherwise the inline resource is used
+	StrictResourceNames bool
+	// StrictOverlays makes a reference from a target or overlay to an overlay that is neither defined in the
+	// bundle nor found on disk an error, otherwise the overlay is empty and Lint warns about it
+	StrictOverlays bool
+	// BundleFiles are the names of the bundle file looked for in the base dir, in order, if no file is
+	// given to Open. Defaults to DefaultBundleFiles.
+	BundleFiles []string
+	// DisabledOverlays are overlays left out of the bundle. References to them from targets and other
+	// overlays are dropped, so a bundle can define optional overlays that are only enabled for some
+	// deployments.
+	DisabledOverlays []string
+	// FS is the filesystem the bundle file and the files of the bundle are read from, such as an embed.FS,
+	// defaults to the OS filesystem. Paths are relative to the root of FS, resources with a URL are still
+	// downloaded.
+	FS fs.FS
+
+	// IgnoreLock skips verifying the bundle against the lock file in the base dir, such as to generate a new
+	// lock file with WriteLock after changing the bundle
+	IgnoreLock bool
+
+	// excludeDirs are the directories of bundles nested in the bundle, set by OpenAll
+	excludeDirs []string
+	// budget limits the size of the resources as they are read, set by OpenStreaming
+	budget *sizeBudget
 }
 
+// DefaultBundleFiles are the names of the bundle file looked for by Open, in order
+var DefaultBundleFiles = []string{"fleet.yaml", "bundle.yaml"}
+
+// ErrNoBundleFile is returned by Open if none of the bundle files exist in the base dir
+var ErrNoBundleFile = errors.New("no bundle file found")
+
 func Open(ctx context.Context, baseDir, file string, opts *Options) (*Bundle, error) {
 	if baseDir == "" {
 		baseDir = "."
@@ -35,20 +102,14 @@ func Open(ctx context.Context, baseDir, file string, opts *Options) (*Bundle, er
 	)
 
 	if file == "" {
-		file = filepath.Join(baseDir, "fleet.yaml")
-		if _, err := os.Stat(file); os.IsNotExist(err) {
-			file = filepath.Join(baseDir, "bundle.yaml")
-		}
-		if f, err := os.Open(file); os.IsNotExist(err) {
-			in = bytes.NewBufferString("{}")
-		} else if err != nil {
+		f, err := openBundleFile(baseDir, opts)
+		if err != nil {
 			return nil, err
-		} else {
-			in = f
-			defer f.Close()
 		}
+		defer f.Close()
+		in = f
 	} else {
-		f, err := os.Open(filepath.Join(baseDir, file))
+		f, err := openFile(opts, filepath.Join(baseDir, file))
 		if err != nil {
 			return nil, err
 		}
@@ -59,31 +120,236 @@ func Open(ctx context.Context, baseDir, file string, opts *Options) (*Bundle, er
 	return Read(ctx, baseDir, in, opts)
 }
 
+// openBundleFile opens the first of the bundle files that exists in baseDir. If none do the error wraps
+// ErrNoBundleFile and lists the names tried.
+func openBundleFile(baseDir string, opts *Options) (fs.File, error) {
+	names := DefaultBundleFiles
+	if opts != nil && len(opts.BundleFiles) > 0 {
+		names = opts.BundleFiles
+	}
+
+	for _, name := range names {
+		f, err := openFile(opts, filepath.Join(baseDir, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+
+	return nil, errors.Wrapf(ErrNoBundleFile, "%s: tried %s", baseDir, strings.Join(names, ", "))
+}
+
+// OpenFS is the same as Open but reads the bundle from fsys instead of the OS filesystem, so bundles
+// can be embedded in a binary with embed.FS or read from a virtual filesystem
+func OpenFS(ctx context.Context, fsys fs.FS, baseDir, file string, opts *Options) (*Bundle, error) {
+	fsOpts := Options{}
+	if opts != nil {
+		fsOpts = *opts
+	}
+	fsOpts.FS = fsys
+	return Open(ctx, baseDir, file, &fsOpts)
+}
+
+// OpenStreaming is the same as Open but is intended for very large bundle directories. All resources
+// are compressed as they are read, so the uncompressed content of only one file is held in memory at a
+// time and the bundle is never read a second time to determine if it must be compressed. The size of
+// the compressed resources is counted as they are read, so reading stops as soon as the bundle exceeds
+// MaxBundleSize, and the size of the bundle is computed one resource at a time rather than by marshalling
+// the whole bundle. The compressed bundle is still returned in memory.
+func OpenStreaming(ctx context.Context, baseDir, file string, opts *Options) (*Bundle, error) {
+	streamingOpts := Options{}
+	if opts != nil {
+		streamingOpts = *opts
+	}
+	streamingOpts.Compress = true
+	streamingOpts.budget = &sizeBudget{
+		max: maxBundleSize(&streamingOpts),
+	}
+	return Open(ctx, baseDir, file, &streamingOpts)
+}
+
 func Read(ctx context.Context, baseDir string, bundleSpecReader io.Reader, opts *Options) (*Bundle, error) {
 	if opts == nil {
 		opts = &Options{}
 	}
 
+	switch opts.CompressionAlgorithm {
+	case "", content.GzipAlgorithm, content.ZstdAlgorithm:
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm %s, must be %s or %s", opts.CompressionAlgorithm,
+			content.GzipAlgorithm, content.ZstdAlgorithm)
+	}
+
 	data, err := ioutil.ReadAll(bundleSpecReader)
 	if err != nil {
 		return nil, err
 	}
 
-	bundle, err := read(ctx, opts.Compress, baseDir, bytes.NewBuffer(data))
+	if isGzip(data) {
+		data, err = content.GUnzip(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decompress bundle")
+		}
+	}
+
+	bundle, err := read(ctx, opts, baseDir, bytes.NewBuffer(data))
 	if err != nil {
 		return nil, err
 	}
 
-	if size, err := size(bundle.Definition); err != nil {
+	if err := checkResourceCount(bundle, baseDir, opts); err != nil {
 		return nil, err
-	} else if size < 1000000 {
-		return bundle, nil
 	}
 
-	return read(ctx, true, baseDir, bytes.NewBuffer(data))
+	if !opts.IgnoreLock {
+		if err := verifyLock(bundle, baseDir, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	if !opts.Compress {
+		size, err := sizeOf(bundle.Definition)
+		if err != nil {
+			return nil, err
+		}
+
+		if size >= 1000000 {
+			compressOpts := *opts
+			compressOpts.Compress = true
+			bundle, err = read(ctx, &compressOpts, baseDir, bytes.NewBuffer(data))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return bundle, checkSize(bundle, baseDir, opts)
+}
+
+// isGzip returns true if data starts with the gzip magic number
+func isGzip(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b
+}
+
+func maxBundleSize(opts *Options) int {
+	if opts.MaxBundleSize <= 0 {
+		return DefaultMaxBundleSize
+	}
+	return opts.MaxBundleSize
+}
+
+func checkSize(bundle *Bundle, baseDir string, opts *Options) error {
+	maxSize := maxBundleSize(opts)
+
+	var (
+		size int
+		err  error
+	)
+	if opts.budget != nil {
+		size, err = streamedSize(bundle.Definition)
+	} else {
+		size, err = sizeOf(bundle.Definition)
+	}
+	if err != nil {
+		return err
+	}
+	if size <= maxSize {
+		return nil
+	}
+
+	name := bundle.Definition.Name
+	if name == "" {
+		name = baseDir
+	}
+	return fmt.Errorf("bundle %s is %d bytes after compression which exceeds the max size of %d bytes, "+
+		"split the resources into multiple bundles or reference an external chart", name, size, maxSize)
+}
+
+// sizeBudget counts the size of the resources of a bundle read by OpenStreaming as they are read, which
+// is shared by the directories of the bundle read concurrently
+type sizeBudget struct {
+	lock sync.Mutex
+	size int
+	max  int
+}
+
+// add counts the content of the resource and fails once the resources read so far exceed the max size.
+// Only the content is counted, the exact size of the bundle is checked once it has been read.
+func (s *sizeBudget) add(resource fleet.BundleResource) error {
+	if s == nil {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.size += len(resource.Content)
+	if s.size > s.max {
+		return fmt.Errorf("resources are more than %d bytes after compression which exceeds the max size of %d bytes, "+
+			"split the resources into multiple bundles or reference an external chart", s.size, s.max)
+	}
+	return nil
+}
+
+// streamedSize returns the size of the bundle as sizeOf does, but marshals the content of only one
+// resource at a time
+func streamedSize(bundle *fleet.Bundle) (int, error) {
+	skeleton := bundle.DeepCopy()
+	contentSize := 0
+
+	strip := func(resources []fleet.BundleResource) error {
+		for i := range resources {
+			if resources[i].Content == "" {
+				continue
+			}
+			data, err := json.Marshal(resources[i].Content)
+			if err != nil {
+				return err
+			}
+			// the content field is omitted once stripped, so it is counted with its key and separator
+			contentSize += len(`,"content":`) + len(data)
+			resources[i].Content = ""
+		}
+		return nil
+	}
+
+	if err := strip(skeleton.Spec.Resources); err != nil {
+		return 0, err
+	}
+	for i := range skeleton.Spec.Overlays {
+		if err := strip(skeleton.Spec.Overlays[i].Resources); err != nil {
+			return 0, err
+		}
+	}
+
+	size, err := sizeOf(skeleton)
+	return size + contentSize, err
+}
+
+func checkResourceCount(bundle *Bundle, baseDir string, opts *Options) error {
+	if opts.MaxResources <= 0 {
+		return nil
+	}
+
+	count := len(bundle.Definition.Spec.Resources)
+	for _, overlay := range bundle.Definition.Spec.Overlays {
+		count += len(overlay.Resources)
+	}
+	if count <= opts.MaxResources {
+		return nil
+	}
+
+	name := bundle.Definition.Name
+	if name == "" {
+		name = baseDir
+	}
+	return fmt.Errorf("bundle %s has %d resources which exceeds the max of %d resources, "+
+		"split the resources into multiple bundles", name, count, opts.MaxResources)
 }
 
-func size(bundle *fleet.Bundle) (int, error) {
+func sizeOf(bundle *fleet.Bundle) (int, error) {
 	marshalled, err := json.Marshal(bundle)
 	if err != nil {
 		return 0, err
@@ -91,7 +357,7 @@ func size(bundle *fleet.Bundle) (int, error) {
 	return len(marshalled), nil
 }
 
-func read(ctx context.Context, compress bool, baseDir string, bundleSpecReader io.Reader) (*Bundle, error) {
+func read(ctx context.Context, opts *Options, baseDir string, bundleSpecReader io.Reader) (*Bundle, error) {
 	if baseDir == "" {
 		baseDir = "./"
 	}
@@ -101,6 +367,11 @@ func read(ctx context.Context, compress bool, baseDir string, bundleSpecReader i
 		return nil, err
 	}
 
+	bytes, err = resolveIncludes(opts, baseDir, bytes)
+	if err != nil {
+		return nil, err
+	}
+
 	bundle := &fleet.BundleSpec{}
 	if err := yaml.Unmarshal(bytes, &bundle); err != nil {
 		return nil, err
@@ -111,54 +382,195 @@ func read(ctx context.Context, compress bool, baseDir string, bundleSpecReader i
 		return nil, err
 	}
 
-	setTargetNames(bundle)
+	if err := setTargetNames(bundle); err != nil {
+		return nil, err
+	}
 
-	overlays, err := readOverlays(ctx, meta, bundle, compress, baseDir)
+	overlays, err := readOverlays(ctx, meta, bundle, opts, baseDir)
 	if err != nil {
 		return nil, err
 	}
 
-	resources, err := readResources(ctx, meta, compress, baseDir)
+	resources, err := readResources(ctx, meta, opts, baseDir)
 	if err != nil {
 		return nil, err
 	}
 
-	bundle.Resources = resources
-	assignOverlay(bundle, overlays)
+	resources, err = mergeInlineResources(opts, bundle.Resources, resources)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := renderTemplates(opts, resources, bundle.Values); err != nil {
+		return nil, err
+	}
+	for name, overlayResources := range overlays {
+		if err := renderTemplates(opts, overlayResources, bundle.Values); err != nil {
+			return nil, errors.Wrapf(err, "overlay %s", name)
+		}
+	}
+
+	if err := renameResources(opts, resources); err != nil {
+		return nil, err
+	}
 
-	return New(&fleet.Bundle{
+	if meta.ResolveReferences {
+		for name, overlayResources := range overlays {
+			if err := resolveReferences(opts, overlayResources, resources, meta.ReferenceValues); err != nil {
+				return nil, errors.Wrapf(err, "overlay %s", name)
+			}
+		}
+		if err := resolveReferences(opts, resources, nil, meta.ReferenceValues); err != nil {
+			return nil, err
+		}
+	}
+
+	bundle.Resources, err = assignTaggedResources(bundle, resources, overlays, opts.StrictOverlays)
+	if err != nil {
+		return nil, err
+	}
+	if err := disableOverlays(opts, bundle, overlays); err != nil {
+		return nil, err
+	}
+	if err := validate(bundle, overlays, opts.StrictOverlays); err != nil {
+		return nil, err
+	}
+	undefined := assignOverlay(bundle, overlays)
+
+	for _, target := range bundle.Targets {
+		if _, _, err := overlay.Resolve(bundle, target.Overlays...); err != nil {
+			return nil, errors.Wrapf(err, "target %s", target.Name)
+		}
+	}
+
+	b, err := New(&fleet.Bundle{
 		ObjectMeta: meta.ObjectMeta,
 		Spec:       *bundle,
 	})
+	if err != nil {
+		return nil, err
+	}
+	b.undefinedOverlays = undefined
+	return b, nil
 }
 
-func assignOverlay(bundle *fleet.BundleSpec, overlays map[string][]fleet.BundleResource) {
+// assignOverlay sets the resources read for each overlay and adds the overlays only found on disk. Referenced
+// overlays that are neither declared nor found on disk are added empty and returned, so the references
+// still resolve.
+func assignOverlay(bundle *fleet.BundleSpec, resources map[string][]fleet.BundleResource) (undefined []string) {
 	defined := map[string]bool{}
 	for i := range bundle.Overlays {
 		defined[bundle.Overlays[i].Name] = true
-		bundle.Overlays[i].Resources = overlays[bundle.Overlays[i].Name]
+		bundle.Overlays[i].Resources = resources[bundle.Overlays[i].Name]
 	}
-	for name, resources := range overlays {
-		if defined[name] {
+	for name, overlayResources := range resources {
+		if defined[name] || len(overlayResources) == 0 {
 			continue
 		}
+		defined[name] = true
 		bundle.Overlays = append(bundle.Overlays, fleet.BundleOverlay{
 			Name:      name,
-			Resources: resources,
+			Resources: overlayResources,
+		})
+	}
+	for _, name := range overlays(bundle) {
+		if defined[name] {
+			continue
+		}
+		undefined = append(undefined, name)
+		bundle.Overlays = append(bundle.Overlays, fleet.BundleOverlay{
+			Name: name,
 		})
 	}
 
 	sort.Slice(bundle.Overlays, func(i, j int) bool {
 		return bundle.Overlays[i].Name < bundle.Overlays[j].Name
 	})
+	return
 }
 
-func setTargetNames(spec *fleet.BundleSpec) {
+// disableOverlays removes the overlays in opts.DisabledOverlays and the resources read for them, and the
+// references to them from targets and other overlays. Disabling an overlay that is not defined is an
+// error, so a misspelled name is not silently ignored.
+func disableOverlays(opts *Options, bundle *fleet.BundleSpec, resources map[string][]fleet.BundleResource) error {
+	if len(opts.DisabledOverlays) == 0 {
+		return nil
+	}
+
+	disabled := sets.NewString(opts.DisabledOverlays...)
+	defined := sets.NewString(overlays(bundle)...)
+	for _, overlay := range bundle.Overlays {
+		defined.Insert(overlay.Name)
+	}
+	for name := range resources {
+		defined.Insert(name)
+	}
+	if missing := disabled.Difference(defined); missing.Len() > 0 {
+		return fmt.Errorf("disabled overlays are not defined in the bundle or found on disk: %s",
+			strings.Join(missing.List(), ", "))
+	}
+
+	var enabled []fleet.BundleOverlay
+	for _, overlay := range bundle.Overlays {
+		if disabled.Has(overlay.Name) {
+			continue
+		}
+		overlay.Overlays = withoutNames(overlay.Overlays, disabled)
+		enabled = append(enabled, overlay)
+	}
+	bundle.Overlays = enabled
+
+	for i := range bundle.Targets {
+		bundle.Targets[i].Overlays = withoutNames(bundle.Targets[i].Overlays, disabled)
+	}
+
+	for name := range disabled {
+		delete(resources, name)
+	}
+	return nil
+}
+
+func withoutNames(names []string, remove sets.String) []string {
+	var result []string
+	for _, name := range names {
+		if !remove.Has(name) {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// setTargetNames assigns a name to each unnamed target derived from the content of the target
+// so that generated names do not change when targets are added, removed or reordered.
+func setTargetNames(spec *fleet.BundleSpec) error {
+	names := sets.String{}
+	for _, target := range spec.Targets {
+		if target.Name != "" {
+			names.Insert(target.Name)
+		}
+	}
+
 	for i, target := range spec.Targets {
-		if target.Name == "" {
-			spec.Targets[i].Name = fmt.Sprintf("target%03d", i)
+		if target.Name != "" {
+			continue
+		}
+
+		data, err := json.Marshal(target)
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.Sum256(data)
+		name := "target-" + hex.EncodeToString(hash[:])[:8]
+		for j := 1; names.Has(name); j++ {
+			name = fmt.Sprintf("target-%s-%d", hex.EncodeToString(hash[:])[:8], j)
 		}
+
+		names.Insert(name)
+		spec.Targets[i].Name = name
 	}
+
+	return nil
 }
 
 func overlays(bundle *fleet.BundleSpec) []string {
@@ -178,9 +590,22 @@ func overlays(bundle *fleet.BundleSpec) []string {
 type bundleMeta struct {
 	metav1.ObjectMeta `json:",inline,omitempty"`
 	Manifests         string `json:"manifestsDir,omitempty"`
-	Overlays          string `json:"overlaysDir,omitempty"`
-	Kustomize         string `json:"kustomizeDir,omitempty"`
-	Chart             string `json:"chart,omitempty"`
+	// ManifestsDirs are additional directories of plain yaml read in to manifests/<prefix>, so files of the
+	// same name in different directories do not collide
+	ManifestsDirs []manifestsDir `json:"manifestsDirs,omitempty"`
+	Overlays      string         `json:"overlaysDir,omitempty"`
+	Kustomize     string         `json:"kustomizeDir,omitempty"`
+	Chart         string         `json:"chart,omitempty"`
+	// ResolveReferences enables replacing $(fleet.hash:<resource name>) and $(fleet.value:<key>) in resources
+	// and the resources of overlays
+	ResolveReferences bool              `json:"resolveReferences,omitempty"`
+	ReferenceValues   map[string]string `json:"referenceValues,omitempty"`
+}
+
+type manifestsDir struct {
+	Path string `json:"path,omitempty"`
+	// Prefix is the directory under manifests/ the files are put in, defaults to the last element of Path
+	Prefix string `json:"prefix,omitempty"`
 }
 
 func readMetadata(bytes []byte) (*bundleMeta, error) {
@@ -0,0 +1,525 @@
+package bundle
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/content"
+)
+
+// writeFiles writes the files, keyed by their path relative to dir
+func writeFiles(t testing.TB, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// manifestLines returns n lines of yaml that compress well but are not all the same
+func manifestLines(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "key-%d: value-%d\n", i, i%100)
+	}
+	return b.String()
+}
+
+func TestOpenStreamingMatchesOpen(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml":                       "defaultNamespace: app\n",
+		"manifests/configmap.yaml":         "kind: ConfigMap\n",
+		"manifests/data.yaml":              manifestLines(1000),
+		"overlays/prod/configmap.yaml":     "kind: ConfigMap\nmetadata:\n  name: prod\n",
+		"overlays/prod/values.yaml":        "replicas: 3\n",
+		"manifests/nested/deployment.yaml": "kind: Deployment\n",
+	})
+
+	expected, err := Open(context.Background(), dir, "", &Options{Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := OpenStreaming(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expected.Definition, actual.Definition) {
+		t.Errorf("expected OpenStreaming to read the same bundle as Open, got %+v, expected %+v", actual.Definition.Spec, expected.Definition.Spec)
+	}
+}
+
+func TestStreamedSize(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml":                   "defaultNamespace: app\nresources:\n- name: inline.yaml\n  content: \"kind: ConfigMap\\n\"\n",
+		"manifests/configmap.yaml":     "kind: ConfigMap\n",
+		"overlays/prod/configmap.yaml": "kind: ConfigMap\nmetadata:\n  name: prod\n",
+	})
+
+	for _, compress := range []bool{false, true} {
+		b, err := Open(context.Background(), dir, "", &Options{Compress: compress})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, err := sizeOf(b.Definition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := streamedSize(b.Definition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != expected {
+			t.Errorf("compress %v: expected size %d, got %d", compress, expected, actual)
+		}
+	}
+}
+
+func TestOpenStreamingMaxSize(t *testing.T) {
+	random := make([]byte, 64*1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"fleet.yaml": "defaultNamespace: app\n",
+	}
+	for i := 0; i < 4; i++ {
+		files[fmt.Sprintf("manifests/data-%d.bin", i)] = string(random)
+	}
+	writeFiles(t, dir, files)
+
+	_, err := OpenStreaming(context.Background(), dir, "", &Options{MaxBundleSize: 128 * 1024})
+	if err == nil || !strings.Contains(err.Error(), "exceeds the max size of 131072 bytes") {
+		t.Fatalf("expected the bundle to exceed the max size, got %v", err)
+	}
+
+	if _, err := OpenStreaming(context.Background(), dir, "", &Options{MaxBundleSize: 1024 * 1024}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenMaxSize(t *testing.T) {
+	random := make([]byte, 64*1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml":               "name: big\n",
+		"manifests/data.bin":       string(random),
+		"manifests/configmap.yaml": "kind: ConfigMap\n",
+	})
+
+	for _, compress := range []bool{false, true} {
+		_, err := Open(context.Background(), dir, "", &Options{Compress: compress, MaxBundleSize: 32 * 1024})
+		if err == nil {
+			t.Fatalf("compress %v: expected the bundle to exceed the max size", compress)
+		}
+		for _, message := range []string{"bundle big is ", " bytes after compression which exceeds the max size of 32768 bytes", "split the resources"} {
+			if !strings.Contains(err.Error(), message) {
+				t.Errorf("compress %v: expected an error containing %q, got %v", compress, message, err)
+			}
+		}
+	}
+
+	if _, err := Open(context.Background(), dir, "", nil); err != nil {
+		t.Errorf("expected the bundle to be under the default max size, got %v", err)
+	}
+}
+
+func TestOpenBundleFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     []string
+		opts      *Options
+		namespace string
+		err       string
+	}{
+		{name: "fleet.yaml", files: []string{"fleet.yaml"}, namespace: "fleet.yaml"},
+		{name: "bundle.yaml", files: []string{"bundle.yaml"}, namespace: "bundle.yaml"},
+		{name: "fleet.yaml first", files: []string{"fleet.yaml", "bundle.yaml"}, namespace: "fleet.yaml"},
+		{
+			name:      "custom order",
+			files:     []string{"fleet.yaml", "bundle.yaml"},
+			opts:      &Options{BundleFiles: []string{"bundle.yaml", "fleet.yaml"}},
+			namespace: "bundle.yaml",
+		},
+		{
+			name:      "custom name",
+			files:     []string{"fleet.yaml", "app.yaml"},
+			opts:      &Options{BundleFiles: []string{"app.yaml"}},
+			namespace: "app.yaml",
+		},
+		{name: "none", err: "tried fleet.yaml, bundle.yaml: no bundle file found"},
+		{
+			name:  "none of the custom names",
+			files: []string{"fleet.yaml"},
+			opts:  &Options{BundleFiles: []string{"app.yaml"}},
+			err:   "tried app.yaml: no bundle file found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]string{"manifests/configmap.yaml": "kind: ConfigMap\n"}
+			for _, name := range test.files {
+				// the default namespace records which file was read
+				files[name] = "namespace: " + name + "\n"
+			}
+			writeFiles(t, dir, files)
+
+			b, err := Open(context.Background(), dir, "", test.opts)
+			if test.err != "" {
+				if !errors.Is(err, ErrNoBundleFile) || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected an error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := b.Definition.Spec.DefaultNamespace; actual != test.namespace {
+				t.Errorf("expected %s to be read, got %s", test.namespace, actual)
+			}
+		})
+	}
+}
+
+func TestUndefinedOverlays(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml": `targets:
+- name: prod
+  clusterGroup: prod
+  overlays: [prodution]
+- name: dev
+  clusterGroup: dev
+  overlays: [dev]
+`,
+		"manifests/configmap.yaml": "kind: ConfigMap\n",
+		"overlays/dev/values.yaml": "replicas: 1\n",
+	})
+
+	_, err := Open(context.Background(), dir, "", &Options{StrictOverlays: true})
+	if err == nil || !strings.Contains(err.Error(), "referenced overlays are not defined in the bundle or found on disk: prodution") {
+		t.Fatalf("expected an error listing the undefined overlay, got %v", err)
+	}
+
+	b, err := Open(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, overlay := range b.Definition.Spec.Overlays {
+		names = append(names, overlay.Name)
+		if overlay.Name == "prodution" && len(overlay.Resources) > 0 {
+			t.Errorf("expected the undefined overlay to be empty, got %v", overlay.Resources)
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"dev", "prodution"}) {
+		t.Errorf("expected overlays dev and prodution, got %v", names)
+	}
+
+	warnings := Lint(b)
+	if !hasWarning(warnings, "overlay prodution", "is referenced but not defined in the bundle or found on disk, it is empty") {
+		t.Errorf("expected a warning about the undefined overlay, got %v", warnings)
+	}
+	if hasWarning(warnings, "overlay prodution", "overrides nothing") {
+		t.Errorf("expected the undefined overlay to only be warned about once, got %v", warnings)
+	}
+}
+
+// benchmarkBundleSize is the size of the bundle directory read by the Open benchmarks
+const benchmarkBundleSize = 256 << 20
+
+// benchmarkBundleDir writes a bundle of about benchmarkBundleSize bytes of manifests in 4MB files
+func benchmarkBundleDir(b *testing.B) string {
+	b.Helper()
+
+	const fileSize = 4 << 20
+	var (
+		dir   = b.TempDir()
+		data  = manifestLines(fileSize / len("key-100000: value-00\n"))
+		files = map[string]string{
+			"fleet.yaml": "defaultNamespace: app\n",
+		}
+	)
+	for i := 0; i < benchmarkBundleSize/fileSize; i++ {
+		files[fmt.Sprintf("manifests/data-%03d.yaml", i)] = data
+	}
+	writeFiles(b, dir, files)
+	return dir
+}
+
+func benchmarkOpen(b *testing.B, open func(ctx context.Context, baseDir, file string, opts *Options) (*Bundle, error)) {
+	dir := benchmarkBundleDir(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := open(context.Background(), dir, "", &Options{MaxBundleSize: 1 << 30}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkOpen(b *testing.B) {
+	benchmarkOpen(b, Open)
+}
+
+func BenchmarkOpenStreaming(b *testing.B) {
+	benchmarkOpen(b, OpenStreaming)
+}
+
+func TestSetTargetNames(t *testing.T) {
+	targets := []fleet.BundleTarget{
+		{Name: "prod", ClusterGroup: "prod"},
+		{ClusterGroup: "dev"},
+		{ClusterGroup: "test"},
+	}
+
+	names := func(targets []fleet.BundleTarget) []string {
+		spec := &fleet.BundleSpec{Targets: append([]fleet.BundleTarget{}, targets...)}
+		if err := setTargetNames(spec); err != nil {
+			t.Fatal(err)
+		}
+		var result []string
+		for _, target := range spec.Targets {
+			result = append(result, target.Name)
+		}
+		return result
+	}
+
+	before := names(targets)
+	if before[0] != "prod" || !strings.HasPrefix(before[1], "target-") || before[1] == before[2] {
+		t.Fatalf("expected the named target to keep its name and distinct generated names, got %v", before)
+	}
+
+	// inserting a target before the unnamed targets and reordering them does not change their names
+	after := names([]fleet.BundleTarget{
+		{ClusterGroup: "staging"},
+		targets[2],
+		targets[0],
+		targets[1],
+	})
+	if after[1] != before[2] || after[2] != "prod" || after[3] != before[1] {
+		t.Errorf("expected unnamed targets to keep their generated names, got %v, before %v", after, before)
+	}
+
+	// identical unnamed targets get distinct names
+	duplicates := names([]fleet.BundleTarget{targets[1], targets[1]})
+	if duplicates[0] != before[1] || duplicates[1] != before[1]+"-1" {
+		t.Errorf("expected identical targets to get distinct names, got %v", duplicates)
+	}
+
+	// a generated name taken by a named target is not reused
+	taken := names([]fleet.BundleTarget{targets[1], {Name: before[1], ClusterGroup: "other"}})
+	if taken[0] == before[1] {
+		t.Errorf("expected a generated name not to collide with a named target, got %v", taken)
+	}
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml":                   "namespace: app\ntargets:\n- clusterGroup: prod\n  overlays: [prod]\n",
+		"manifests/configmap.yaml":     "kind: ConfigMap\n",
+		"manifests/data.yaml":          manifestLines(100),
+		"overlays/prod/configmap.yaml": "kind: ConfigMap\nmetadata:\n  name: prod\n",
+	})
+
+	expected, err := Open(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for algorithm, encoding := range map[string]string{"": "base64+gz", "gzip": "base64+gz", "zstd": "base64+zstd"} {
+		b, err := Open(context.Background(), dir, "", &Options{Compress: true, CompressionAlgorithm: algorithm})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, resource := range b.Definition.Spec.Resources {
+			if resource.Encoding != encoding {
+				t.Errorf("%q: expected %s to be encoded as %s, got %s", algorithm, resource.Name, encoding, resource.Encoding)
+			}
+		}
+		if actual := resourceContents(t, b.Definition.Spec.Resources); !reflect.DeepEqual(actual, resourceContents(t, expected.Definition.Spec.Resources)) {
+			t.Errorf("%q: expected the decoded resources to equal the uncompressed resources, got %v", algorithm, actual)
+		}
+		if actual := resourceContents(t, b.Definition.Spec.Overlays[0].Resources); actual["configmap.yaml"] != "kind: ConfigMap\nmetadata:\n  name: prod\n" {
+			t.Errorf("%q: expected the decoded overlay resources to equal the original, got %v", algorithm, actual)
+		}
+	}
+
+	_, err = Open(context.Background(), dir, "", &Options{Compress: true, CompressionAlgorithm: "lz4"})
+	if err == nil || !strings.Contains(err.Error(), "unsupported compression algorithm lz4") {
+		t.Errorf("expected an unsupported algorithm to fail, got %v", err)
+	}
+}
+
+func TestOpenStdin(t *testing.T) {
+	const bundleFile = "namespace: piped\n"
+	gz, err := content.Gzip([]byte(bundleFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"manifests/configmap.yaml": "kind: ConfigMap\n",
+		"stdin.yaml":               bundleFile,
+		"stdin.yaml.gz":            string(gz),
+	})
+
+	defer func(stdin *os.File) { os.Stdin = stdin }(os.Stdin)
+	for _, name := range []string{"stdin.yaml", "stdin.yaml.gz"} {
+		stdin, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdin = stdin
+
+		b, err := Open(context.Background(), dir, "-", nil)
+		stdin.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if b.Definition.Spec.DefaultNamespace != "piped" {
+			t.Errorf("%s: expected the bundle piped to stdin to be read, got namespace %q", name, b.Definition.Spec.DefaultNamespace)
+		}
+		if len(b.Definition.Spec.Resources) != 1 {
+			t.Errorf("%s: expected the resources of the base dir, got %v", name, b.Definition.Spec.Resources)
+		}
+	}
+}
+
+func TestDisabledOverlays(t *testing.T) {
+	files := map[string]string{
+		"fleet.yaml": `overlays:
+- name: prod
+  overlays: [monitoring]
+targets:
+- name: prod
+  clusterGroup: prod
+  overlays: [monitoring, prod]
+`,
+		"manifests/configmap.yaml":                "kind: ConfigMap\n",
+		"overlays/prod/configmap.yaml":            "kind: ConfigMap\nprod: true\n",
+		"overlays/monitoring/servicemonitor.yaml": "kind: ServiceMonitor\n",
+	}
+	open := func(t *testing.T, files map[string]string, disabled ...string) (*Bundle, error) {
+		t.Helper()
+		dir := t.TempDir()
+		writeFiles(t, dir, files)
+		return Open(context.Background(), dir, "", &Options{DisabledOverlays: disabled})
+	}
+
+	b, err := open(t, files, "monitoring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := b.Definition.Spec
+	for _, overlay := range spec.Overlays {
+		if overlay.Name == "monitoring" {
+			t.Errorf("expected the disabled overlay to be left out, got %+v", overlay)
+		}
+		if overlay.Name == "prod" && len(overlay.Overlays) != 0 {
+			t.Errorf("expected the reference to the disabled overlay to be dropped, got %v", overlay.Overlays)
+		}
+	}
+	if !reflect.DeepEqual(spec.Targets[0].Overlays, []string{"prod"}) {
+		t.Errorf("expected the target to reference only the enabled overlay, got %v", spec.Targets[0].Overlays)
+	}
+
+	// the bundle is the same as one without the optional overlay
+	without := map[string]string{
+		"fleet.yaml": `overlays:
+- name: prod
+targets:
+- name: prod
+  clusterGroup: prod
+  overlays: [prod]
+`,
+		"manifests/configmap.yaml":     files["manifests/configmap.yaml"],
+		"overlays/prod/configmap.yaml": files["overlays/prod/configmap.yaml"],
+	}
+	expected, err := open(t, without)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetContent := func(b *Bundle) string {
+		return contentID(t, b, b.Definition.Spec.Targets[0].Overlays...)
+	}
+	if targetContent(b) != targetContent(expected) {
+		t.Error("expected the disabled overlay not to change the bundle content")
+	}
+	enabled, err := open(t, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if targetContent(enabled) == targetContent(b) {
+		t.Error("expected the enabled overlay to be part of the bundle content")
+	}
+
+	if _, err := open(t, files, "monitorng"); err == nil ||
+		!strings.Contains(err.Error(), "disabled overlays are not defined in the bundle or found on disk: monitorng") {
+		t.Errorf("expected disabling an undefined overlay to fail, got %v", err)
+	}
+}
+
+func TestOpenMaxResources(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml":                   "name: app\ntargets:\n- clusterGroup: prod\n  overlays: [prod]\n",
+		"manifests/configmap.yaml":     "kind: ConfigMap\n",
+		"manifests/secret.yaml":        "kind: Secret\n",
+		"overlays/prod/configmap.yaml": "kind: ConfigMap\n",
+	})
+
+	tests := []struct {
+		maxResources int
+		err          string
+	}{
+		{maxResources: 0},
+		{maxResources: 3},
+		// the resources of overlays count towards the limit
+		{maxResources: 2, err: "bundle app has 3 resources which exceeds the max of 2 resources"},
+	}
+
+	for _, test := range tests {
+		_, err := Open(context.Background(), dir, "", &Options{MaxResources: test.maxResources})
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("max %d: expected the bundle to be read, got %v", test.maxResources, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("max %d: expected an error containing %q, got %v", test.maxResources, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package bundle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/content"
+)
+
+// refPattern matches $(fleet.hash:<resource name>) and $(fleet.value:<key>)
+var refPattern = regexp.MustCompile(`\$\(fleet\.(hash|value):([^)]+)\)`)
+
+// resolveReferences replaces references in the content of the resources. $(fleet.hash:<resource name>)
+// is replaced with the sha256 of the named resource, such as manifests/configmap.yaml, and
+// $(fleet.value:<key>) with the value of the key in values. References are resolved against the
+// content as read, so they are not recursive. A reference that can not be resolved is an error.
+//
+// Hash references are resolved against the resources followed by base, the first resource of a name wins,
+// so a resource of an overlay can reference a resource of the bundle and the resources the overlay
+// replaces are hashed as the overlay deploys them.
+func resolveReferences(opts *Options, resources, base []fleet.BundleResource, values map[string]string) error {
+	decoded := map[string][]byte{}
+	for _, resource := range append(append([]fleet.BundleResource{}, resources...), base...) {
+		if _, ok := decoded[resource.Name]; ok {
+			continue
+		}
+		data, err := content.Decode(resource.Content, resource.Encoding)
+		if err != nil {
+			return err
+		}
+		decoded[resource.Name] = data
+	}
+
+	for i, resource := range resources {
+		data := decoded[resource.Name]
+		if !refPattern.Match(data) {
+			continue
+		}
+
+		var errs ValidationErrors
+		data = refPattern.ReplaceAllFunc(data, func(ref []byte) []byte {
+			match := refPattern.FindSubmatch(ref)
+			kind, key := string(match[1]), string(match[2])
+			switch kind {
+			case "hash":
+				if target, ok := decoded[key]; ok {
+					return []byte(resourceHash(target))
+				}
+			case "value":
+				if value, ok := values[key]; ok {
+					return []byte(value)
+				}
+			}
+			errs = append(errs, fmt.Errorf("%s: unresolved reference %s", resource.Name, ref))
+			return ref
+		})
+		if err := errs.err(); err != nil {
+			return err
+		}
+
+		if resource.Encoding == "" {
+			resources[i].Content = string(data)
+			continue
+		}
+
+		c, encoding, err := content.Encode(data, opts.CompressionAlgorithm)
+		if err != nil {
+			return err
+		}
+		resources[i].Content = c
+		resources[i].Encoding = encoding
+	}
+
+	return nil
+}
+
+// resourceHash returns the hex encoded sha256 of the decoded content of a resource
+func resourceHash(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,119 @@
+package bundle
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const refsBundle = `resolveReferences: true
+referenceValues:
+  release: v1
+targets:
+- name: prod
+  clusterGroup: prod
+  overlays: [prod]
+`
+
+func TestResolveReferences(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml":                             refsBundle,
+		"manifests/configmap.yaml":               "kind: ConfigMap\ndata: {}\n",
+		"manifests/deployment.yaml":              "config: $(fleet.hash:manifests/configmap.yaml)\nrelease: $(fleet.value:release)\n",
+		"overlays/prod/manifests/configmap.yaml": "kind: ConfigMap\ndata: {env: prod}\n",
+		"overlays/prod/deployment.yaml":          "config: $(fleet.hash:manifests/configmap.yaml)\nrelease: $(fleet.value:release)\n",
+	})
+
+	b, err := Open(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resources := resourceContents(t, b.Definition.Spec.Resources)
+	baseHash := resourceHash([]byte("kind: ConfigMap\ndata: {}\n"))
+	if expected := "config: " + baseHash + "\nrelease: v1\n"; resources["manifests/deployment.yaml"] != expected {
+		t.Errorf("expected %q, got %q", expected, resources["manifests/deployment.yaml"])
+	}
+
+	var overlay map[string]string
+	for _, o := range b.Definition.Spec.Overlays {
+		if o.Name == "prod" {
+			overlay = resourceContents(t, o.Resources)
+		}
+	}
+	prodHash := resourceHash([]byte("kind: ConfigMap\ndata: {env: prod}\n"))
+	if expected := "config: " + prodHash + "\nrelease: v1\n"; overlay["deployment.yaml"] != expected {
+		t.Errorf("expected the overlay to hash the resource it replaces, %q, got %q", expected, overlay["deployment.yaml"])
+	}
+
+	// changing the referenced resource changes the resource referencing it
+	writeFiles(t, dir, map[string]string{"manifests/configmap.yaml": "kind: ConfigMap\ndata: {key: value}\n"})
+	b, err = Open(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := resourceContents(t, b.Definition.Spec.Resources)["manifests/deployment.yaml"]; strings.Contains(actual, baseHash) {
+		t.Errorf("expected the hash to change with the referenced resource, got %q", actual)
+	}
+}
+
+func TestResolveReferencesError(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		message string
+	}{
+		{
+			name: "dangling hash",
+			files: map[string]string{
+				"manifests/deployment.yaml": "config: $(fleet.hash:manifests/missing.yaml)\n",
+			},
+			message: "manifests/deployment.yaml: unresolved reference $(fleet.hash:manifests/missing.yaml)",
+		},
+		{
+			name: "dangling value",
+			files: map[string]string{
+				"manifests/deployment.yaml": "release: $(fleet.value:missing)\n",
+			},
+			message: "manifests/deployment.yaml: unresolved reference $(fleet.value:missing)",
+		},
+		{
+			name: "dangling in overlay",
+			files: map[string]string{
+				"manifests/deployment.yaml":     "kind: Deployment\n",
+				"overlays/prod/deployment.yaml": "config: $(fleet.hash:missing.yaml)\n",
+			},
+			message: "overlay prod: invalid bundle: deployment.yaml: unresolved reference $(fleet.hash:missing.yaml)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			test.files["fleet.yaml"] = refsBundle
+			writeFiles(t, dir, test.files)
+
+			_, err := Open(context.Background(), dir, "", nil)
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Errorf("expected an error containing %q, got %v", test.message, err)
+			}
+		})
+	}
+}
+
+func TestReferencesNotResolvedByDefault(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml":                "{}\n",
+		"manifests/deployment.yaml": "config: $(fleet.hash:manifests/missing.yaml)\n",
+	})
+
+	b, err := Open(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := resourceContents(t, b.Definition.Spec.Resources)["manifests/deployment.yaml"]; !strings.Contains(actual, "$(fleet.hash:") {
+		t.Errorf("expected references to be left as is unless resolveReferences is set, got %q", actual)
+	}
+}
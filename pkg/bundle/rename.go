@@ -0,0 +1,102 @@
+package bundle
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/content"
+	"github.com/rancher/wrangler/pkg/name"
+	"github.com/rancher/wrangler/pkg/yaml"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// maxNameLength is the length names are limited to, the limit of names that must be DNS labels such as services
+const maxNameLength = 63
+
+// renameResources adds the NamePrefix and NameSuffix of opts to the name of every object in the plain yaml
+// files of the manifests directory, so the same bundle can be deployed more than once side by side. Names
+// that become too long are shortened with a hash. Only the names of the objects and the namespace of
+// objects in a renamed Namespace are changed, other references between objects are not. Files that can
+// not be parsed, such as files with Helm templating, are left as is.
+func renameResources(opts *Options, resources []fleet.BundleResource) error {
+	if opts.NamePrefix == "" && opts.NameSuffix == "" {
+		return nil
+	}
+
+	for i, resource := range resources {
+		if !strings.HasPrefix(resource.Name, ManifestsDir+"/") {
+			continue
+		}
+		switch filepath.Ext(resource.Name) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+
+		data, err := content.Decode(resource.Content, resource.Encoding)
+		if err != nil {
+			return err
+		}
+
+		objs, err := yaml.ToObjects(bytes.NewBuffer(data))
+		if err != nil || len(objs) == 0 {
+			continue
+		}
+
+		if err := renameObjects(opts, objs); err != nil {
+			return err
+		}
+
+		data, err = yaml.ToBytes(objs)
+		if err != nil {
+			return err
+		}
+
+		if resource.Encoding == "" {
+			resources[i].Content = string(data)
+			continue
+		}
+
+		c, encoding, err := content.Encode(data, opts.CompressionAlgorithm)
+		if err != nil {
+			return err
+		}
+		resources[i].Content = c
+		resources[i].Encoding = encoding
+	}
+
+	return nil
+}
+
+func renameObjects(opts *Options, objs []runtime.Object) error {
+	namespaces := map[string]string{}
+	for _, obj := range objs {
+		m, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		if m.GetName() == "" {
+			continue
+		}
+		newName := name.Limit(opts.NamePrefix+m.GetName()+opts.NameSuffix, maxNameLength)
+		if obj.GetObjectKind().GroupVersionKind().Kind == "Namespace" {
+			namespaces[m.GetName()] = newName
+		}
+		m.SetName(newName)
+	}
+
+	for _, obj := range objs {
+		m, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		if newName, ok := namespaces[m.GetNamespace()]; ok {
+			m.SetNamespace(newName)
+		}
+	}
+
+	return nil
+}
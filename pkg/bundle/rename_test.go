@@ -0,0 +1,93 @@
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rancher/wrangler/pkg/yaml"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+const renameManifests = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: app
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: app
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: a-service-with-a-name-that-is-already-close-to-the-limit-of-63
+  namespace: other
+`
+
+// openRenamed reads a bundle with the prefix and suffix and returns the names of the objects in its manifest
+// as namespace/name, along with the ID of the bundle content
+func openRenamed(t *testing.T, prefix, suffix string) ([]string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml":         "{}\n",
+		"manifests/app.yaml": renameManifests,
+	})
+
+	b, err := Open(context.Background(), dir, "", &Options{NamePrefix: prefix, NameSuffix: suffix})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, resource := range b.Definition.Spec.Resources {
+		if resource.Name != "manifests/app.yaml" {
+			continue
+		}
+		objs, err := yaml.ToObjects(bytes.NewBufferString(resource.Content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range objs {
+			m, err := meta.Accessor(obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, m.GetNamespace()+"/"+m.GetName())
+		}
+	}
+
+	return names, contentID(t, b)
+}
+
+func TestRenameResources(t *testing.T) {
+	names, blue := openRenamed(t, "blue-", "-v1")
+	if len(names) != 3 {
+		t.Fatalf("expected 3 objects, got %v", names)
+	}
+	if names[0] != "/blue-app-v1" {
+		t.Errorf("expected the namespace to be renamed, got %s", names[0])
+	}
+	// an object in a renamed namespace moves with it
+	if names[1] != "blue-app-v1/blue-config-v1" {
+		t.Errorf("expected the config map to be renamed in the renamed namespace, got %s", names[1])
+	}
+	service := strings.TrimPrefix(names[2], "other/")
+	if !strings.HasPrefix(service, "blue-") || len(service) > maxNameLength {
+		t.Errorf("expected the service to be renamed within %d characters, got %s", maxNameLength, names[2])
+	}
+
+	_, blueAgain := openRenamed(t, "blue-", "-v1")
+	_, green := openRenamed(t, "green-", "-v1")
+	_, plain := openRenamed(t, "", "")
+	if blue != blueAgain {
+		t.Errorf("expected the same content for the same prefix, got %s and %s", blue, blueAgain)
+	}
+	if blue == green || blue == plain {
+		t.Errorf("expected distinct content for each prefix, got %s, %s and %s", blue, green, plain)
+	}
+}
@@ -3,11 +3,13 @@ package bundle
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -18,6 +20,7 @@ import (
 	"github.com/rancher/fleet/pkg/content"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/semaphore"
+	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 const (
@@ -27,7 +30,7 @@ const (
 	Overlays     = "overlays"
 )
 
-func readOverlays(ctx context.Context, meta *bundleMeta, bundle *fleet.BundleSpec, compress bool, base string) (map[string][]fleet.BundleResource, error) {
+func readOverlays(ctx context.Context, meta *bundleMeta, bundle *fleet.BundleSpec, opts *Options, base string) (map[string][]fleet.BundleResource, error) {
 	var directories []directory
 
 	overlayDir := meta.Overlays
@@ -35,7 +38,12 @@ func readOverlays(ctx context.Context, meta *bundleMeta, bundle *fleet.BundleSpe
 		overlayDir = Overlays
 	}
 
-	for _, overlay := range overlays(bundle) {
+	names, err := labelOverlays(opts, filepath.Join(base, overlayDir))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, overlay := range sets.NewString(overlays(bundle)...).Insert(names...).List() {
 		directories = append(directories, directory{
 			base: base,
 			path: filepath.Join(overlayDir, overlay),
@@ -43,38 +51,215 @@ func readOverlays(ctx context.Context, meta *bundleMeta, bundle *fleet.BundleSpe
 		})
 	}
 
-	return readDirectories(ctx, compress, directories...)
+	return readDirectories(ctx, opts, directories...)
 }
 
-func readResources(ctx context.Context, meta *bundleMeta, compress bool, base string) ([]fleet.BundleResource, error) {
+// labelOverlays returns the overlay directories named key=value, which are applied automatically to
+// clusters with that label
+func labelOverlays(opts *Options, dir string) ([]string, error) {
+	files, err := readDir(opts, dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, file := range files {
+		if file.IsDir() && isLabelOverlay(file.Name()) {
+			result = append(result, file.Name())
+		}
+	}
+	return result, nil
+}
+
+func isLabelOverlay(name string) bool {
+	return strings.Contains(name, "=")
+}
+
+func readResources(ctx context.Context, meta *bundleMeta, opts *Options, base string) ([]fleet.BundleResource, error) {
 	var directories []directory
 
-	directories, err := addDirectory(directories, base, meta.Manifests, ManifestsDir)
+	directories, err := addDirectory(opts, directories, base, meta.Manifests, ManifestsDir)
 	if err != nil {
 		return nil, err
 	}
 
-	directories, err = addDirectory(directories, base, meta.Chart, ChartDir)
+	directories, err = addDirectory(opts, directories, base, meta.Chart, ChartDir)
 	if err != nil {
 		return nil, err
 	}
 
-	directories, err = addDirectory(directories, base, meta.Kustomize, KustomizeDir)
+	directories, err = addDirectory(opts, directories, base, meta.Kustomize, KustomizeDir)
 	if err != nil {
 		return nil, err
 	}
 
-	resources, err := readDirectories(ctx, compress, directories...)
+	directories, prefixed, err := addManifestsDirs(directories, base, meta.ManifestsDirs)
+	if err != nil {
+		return nil, err
+	}
+
+	resources, err := readDirectories(ctx, opts, directories...)
 	if err != nil {
 		return nil, err
 	}
 
 	result := stripChartPrefix(resources[ChartDir])
 	result = append(result, resources[ManifestsDir]...)
+	for _, key := range prefixed {
+		result = append(result, resources[key]...)
+	}
 	result = append(result, resources[KustomizeDir]...)
 	return result, nil
 }
 
+// addManifestsDirs adds a directory for each of dirs read in to manifests/<prefix> and returns their keys
+// sorted, so the order of the resources does not depend on the order the directories are listed in
+func addManifestsDirs(directories []directory, base string, dirs []manifestsDir) ([]directory, []string, error) {
+	keys := sets.NewString()
+	for _, dir := range dirs {
+		if dir.Path == "" {
+			return nil, nil, errors.New("manifestsDirs entry is missing a path")
+		}
+		prefix := dir.Prefix
+		if prefix == "" {
+			prefix = filepath.Base(filepath.Clean(dir.Path))
+		}
+
+		key := filepath.Join(ManifestsDir, prefix)
+		if keys.Has(key) {
+			return nil, nil, fmt.Errorf("manifestsDirs prefix %s is used more than once", prefix)
+		}
+		keys.Insert(key)
+
+		directories = append(directories, directory{
+			prefix: key,
+			base:   base,
+			path:   dir.Path,
+			key:    key,
+		})
+	}
+	return directories, keys.List(), nil
+}
+
+// overlayTag returns the resource name without the tag and the overlay for a file of the manifests
+// directory tagged with the name of an overlay, such as manifests/configmap@prod.yaml. Files of charts and
+// kustomize directories are never tagged.
+func overlayTag(name string) (string, string, bool) {
+	if !strings.HasPrefix(filepath.ToSlash(name), ManifestsDir+"/") {
+		return "", "", false
+	}
+	dir, base := filepath.Split(name)
+	ext := filepath.Ext(base)
+	i := strings.LastIndex(base, "@")
+	if i <= 0 || i+1 >= len(base)-len(ext) {
+		return "", "", false
+	}
+	return dir + base[:i] + ext, base[i+1 : len(base)-len(ext)], true
+}
+
+// assignTaggedResources moves the resources tagged with an overlay name from the base resources to the
+// resources of that overlay, so the file is only included for targets using the overlay. A file of the
+// same name in the overlay directory takes precedence over the tagged file. Only overlays defined in the
+// bundle or found on disk are tagged, a file tagged with any other name is an error if strictOverlays is
+// set, otherwise it stays in the base resources and Lint warns about it.
+func assignTaggedResources(spec *fleet.BundleSpec, resources []fleet.BundleResource, overlays map[string][]fleet.BundleResource, strictOverlays bool) ([]fleet.BundleResource, error) {
+	defined := map[string]bool{}
+	for _, overlay := range spec.Overlays {
+		defined[overlay.Name] = true
+	}
+	for name := range overlays {
+		defined[name] = true
+	}
+
+	var (
+		result  []fleet.BundleResource
+		unknown []string
+	)
+	for _, resource := range resources {
+		name, overlay, ok := overlayTag(resource.Name)
+		if ok && !defined[overlay] {
+			unknown = append(unknown, resource.Name)
+			ok = false
+		}
+		if !ok {
+			result = append(result, resource)
+			continue
+		}
+
+		exists := false
+		for _, existing := range overlays[overlay] {
+			if existing.Name == name {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			resource.Name = name
+			overlays[overlay] = append(overlays[overlay], resource)
+		}
+	}
+
+	if strictOverlays && len(unknown) > 0 {
+		return nil, fmt.Errorf("resources are tagged with overlays that are not defined in the bundle or found on disk: %s",
+			strings.Join(unknown, ", "))
+	}
+	return result, nil
+}
+
+// mergeInlineResources merges the resources defined in the bundle file with the resources read from
+// disk. If both define a resource of the same name the inline resource is used in its place, or with
+// StrictResourceNames it is an error. Inline resources are encoded like resources read from disk.
+func mergeInlineResources(opts *Options, inline, resources []fleet.BundleResource) ([]fleet.BundleResource, error) {
+	if len(inline) == 0 {
+		return resources, nil
+	}
+
+	byName := map[string]int{}
+	for i, resource := range inline {
+		if resource.Name == "" {
+			return nil, fmt.Errorf("inline resource %d is missing a name", i)
+		}
+		byName[resource.Name] = i
+
+		if resource.Encoding != "" || !(opts.Compress || strings.ContainsRune(resource.Content, 0x0)) {
+			continue
+		}
+		content, encoding, err := content.Encode([]byte(resource.Content), opts.CompressionAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		inline[i].Content = content
+		inline[i].Encoding = encoding
+	}
+
+	var (
+		result   []fleet.BundleResource
+		replaced = map[string]bool{}
+	)
+	for _, resource := range resources {
+		i, ok := byName[resource.Name]
+		if !ok {
+			result = append(result, resource)
+			continue
+		}
+		if opts.StrictResourceNames {
+			return nil, fmt.Errorf("resource %s is defined inline and on disk", resource.Name)
+		}
+		result = append(result, inline[i])
+		replaced[resource.Name] = true
+	}
+
+	// inline resources not replacing a file are added after the resources read from disk
+	for _, resource := range inline {
+		if !replaced[resource.Name] {
+			result = append(result, resource)
+		}
+	}
+	return result, nil
+}
+
 func stripChartPrefix(resources []fleet.BundleResource) []fleet.BundleResource {
 	chart := ""
 	for _, resource := range resources {
@@ -102,15 +287,16 @@ func stripChartPrefix(resources []fleet.BundleResource) []fleet.BundleResource {
 			Name:     filepath.Join(ChartDir, strings.TrimPrefix(resource.Name, prefix)),
 			Content:  resource.Content,
 			Encoding: resource.Encoding,
+			Mode:     resource.Mode,
 		})
 	}
 
 	return newResources
 }
 
-func addDirectory(directories []directory, base, customDir, defaultDir string) ([]directory, error) {
+func addDirectory(opts *Options, directories []directory, base, customDir, defaultDir string) ([]directory, error) {
 	if customDir == "" {
-		if _, err := os.Stat(filepath.Join(base, defaultDir)); os.IsNotExist(err) {
+		if _, err := stat(opts, filepath.Join(base, defaultDir)); os.IsNotExist(err) {
 			return directories, nil
 		} else if err != nil {
 			return directories, err
@@ -133,7 +319,7 @@ type directory struct {
 	key    string
 }
 
-func readDirectories(ctx context.Context, compress bool, directories ...directory) (map[string][]fleet.BundleResource, error) {
+func readDirectories(ctx context.Context, opts *Options, directories ...directory) (map[string][]fleet.BundleResource, error) {
 	var (
 		sem    = semaphore.NewWeighted(4)
 		result = map[string][]fleet.BundleResource{}
@@ -151,7 +337,7 @@ func readDirectories(ctx context.Context, compress bool, directories ...director
 		dir := dir
 		eg.Go(func() error {
 			defer sem.Release(1)
-			resources, err := readDirectory(ctx, p, compress, dir.prefix, dir.base, dir.path)
+			resources, err := readDirectory(ctx, p, opts, dir.prefix, dir.base, dir.path)
 			if err != nil {
 				return err
 			}
@@ -171,52 +357,106 @@ func readDirectories(ctx context.Context, compress bool, directories ...director
 	return result, eg.Wait()
 }
 
-func readDirectory(ctx context.Context, progress *progress.Progress, compress bool, prefix, base, name string) ([]fleet.BundleResource, error) {
+func readDirectory(ctx context.Context, progress *progress.Progress, opts *Options, prefix, base, name string) ([]fleet.BundleResource, error) {
 	var resources []fleet.BundleResource
 
-	files, err := readContent(ctx, progress, base, name)
-	if err != nil {
-		return nil, err
-	}
-
-	for k := range files {
-		resources = append(resources, fleet.BundleResource{
-			Name: k,
-		})
-	}
-
-	for i, resource := range resources {
-		data := files[resource.Name]
-		if compress || hasZero(data) {
-			content, err := content.Base64GZ(files[resource.Name])
+	// each file is encoded as soon as it is read so only one file is held unencoded in memory
+	err := readContent(ctx, progress, opts, base, name, func(name string, mode os.FileMode, data []byte) error {
+		resource := fleet.BundleResource{
+			Name: name,
+		}
+		if mode&0111 != 0 {
+			resource.Mode = int32(mode.Perm())
+		}
+		if opts.Compress || hasZero(data) {
+			content, encoding, err := content.Encode(data, opts.CompressionAlgorithm)
 			if err != nil {
-				return nil, err
+				return err
 			}
-			resources[i].Content = content
-			resources[i].Encoding = "base64+gz"
+			resource.Content = content
+			resource.Encoding = encoding
 		} else {
-			resources[i].Content = string(data)
+			resource.Content = string(data)
 		}
 		if prefix != "" {
-			resources[i].Name = filepath.Join(prefix, resources[i].Name)
+			resource.Name = filepath.Join(prefix, resource.Name)
 		}
+		if err := opts.budget.add(resource); err != nil {
+			return err
+		}
+		resources = append(resources, resource)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	sort.Slice(resources, func(i, j int) bool {
+		if opts.PreserveOrder {
+			return declaredOrderLess(resources[i].Name, resources[j].Name)
+		}
 		return resources[i].Name < resources[j].Name
 	})
 
 	return resources, nil
 }
 
+// declaredOrderLess compares names one path element at a time. Elements with a numeric prefix, such as
+// 01-namespace.yaml, are ordered by the value of the prefix and come before elements without one. Ties,
+// including elements with an equal prefix such as 1-a.yaml and 01-b.yaml, are broken lexically.
+func declaredOrderLess(a, b string) bool {
+	aParts := strings.Split(filepath.ToSlash(a), "/")
+	bParts := strings.Split(filepath.ToSlash(b), "/")
+
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		if aParts[i] == bParts[i] {
+			continue
+		}
+		aOrder, aOK := orderPrefix(aParts[i])
+		bOrder, bOK := orderPrefix(bParts[i])
+		switch {
+		case aOK && bOK && aOrder != bOrder:
+			return aOrder < bOrder
+		case aOK != bOK:
+			return aOK
+		}
+		return aParts[i] < bParts[i]
+	}
+
+	return len(aParts) < len(bParts)
+}
+
+func orderPrefix(name string) (int, bool) {
+	i := 0
+	for i < len(name) && name[i] >= '0' && name[i] <= '9' {
+		i++
+	}
+	if i == 0 || i == len(name) || (name[i] != '-' && name[i] != '_') {
+		return 0, false
+	}
+	order, err := strconv.Atoi(name[:i])
+	return order, err == nil
+}
+
 func hasZero(data []byte) bool {
 	return bytes.ContainsRune(data, 0x0)
 }
 
-func readContent(ctx context.Context, progress *progress.Progress, base, name string) (map[string][]byte, error) {
+func readContent(ctx context.Context, progress *progress.Progress, opts *Options, base, name string, handle func(name string, mode os.FileMode, data []byte) error) error {
+	if isHTTPManifest(name) {
+		return readHTTP(ctx, opts, name, handle)
+	}
+
+	if isFSPath(opts, name) {
+		if err := readFS(opts, base, name, handle); err != nil {
+			return errors.Wrapf(err, "failed to read %s relative to %s", name, base)
+		}
+		return nil
+	}
+
 	temp, err := ioutil.TempDir("", "fleet")
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer os.RemoveAll(temp)
 
@@ -224,7 +464,7 @@ func readContent(ctx context.Context, progress *progress.Progress, base, name st
 
 	base, err = filepath.Abs(base)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	u, uerr := url.Parse(name)
@@ -240,13 +480,11 @@ func readContent(ctx context.Context, progress *progress.Progress, base, name st
 	if err := c.Get(); err != nil {
 		// ignore file paths that don't exist
 		if uerr == nil && u.Scheme == "" {
-			return nil, nil
+			return nil
 		}
-		return nil, err
+		return err
 	}
 
-	files := map[string][]byte{}
-
 	// dereference link if possible
 	if dest, err := os.Readlink(temp); err == nil {
 		temp = dest
@@ -254,6 +492,9 @@ func readContent(ctx context.Context, progress *progress.Progress, base, name st
 
 	err = filepath.Walk(temp, func(path string, info os.FileInfo, err error) error {
 		if info.IsDir() {
+			if isExcluded(opts, path) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
@@ -267,12 +508,11 @@ func readContent(ctx context.Context, progress *progress.Progress, base, name st
 			return err
 		}
 
-		files[name] = content
-		return nil
+		return handle(name, info.Mode(), content)
 	})
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to read %s relative to %s", name, base)
+		return errors.Wrapf(err, "failed to read %s relative to %s", name, base)
 	}
 
-	return files, nil
+	return nil
 }
@@ -0,0 +1,420 @@
+package bundle
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rancher/fleet/pkg/manifest"
+)
+
+func TestPreserveOrder(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml":                  "{}\n",
+		"manifests/10-deploy.yaml":    "kind: Deployment\n",
+		"manifests/00-ns.yaml":        "kind: Namespace\n",
+		"manifests/2-svc.yaml":        "kind: Service\n",
+		"manifests/readme.yaml":       "kind: ConfigMap\n",
+		"manifests/01-crds/crd.yaml":  "kind: CustomResourceDefinition\n",
+		"manifests/20-app/01-cm.yaml": "kind: ConfigMap\n",
+	})
+
+	tests := []struct {
+		preserveOrder bool
+		expected      []string
+	}{
+		{
+			expected: []string{
+				"manifests/00-ns.yaml",
+				"manifests/01-crds/crd.yaml",
+				"manifests/10-deploy.yaml",
+				"manifests/2-svc.yaml",
+				"manifests/20-app/01-cm.yaml",
+				"manifests/readme.yaml",
+			},
+		},
+		{
+			preserveOrder: true,
+			expected: []string{
+				"manifests/00-ns.yaml",
+				"manifests/01-crds/crd.yaml",
+				"manifests/2-svc.yaml",
+				"manifests/10-deploy.yaml",
+				"manifests/20-app/01-cm.yaml",
+				"manifests/readme.yaml",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		b, err := Open(context.Background(), dir, "", &Options{PreserveOrder: test.preserveOrder})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, resource := range b.Definition.Spec.Resources {
+			names = append(names, resource.Name)
+		}
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("preserveOrder %v: expected %v, got %v", test.preserveOrder, test.expected, names)
+		}
+	}
+}
+
+func TestDeclaredOrderLess(t *testing.T) {
+	expected := []string{
+		"00-ns.yaml",
+		// ties are broken lexically
+		"01-b.yaml",
+		"1-a.yaml",
+		"2_svc.yaml",
+		"10-deploy/service.yaml",
+		"10-deploy.yaml",
+		"100.yaml",
+		"a.yaml",
+		"b/00-first.yaml",
+		"b/second.yaml",
+	}
+
+	names := []string{}
+	for i := len(expected) - 1; i >= 0; i-- {
+		names = append(names, expected[i])
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return declaredOrderLess(names[i], names[j])
+	})
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
+
+func TestResourceMode(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml":               "{}\n",
+		"manifests/configmap.yaml": "kind: ConfigMap\n",
+		"manifests/init.sh":        "#!/bin/sh\necho init\n",
+	})
+	if err := os.Chmod(filepath.Join(dir, "manifests", "init.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, compress := range []bool{false, true} {
+		b, err := Open(context.Background(), dir, "", &Options{Compress: compress})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		modes := map[string]int32{}
+		for _, resource := range b.Definition.Spec.Resources {
+			modes[resource.Name] = resource.Mode
+		}
+		if modes["manifests/init.sh"] != 0755 {
+			t.Errorf("compress %v: expected the mode of the executable file to be 0755, got %o", compress, modes["manifests/init.sh"])
+		}
+		if modes["manifests/configmap.yaml"] != 0 {
+			t.Errorf("compress %v: expected no mode for a file that is not executable, got %o", compress, modes["manifests/configmap.yaml"])
+		}
+	}
+}
+
+func TestOverlayTaggedResources(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml": `overlays:
+- name: prod
+- name: dev
+targets:
+- name: prod
+  clusterGroup: prod
+  overlays: [prod]
+- name: dev
+  clusterGroup: dev
+  overlays: [dev]
+`,
+		"manifests/deployment.yaml":          "kind: Deployment\n",
+		"manifests/configmap@prod.yaml":      "kind: ConfigMap\nprod: true\n",
+		"manifests/secret@dev.yaml":          "kind: Secret\ntagged: true\n",
+		"overlays/dev/manifests/secret.yaml": "kind: Secret\ntagged: false\n",
+	})
+
+	b, err := Open(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var base []string
+	for _, resource := range b.Definition.Spec.Resources {
+		base = append(base, resource.Name)
+	}
+	if expected := []string{"manifests/deployment.yaml"}; !reflect.DeepEqual(base, expected) {
+		t.Errorf("expected base resources %v, got %v", expected, base)
+	}
+
+	overlays := map[string]map[string]string{}
+	for _, overlay := range b.Definition.Spec.Overlays {
+		overlays[overlay.Name] = map[string]string{}
+		for _, resource := range overlay.Resources {
+			overlays[overlay.Name][resource.Name] = resource.Content
+		}
+	}
+	if content := overlays["prod"]["manifests/configmap.yaml"]; content != "kind: ConfigMap\nprod: true\n" {
+		t.Errorf("expected the tagged file in the prod overlay, got %v", overlays["prod"])
+	}
+	if _, ok := overlays["dev"]["manifests/configmap.yaml"]; ok {
+		t.Errorf("expected the file tagged for prod not to be in the dev overlay, got %v", overlays["dev"])
+	}
+	if _, ok := overlays["prod"]["manifests/secret.yaml"]; ok {
+		t.Errorf("expected the file tagged for dev not to be in the prod overlay, got %v", overlays["prod"])
+	}
+	// the file in the overlay directory takes precedence over the tagged file
+	if content := overlays["dev"]["manifests/secret.yaml"]; content != "kind: Secret\ntagged: false\n" {
+		t.Errorf("expected the file of the dev overlay directory, got %v", overlays["dev"])
+	}
+}
+
+func TestOverlayTag(t *testing.T) {
+	tests := []struct {
+		name     string
+		resource string
+		overlay  string
+		ok       bool
+	}{
+		{name: "manifests/configmap@prod.yaml", resource: "manifests/configmap.yaml", overlay: "prod", ok: true},
+		{name: "manifests/app/cm@env=prod.yml", resource: "manifests/app/cm.yml", overlay: "env=prod", ok: true},
+		{name: "manifests/a@b@prod.yaml", resource: "manifests/a@b.yaml", overlay: "prod", ok: true},
+		{name: "manifests/configmap.yaml"},
+		{name: "manifests/@prod.yaml"},
+		{name: "manifests/configmap@.yaml"},
+		{name: "chart/templates/foo@v2.yaml"},
+		{name: "kustomize/base/configmap@prod.yaml"},
+	}
+
+	for _, test := range tests {
+		resource, overlay, ok := overlayTag(test.name)
+		if resource != test.resource || overlay != test.overlay || ok != test.ok {
+			t.Errorf("%s: expected %q, %q, %v, got %q, %q, %v", test.name, test.resource, test.overlay, test.ok, resource, overlay, ok)
+		}
+	}
+}
+
+func TestOverlayTagOutsideManifests(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml": `overlays:
+- name: v2
+targets:
+- name: prod
+  clusterGroup: prod
+  overlays: [v2]
+`,
+		"chart/Chart.yaml":            "name: app\nversion: 0.1.0\n",
+		"chart/templates/foo@v2.yaml": "kind: ConfigMap\n",
+	})
+
+	b, err := Open(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resources := resourceContents(t, b.Definition.Spec.Resources); resources["chart/templates/foo@v2.yaml"] == "" {
+		t.Errorf("expected the chart file to stay in the base resources, got %v", resources)
+	}
+	for _, overlay := range b.Definition.Spec.Overlays {
+		if len(overlay.Resources) > 0 {
+			t.Errorf("expected no resources in overlay %s, got %v", overlay.Name, overlay.Resources)
+		}
+	}
+}
+
+func TestOverlayTagUnknownOverlay(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml":                   "overlays:\n- name: prod\n  namespace: prod\n",
+		"manifests/configmap@prd.yaml": "kind: ConfigMap\n",
+	})
+
+	b, err := Open(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resources := resourceContents(t, b.Definition.Spec.Resources); resources["manifests/configmap@prd.yaml"] == "" {
+		t.Errorf("expected the file tagged with an unknown overlay to stay in the base resources, got %v", resources)
+	}
+	for _, overlay := range b.Definition.Spec.Overlays {
+		if overlay.Name == "prd" {
+			t.Errorf("expected no overlay for the unknown tag, got %v", overlay)
+		}
+	}
+	if !hasWarning(Lint(b), "manifests/configmap@prd.yaml", "is tagged with overlay prd, which is not defined") {
+		t.Errorf("expected a warning about the unknown tag, got %v", Lint(b))
+	}
+
+	_, err = Open(context.Background(), dir, "", &Options{StrictOverlays: true})
+	if err == nil || !strings.Contains(err.Error(), "resources are tagged with overlays that are not defined in the bundle or found on disk: manifests/configmap@prd.yaml") {
+		t.Errorf("expected an error for the unknown tag with strictOverlays, got %v", err)
+	}
+}
+
+func TestManifestsDirs(t *testing.T) {
+	files := map[string]string{
+		"manifests/deployment.yaml":     "kind: Deployment\nname: base\n",
+		"app/manifests/deployment.yaml": "kind: Deployment\nname: app\n",
+		"db/deployment.yaml":            "kind: Deployment\nname: db\n",
+	}
+
+	read := func(t *testing.T, fleetYAML string) *Bundle {
+		t.Helper()
+		dir := t.TempDir()
+		writeFiles(t, dir, files)
+		writeFiles(t, dir, map[string]string{"fleet.yaml": fleetYAML})
+		b, err := Open(context.Background(), dir, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	b := read(t, "manifestsDirs:\n- path: ./db\n- path: ./app/manifests\n  prefix: app\n")
+	contents := map[string]string{}
+	var names []string
+	for _, resource := range b.Definition.Spec.Resources {
+		names = append(names, resource.Name)
+		contents[resource.Name] = resource.Content
+	}
+	expected := []string{"manifests/deployment.yaml", "manifests/app/deployment.yaml", "manifests/db/deployment.yaml"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected resources %v, got %v", expected, names)
+	}
+	for name, content := range map[string]string{
+		"manifests/deployment.yaml":     files["manifests/deployment.yaml"],
+		"manifests/app/deployment.yaml": files["app/manifests/deployment.yaml"],
+		"manifests/db/deployment.yaml":  files["db/deployment.yaml"],
+	} {
+		if contents[name] != content {
+			t.Errorf("%s: expected %q, got %q", name, content, contents[name])
+		}
+	}
+
+	// the order the directories are listed in does not change the bundle
+	reordered := read(t, "manifestsDirs:\n- path: ./app/manifests\n  prefix: app\n- path: ./db\n")
+	if !reflect.DeepEqual(reordered.Definition.Spec.Resources, b.Definition.Spec.Resources) {
+		t.Errorf("expected the same resources regardless of the order of manifestsDirs, got %v", reordered.Definition.Spec.Resources)
+	}
+}
+
+func TestManifestsDirsError(t *testing.T) {
+	tests := map[string]string{
+		"manifestsDirs:\n- path: ./app/manifests\n- path: ./db/manifests\n": "manifestsDirs prefix manifests is used more than once",
+		"manifestsDirs:\n- prefix: app\n":                                   "manifestsDirs entry is missing a path",
+	}
+
+	for fleetYAML, message := range tests {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{
+			"fleet.yaml":                    fleetYAML,
+			"app/manifests/deployment.yaml": "kind: Deployment\n",
+			"db/manifests/deployment.yaml":  "kind: Deployment\n",
+		})
+		_, err := Open(context.Background(), dir, "", nil)
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("expected an error containing %q, got %v", message, err)
+		}
+	}
+}
+
+func TestInlineResources(t *testing.T) {
+	const fleetYAML = `resources:
+- name: manifests/inline.yaml
+  content: |
+    kind: ConfigMap
+    source: inline
+- name: manifests/file.yaml
+  content: |
+    kind: ConfigMap
+    source: inline replacing a file
+`
+	files := map[string]string{
+		"fleet.yaml":          fleetYAML,
+		"manifests/file.yaml": "kind: ConfigMap\nsource: file\n",
+		"manifests/disk.yaml": "kind: ConfigMap\nsource: disk\n",
+	}
+
+	open := func(t *testing.T, opts *Options, files map[string]string) (*Bundle, error) {
+		t.Helper()
+		dir := t.TempDir()
+		writeFiles(t, dir, files)
+		return Open(context.Background(), dir, "", opts)
+	}
+
+	b, err := open(t, nil, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	contents := map[string]string{}
+	for _, resource := range b.Definition.Spec.Resources {
+		names = append(names, resource.Name)
+		contents[resource.Name] = resource.Content
+	}
+	expected := []string{"manifests/disk.yaml", "manifests/file.yaml", "manifests/inline.yaml"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected resources %v, got %v", expected, names)
+	}
+	if contents["manifests/file.yaml"] != "kind: ConfigMap\nsource: inline replacing a file\n" {
+		t.Errorf("expected the inline resource to replace the file, got %q", contents["manifests/file.yaml"])
+	}
+
+	// inline resources are compressed like files
+	compressed, err := open(t, &Options{Compress: true}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, resource := range compressed.Definition.Spec.Resources {
+		if resource.Encoding == "" {
+			t.Errorf("expected %s to be compressed", resource.Name)
+		}
+	}
+
+	// inline content is part of the bundle content
+	changed := map[string]string{}
+	for name, content := range files {
+		changed[name] = content
+	}
+	changed["fleet.yaml"] = strings.Replace(fleetYAML, "source: inline\n", "source: changed\n", 1)
+	other, err := open(t, nil, changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentID(t, b) == contentID(t, other) {
+		t.Error("expected a change of an inline resource to change the bundle content")
+	}
+
+	if _, err := open(t, &Options{StrictResourceNames: true}, files); err == nil ||
+		!strings.Contains(err.Error(), "resource manifests/file.yaml is defined inline and on disk") {
+		t.Errorf("expected the name collision to fail with strict resource names, got %v", err)
+	}
+	if _, err := open(t, nil, map[string]string{"fleet.yaml": "resources:\n- content: data\n"}); err == nil ||
+		!strings.Contains(err.Error(), "inline resource 0 is missing a name") {
+		t.Errorf("expected an inline resource without a name to fail, got %v", err)
+	}
+}
+
+// contentID returns the ID of the content of the bundle with the overlays
+func contentID(t *testing.T, b *Bundle, overlays ...string) string {
+	t.Helper()
+	m, err := manifest.New(&b.Definition.Spec, overlays...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, id, err := m.Content()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
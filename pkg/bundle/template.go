@@ -0,0 +1,76 @@
+package bundle
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/content"
+)
+
+// templateSuffix is the suffix of resource files rendered as Go templates
+const templateSuffix = ".tpl"
+
+// renderTemplates renders the resources ending in .tpl as Go templates with the values of the bundle
+// available as .Values, and removes the suffix from their name, so manifests/configmap.yaml.tpl becomes
+// manifests/configmap.yaml. The resources of overlays are rendered the same way, each overlay on its own,
+// with the values of the bundle. Chart files are left as is, as .tpl files in charts are Helm templates. A
+// referenced value that is not set is an error and errors name the file and line.
+func renderTemplates(opts *Options, resources []fleet.BundleResource, values *fleet.GenericMap) error {
+	var data map[string]interface{}
+	if values != nil {
+		data = values.Data
+	}
+
+	names := map[string]bool{}
+	for _, resource := range resources {
+		names[resource.Name] = true
+	}
+
+	for i, resource := range resources {
+		if !strings.HasSuffix(resource.Name, templateSuffix) || strings.HasPrefix(resource.Name, ChartDir+"/") {
+			continue
+		}
+
+		name := strings.TrimSuffix(resource.Name, templateSuffix)
+		if names[name] {
+			return fmt.Errorf("%s: renders to %s which already exists", resource.Name, name)
+		}
+
+		src, err := content.Decode(resource.Content, resource.Encoding)
+		if err != nil {
+			return err
+		}
+
+		tmpl, err := template.New(resource.Name).Option("missingkey=error").Parse(string(src))
+		if err != nil {
+			return err
+		}
+
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, map[string]interface{}{
+			"Values": data,
+		}); err != nil {
+			return err
+		}
+
+		resources[i].Name = name
+		names[name] = true
+
+		if resource.Encoding == "" {
+			resources[i].Content = out.String()
+			continue
+		}
+
+		c, encoding, err := content.Encode(out.Bytes(), opts.CompressionAlgorithm)
+		if err != nil {
+			return err
+		}
+		resources[i].Content = c
+		resources[i].Encoding = encoding
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package bundle
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml": `values:
+  image: nginx:1.19
+targets:
+- name: prod
+  clusterGroup: prod
+  overlays: [prod]
+`,
+		"manifests/deployment.yaml.tpl":    "image: {{ .Values.image }}\n",
+		"overlays/prod/configmap.yaml.tpl": "image: {{ .Values.image }}\nenv: prod\n",
+		"chart/templates/_helpers.tpl":     "{{- define \"name\" -}}{{ .Chart.Name }}{{- end -}}\n",
+		"chart/Chart.yaml":                 "name: app\nversion: 0.1.0\n",
+	})
+
+	b, err := Open(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resources := resourceContents(t, b.Definition.Spec.Resources)
+	if resources["manifests/deployment.yaml"] != "image: nginx:1.19\n" {
+		t.Errorf("expected the template to be rendered without its suffix, got %v", resources)
+	}
+	if resources["chart/templates/_helpers.tpl"] == "" {
+		t.Errorf("expected chart templates to be left as is, got %v", resources)
+	}
+
+	var overlay map[string]string
+	for _, o := range b.Definition.Spec.Overlays {
+		if o.Name == "prod" {
+			overlay = resourceContents(t, o.Resources)
+		}
+	}
+	if overlay["configmap.yaml"] != "image: nginx:1.19\nenv: prod\n" {
+		t.Errorf("expected the template of the overlay to be rendered, got %v", overlay)
+	}
+}
+
+func TestRenderTemplatesError(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		message string
+	}{
+		{
+			name: "missing value",
+			files: map[string]string{
+				"fleet.yaml":                    "values:\n  image: nginx\n",
+				"manifests/deployment.yaml.tpl": "kind: Deployment\nreplicas: {{ .Values.replicas }}\n",
+			},
+			message: "manifests/deployment.yaml.tpl:2",
+		},
+		{
+			name: "invalid template",
+			files: map[string]string{
+				"fleet.yaml":                    "values:\n  image: nginx\n",
+				"manifests/deployment.yaml.tpl": "kind: Deployment\n\nimage: {{ .Values.image\n",
+			},
+			message: "manifests/deployment.yaml.tpl:3",
+		},
+		{
+			name: "overlay",
+			files: map[string]string{
+				"fleet.yaml":                       "targets:\n- clusterGroup: prod\n  overlays: [prod]\n",
+				"manifests/configmap.yaml":         "kind: ConfigMap\n",
+				"overlays/prod/configmap.yaml.tpl": "env: {{ .Values.env }}\n",
+			},
+			message: "overlay prod: template: configmap.yaml.tpl:1",
+		},
+		{
+			name: "rendered name exists",
+			files: map[string]string{
+				"fleet.yaml":                   "{}\n",
+				"manifests/configmap.yaml":     "kind: ConfigMap\n",
+				"manifests/configmap.yaml.tpl": "kind: ConfigMap\n",
+			},
+			message: "manifests/configmap.yaml.tpl: renders to manifests/configmap.yaml which already exists",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, test.files)
+
+			_, err := Open(context.Background(), dir, "", nil)
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Errorf("expected an error containing %q, got %v", test.message, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package bundle
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/match"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// ValidationErrors is the list of all problems found while reading a bundle
+type ValidationErrors []error
+
+func (v ValidationErrors) Error() string {
+	var msgs []string
+	for _, err := range v {
+		msgs = append(msgs, err.Error())
+	}
+	return "invalid bundle: " + strings.Join(msgs, "; ")
+}
+
+func (v ValidationErrors) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+// validate checks the bundle for all known problems and returns them together as ValidationErrors.
+// overlayResources is the resources of each overlay read from disk. References to undefined overlays are
+// only an error if strictOverlays is set.
+func validate(spec *fleet.BundleSpec, overlayResources map[string][]fleet.BundleResource, strictOverlays bool) error {
+	var (
+		errs     ValidationErrors
+		declared = map[string]bool{}
+		targets  = map[string]bool{}
+	)
+
+	for i, overlay := range spec.Overlays {
+		if overlay.Name == "" {
+			errs = append(errs, fmt.Errorf("overlay %d is missing a name", i))
+			continue
+		}
+		if declared[overlay.Name] {
+			errs = append(errs, fmt.Errorf("overlay %s is defined more than once", overlay.Name))
+		}
+		declared[overlay.Name] = true
+	}
+
+	for _, target := range spec.Targets {
+		if targets[target.Name] {
+			errs = append(errs, fmt.Errorf("target %s is defined more than once", target.Name))
+		}
+		targets[target.Name] = true
+
+		for _, path := range sets.StringKeySet(target.ClusterLabelValues).List() {
+			if label := target.ClusterLabelValues[path]; !contains(target.ClusterLabels, label) {
+				errs = append(errs, fmt.Errorf("target %s sets value %s from cluster label %s which is not listed in clusterLabels",
+					target.Name, path, label))
+			}
+		}
+
+		if err := match.ValidateSelector(target.ClusterSelector); err != nil {
+			errs = append(errs, fmt.Errorf("target %s has an invalid clusterSelector: %w", target.Name, err))
+		}
+		if err := match.ValidateSelector(target.ClusterGroupSelector); err != nil {
+			errs = append(errs, fmt.Errorf("target %s has an invalid clusterGroupSelector: %w", target.Name, err))
+		}
+		if _, err := regexp.Compile(target.ClusterNameRegex); err != nil {
+			errs = append(errs, fmt.Errorf("target %s has an invalid clusterNameRegex: %w", target.Name, err))
+		}
+		errs = append(errs, validateDeletions("target "+target.Name, target.Deletions)...)
+	}
+
+	errs = append(errs, validateDeletions("bundle", spec.Deletions)...)
+	for _, overlay := range spec.Overlays {
+		errs = append(errs, validateDeletions("overlay "+overlay.Name, overlay.Deletions)...)
+	}
+
+	if undefined := undefinedOverlays(spec, declared, overlayResources); strictOverlays && len(undefined) > 0 {
+		errs = append(errs, fmt.Errorf("referenced overlays are not defined in the bundle or found on disk: %s",
+			strings.Join(undefined, ", ")))
+	}
+
+	if spec.RolloutStrategy != nil {
+		if err := validateSize(spec.RolloutStrategy.AutoPartitionSize); err != nil {
+			errs = append(errs, fmt.Errorf("invalid rolloutStrategy autoPartitionSize: %w", err))
+		}
+		errs = append(errs, validatePartitionOrder(spec.RolloutStrategy)...)
+	}
+
+	for _, resource := range spec.Resources {
+		if isEmptyManifest(resource) {
+			errs = append(errs, fmt.Errorf("manifest %s is empty", resource.Name))
+		}
+	}
+
+	return errs.err()
+}
+
+// validateDeletions checks that each deletion identifies a single resource
+func validateDeletions(owner string, deletions []fleet.ResourceDeletion) (errs []error) {
+	for i, deletion := range deletions {
+		if deletion.APIVersion == "" || deletion.Kind == "" || deletion.Name == "" {
+			errs = append(errs, fmt.Errorf("%s deletion %d must set apiVersion, kind and name", owner, i))
+		}
+	}
+	return
+}
+
+// undefinedOverlays returns the overlay names referenced by targets or other overlays that are
+// neither declared in the spec nor have any resources on disk.
+func undefinedOverlays(spec *fleet.BundleSpec, declared map[string]bool, overlayResources map[string][]fleet.BundleResource) (result []string) {
+	for _, name := range overlays(spec) {
+		if !declared[name] && len(overlayResources[name]) == 0 {
+			result = append(result, name)
+		}
+	}
+	return
+}
+
+// validatePartitionOrder checks that the partition order only names defined partitions. Partitions created
+// by label, step or automatically are only known once the bundle is targeted, so their names are not checked.
+func validatePartitionOrder(rollout *fleet.RolloutStrategy) (errs []error) {
+	if len(rollout.Partitions) == 0 {
+		return nil
+	}
+	defined := map[string]bool{}
+	for _, partition := range rollout.Partitions {
+		defined[partition.Name] = true
+	}
+	for _, name := range rollout.PartitionOrder {
+		if !defined[name] {
+			errs = append(errs, fmt.Errorf("rolloutStrategy partitionOrder references undefined partition %s", name))
+		}
+	}
+	return
+}
+
+// validateSize checks that the value is an int or a percentage, matching how rollout limits are parsed
+// when the bundle is targeted
+func validateSize(val *intstr.IntOrString) error {
+	if val == nil || val.Type == intstr.Int || val.IntValue() > 0 {
+		return nil
+	}
+	if !strings.HasSuffix(val.StrVal, "%") {
+		return fmt.Errorf("must be int or percentage (ending with %%): %s", val.StrVal)
+	}
+	if _, err := strconv.ParseFloat(strings.TrimSuffix(val.StrVal, "%"), 64); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", val.StrVal, err)
+	}
+	return nil
+}
+
+func isEmptyManifest(resource fleet.BundleResource) bool {
+	if resource.Encoding != "" || !strings.HasPrefix(resource.Name, ManifestsDir+"/") {
+		return false
+	}
+	switch filepath.Ext(resource.Name) {
+	case ".yaml", ".yml", ".json":
+		return strings.TrimSpace(resource.Content) == ""
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package bundle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateReportsAllErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml": `overlays:
+- name: prod
+- name: prod
+- overlays: [prod]
+targets:
+- name: prod
+  clusterGroup: prod
+  overlays: [prodution]
+  clusterLabelValues:
+    image.tag: version
+- name: prod
+  clusterSelector:
+    matchExpressions:
+    - key: env
+      operator: Within
+      values: [prod]
+- name: edge
+  clusterNameRegex: "edge-("
+  deletions:
+  - kind: ConfigMap
+rolloutStrategy:
+  autoPartitionSize: ten
+  partitions:
+  - name: canary
+    clusterGroup: canary
+  partitionOrder: [canary, rest]
+`,
+		"manifests/configmap.yaml": "kind: ConfigMap\n",
+		"manifests/empty.yaml":     "  \n",
+	})
+
+	_, err := Open(context.Background(), dir, "", &Options{StrictOverlays: true})
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+
+	expected := []string{
+		"overlay 2 is missing a name",
+		"overlay prod is defined more than once",
+		"target prod is defined more than once",
+		"target prod sets value image.tag from cluster label version which is not listed in clusterLabels",
+		"target prod has an invalid clusterSelector",
+		"target edge has an invalid clusterNameRegex",
+		"target edge deletion 0 must set apiVersion, kind and name",
+		"referenced overlays are not defined in the bundle or found on disk: prodution",
+		"invalid rolloutStrategy autoPartitionSize: must be int or percentage (ending with %): ten",
+		"rolloutStrategy partitionOrder references undefined partition rest",
+		"manifest manifests/empty.yaml is empty",
+	}
+	for _, message := range expected {
+		found := false
+		for _, err := range errs {
+			if strings.Contains(err.Error(), message) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected an error containing %q, got %v", message, errs)
+		}
+	}
+	if len(errs) != len(expected) {
+		t.Errorf("expected %d errors, got %d: %v", len(expected), len(errs), errs)
+	}
+	if !strings.HasPrefix(err.Error(), "invalid bundle: ") {
+		t.Errorf("expected the errors to be reported together, got %v", err)
+	}
+}
+
+func TestValidBundle(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"fleet.yaml": `targets:
+- name: prod
+  clusterSelector:
+    matchLabels:
+      env: prod
+rolloutStrategy:
+  autoPartitionSize: 10%
+`,
+		"manifests/configmap.yaml": "kind: ConfigMap\n",
+	})
+
+	if _, err := Open(context.Background(), dir, "", &Options{StrictOverlays: true}); err != nil {
+		t.Errorf("expected a valid bundle, got %v", err)
+	}
+}
+
+func TestValidateSelectors(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		message string
+	}{
+		{
+			name: "unknown operator",
+			target: `clusterSelector:
+    matchExpressions:
+    - key: env
+      operator: Within
+      values: [prod]`,
+			message: "target prod has an invalid clusterSelector",
+		},
+		{
+			name: "invalid label value",
+			target: `clusterSelector:
+    matchLabels:
+      env: "prod env"`,
+			message: "target prod has an invalid clusterSelector",
+		},
+		{
+			name: "exists with values",
+			target: `clusterGroupSelector:
+    matchExpressions:
+    - key: env
+      operator: Exists
+      values: [prod]`,
+			message: "target prod has an invalid clusterGroupSelector",
+		},
+		{
+			name: "valid",
+			target: `clusterSelector:
+    matchExpressions:
+    - key: env
+      operator: In
+      values: [prod]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"fleet.yaml":               "targets:\n- name: prod\n  " + test.target + "\n",
+				"manifests/configmap.yaml": "kind: ConfigMap\n",
+			})
+
+			_, err := Open(context.Background(), dir, "", nil)
+			if test.message == "" {
+				if err != nil {
+					t.Errorf("expected a valid bundle, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Errorf("expected an error containing %q, got %v", test.message, err)
+			}
+		})
+	}
+}
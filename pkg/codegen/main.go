@@ -19,6 +19,13 @@ func main() {
 		return
 	}
 
+	if len(os.Args) > 2 && os.Args[1] == "bundle-schema" {
+		if err := crd.WriteBundleSpecSchema(os.Args[2]); err != nil {
+			panic(err)
+		}
+		return
+	}
+
 	os.Unsetenv("GOPATH")
 	controllergen.Run(args.Options{
 		OutputPackage: "github.com/rancher/fleet/pkg/generated",
@@ -42,6 +42,17 @@ type Config struct {
 	Bootstrap            Bootstrap         `json:"bootstrap,omitempty"`
 	GithubURLPrefix      string            `json:"githubURLPrefix,omitempty"`
 	WebhookReceiverURL   string            `json:"webhookReceiverURL,omitempty"`
+	MaxConcurrentGitJobs int               `json:"maxConcurrentGitJobs,omitempty"`
+	DefaultBranch        string            `json:"defaultBranch,omitempty"`
+	// DefaultServiceAccount is used to deploy bundles of GitRepos that don't specify a service account
+	DefaultServiceAccount string `json:"defaultServiceAccount,omitempty"`
+	// RequireServiceAccount if true GitRepos are not synced unless a service account is set on the
+	// GitRepo or as the default
+	RequireServiceAccount bool `json:"requireServiceAccount,omitempty"`
+	// MaxConcurrentContentStores is the max number of bundle contents stored at once for a bundle, defaults to 4
+	MaxConcurrentContentStores int `json:"maxConcurrentContentStores,omitempty"`
+	// GitJobImagePullSecrets are the image pull secrets of git jobs of GitRepos that don't specify any
+	GitJobImagePullSecrets []string `json:"gitJobImagePullSecrets,omitempty"`
 }
 
 type Bootstrap struct {
@@ -4,8 +4,16 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/base64"
+	"fmt"
 	"io/ioutil"
 	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	GzipAlgorithm = "gzip"
+	ZstdAlgorithm = "zstd"
 )
 
 func GUnzip(content []byte) ([]byte, error) {
@@ -24,6 +32,47 @@ func Base64GZ(data []byte) (string, error) {
 	return base64.StdEncoding.EncodeToString(gz), nil
 }
 
+// Encode compresses and base64 encodes data with the given algorithm, returning the content and the
+// encoding to record on the resource. An empty algorithm defaults to gzip.
+func Encode(data []byte, algorithm string) (string, string, error) {
+	switch algorithm {
+	case "", GzipAlgorithm:
+		content, err := Base64GZ(data)
+		return content, "base64+gz", err
+	case ZstdAlgorithm:
+		content, err := Base64Zstd(data)
+		return content, "base64+zstd", err
+	default:
+		return "", "", fmt.Errorf("unsupported compression algorithm %s, must be %s or %s", algorithm, GzipAlgorithm, ZstdAlgorithm)
+	}
+}
+
+func Base64Zstd(data []byte) (string, error) {
+	z, err := Zstd(data)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(z), nil
+}
+
+func Zstd(data []byte) ([]byte, error) {
+	w, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+	return w.EncodeAll(data, nil), nil
+}
+
+func UnZstd(content []byte) ([]byte, error) {
+	r, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return r.DecodeAll(content, nil)
+}
+
 func Decode(content, encoding string) ([]byte, error) {
 	var data []byte
 
@@ -39,8 +88,11 @@ func Decode(content, encoding string) ([]byte, error) {
 		data = []byte(content)
 	}
 
-	if encoding == "gz" {
+	switch encoding {
+	case "gz":
 		return GUnzip(data)
+	case "zstd":
+		return UnZstd(data)
 	}
 
 	return data, nil
@@ -0,0 +1,56 @@
+package content
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("kind: ConfigMap\napiVersion: v1\n", 100))
+
+	tests := []struct {
+		algorithm string
+		encoding  string
+	}{
+		{algorithm: "", encoding: "base64+gz"},
+		{algorithm: GzipAlgorithm, encoding: "base64+gz"},
+		{algorithm: ZstdAlgorithm, encoding: "base64+zstd"},
+	}
+
+	for _, test := range tests {
+		content, encoding, err := Encode(data, test.algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encoding != test.encoding {
+			t.Errorf("%q: expected encoding %s, got %s", test.algorithm, test.encoding, encoding)
+		}
+		if len(content) >= len(data) {
+			t.Errorf("%q: expected the content to be compressed, got %d bytes from %d", test.algorithm, len(content), len(data))
+		}
+
+		decoded, err := Decode(content, encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decoded) != string(data) {
+			t.Errorf("%q: expected the decoded content to equal the original", test.algorithm)
+		}
+	}
+}
+
+func TestEncodeUnsupportedAlgorithm(t *testing.T) {
+	if _, _, err := Encode([]byte("kind: ConfigMap\n"), "lz4"); err == nil || !strings.Contains(err.Error(), "unsupported compression algorithm lz4") {
+		t.Errorf("expected an unsupported algorithm to fail, got %v", err)
+	}
+}
+
+func TestDecodePlain(t *testing.T) {
+	decoded, err := Decode("kind: ConfigMap\n", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "kind: ConfigMap\n" {
+		t.Errorf("expected content without an encoding to be returned as is, got %q", decoded)
+	}
+}
@@ -2,8 +2,10 @@ package bundle
 
 import (
 	"context"
+	"time"
 
 	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	fleetbundle "github.com/rancher/fleet/pkg/bundle"
 	fleetcontrollers "github.com/rancher/fleet/pkg/generated/controllers/fleet.cattle.io/v1alpha1"
 	"github.com/rancher/fleet/pkg/summary"
 	"github.com/rancher/fleet/pkg/target"
@@ -16,11 +18,17 @@ import (
 
 const (
 	maxNew = 50
+
+	maintenanceWindowRequeueInterval = time.Minute
 )
 
 type handler struct {
+	// ctx is the context the controller was registered with, storing bundle content stops once it is done
+	ctx     context.Context
 	targets *target.Manager
 	bundles fleetcontrollers.BundleController
+	// now returns the current time, rollouts held back for a time are released once it has passed
+	now func() time.Time
 }
 
 func Register(ctx context.Context,
@@ -31,8 +39,10 @@ func Register(ctx context.Context,
 	bundleDeployments fleetcontrollers.BundleDeploymentController,
 ) {
 	h := &handler{
+		ctx:     ctx,
 		targets: targets,
 		bundles: bundles,
+		now:     time.Now,
 	}
 
 	fleetcontrollers.RegisterBundleGeneratingHandler(ctx,
@@ -53,12 +63,26 @@ func (h *handler) resolveApp(_ string, _ string, obj runtime.Object) ([]relatedr
 	if ad, ok := obj.(*fleet.BundleDeployment); ok {
 		ns, name := h.targets.BundleFromDeployment(ad)
 		if ns != "" && name != "" {
-			return []relatedresource.Key{
+			keys := []relatedresource.Key{
 				{
 					Namespace: ns,
 					Name:      name,
 				},
-			}, nil
+			}
+
+			// bundles that depend on this bundle may now be ready to deploy
+			dependents, err := h.targets.BundlesDependingOn(ns, name)
+			if err != nil {
+				return nil, err
+			}
+			for _, dependent := range dependents {
+				keys = append(keys, relatedresource.Key{
+					Namespace: dependent.Namespace,
+					Name:      dependent.Name,
+				})
+			}
+
+			return keys, nil
 		}
 	}
 	return nil, nil
@@ -82,7 +106,11 @@ func (h *handler) OnClusterChange(_ string, cluster *fleet.Cluster) (*fleet.Clus
 }
 
 func (h *handler) OnBundleChange(bundle *fleet.Bundle, status fleet.BundleStatus) ([]runtime.Object, fleet.BundleStatus, error) {
-	targets, err := h.targets.Targets(bundle)
+	if err := setStats(bundle, &status); err != nil {
+		return nil, status, err
+	}
+
+	targets, err := h.targets.Targets(h.ctx, bundle)
 	if err != nil {
 		return nil, status, err
 	}
@@ -91,30 +119,84 @@ func (h *handler) OnBundleChange(bundle *fleet.Bundle, status fleet.BundleStatus
 		return nil, status, err
 	}
 
+	setAdopted(bundle, &status, targets)
+
+	now := h.now()
+	if target.IsPausedUntil(bundle, now) {
+		h.bundles.EnqueueAfter(bundle.Namespace, bundle.Name, bundle.Spec.PausedUntil.Sub(now))
+	}
+
+	for _, partition := range status.PartitionStatus {
+		if partition.SoakingUntil != nil {
+			h.bundles.EnqueueAfter(bundle.Namespace, bundle.Name, partition.SoakingUntil.Sub(now))
+		}
+	}
+
+	if deadline, ok := target.NextReadyDeadline(targets, now); ok {
+		h.bundles.EnqueueAfter(bundle.Namespace, bundle.Name, deadline.Sub(now))
+	}
+
+	if target.WaitingForMaintenanceWindow(targets, now) {
+		h.bundles.EnqueueAfter(bundle.Namespace, bundle.Name, maintenanceWindowRequeueInterval)
+	}
+
 	summary.SetReadyConditions(&status, status.Summary)
 	return toRuntimeObjects(targets), status, nil
 }
 
 func toRuntimeObjects(targets []*target.Target) (result []runtime.Object) {
-	for _, target := range targets {
-		if target.Deployment == nil {
+	for _, t := range targets {
+		if t.Deployment == nil {
 			continue
 		}
 
 		result = append(result, &fleet.BundleDeployment{
 			ObjectMeta: v1.ObjectMeta{
-				Name:      target.Deployment.Name,
-				Namespace: target.Deployment.Namespace,
-				Labels:    target.Deployment.Labels,
+				Name:        t.Deployment.Name,
+				Namespace:   t.Deployment.Namespace,
+				Labels:      t.Deployment.Labels,
+				Annotations: target.DeploymentAnnotations(t.Bundle),
 			},
-			Spec: target.Deployment.Spec,
+			Spec: t.Deployment.Spec,
 		})
 	}
 
 	return
 }
 
+// setAdopted records the clusters targeted when the bundle changes if it does not auto adopt clusters
+func setAdopted(bundle *fleet.Bundle, status *fleet.BundleStatus, targets []*target.Target) {
+	if target.AutoAdopt(bundle) {
+		status.AdoptedClusters = nil
+		status.AdoptedGeneration = 0
+		return
+	}
+	if status.AdoptedGeneration == bundle.Generation {
+		return
+	}
+
+	status.AdoptedClusters = nil
+	for _, t := range targets {
+		status.AdoptedClusters = append(status.AdoptedClusters, t.Cluster.Name)
+	}
+	status.AdoptedGeneration = bundle.Generation
+}
+
+func setStats(bundle *fleet.Bundle, status *fleet.BundleStatus) (err error) {
+	b, err := fleetbundle.New(bundle)
+	if err != nil {
+		return err
+	}
+	status.ResourceCount, status.ResourcesSize, status.ResourcesStoredSize, err = b.Stats()
+	return err
+}
+
 func (h *handler) calculateChanges(status *fleet.BundleStatus, allTargets []*target.Target) (err error) {
+	previous := map[string]*fleet.PartitionStatus{}
+	for i := range status.PartitionStatus {
+		previous[status.PartitionStatus[i].Name] = &status.PartitionStatus[i]
+	}
+
 	// reset
 	status.MaxNew = maxNew
 	status.Summary = fleet.BundleSummary{}
@@ -133,15 +215,26 @@ func (h *handler) calculateChanges(status *fleet.BundleStatus, allTargets []*tar
 		return err
 	}
 
+	groupBudget, err := target.NewGroupBudget(allTargets)
+	if err != nil {
+		return err
+	}
+
 	status.UnavailablePartitions = 0
 	status.MaxUnavailablePartitions, err = target.MaxUnavailablePartitions(partitions, allTargets)
 	if err != nil {
 		return err
 	}
 
-	for _, partition := range partitions {
+	now := h.now()
+	for i := range partitions {
+		partition := &partitions[i]
 		for _, target := range partition.Targets {
-			if target.Deployment == nil {
+			if target.RenderError != nil {
+				// the current deployment is kept until the target renders
+				continue
+			}
+			if target.Deployment == nil && target.HasNamespace() {
 				newTarget(target, status)
 			}
 			if target.Deployment != nil {
@@ -151,29 +244,59 @@ func (h *handler) calculateChanges(status *fleet.BundleStatus, allTargets []*tar
 		}
 
 		for _, currentTarget := range partition.Targets {
-			updateManifest(currentTarget, status, &partition.Status)
+			updateManifest(currentTarget, status, &partition.Status, groupBudget, now)
 		}
 
 		if target.IsPartitionUnavailable(&partition.Status, partition.Targets) {
 			status.UnavailablePartitions++
 		}
+		target.SetPartitionCompleted(&partition.Status, previous[partition.Status.Name], now)
 
 		if status.UnavailablePartitions > status.MaxUnavailablePartitions {
 			break
 		}
+		// the next step of the rollout waits until this one is available
+		if partition.Sequential && partition.Status.Unavailable > 0 {
+			break
+		}
+		// the next partition waits until this one has soaked
+		if target.SetPartitionSoaking(&partition.Status, partition.Targets, now) {
+			break
+		}
 	}
 
 	for _, partition := range partitions {
+		// partitions after the last one rolled out keep when they were last completed
+		if partition.Status.LastCompleted == nil && previous[partition.Status.Name] != nil {
+			partition.Status.LastCompleted = previous[partition.Status.Name].LastCompleted
+		}
 		status.PartitionStatus = append(status.PartitionStatus, partition.Status)
+
+		last := status.Summary.LastPartitionCompleted
+		if partition.Status.LastCompleted != nil && (last == nil || last.Before(partition.Status.LastCompleted)) {
+			status.Summary.LastPartitionCompleted = partition.Status.LastCompleted
+		}
 	}
 
 	return nil
 }
 
-func updateManifest(t *target.Target, status *fleet.BundleStatus, partitionStatus *fleet.PartitionStatus) {
+func updateManifest(t *target.Target, status *fleet.BundleStatus, partitionStatus *fleet.PartitionStatus, groupBudget *target.GroupBudget, now time.Time) {
+	// a target with an invalid maintenance window is not updated, the error is reported in its message
+	inWindow, err := t.InMaintenanceWindow(now)
+	if err != nil {
+		inWindow = false
+	}
+
 	if t.Deployment != nil &&
+		// Rendered
+		t.RenderError == nil &&
 		// Not Paused
 		!t.IsPaused() &&
+		// In a maintenance window
+		inWindow &&
+		// Bundles this depends on are ready
+		t.DependenciesSatisfied &&
 		// Has been staged
 		t.Deployment.Spec.StagedDeploymentID != "" &&
 		// Is out of sync
@@ -181,11 +304,14 @@ func updateManifest(t *target.Target, status *fleet.BundleStatus, partitionStatu
 		// Global max unavailable not reached
 		(status.Unavailable < status.MaxUnavailable || target.IsUnavailable(t.Deployment)) &&
 		// Partition max unavailable not reached
-		(partitionStatus.Unavailable < partitionStatus.MaxUnavailable || target.IsUnavailable(t.Deployment)) {
-		if !target.IsUnavailable(t.Deployment) {
+		(partitionStatus.Unavailable < partitionStatus.MaxUnavailable || target.IsUnavailable(t.Deployment)) &&
+		// Cluster group max unavailable not reached
+		(groupBudget.Allows(t) || target.IsUnavailable(t.Deployment)) {
+		if !target.IsUnavailable(t.Deployment) && !t.SkipOffline() {
 			// If this was previously available, now increment unavailable count. "Upgrading" is treated as unavailable.
 			status.Unavailable++
 			partitionStatus.Unavailable++
+			groupBudget.Consume(t)
 		}
 		t.Deployment.Spec.DeploymentID = t.Deployment.Spec.StagedDeploymentID
 		t.Deployment.Spec.Options = t.Deployment.Spec.StagedOptions
@@ -0,0 +1,749 @@
+package bundle
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/config"
+	fleetcontrollers "github.com/rancher/fleet/pkg/generated/controllers/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/manifest"
+	"github.com/rancher/fleet/pkg/target"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+type fakeClusterCache struct {
+	fleetcontrollers.ClusterCache
+	clusters []*fleet.Cluster
+}
+
+func (f *fakeClusterCache) List(namespace string, selector labels.Selector) (result []*fleet.Cluster, _ error) {
+	for _, cluster := range f.clusters {
+		if cluster.Namespace == namespace && selector.Matches(labels.Set(cluster.Labels)) {
+			result = append(result, cluster)
+		}
+	}
+	return result, nil
+}
+
+type fakeClusterGroupCache struct {
+	fleetcontrollers.ClusterGroupCache
+}
+
+func (f *fakeClusterGroupCache) List(string, labels.Selector) ([]*fleet.ClusterGroup, error) {
+	return nil, nil
+}
+
+type fakeBundleDeploymentCache struct {
+	fleetcontrollers.BundleDeploymentCache
+	deployments []*fleet.BundleDeployment
+}
+
+func (f *fakeBundleDeploymentCache) List(_ string, selector labels.Selector) (result []*fleet.BundleDeployment, _ error) {
+	for _, bd := range f.deployments {
+		if selector.Matches(labels.Set(bd.Labels)) {
+			result = append(result, bd)
+		}
+	}
+	return result, nil
+}
+
+type fakeStore struct{}
+
+func (f *fakeStore) Store(m *manifest.Manifest) (string, error) {
+	_, id, err := m.Content()
+	return id, err
+}
+
+type fakeBundles struct {
+	fleetcontrollers.BundleController
+	enqueued []time.Duration
+}
+
+func (f *fakeBundles) EnqueueAfter(_, _ string, duration time.Duration) {
+	f.enqueued = append(f.enqueued, duration)
+}
+
+func newTestHandler(clusters ...*fleet.Cluster) (*handler, *fakeBundles) {
+	if err := config.Set(&config.Config{}); err != nil {
+		panic(err)
+	}
+	bundles := &fakeBundles{}
+	return &handler{
+		ctx:     context.Background(),
+		targets: target.New(&fakeClusterCache{clusters: clusters}, &fakeClusterGroupCache{}, nil, &fakeStore{}, &fakeBundleDeploymentCache{}),
+		bundles: bundles,
+		now:     time.Now,
+	}, bundles
+}
+
+func newCluster(name string, clusterLabels map[string]string) *fleet.Cluster {
+	return &fleet.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "fleet-default",
+			Labels:    clusterLabels,
+		},
+		Status: fleet.ClusterStatus{
+			Namespace: "cluster-fleet-default-" + name,
+		},
+	}
+}
+
+func newBundle(targets ...fleet.BundleTarget) *fleet.Bundle {
+	return &fleet.Bundle{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "fleet-default",
+		},
+		Spec: fleet.BundleSpec{
+			Resources: []fleet.BundleResource{
+				{Name: "manifests/configmap.yaml", Content: "kind: ConfigMap\n"},
+			},
+			Targets: targets,
+		},
+	}
+}
+
+// deployments returns the bundle deployments of the objects by cluster namespace
+func deployments(objs []runtime.Object) map[string]*fleet.BundleDeployment {
+	result := map[string]*fleet.BundleDeployment{}
+	for _, obj := range objs {
+		if bd, ok := obj.(*fleet.BundleDeployment); ok {
+			result[bd.Namespace] = bd
+		}
+	}
+	return result
+}
+
+// stagedTarget returns a ready target of the cluster with a new deployment ID staged
+func stagedTarget(clusterName string, clusterLabels map[string]string) *target.Target {
+	return &target.Target{
+		Bundle: &fleet.Bundle{},
+		Cluster: &fleet.Cluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      clusterName,
+				Namespace: "fleet-default",
+				Labels:    clusterLabels,
+			},
+			Status: fleet.ClusterStatus{
+				Namespace: "cluster-fleet-default-" + clusterName,
+			},
+		},
+		Deployment: &fleet.BundleDeployment{
+			Spec: fleet.BundleDeploymentSpec{
+				DeploymentID:       "v1",
+				StagedDeploymentID: "v2",
+			},
+			Status: fleet.BundleDeploymentStatus{
+				AppliedDeploymentID: "v1",
+				Ready:               true,
+			},
+		},
+		DeploymentID:          "v2",
+		DependenciesSatisfied: true,
+	}
+}
+
+func TestInvalidMaintenanceWindowClosesOnlyItsTarget(t *testing.T) {
+	var (
+		good = stagedTarget("good", map[string]string{fleet.MaintenanceWindowLabel: "0000-0000"})
+		bad  = stagedTarget("bad", map[string]string{fleet.MaintenanceWindowLabel: "midnight"})
+
+		status          = &fleet.BundleStatus{MaxUnavailable: 2}
+		partitionStatus = &fleet.PartitionStatus{MaxUnavailable: 2}
+	)
+
+	for _, currentTarget := range []*target.Target{bad, good} {
+		updateManifest(currentTarget, status, partitionStatus, nil, time.Now())
+	}
+
+	if good.Deployment.Spec.DeploymentID != "v2" {
+		t.Errorf("expected the target with a valid maintenance window to be updated, got %s", good.Deployment.Spec.DeploymentID)
+	}
+	if bad.Deployment.Spec.DeploymentID != "v1" {
+		t.Errorf("expected the target with an invalid maintenance window not to be updated, got %s", bad.Deployment.Spec.DeploymentID)
+	}
+	if message := bad.Message(); !strings.Contains(message, "invalid "+fleet.MaintenanceWindowLabel+" label") {
+		t.Errorf("expected the error in the message of the target, got %q", message)
+	}
+	if message := good.Message(); strings.Contains(message, "invalid") {
+		t.Errorf("expected no error in the message of the other target, got %q", message)
+	}
+}
+
+func TestUpdateManifestMaintenanceWindowAt(t *testing.T) {
+	day := time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		updated bool
+	}{
+		{
+			name:    "in the window",
+			now:     day.Add(3 * time.Hour),
+			updated: true,
+		},
+		{
+			name: "before the window",
+			now:  day.Add(time.Hour),
+		},
+		{
+			name: "after the window",
+			now:  day.Add(5 * time.Hour),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				currentTarget   = stagedTarget("prod", map[string]string{fleet.MaintenanceWindowLabel: "0200-0400"})
+				status          = &fleet.BundleStatus{MaxUnavailable: 1}
+				partitionStatus = &fleet.PartitionStatus{MaxUnavailable: 1}
+			)
+
+			updateManifest(currentTarget, status, partitionStatus, nil, test.now)
+			if updated := currentTarget.Deployment.Spec.DeploymentID == "v2"; updated != test.updated {
+				t.Errorf("expected updated %v at %v, got %v", test.updated, test.now, updated)
+			}
+		})
+	}
+}
+
+func TestRequeueAtPausedUntil(t *testing.T) {
+	h, bundles := newTestHandler(newCluster("prod-1", nil))
+
+	bundle := newBundle(fleet.BundleTarget{Name: "all", All: true})
+	bundle.Spec.PausedUntil = &metav1.Time{Time: time.Now().Add(time.Hour)}
+	if _, _, err := h.OnBundleChange(bundle, fleet.BundleStatus{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(bundles.enqueued) != 1 || bundles.enqueued[0] <= 59*time.Minute || bundles.enqueued[0] > time.Hour {
+		t.Errorf("expected the bundle to be requeued at the resume time, got %v", bundles.enqueued)
+	}
+
+	bundles.enqueued = nil
+	bundle.Spec.PausedUntil = &metav1.Time{Time: time.Now().Add(-time.Hour)}
+	if _, _, err := h.OnBundleChange(bundle, fleet.BundleStatus{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(bundles.enqueued) != 0 {
+		t.Errorf("expected a resumed bundle not to be requeued, got %v", bundles.enqueued)
+	}
+}
+
+func TestRolloutStepsWaitForAvailableBatch(t *testing.T) {
+	maxUnavailable := intstr.FromString("100%")
+	bundle := &fleet.Bundle{
+		Spec: fleet.BundleSpec{
+			RolloutStrategy: &fleet.RolloutStrategy{
+				MaxUnavailable: &maxUnavailable,
+				Steps:          []intstr.IntOrString{intstr.FromInt(1), intstr.FromString("100%")},
+			},
+		},
+	}
+
+	var targets []*target.Target
+	for _, name := range []string{"canary", "prod-1", "prod-2"} {
+		currentTarget := stagedTarget(name, nil)
+		currentTarget.Bundle = bundle
+		targets = append(targets, currentTarget)
+	}
+	deploymentIDs := func() (result []string) {
+		for _, currentTarget := range targets {
+			result = append(result, currentTarget.Deployment.Spec.DeploymentID)
+		}
+		return result
+	}
+
+	h, _ := newTestHandler()
+	status := &fleet.BundleStatus{}
+	if err := h.calculateChanges(status, targets); err != nil {
+		t.Fatal(err)
+	}
+	if actual := deploymentIDs(); !reflect.DeepEqual(actual, []string{"v2", "v1", "v1"}) {
+		t.Errorf("expected only the first step to be rolled out, got %v", actual)
+	}
+
+	// the first step is still being applied
+	if err := h.calculateChanges(status, targets); err != nil {
+		t.Fatal(err)
+	}
+	if actual := deploymentIDs(); !reflect.DeepEqual(actual, []string{"v2", "v1", "v1"}) {
+		t.Errorf("expected the next step to wait for the first one, got %v", actual)
+	}
+
+	targets[0].Deployment.Status.AppliedDeploymentID = "v2"
+	if err := h.calculateChanges(status, targets); err != nil {
+		t.Fatal(err)
+	}
+	if actual := deploymentIDs(); !reflect.DeepEqual(actual, []string{"v2", "v2", "v2"}) {
+		t.Errorf("expected the next step to be rolled out once the first one is available, got %v", actual)
+	}
+}
+
+func TestPartitionSoak(t *testing.T) {
+	maxUnavailable := intstr.FromString("100%")
+	bundle := &fleet.Bundle{
+		Spec: fleet.BundleSpec{
+			RolloutStrategy: &fleet.RolloutStrategy{
+				MaxUnavailable:       &maxUnavailable,
+				Steps:                []intstr.IntOrString{intstr.FromInt(1), intstr.FromString("100%")},
+				PartitionSoakSeconds: 600,
+			},
+		},
+	}
+
+	var targets []*target.Target
+	for _, name := range []string{"canary", "prod-1", "prod-2"} {
+		currentTarget := stagedTarget(name, nil)
+		currentTarget.Bundle = bundle
+		targets = append(targets, currentTarget)
+	}
+	deploymentIDs := func() (result []string) {
+		for _, currentTarget := range targets {
+			result = append(result, currentTarget.Deployment.Spec.DeploymentID)
+		}
+		return result
+	}
+
+	start := time.Date(2020, 10, 3, 12, 0, 0, 0, time.UTC)
+	now := start
+	h, _ := newTestHandler()
+	h.now = func() time.Time { return now }
+
+	status := &fleet.BundleStatus{}
+	if err := h.calculateChanges(status, targets); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first step becomes available and soaks
+	targets[0].Deployment.Status.AppliedDeploymentID = "v2"
+	for _, minutes := range []int{0, 5} {
+		now = start.Add(time.Duration(minutes) * time.Minute)
+		if err := h.calculateChanges(status, targets); err != nil {
+			t.Fatal(err)
+		}
+		if actual := deploymentIDs(); !reflect.DeepEqual(actual, []string{"v2", "v1", "v1"}) {
+			t.Errorf("after %d minutes: expected the next step to be held back while soaking, got %v", minutes, actual)
+		}
+		if soaking := status.PartitionStatus[0].SoakingUntil; soaking == nil || !soaking.Time.Equal(start.Add(10*time.Minute)) {
+			t.Errorf("after %d minutes: expected the first step to soak until %v, got %v", minutes, start.Add(10*time.Minute), soaking)
+		}
+	}
+
+	now = start.Add(10 * time.Minute)
+	if err := h.calculateChanges(status, targets); err != nil {
+		t.Fatal(err)
+	}
+	if actual := deploymentIDs(); !reflect.DeepEqual(actual, []string{"v2", "v2", "v2"}) {
+		t.Errorf("expected the next step to be rolled out after the soak time, got %v", actual)
+	}
+	if soaking := status.PartitionStatus[0].SoakingUntil; soaking != nil {
+		t.Errorf("expected the first step to have soaked, got %v", soaking)
+	}
+}
+
+func TestKeepResourcesPropagates(t *testing.T) {
+	tests := []struct {
+		name          string
+		bundle        bool
+		target        bool
+		keepResources bool
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name:          "bundle",
+			bundle:        true,
+			keepResources: true,
+		},
+		{
+			name:          "target",
+			target:        true,
+			keepResources: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, _ := newTestHandler(newCluster("prod-1", nil))
+
+			bundle := newBundle(fleet.BundleTarget{
+				Name:                    "all",
+				All:                     true,
+				BundleDeploymentOptions: fleet.BundleDeploymentOptions{KeepResources: test.target},
+			})
+			bundle.Spec.KeepResources = test.bundle
+
+			objs, _, err := h.OnBundleChange(bundle, fleet.BundleStatus{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			bd := deployments(objs)["cluster-fleet-default-prod-1"]
+			if bd == nil {
+				t.Fatalf("expected a bundle deployment for the cluster, got %v", objs)
+			}
+			if bd.Spec.Options.KeepResources != test.keepResources || bd.Spec.StagedOptions.KeepResources != test.keepResources {
+				t.Errorf("expected keepResources %v in the options of the bundle deployment, got %+v", test.keepResources, bd.Spec)
+			}
+		})
+	}
+}
+
+func TestNoPruneResourcesPropagate(t *testing.T) {
+	h, _ := newTestHandler(newCluster("prod-1", nil))
+
+	bundle := newBundle(fleet.BundleTarget{
+		Name:                    "all",
+		All:                     true,
+		BundleDeploymentOptions: fleet.BundleDeploymentOptions{NoPruneResources: []string{"ConfigMap/settings"}},
+	})
+	bundle.Spec.NoPruneResources = []string{"PersistentVolumeClaim"}
+
+	objs, _, err := h.OnBundleChange(bundle, fleet.BundleStatus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bd := deployments(objs)["cluster-fleet-default-prod-1"]
+	if bd == nil {
+		t.Fatalf("expected a bundle deployment for the cluster, got %v", objs)
+	}
+
+	expected := []string{"PersistentVolumeClaim", "ConfigMap/settings"}
+	if !reflect.DeepEqual(bd.Spec.Options.NoPruneResources, expected) || !reflect.DeepEqual(bd.Spec.StagedOptions.NoPruneResources, expected) {
+		t.Errorf("expected noPruneResources %v in the options of the bundle deployment, got %+v", expected, bd.Spec)
+	}
+}
+
+func TestApplyConcurrencyPropagates(t *testing.T) {
+	tests := []struct {
+		name     string
+		bundle   int
+		target   int
+		expected int
+	}{
+		{
+			name: "not set",
+		},
+		{
+			name:     "bundle",
+			bundle:   8,
+			expected: 8,
+		},
+		{
+			name:     "target",
+			bundle:   8,
+			target:   4,
+			expected: 4,
+		},
+		{
+			name:   "cleared by the target",
+			bundle: 8,
+			target: -1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, _ := newTestHandler(newCluster("prod-1", nil))
+
+			bundle := newBundle(fleet.BundleTarget{
+				Name:                    "all",
+				All:                     true,
+				BundleDeploymentOptions: fleet.BundleDeploymentOptions{ApplyConcurrency: test.target},
+			})
+			bundle.Spec.ApplyConcurrency = test.bundle
+
+			objs, _, err := h.OnBundleChange(bundle, fleet.BundleStatus{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			bd := deployments(objs)["cluster-fleet-default-prod-1"]
+			if bd == nil {
+				t.Fatalf("expected a bundle deployment for the cluster, got %v", objs)
+			}
+			if bd.Spec.Options.ApplyConcurrency != test.expected || bd.Spec.StagedOptions.ApplyConcurrency != test.expected {
+				t.Errorf("expected applyConcurrency %d in the options of the bundle deployment, got %+v", test.expected, bd.Spec)
+			}
+		})
+	}
+}
+
+func TestClusterWithoutNamespace(t *testing.T) {
+	pending := newCluster("new-1", nil)
+	pending.Status.Namespace = ""
+	h, _ := newTestHandler(newCluster("prod-1", nil), pending)
+
+	objs, status, err := h.OnBundleChange(newBundle(fleet.BundleTarget{Name: "all", All: true}), fleet.BundleStatus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bds := deployments(objs)
+	if _, ok := bds[""]; ok {
+		t.Errorf("expected no bundle deployment without a namespace, got %v", bds)
+	}
+	if len(bds) != 1 || bds["cluster-fleet-default-prod-1"] == nil {
+		t.Errorf("expected only the bundle deployment of the cluster with a namespace, got %v", bds)
+	}
+
+	// the new bundle deployment hasn't been applied yet either, so both clusters are pending
+	if status.Summary.DesiredReady != 2 || status.Summary.Pending != 2 {
+		t.Errorf("expected both clusters to be pending, got %+v", status.Summary)
+	}
+	found := false
+	for _, resource := range status.Summary.NonReadyResources {
+		if resource.Name == "fleet-default/new-1" {
+			found = resource.State == fleet.Pending && resource.Message == "waiting for cluster namespace to be assigned"
+		}
+	}
+	if !found {
+		t.Errorf("expected the cluster without a namespace to be waiting for it, got %+v", status.Summary.NonReadyResources)
+	}
+}
+
+func TestServiceAccountPropagates(t *testing.T) {
+	h, _ := newTestHandler(
+		newCluster("default-1", map[string]string{"sa": "default"}),
+		newCluster("custom-1", map[string]string{"sa": "custom"}),
+		newCluster("cleared-1", map[string]string{"sa": "cleared"}),
+	)
+
+	target := func(name, serviceAccount string) fleet.BundleTarget {
+		return fleet.BundleTarget{
+			Name:                    name,
+			ClusterSelector:         &metav1.LabelSelector{MatchLabels: map[string]string{"sa": name}},
+			BundleDeploymentOptions: fleet.BundleDeploymentOptions{ServiceAccount: serviceAccount},
+		}
+	}
+	bundle := newBundle(
+		target("default", ""),
+		target("custom", "custom"),
+		target("cleared", "-"),
+	)
+	// the service account of the GitRepo is set on the bundle by fleet apply
+	bundle.Spec.ServiceAccount = "repo"
+
+	objs, _, err := h.OnBundleChange(bundle, fleet.BundleStatus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bds := deployments(objs)
+	for cluster, expected := range map[string]string{"default-1": "repo", "custom-1": "custom", "cleared-1": ""} {
+		bd := bds["cluster-fleet-default-"+cluster]
+		if bd == nil {
+			t.Fatalf("expected a bundle deployment for %s, got %v", cluster, bds)
+		}
+		if bd.Spec.Options.ServiceAccount != expected || bd.Spec.StagedOptions.ServiceAccount != expected {
+			t.Errorf("%s: expected service account %q, got %q", cluster, expected, bd.Spec.Options.ServiceAccount)
+		}
+	}
+}
+
+func TestAnnotationsPropagate(t *testing.T) {
+	h, _ := newTestHandler(newCluster("prod-1", nil))
+
+	bundle := newBundle(fleet.BundleTarget{Name: "all", All: true})
+	bundle.Annotations = map[string]string{
+		"deployment.fleet.cattle.io/owner": "team-a",
+		"fleet.cattle.io/commit":           "abc123",
+		"example.com/note":                 "not copied",
+	}
+
+	objs, _, err := h.OnBundleChange(bundle, fleet.BundleStatus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bd := deployments(objs)["cluster-fleet-default-prod-1"]
+	if bd == nil {
+		t.Fatalf("expected a bundle deployment for the cluster, got %v", objs)
+	}
+	if expected := map[string]string{"deployment.fleet.cattle.io/owner": "team-a"}; !reflect.DeepEqual(bd.Annotations, expected) {
+		t.Errorf("expected annotations %v on the bundle deployment, got %v", expected, bd.Annotations)
+	}
+
+	// changing the annotations doesn't change the deployment
+	bundle.Annotations["deployment.fleet.cattle.io/owner"] = "team-b"
+	objs, _, err = h.OnBundleChange(bundle, fleet.BundleStatus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := deployments(objs)["cluster-fleet-default-prod-1"]
+	if changed.Annotations["deployment.fleet.cattle.io/owner"] != "team-b" {
+		t.Errorf("expected the changed annotation on the bundle deployment, got %v", changed.Annotations)
+	}
+	if changed.Spec.DeploymentID != bd.Spec.DeploymentID {
+		t.Errorf("expected the deployment ID %s not to change, got %s", bd.Spec.DeploymentID, changed.Spec.DeploymentID)
+	}
+}
+
+func TestContinueOnRenderError(t *testing.T) {
+	newRenderBundle := func(continueOnRenderError bool) *fleet.Bundle {
+		bundle := newBundle(
+			fleet.BundleTarget{
+				Name:            "broken",
+				ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"render": "broken"}},
+				Overlays:        []string{"missing"},
+			},
+			fleet.BundleTarget{Name: "all", All: true},
+		)
+		bundle.Spec.RolloutStrategy = &fleet.RolloutStrategy{ContinueOnRenderError: continueOnRenderError}
+		return bundle
+	}
+	clusters := []*fleet.Cluster{
+		newCluster("prod-1", nil),
+		newCluster("broken-1", map[string]string{"render": "broken"}),
+		newCluster("prod-2", nil),
+	}
+
+	// the current deployment of the cluster that fails to render is kept
+	current := &fleet.BundleDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: clusters[1].Status.Namespace,
+			Labels:    target.DeploymentLabels(newRenderBundle(true)),
+		},
+		Spec: fleet.BundleDeploymentSpec{DeploymentID: "current", StagedDeploymentID: "current"},
+	}
+	h, _ := newTestHandler(clusters...)
+	h.targets = target.New(&fakeClusterCache{clusters: clusters}, &fakeClusterGroupCache{}, nil, &fakeStore{},
+		&fakeBundleDeploymentCache{deployments: []*fleet.BundleDeployment{current}})
+	if _, _, err := h.OnBundleChange(newRenderBundle(false), fleet.BundleStatus{}); err == nil {
+		t.Error("expected a target that fails to render to fail the bundle by default")
+	}
+
+	objs, status, err := h.OnBundleChange(newRenderBundle(true), fleet.BundleStatus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bds := deployments(objs)
+	if len(bds) != 3 || bds["cluster-fleet-default-prod-1"] == nil || bds["cluster-fleet-default-prod-2"] == nil {
+		t.Errorf("expected bundle deployments for the clusters that render, got %v", bds)
+	}
+	if bd := bds[current.Namespace]; bd == nil || bd.Spec.DeploymentID != "current" || bd.Spec.StagedDeploymentID != "current" {
+		t.Errorf("expected the current deployment of broken-1 to be kept, got %v", bd)
+	}
+	if status.Summary.ErrApplied != 1 || status.Summary.DesiredReady != 3 {
+		t.Errorf("expected the cluster that fails to render to be reported as ErrApplied, got %+v", status.Summary)
+	}
+	found := false
+	for _, resource := range status.Summary.NonReadyResources {
+		if resource.Name == "fleet-default/broken-1" {
+			found = resource.State == fleet.ErrApplied && strings.Contains(resource.Message, "failed to render: failed to find referenced overlay missing")
+		}
+	}
+	if !found {
+		t.Errorf("expected the render error in the status of broken-1, got %+v", status.Summary.NonReadyResources)
+	}
+}
+
+func TestStatsInStatus(t *testing.T) {
+	h, _ := newTestHandler(newCluster("prod-1", nil))
+
+	bundle := newBundle(fleet.BundleTarget{Name: "all", All: true})
+	bundle.Spec.Overlays = []fleet.BundleOverlay{
+		{Name: "prod", Resources: []fleet.BundleResource{{Name: "values.yaml", Content: "replicas: 3\n"}}},
+	}
+
+	_, status, err := h.OnBundleChange(bundle, fleet.BundleStatus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := len("kind: ConfigMap\n") + len("replicas: 3\n")
+	if status.ResourceCount != 2 || status.ResourcesSize != size || status.ResourcesStoredSize != size {
+		t.Errorf("expected 2 resources of %d bytes in the status, got %d resources of %d bytes, %d stored",
+			size, status.ResourceCount, status.ResourcesSize, status.ResourcesStoredSize)
+	}
+}
+
+func TestMaxUnavailablePerGroup(t *testing.T) {
+	newRollout := func(maxUnavailable, perGroup intstr.IntOrString) *fleet.Bundle {
+		noPartitions := intstr.FromInt(0)
+		return &fleet.Bundle{
+			Spec: fleet.BundleSpec{
+				RolloutStrategy: &fleet.RolloutStrategy{
+					MaxUnavailable:         &maxUnavailable,
+					MaxUnavailablePerGroup: &perGroup,
+					AutoPartitionSize:      &noPartitions,
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		bundle   *fleet.Bundle
+		failing  string
+		expected map[string]int
+	}{
+		{
+			name:     "each group has its own budget",
+			bundle:   newRollout(intstr.FromString("100%"), intstr.FromInt(1)),
+			expected: map[string]int{"eu": 1, "us": 1},
+		},
+		{
+			name:     "an outage in one group does not consume the budget of the other",
+			bundle:   newRollout(intstr.FromString("100%"), intstr.FromInt(1)),
+			failing:  "eu-0",
+			expected: map[string]int{"eu": 0, "us": 1},
+		},
+		{
+			name:     "percentage per group",
+			bundle:   newRollout(intstr.FromString("100%"), intstr.FromString("50%")),
+			expected: map[string]int{"eu": 2, "us": 2},
+		},
+		{
+			name:     "the global budget is an upper bound",
+			bundle:   newRollout(intstr.FromInt(3), intstr.FromInt(2)),
+			expected: map[string]int{"eu": 2, "us": 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var targets []*target.Target
+			for _, group := range []string{"eu", "us"} {
+				for i := 0; i < 4; i++ {
+					currentTarget := stagedTarget(fmt.Sprintf("%s-%d", group, i), nil)
+					currentTarget.Bundle = test.bundle
+					currentTarget.ClusterGroups = []*fleet.ClusterGroup{{ObjectMeta: metav1.ObjectMeta{Name: group}}}
+					if currentTarget.Cluster.Name == test.failing {
+						// failing on the current deployment, which is left as it is
+						currentTarget.Deployment.Status.Ready = false
+						currentTarget.Deployment.Spec.StagedDeploymentID = "v1"
+						currentTarget.DeploymentID = "v1"
+					}
+					targets = append(targets, currentTarget)
+				}
+			}
+
+			h, _ := newTestHandler()
+			if err := h.calculateChanges(&fleet.BundleStatus{}, targets); err != nil {
+				t.Fatal(err)
+			}
+
+			updated := map[string]int{}
+			for _, currentTarget := range targets {
+				if currentTarget.Deployment.Spec.DeploymentID == "v2" {
+					updated[currentTarget.ClusterGroups[0].Name]++
+				}
+			}
+			for group, count := range test.expected {
+				if updated[group] != count {
+					t.Errorf("expected %d targets of group %s to be updated, got %v", count, group, updated)
+				}
+			}
+		})
+	}
+}
@@ -98,6 +98,7 @@ func (h *handler) OnClusterChanged(cluster *fleet.Cluster, status fleet.ClusterS
 		summary.IncrementState(&status.Summary, app.Name, state, summary.MessageFromDeployment(app))
 		status.Summary.DesiredReady++
 	}
+	summary.SetReadyPercent(&status.Summary)
 
 	summary.SetReadyConditions(&status, status.Summary)
 	return []runtime.Object{
@@ -69,6 +69,7 @@ func (h *handler) OnClusterGroup(clusterGroup *fleet.ClusterGroup, status fleet.
 		}
 	}
 
+	summary.SetReadyPercent(&status.Summary)
 	summary.SetReadyConditions(&status, status.Summary)
 	return status, nil
 }
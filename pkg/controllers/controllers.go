@@ -179,7 +179,9 @@ func Register(ctx context.Context, systemNamespace string, cfg clientcmd.ClientC
 			appCtx.GitJob.GitJob(),
 			appCtx.Core.ServiceAccount()),
 		appCtx.GitJob.GitJob(),
-		appCtx.GitRepo())
+		appCtx.GitRepo(),
+		appCtx.Bundle(),
+		appCtx.Core.Secret().Cache())
 
 	bootstrap.Register(ctx,
 		systemNamespace,
@@ -2,6 +2,11 @@ package git
 
 import (
 	"context"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
@@ -10,27 +15,270 @@ import (
 	gitjob "github.com/rancher/gitjob/pkg/apis/gitjob.cattle.io/v1"
 	v1 "github.com/rancher/gitjob/pkg/generated/controllers/gitjob.cattle.io/v1"
 	"github.com/rancher/wrangler/pkg/apply"
+	"github.com/rancher/wrangler/pkg/condition"
+	corecontrollers "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
 	"github.com/rancher/wrangler/pkg/name"
 	"github.com/rancher/wrangler/pkg/relatedresource"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
 )
 
-func Register(ctx context.Context, apply apply.Apply, gitJobs v1.GitJobController, gitRepos fleetcontrollers.GitRepoController) {
+const (
+	repoNameLabel = "fleet.cattle.io/repo-name"
+	// repoGenerationAnnotation is the generation of the GitRepo the git job was last updated for
+	repoGenerationAnnotation = "fleet.cattle.io/repo-generation"
+
+	defaultBranch         = "master"
+	defaultWorkingDir     = "/workspace/source"
+	queuedRequeueInterval = 15 * time.Second
+
+	// jobStatusInProgress and jobStatusFailed are the kstatus values gitjob reports for the job of the commit
+	jobStatusInProgress = "InProgress"
+	jobStatusFailed     = "Failed"
+
+	pollingProvider = "polling"
+	githubProvider  = "github"
+	gitlabProvider  = "gitlab"
+	webhookProvider = "webhook"
+
+	defaultGitHostname = "github.com"
+
+	// webhookSecretKey is the key of the WebhookSecretName secret holding the token of webhook requests
+	webhookSecretKey       = "token"
+	defaultWebhookFallback = time.Hour
+)
+
+var (
+	gitRepoConditionQueued      = condition.Cond("Queued")
+	gitRepoConditionPaused      = condition.Cond("Paused")
+	gitRepoConditionDryRun      = condition.Cond("DryRun")
+	gitRepoConditionPlan        = condition.Cond("Plan")
+	gitRepoConditionAccepted    = condition.Cond("Accepted")
+	gitRepoConditionPullSecrets = condition.Cond("ImagePullSecrets")
+	gitRepoConditionProvider    = condition.Cond("Provider")
+
+	// gitJobConditionStalled is set by gitjob with the error of a failed job
+	gitJobConditionStalled = condition.Cond("Stalled")
+)
+
+func Register(ctx context.Context, apply apply.Apply, gitJobs v1.GitJobController, gitRepos fleetcontrollers.GitRepoController,
+	bundles fleetcontrollers.BundleController, secrets corecontrollers.SecretCache) {
 	h := &handler{
 		gitjobCache: gitJobs.Cache(),
+		gitRepos:    gitRepos,
+		bundleCache: bundles.Cache(),
+		secretCache: secrets,
 	}
 
 	fleetcontrollers.RegisterGitRepoGeneratingHandler(ctx, gitRepos, apply, "", "gitjobs", h.OnChange, nil)
 	relatedresource.Watch(ctx, "gitjobs",
 		relatedresource.OwnerResolver(true, fleet.SchemeGroupVersion.String(), "GitRepo"), gitRepos, gitJobs)
+	relatedresource.Watch(ctx, "gitrepo-bundles", resolveRepo, gitRepos, bundles)
 }
 
 type handler struct {
 	gitjobCache v1.GitJobCache
+	gitRepos    fleetcontrollers.GitRepoController
+	bundleCache fleetcontrollers.BundleCache
+	secretCache corecontrollers.SecretCache
+}
+
+// resolveRepo enqueues the gitrepo that created a bundle so the bundles in its status are kept up to date
+func resolveRepo(_, _ string, obj runtime.Object) ([]relatedresource.Key, error) {
+	if bundle, ok := obj.(*fleet.Bundle); ok && bundle.Labels[repoNameLabel] != "" {
+		return []relatedresource.Key{
+			{
+				Namespace: bundle.Namespace,
+				Name:      bundle.Labels[repoNameLabel],
+			},
+		}, nil
+	}
+	return nil, nil
+}
+
+// bundleNames returns the sorted names of the bundles created by the gitrepo
+func (h *handler) bundleNames(gitrepo *fleet.GitRepo) ([]string, error) {
+	bundles, err := h.bundleCache.List(gitrepo.Namespace, labels.SelectorFromSet(labels.Set{
+		repoNameLabel: gitrepo.Name,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, bundle := range bundles {
+		result = append(result, bundle.Name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// allowJobRun returns false if another run of a git job would exceed the configured MaxConcurrentGitJobs.
+// Only the git jobs of GitRepos that are running count, and the git job of the gitrepo never counts against
+// its own run.
+func (h *handler) allowJobRun(gitrepo *fleet.GitRepo) (bool, error) {
+	max := config.Get().MaxConcurrentGitJobs
+	if max <= 0 {
+		return true, nil
+	}
+
+	owned, err := labels.NewRequirement(repoNameLabel, selection.Exists, nil)
+	if err != nil {
+		return false, err
+	}
+
+	gitJobs, err := h.gitjobCache.List("", labels.NewSelector().Add(*owned))
+	if err != nil {
+		return false, err
+	}
+
+	running := 0
+	for _, gitJob := range gitJobs {
+		if gitJob.Namespace == gitrepo.Namespace && gitJob.Name == gitrepo.Name {
+			continue
+		}
+		if isRunning(gitJob) {
+			running++
+		}
+	}
+
+	return running < max, nil
+}
+
+// isRunning returns true if a job of the git job is running or about to run for the commit the git job
+// has fetched, but has not completed or failed yet
+func isRunning(gitJob *gitjob.GitJob) bool {
+	switch gitJob.Status.JobStatus {
+	case jobStatusInProgress:
+		return true
+	case jobStatusFailed:
+		return false
+	}
+	return gitJob.Status.Commit != "" && gitJob.Status.Commit != gitJob.Status.LastExecutedCommit
+}
+
+// setDryRunStatus sets the DryRun condition from the outcome of the last run of the git job, which only
+// validates the bundles. The condition is unknown until a run for the current commit completes.
+func setDryRunStatus(gitJob *gitjob.GitJob, status *fleet.GitRepoStatus) {
+	switch {
+	case gitJob != nil && gitJob.Status.JobStatus == jobStatusFailed:
+		message := gitJobConditionStalled.GetMessage(gitJob)
+		if message == "" {
+			message = "validation of the bundles failed"
+		}
+		gitRepoConditionDryRun.SetStatusBool(status, false)
+		gitRepoConditionDryRun.Message(status, message)
+	case gitJob == nil || gitJob.Status.Commit == "" || isRunning(gitJob):
+		gitRepoConditionDryRun.SetStatus(status, "Unknown")
+		gitRepoConditionDryRun.Message(status, "bundles are being validated")
+	default:
+		gitRepoConditionDryRun.SetStatusBool(status, true)
+		gitRepoConditionDryRun.Message(status, "bundles are valid at commit "+gitJob.Status.Commit+", they are not deployed")
+	}
+}
+
+// jobObjectMeta returns the metadata for an object generated for the gitrepo, including the
+// user supplied JobMetadata. Keys reserved for fleet and apply are never copied, and the objects are
+// labeled with the name of the gitrepo.
+func jobObjectMeta(gitrepo *fleet.GitRepo, name string) metav1.ObjectMeta {
+	labels := userMetadata(gitrepo.Spec.JobMetadata.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[repoNameLabel] = gitrepo.Name
+	return metav1.ObjectMeta{
+		Name:        name,
+		Namespace:   gitrepo.Namespace,
+		Labels:      labels,
+		Annotations: userMetadata(gitrepo.Spec.JobMetadata.Annotations),
+	}
+}
+
+// gitJobObjectMeta returns the metadata of the git job, which records the generation of the gitrepo it
+// was generated from
+func gitJobObjectMeta(gitrepo *fleet.GitRepo) metav1.ObjectMeta {
+	meta := jobObjectMeta(gitrepo, gitrepo.Name)
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[repoGenerationAnnotation] = strconv.FormatInt(gitrepo.Generation, 10)
+	return meta
+}
+
+func userMetadata(data map[string]string) map[string]string {
+	var result map[string]string
+	for k, v := range data {
+		if strings.HasPrefix(k, fleet.AnnotationGroup) || strings.HasPrefix(k, "objectset.rio.cattle.io/") {
+			continue
+		}
+		if result == nil {
+			result = map[string]string{}
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// pinCommit returns the branch and revision of the git job. If the gitrepo deploys a single commit the
+// git job tracks the branch until it reports a commit, which is then pinned until the spec of the gitrepo
+// changes. The commit is only pinned once gitjob has observed the git job updated for the current
+// generation of the gitrepo, so a commit reported for an earlier spec is never pinned.
+func pinCommit(gitrepo *fleet.GitRepo, gitJob *gitjob.GitJob, branch, rev string, status *fleet.GitRepoStatus) (string, string) {
+	if !gitrepo.Spec.DeployCommitOnly || rev != "" {
+		status.PinnedCommit = ""
+		status.PinnedGeneration = 0
+		return branch, rev
+	}
+
+	if status.PinnedGeneration != gitrepo.Generation {
+		status.PinnedCommit = ""
+	}
+
+	// only a git job tracking the branch reports its current commit
+	if status.PinnedCommit == "" && gitJob != nil && gitJob.Status.Commit != "" &&
+		gitJob.Spec.Git.Branch == branch && gitJob.Spec.Git.Revision == "" &&
+		gitJob.Annotations[repoGenerationAnnotation] == strconv.FormatInt(gitrepo.Generation, 10) &&
+		gitJob.Status.ObservedGeneration == gitJob.Generation {
+		status.PinnedCommit = gitJob.Status.Commit
+		status.PinnedGeneration = gitrepo.Generation
+	}
+
+	if status.PinnedCommit == "" {
+		return branch, ""
+	}
+	return "", status.PinnedCommit
+}
+
+// imagePullSecrets returns the image pull secrets of the git job and the names of those that do not
+// exist in the namespace of the gitrepo
+func (h *handler) imagePullSecrets(gitrepo *fleet.GitRepo) ([]corev1.LocalObjectReference, []string, error) {
+	names := gitrepo.Spec.ImagePullSecrets
+	if len(names) == 0 {
+		names = config.Get().GitJobImagePullSecrets
+	}
+
+	var (
+		result  []corev1.LocalObjectReference
+		missing []string
+	)
+	for _, name := range names {
+		if _, err := h.secretCache.Get(gitrepo.Namespace, name); apierrors.IsNotFound(err) {
+			missing = append(missing, name)
+		} else if err != nil {
+			return nil, nil, err
+		}
+		result = append(result, corev1.LocalObjectReference{
+			Name: name,
+		})
+	}
+	return result, missing, nil
 }
 
 func (h *handler) OnChange(gitrepo *fleet.GitRepo, status fleet.GitRepoStatus) ([]runtime.Object, fleet.GitRepoStatus, error) {
@@ -39,33 +287,218 @@ func (h *handler) OnChange(gitrepo *fleet.GitRepo, status fleet.GitRepoStatus) (
 		dirs = []string{"."}
 	}
 
+	serviceAccount := gitrepo.Spec.ServiceAccount
+	if serviceAccount == "" {
+		serviceAccount = config.Get().DefaultServiceAccount
+	}
+
+	args := []string{
+		"fleet",
+		"apply",
+		"--label=" + repoNameLabel + "=" + gitrepo.Name,
+		"--namespace", gitrepo.Namespace,
+		"--service-account", serviceAccount,
+	}
+	if gitrepo.Spec.TargetNamespace != "" {
+		args = append(args, "--target-namespace", gitrepo.Spec.TargetNamespace)
+	}
+	if gitrepo.Spec.DryRun {
+		args = append(args, "--dry-run")
+	} else if gitrepo.Spec.Plan {
+		args = append(args, "--plan-repo", gitrepo.Name)
+	}
+	paths := gitrepo.Spec.Paths
+	if len(paths) == 0 {
+		paths = dirs
+	}
+	for _, path := range paths {
+		args = append(args, "--paths", path)
+	}
+	if !gitrepo.Spec.DryRun && !gitrepo.Spec.Plan {
+		args = append(args, "--paths-repo", gitrepo.Name)
+	}
+	args = append(args, gitrepo.Name)
+
 	gitJob, err := h.gitjobCache.Get(gitrepo.Namespace, gitrepo.Name)
 	if err == nil {
 		status.Commit = gitJob.Status.Commit
 		status.Conditions = gitJob.Status.Conditions
-	} else {
+	} else if apierrors.IsNotFound(err) {
 		status.Commit = ""
 		status.Conditions = nil
+	} else {
+		return nil, status, err
+	}
+
+	saName := name.SafeConcatName("git", gitrepo.Name)
+
+	if serviceAccount == "" && config.Get().RequireServiceAccount {
+		return notAccepted(gitrepo, gitJob, saName, &status, "a serviceAccount is required, none is set on the gitrepo and no default is configured"), status, nil
+	}
+
+	workingDir := gitrepo.Spec.WorkingDir
+	if workingDir == "" {
+		workingDir = defaultWorkingDir
+	} else if !path.IsAbs(workingDir) {
+		return notAccepted(gitrepo, gitJob, saName, &status, "workingDir must be an absolute path: "+workingDir), status, nil
+	}
+
+	restartPolicy := corev1.RestartPolicy(gitrepo.Spec.JobRestartPolicy)
+	switch restartPolicy {
+	case "":
+		restartPolicy = corev1.RestartPolicyNever
+	case corev1.RestartPolicyNever, corev1.RestartPolicyOnFailure:
+	default:
+		return notAccepted(gitrepo, gitJob, saName, &status, "jobRestartPolicy must be Never or OnFailure: "+gitrepo.Spec.JobRestartPolicy), status, nil
+	}
+
+	switch gitrepo.Spec.Provider {
+	case "", pollingProvider, webhookProvider:
+	case githubProvider, gitlabProvider:
+		if repoHostname(gitrepo.Spec.Repo) == "" {
+			return notAccepted(gitrepo, gitJob, saName, &status, "provider "+gitrepo.Spec.Provider+" requires repo to be a URL with a hostname: "+gitrepo.Spec.Repo), status, nil
+		}
+	default:
+		return notAccepted(gitrepo, gitJob, saName, &status, "provider must be polling, github, gitlab or webhook: "+gitrepo.Spec.Provider), status, nil
+	}
+
+	jobProvider, webhookToken, providerMessage, err := h.provider(gitrepo, gitJob, &status)
+	if err != nil {
+		return nil, status, err
+	}
+	gitRepoConditionProvider.SetStatusBool(&status, providerMessage == "")
+	gitRepoConditionProvider.Message(&status, providerMessage)
+
+	pullSecrets, missingSecrets, err := h.imagePullSecrets(gitrepo)
+	if err != nil {
+		return nil, status, err
+	}
+	pullSecretsMessage := ""
+	if len(missingSecrets) > 0 {
+		pullSecretsMessage = "image pull secrets not found: " + strings.Join(missingSecrets, ", ")
+		h.gitRepos.EnqueueAfter(gitrepo.Namespace, gitrepo.Name, queuedRequeueInterval)
+	}
+	gitRepoConditionPullSecrets.SetStatusBool(&status, pullSecretsMessage == "")
+	gitRepoConditionPullSecrets.Message(&status, pullSecretsMessage)
+
+	status.Bundles, err = h.bundleNames(gitrepo)
+	if err != nil {
+		return nil, status, err
+	}
+
+	if gitrepo.Spec.DryRun {
+		setDryRunStatus(gitJob, &status)
+	} else if gitrepo.Spec.Plan {
+		gitRepoConditionPlan.SetStatusBool(&status, true)
+		gitRepoConditionPlan.Message(&status, "changes to bundles are planned but not applied")
+	} else {
+		status.Plan = nil
+	}
+
+	if gitrepo.Spec.Paused {
+		gitRepoConditionPaused.SetStatusBool(&status, true)
+		gitRepoConditionPaused.Message(&status, "reconciliation is paused, the git job is not updated")
 	}
 
 	branch, rev := gitrepo.Spec.Branch, gitrepo.Spec.Revision
 	if branch == "" && rev == "" {
-		branch = "master"
+		branch = config.Get().DefaultBranch
+		if branch == "" {
+			branch = defaultBranch
+		}
 	}
+	branch, rev = pinCommit(gitrepo, gitJob, branch, rev, &status)
 
-	saName := name.SafeConcatName("git", gitrepo.Name)
+	var env []corev1.EnvVar
+	if gitrepo.Spec.HelmSecretName != "" {
+		env = append(env,
+			secretEnvVar("HELM_USERNAME", gitrepo.Spec.HelmSecretName, corev1.BasicAuthUsernameKey),
+			secretEnvVar("HELM_PASSWORD", gitrepo.Spec.HelmSecretName, corev1.BasicAuthPasswordKey))
+	}
+
+	objs := jobRBAC(gitrepo, saName)
+
+	if gitrepo.Spec.Paused {
+		return append(objs, existingGitJob(gitrepo, gitJob)...), status, nil
+	}
+
+	desired := &gitjob.GitJob{
+		ObjectMeta: gitJobObjectMeta(gitrepo),
+		Spec: gitjob.GitJobSpec{
+			Git: gitjob.GitInfo{
+				Credential: credential(gitrepo),
+				Provider:   jobProvider,
+				Repo:       gitrepo.Spec.Repo,
+				Revision:   rev,
+				Branch:     branch,
+				Github: gitjob.Github{
+					Token: webhookToken,
+				},
+			},
+			JobSpec: batchv1.JobSpec{
+				ActiveDeadlineSeconds: gitrepo.Spec.JobActiveDeadlineSeconds,
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						CreationTimestamp: metav1.Time{Time: time.Unix(0, 0)},
+					},
+					Spec: corev1.PodSpec{
+						ServiceAccountName: saName,
+						RestartPolicy:      restartPolicy,
+						ImagePullSecrets:   pullSecrets,
+						Containers: []corev1.Container{
+							{
+								Name:            "fleet",
+								Image:           config.Get().AgentImage,
+								ImagePullPolicy: corev1.PullPolicy(config.Get().AgentImagePullPolicy),
+								Command:         append(args, dirs...),
+								WorkingDir:      workingDir,
+								Env:             env,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// creating or changing the git job starts a run of the job
+	if gitJob == nil || !equality.Semantic.DeepEqual(gitJob.Spec, desired.Spec) {
+		allowed, err := h.allowJobRun(gitrepo)
+		if err != nil {
+			return nil, status, err
+		}
+		if !allowed {
+			gitRepoConditionQueued.SetStatusBool(&status, true)
+			gitRepoConditionQueued.Message(&status, "waiting for other git jobs to complete")
+			h.gitRepos.EnqueueAfter(gitrepo.Namespace, gitrepo.Name, queuedRequeueInterval)
+			return append(objs, existingGitJob(gitrepo, gitJob)...), status, nil
+		}
+	}
+
+	// The status is only persisted if the objects are successfully applied, so this is
+	// not observed until the git job has been updated.
+	status.ObservedGeneration = gitrepo.Generation
+
+	return append(objs, desired), status, nil
+}
+
+// notAccepted sets the Accepted condition to false with the message. The objects last applied for the
+// gitrepo are returned so an invalid change neither deletes nor updates its git job.
+func notAccepted(gitrepo *fleet.GitRepo, gitJob *gitjob.GitJob, saName string, status *fleet.GitRepoStatus, message string) []runtime.Object {
+	gitRepoConditionAccepted.SetStatusBool(status, false)
+	gitRepoConditionAccepted.Message(status, message)
+	return append(jobRBAC(gitrepo, saName), existingGitJob(gitrepo, gitJob)...)
+}
+
+// jobRBAC returns the service account of the git job and the role allowing it to apply the bundles of
+// the gitrepo
+func jobRBAC(gitrepo *fleet.GitRepo, saName string) []runtime.Object {
 	return []runtime.Object{
 		&corev1.ServiceAccount{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      saName,
-				Namespace: gitrepo.Namespace,
-			},
+			ObjectMeta: jobObjectMeta(gitrepo, saName),
 		},
 		&rbacv1.Role{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      saName,
-				Namespace: gitrepo.Namespace,
-			},
+			ObjectMeta: jobObjectMeta(gitrepo, saName),
 			Rules: []rbacv1.PolicyRule{
 				{
 					Verbs:     []string{"get", "create", "update"},
@@ -77,13 +510,15 @@ func (h *handler) OnChange(gitrepo *fleet.GitRepo, status fleet.GitRepoStatus) (
 					APIGroups: []string{"fleet.cattle.io"},
 					Resources: []string{"gitrepos"},
 				},
+				{
+					Verbs:     []string{"update"},
+					APIGroups: []string{"fleet.cattle.io"},
+					Resources: []string{"gitrepos/status"},
+				},
 			},
 		},
 		&rbacv1.RoleBinding{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      saName,
-				Namespace: gitrepo.Namespace,
-			},
+			ObjectMeta: jobObjectMeta(gitrepo, saName),
 			Subjects: []rbacv1.Subject{
 				{
 					Kind:      "ServiceAccount",
@@ -97,50 +532,126 @@ func (h *handler) OnChange(gitrepo *fleet.GitRepo, status fleet.GitRepoStatus) (
 				Name:     saName,
 			},
 		},
-		&gitjob.GitJob{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      gitrepo.Name,
-				Namespace: gitrepo.Namespace,
-			},
-			Spec: gitjob.GitJobSpec{
-				Git: gitjob.GitInfo{
-					Credential: gitjob.Credential{
-						GitSecretName: gitrepo.Spec.ClientSecretName,
-						GitHostname:   "github.com",
-					},
-					Provider: "polling",
-					Repo:     gitrepo.Spec.Repo,
-					Revision: rev,
-					Branch:   branch,
-				},
-				JobSpec: batchv1.JobSpec{
-					Template: corev1.PodTemplateSpec{
-						ObjectMeta: metav1.ObjectMeta{
-							CreationTimestamp: metav1.Time{Time: time.Unix(0, 0)},
-						},
-						Spec: corev1.PodSpec{
-							ServiceAccountName: saName,
-							RestartPolicy:      corev1.RestartPolicyNever,
-							Containers: []corev1.Container{
-								{
-									Name:            "fleet",
-									Image:           config.Get().AgentImage,
-									ImagePullPolicy: corev1.PullPolicy(config.Get().AgentImagePullPolicy),
-									Command: append([]string{
-										"fleet",
-										"apply",
-										"--label=fleet.cattle.io/repo-name=" + gitrepo.Name,
-										"--namespace", gitrepo.Namespace,
-										"--service-account", gitrepo.Spec.ServiceAccount,
-										gitrepo.Name,
-									}, dirs...),
-									WorkingDir: "/workspace/source",
-								},
-							},
-						},
-					},
+	}
+}
+
+// existingGitJob returns the git job as it was last applied, so it is neither updated nor deleted
+func existingGitJob(gitrepo *fleet.GitRepo, gitJob *gitjob.GitJob) []runtime.Object {
+	if gitJob == nil {
+		return nil
+	}
+	result := &gitjob.GitJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        gitJob.Name,
+			Namespace:   gitJob.Namespace,
+			Labels:      userMetadata(gitJob.Labels),
+			Annotations: userMetadata(gitJob.Annotations),
+		},
+		Spec: gitJob.Spec,
+	}
+	if result.Labels == nil {
+		result.Labels = map[string]string{}
+	}
+	result.Labels[repoNameLabel] = gitrepo.Name
+	if generation, ok := gitJob.Annotations[repoGenerationAnnotation]; ok {
+		if result.Annotations == nil {
+			result.Annotations = map[string]string{}
+		}
+		result.Annotations[repoGenerationAnnotation] = generation
+	}
+	return []runtime.Object{result}
+}
+
+// credential returns the credential used to clone the repo, which is empty for a public repo without
+// a client secret so it is cloned anonymously
+func credential(gitrepo *fleet.GitRepo) gitjob.Credential {
+	if gitrepo.Spec.ClientSecretName == "" {
+		return gitjob.Credential{}
+	}
+	hostname := repoHostname(gitrepo.Spec.Repo)
+	if hostname == "" {
+		hostname = defaultGitHostname
+	}
+	return gitjob.Credential{
+		GitSecretName: gitrepo.Spec.ClientSecretName,
+		GitHostname:   hostname,
+	}
+}
+
+// repoHostname returns the hostname of a repo URL, such as https://gitlab.com/org/repo, or of an scp-like
+// ssh address, such as git@gitlab.com:org/repo, or "" if there is none
+func repoHostname(repo string) string {
+	if u, err := url.Parse(repo); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	if i := strings.Index(repo, ":"); i > 0 && !strings.Contains(repo[:i], "/") {
+		host := repo[:i]
+		if j := strings.LastIndex(host, "@"); j >= 0 {
+			host = host[j+1:]
+		}
+		return host
+	}
+	return ""
+}
+
+func secretEnvVar(name, secretName, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: secretName,
 				},
+				Key: key,
 			},
 		},
-	}, status, nil
+	}
+}
+
+// provider returns the gitjob provider for the repo and the token verifying the signature of webhook
+// requests. Providers other than polling are notified of changes through the webhook receiver, so they
+// fall back to polling if there is nowhere for webhooks to be received, or none has been received within
+// the fallback interval, and the message explains why. The token is kept when falling back, so a webhook received later switches the repo back.
+func (h *handler) provider(gitrepo *fleet.GitRepo, gitJob *gitjob.GitJob, status *fleet.GitRepoStatus) (string, string, string, error) {
+	p := gitrepo.Spec.Provider
+	if p == "" || p == pollingProvider {
+		status.WebhookSince = nil
+		return pollingProvider, "", "", nil
+	}
+	if config.Get().WebhookReceiverURL == "" {
+		return pollingProvider, "", "provider " + p + " requires the webhook receiver URL to be configured, polling instead", nil
+	}
+
+	var token string
+	if secretName := gitrepo.Spec.WebhookSecretName; secretName != "" {
+		secret, err := h.secretCache.Get(gitrepo.Namespace, secretName)
+		if apierrors.IsNotFound(err) {
+			h.gitRepos.EnqueueAfter(gitrepo.Namespace, gitrepo.Name, queuedRequeueInterval)
+			return pollingProvider, "", "webhook secret " + secretName + " not found, polling instead", nil
+		} else if err != nil {
+			return "", "", "", err
+		}
+		token = string(secret.Data[webhookSecretKey])
+		if token == "" {
+			return pollingProvider, "", "webhook secret " + secretName + " has no " + webhookSecretKey + ", polling instead", nil
+		}
+	}
+
+	fallback := defaultWebhookFallback
+	if gitrepo.Spec.WebhookFallbackSeconds != 0 {
+		fallback = time.Duration(gitrepo.Spec.WebhookFallbackSeconds) * time.Second
+	}
+	if fallback < 0 || (gitJob != nil && gitJob.Status.Event != "") {
+		return p, token, "", nil
+	}
+
+	now := time.Now()
+	if status.WebhookSince == nil {
+		status.WebhookSince = &metav1.Time{Time: now}
+	}
+	if wait := status.WebhookSince.Add(fallback).Sub(now); wait > 0 {
+		h.gitRepos.EnqueueAfter(gitrepo.Namespace, gitrepo.Name, wait)
+		return p, token, "", nil
+	}
+	return pollingProvider, token, "no webhook received within " + fallback.String() + ", polling instead", nil
 }
@@ -0,0 +1,1262 @@
+package git
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/config"
+	fleetcontrollers "github.com/rancher/fleet/pkg/generated/controllers/fleet.cattle.io/v1alpha1"
+	gitjob "github.com/rancher/gitjob/pkg/apis/gitjob.cattle.io/v1"
+	v1 "github.com/rancher/gitjob/pkg/generated/controllers/gitjob.cattle.io/v1"
+	"github.com/rancher/wrangler/pkg/condition"
+	corecontrollers "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type fakeGitJobCache struct {
+	v1.GitJobCache
+	gitJobs []*gitjob.GitJob
+}
+
+func (f *fakeGitJobCache) Get(namespace, name string) (*gitjob.GitJob, error) {
+	for _, gitJob := range f.gitJobs {
+		if gitJob.Namespace == namespace && gitJob.Name == name {
+			return gitJob, nil
+		}
+	}
+	return nil, apierrors.NewNotFound(schema.GroupResource{Group: "gitjob.cattle.io", Resource: "gitjobs"}, name)
+}
+
+func (f *fakeGitJobCache) List(namespace string, selector labels.Selector) (result []*gitjob.GitJob, _ error) {
+	for _, gitJob := range f.gitJobs {
+		if (namespace == "" || gitJob.Namespace == namespace) && selector.Matches(labels.Set(gitJob.Labels)) {
+			result = append(result, gitJob)
+		}
+	}
+	return result, nil
+}
+
+type fakeGitRepos struct {
+	fleetcontrollers.GitRepoController
+	enqueued []time.Duration
+}
+
+func (f *fakeGitRepos) EnqueueAfter(_, _ string, duration time.Duration) {
+	f.enqueued = append(f.enqueued, duration)
+}
+
+type fakeBundleCache struct {
+	fleetcontrollers.BundleCache
+	bundles []*fleet.Bundle
+}
+
+func (f *fakeBundleCache) List(namespace string, selector labels.Selector) (result []*fleet.Bundle, _ error) {
+	for _, bundle := range f.bundles {
+		if bundle.Namespace == namespace && selector.Matches(labels.Set(bundle.Labels)) {
+			result = append(result, bundle)
+		}
+	}
+	return result, nil
+}
+
+type fakeSecretCache struct {
+	corecontrollers.SecretCache
+	secrets []*corev1.Secret
+}
+
+func (f *fakeSecretCache) Get(namespace, name string) (*corev1.Secret, error) {
+	for _, secret := range f.secrets {
+		if secret.Namespace == namespace && secret.Name == name {
+			return secret, nil
+		}
+	}
+	return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, name)
+}
+
+func newTestHandler(cfg *config.Config, gitJobs ...*gitjob.GitJob) (*handler, *fakeGitRepos) {
+	if err := config.Set(cfg); err != nil {
+		panic(err)
+	}
+	gitRepos := &fakeGitRepos{}
+	return &handler{
+		gitjobCache: &fakeGitJobCache{gitJobs: gitJobs},
+		gitRepos:    gitRepos,
+		bundleCache: &fakeBundleCache{},
+		secretCache: &fakeSecretCache{},
+	}, gitRepos
+}
+
+func newGitRepo(name string) *fleet.GitRepo {
+	return &fleet.GitRepo{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Namespace:  "fleet-local",
+			Generation: 1,
+		},
+		Spec: fleet.GitRepoSpec{
+			Repo: "https://github.com/rancher/fleet-examples",
+		},
+	}
+}
+
+func newGitJob(gitrepo *fleet.GitRepo, jobStatus, commit, lastExecuted string) *gitjob.GitJob {
+	return &gitjob.GitJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      gitrepo.Name,
+			Namespace: gitrepo.Namespace,
+			Labels: map[string]string{
+				repoNameLabel: gitrepo.Name,
+			},
+		},
+		Status: gitjob.GitJobStatus{
+			GitEvent: gitjob.GitEvent{
+				Commit:             commit,
+				LastExecutedCommit: lastExecuted,
+			},
+			JobStatus: jobStatus,
+		},
+	}
+}
+
+// findGitJob returns the git job in the objects generated for a gitrepo, or nil if there is none
+func findGitJob(objs []runtime.Object) *gitjob.GitJob {
+	for _, obj := range objs {
+		if gitJob, ok := obj.(*gitjob.GitJob); ok {
+			return gitJob
+		}
+	}
+	return nil
+}
+
+func TestMaxConcurrentGitJobs(t *testing.T) {
+	other := newGitRepo("other")
+
+	tests := []struct {
+		name    string
+		gitJobs []*gitjob.GitJob
+		queued  bool
+	}{
+		{
+			name:    "no other jobs",
+			gitJobs: nil,
+		},
+		{
+			name:    "other job running",
+			gitJobs: []*gitjob.GitJob{newGitJob(other, jobStatusInProgress, "abc", "")},
+			queued:  true,
+		},
+		{
+			name:    "other job run not started yet",
+			gitJobs: []*gitjob.GitJob{newGitJob(other, "Current", "def", "abc")},
+			queued:  true,
+		},
+		{
+			name:    "other job completed",
+			gitJobs: []*gitjob.GitJob{newGitJob(other, "Current", "abc", "abc")},
+		},
+		{
+			name:    "other job failed",
+			gitJobs: []*gitjob.GitJob{newGitJob(other, jobStatusFailed, "abc", "")},
+		},
+		{
+			name: "running job not owned by a gitrepo",
+			gitJobs: []*gitjob.GitJob{func() *gitjob.GitJob {
+				gitJob := newGitJob(other, jobStatusInProgress, "abc", "")
+				gitJob.Labels = nil
+				return gitJob
+			}()},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, gitRepos := newTestHandler(&config.Config{MaxConcurrentGitJobs: 1}, test.gitJobs...)
+
+			objs, status, err := h.OnChange(newGitRepo("test"), fleet.GitRepoStatus{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			queued := condition.Cond("Queued").IsTrue(&status)
+			if queued != test.queued {
+				t.Errorf("expected queued %v, got %v", test.queued, queued)
+			}
+			if created := findGitJob(objs) != nil; created == test.queued {
+				t.Errorf("expected git job created %v, got %v", !test.queued, created)
+			}
+			if test.queued && len(gitRepos.enqueued) == 0 {
+				t.Error("expected queued gitrepo to be requeued")
+			}
+		})
+	}
+}
+
+func TestMaxConcurrentGitJobsHoldsUpdates(t *testing.T) {
+	gitrepo := newGitRepo("test")
+	existing := newGitJob(gitrepo, "Current", "abc", "abc")
+	existing.Spec.Git.Branch = "old"
+
+	h, _ := newTestHandler(&config.Config{MaxConcurrentGitJobs: 1},
+		existing, newGitJob(newGitRepo("other"), jobStatusInProgress, "abc", ""))
+
+	objs, status, err := h.OnChange(gitrepo, fleet.GitRepoStatus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !condition.Cond("Queued").IsTrue(&status) {
+		t.Error("expected the update of the git job to be queued")
+	}
+	gitJob := findGitJob(objs)
+	if gitJob == nil {
+		t.Fatal("expected the existing git job to be kept")
+	}
+	if gitJob.Spec.Git.Branch != "old" {
+		t.Errorf("expected the existing git job to be kept unchanged, got branch %s", gitJob.Spec.Git.Branch)
+	}
+	if status.ObservedGeneration != 0 {
+		t.Errorf("expected the generation not to be observed while queued, got %d", status.ObservedGeneration)
+	}
+}
+
+// command returns the command of the fleet container of the git job
+func TestDefaultBranch(t *testing.T) {
+	tests := []struct {
+		name          string
+		defaultBranch string
+		branch        string
+		revision      string
+		expected      string
+	}{
+		{
+			name:     "master by default",
+			expected: "master",
+		},
+		{
+			name:          "configured default",
+			defaultBranch: "main",
+			expected:      "main",
+		},
+		{
+			name:          "explicit branch",
+			defaultBranch: "main",
+			branch:        "release",
+			expected:      "release",
+		},
+		{
+			name:          "explicit revision",
+			defaultBranch: "main",
+			revision:      "v1.0.0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gitrepo := newGitRepo("test")
+			gitrepo.Spec.Branch = test.branch
+			gitrepo.Spec.Revision = test.revision
+
+			h, _ := newTestHandler(&config.Config{DefaultBranch: test.defaultBranch})
+			objs, _, err := h.OnChange(gitrepo, fleet.GitRepoStatus{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			git := findGitJob(objs).Spec.Git
+			if git.Branch != test.expected {
+				t.Errorf("expected branch %q, got %q", test.expected, git.Branch)
+			}
+			if git.Revision != test.revision {
+				t.Errorf("expected revision %q, got %q", test.revision, git.Revision)
+			}
+		})
+	}
+}
+
+func TestHelmSecret(t *testing.T) {
+	for _, helmSecretName := range []string{"", "helm-creds"} {
+		gitrepo := newGitRepo("test")
+		gitrepo.Spec.ClientSecretName = "git-creds"
+		gitrepo.Spec.HelmSecretName = helmSecretName
+
+		h, _ := newTestHandler(&config.Config{})
+		objs, _, err := h.OnChange(gitrepo, fleet.GitRepoStatus{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gitJob := findGitJob(objs)
+		if gitJob.Spec.Git.GitSecretName != "git-creds" {
+			t.Errorf("%q: expected the repo to be cloned with the client secret, got %q", helmSecretName, gitJob.Spec.Git.GitSecretName)
+		}
+
+		env := gitJob.Spec.JobSpec.Template.Spec.Containers[0].Env
+		if helmSecretName == "" {
+			if len(env) != 0 {
+				t.Errorf("expected no helm credentials without a helm secret, got %v", env)
+			}
+			continue
+		}
+
+		expected := map[string]string{
+			"HELM_USERNAME": corev1.BasicAuthUsernameKey,
+			"HELM_PASSWORD": corev1.BasicAuthPasswordKey,
+		}
+		for _, envVar := range env {
+			key, ok := expected[envVar.Name]
+			if !ok {
+				continue
+			}
+			if ref := envVar.ValueFrom.SecretKeyRef; ref == nil || ref.Name != helmSecretName || ref.Key != key {
+				t.Errorf("expected %s from key %s of secret %s, got %+v", envVar.Name, key, helmSecretName, envVar.ValueFrom)
+			}
+			delete(expected, envVar.Name)
+		}
+		if len(expected) != 0 {
+			t.Errorf("expected the helm credentials %v in the env of the apply container, got %v", expected, env)
+		}
+	}
+}
+
+func TestBundlesStatus(t *testing.T) {
+	bundle := func(namespace, name, repo string) *fleet.Bundle {
+		return &fleet.Bundle{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels: map[string]string{
+					repoNameLabel: repo,
+				},
+			},
+		}
+	}
+
+	gitrepo := newGitRepo("test")
+	h, _ := newTestHandler(&config.Config{})
+	h.bundleCache = &fakeBundleCache{
+		bundles: []*fleet.Bundle{
+			bundle(gitrepo.Namespace, "test-web", gitrepo.Name),
+			bundle(gitrepo.Namespace, "other-web", "other"),
+			bundle(gitrepo.Namespace, "test-db", gitrepo.Name),
+			bundle("fleet-default", "test-cache", gitrepo.Name),
+		},
+	}
+
+	_, status, err := h.OnChange(gitrepo, fleet.GitRepoStatus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"test-db", "test-web"}; !reflect.DeepEqual(status.Bundles, expected) {
+		t.Errorf("expected bundles %v, got %v", expected, status.Bundles)
+	}
+
+	// bundles that are gone are dropped on the next sync
+	h.bundleCache = &fakeBundleCache{}
+	_, status, err = h.OnChange(gitrepo, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Bundles) != 0 {
+		t.Errorf("expected no bundles, got %v", status.Bundles)
+	}
+}
+
+func TestCredential(t *testing.T) {
+	tests := []struct {
+		name             string
+		clientSecretName string
+		expected         gitjob.Credential
+	}{
+		{
+			name: "public repo",
+		},
+		{
+			name:             "client secret",
+			clientSecretName: "git-auth",
+			expected: gitjob.Credential{
+				GitSecretName: "git-auth",
+				GitHostname:   "github.com",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gitrepo := newGitRepo("test")
+			gitrepo.Spec.ClientSecretName = test.clientSecretName
+
+			h, _ := newTestHandler(&config.Config{})
+			objs, _, err := h.OnChange(gitrepo, fleet.GitRepoStatus{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if credential := findGitJob(objs).Spec.Git.Credential; !reflect.DeepEqual(credential, test.expected) {
+				t.Errorf("expected credential %+v, got %+v", test.expected, credential)
+			}
+		})
+	}
+}
+
+func command(gitJob *gitjob.GitJob) []string {
+	if gitJob == nil || len(gitJob.Spec.JobSpec.Template.Spec.Containers) == 0 {
+		return nil
+	}
+	return gitJob.Spec.JobSpec.Template.Spec.Containers[0].Command
+}
+
+func hasArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
+func TestJobMetadata(t *testing.T) {
+	gitrepo := newGitRepo("test")
+	gitrepo.Spec.JobMetadata = fleet.GitJobMetadata{
+		Labels: map[string]string{
+			"team":                       "apps",
+			repoNameLabel:                "other",
+			"objectset.rio.cattle.io/id": "other",
+		},
+		Annotations: map[string]string{
+			"owner":                        "apps@example.com",
+			repoGenerationAnnotation:       "100",
+			"objectset.rio.cattle.io/hash": "other",
+		},
+	}
+
+	h, _ := newTestHandler(&config.Config{})
+	objs, _, err := h.OnChange(gitrepo, fleet.GitRepoStatus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := map[string]bool{}
+	for _, obj := range objs {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kind := fmt.Sprintf("%T", obj)
+		kinds[kind] = true
+
+		labels, annotations := accessor.GetLabels(), accessor.GetAnnotations()
+		if labels["team"] != "apps" || annotations["owner"] != "apps@example.com" {
+			t.Errorf("%s: expected the job metadata to be added, got labels %v and annotations %v", kind, labels, annotations)
+		}
+		if labels[repoNameLabel] != "test" {
+			t.Errorf("%s: expected the repo name label not to be overwritten, got %s", kind, labels[repoNameLabel])
+		}
+		if _, ok := labels["objectset.rio.cattle.io/id"]; ok {
+			t.Errorf("%s: expected apply labels not to be copied, got %v", kind, labels)
+		}
+		if _, ok := annotations["objectset.rio.cattle.io/hash"]; ok {
+			t.Errorf("%s: expected apply annotations not to be copied, got %v", kind, annotations)
+		}
+		if _, ok := obj.(*gitjob.GitJob); ok && annotations[repoGenerationAnnotation] != "1" {
+			t.Errorf("%s: expected the generation annotation not to be overwritten, got %s", kind, annotations[repoGenerationAnnotation])
+		}
+	}
+
+	for _, kind := range []string{"*v1.ServiceAccount", "*v1.Role", "*v1.RoleBinding", "*v1.GitJob"} {
+		if !kinds[kind] {
+			t.Errorf("expected a %s to be generated, got %v", kind, kinds)
+		}
+	}
+}
+
+func TestDryRunCommand(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		gitrepo := newGitRepo("test")
+		gitrepo.Spec.DryRun = dryRun
+		gitrepo.Spec.Plan = true
+
+		h, _ := newTestHandler(&config.Config{})
+		objs, _, err := h.OnChange(gitrepo, fleet.GitRepoStatus{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		args := command(findGitJob(objs))
+		if hasArg(args, "--dry-run") != dryRun {
+			t.Errorf("dryRun %v: expected --dry-run %v in %v", dryRun, dryRun, args)
+		}
+		if hasArg(args, "--plan-repo") == dryRun {
+			t.Errorf("dryRun %v: expected --plan-repo %v in %v", dryRun, !dryRun, args)
+		}
+		if hasArg(args, "--paths-repo") {
+			t.Errorf("dryRun %v: expected no --paths-repo when nothing is applied in %v", dryRun, args)
+		}
+	}
+}
+
+func TestPathsRepoCommand(t *testing.T) {
+	gitrepo := newGitRepo("test")
+
+	h, _ := newTestHandler(&config.Config{})
+	objs, _, err := h.OnChange(gitrepo, fleet.GitRepoStatus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := command(findGitJob(objs))
+	if value := argValue(args, "--paths-repo"); value != "test" {
+		t.Errorf("expected --paths-repo test in %v", args)
+	}
+}
+
+func TestDryRunStatus(t *testing.T) {
+	failed := newGitJob(newGitRepo("test"), jobStatusFailed, "abc", "")
+	gitJobConditionStalled.SetStatusBool(failed, true)
+	gitJobConditionStalled.Message(failed, "bundle test: invalid fleet.yaml")
+
+	tests := []struct {
+		name    string
+		gitJob  *gitjob.GitJob
+		status  string
+		message string
+	}{
+		{
+			name:    "no git job",
+			status:  "Unknown",
+			message: "bundles are being validated",
+		},
+		{
+			name:    "running",
+			gitJob:  newGitJob(newGitRepo("test"), jobStatusInProgress, "abc", ""),
+			status:  "Unknown",
+			message: "bundles are being validated",
+		},
+		{
+			name:    "failed",
+			gitJob:  failed,
+			status:  "False",
+			message: "bundle test: invalid fleet.yaml",
+		},
+		{
+			name:    "valid",
+			gitJob:  newGitJob(newGitRepo("test"), "Current", "abc", "abc"),
+			status:  "True",
+			message: "bundles are valid at commit abc, they are not deployed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status := fleet.GitRepoStatus{}
+			setDryRunStatus(test.gitJob, &status)
+			if s := gitRepoConditionDryRun.GetStatus(&status); s != test.status {
+				t.Errorf("expected status %s, got %s", test.status, s)
+			}
+			if m := gitRepoConditionDryRun.GetMessage(&status); m != test.message {
+				t.Errorf("expected message %q, got %q", test.message, m)
+			}
+		})
+	}
+}
+
+// argValue returns the value following flag in args
+func argValue(args []string, flag string) string {
+	for i, arg := range args {
+		if arg == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func TestDefaultServiceAccount(t *testing.T) {
+	tests := []struct {
+		name           string
+		serviceAccount string
+		expected       string
+	}{
+		{
+			name:     "default",
+			expected: "fleet-default-sa",
+		},
+		{
+			name:           "override",
+			serviceAccount: "repo-sa",
+			expected:       "repo-sa",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gitrepo := newGitRepo("test")
+			gitrepo.Spec.ServiceAccount = test.serviceAccount
+
+			h, _ := newTestHandler(&config.Config{DefaultServiceAccount: "fleet-default-sa", RequireServiceAccount: true})
+			objs, _, err := h.OnChange(gitrepo, fleet.GitRepoStatus{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sa := argValue(command(findGitJob(objs)), "--service-account"); sa != test.expected {
+				t.Errorf("expected service account %s, got %s", test.expected, sa)
+			}
+		})
+	}
+}
+
+func TestRequireServiceAccountKeepsGitJob(t *testing.T) {
+	gitrepo := newGitRepo("test")
+	existing := newGitJob(gitrepo, "Current", "abc", "abc")
+	existing.Spec.Git.Branch = "main"
+
+	h, _ := newTestHandler(&config.Config{RequireServiceAccount: true}, existing)
+	objs, status, err := h.OnChange(gitrepo, fleet.GitRepoStatus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !gitRepoConditionAccepted.IsFalse(&status) {
+		t.Error("expected gitrepo without a service account not to be accepted")
+	}
+	assertKept(t, objs, "main")
+}
+
+// assertKept fails the test unless the objects keep the RBAC of the git job and the git job as it was
+// last applied, with the given branch
+func assertKept(t *testing.T, objs []runtime.Object, branch string) {
+	t.Helper()
+
+	kinds := map[string]bool{}
+	for _, obj := range objs {
+		switch obj.(type) {
+		case *corev1.ServiceAccount:
+			kinds["ServiceAccount"] = true
+		case *rbacv1.Role:
+			kinds["Role"] = true
+		case *rbacv1.RoleBinding:
+			kinds["RoleBinding"] = true
+		}
+	}
+	for _, kind := range []string{"ServiceAccount", "Role", "RoleBinding"} {
+		if !kinds[kind] {
+			t.Errorf("expected the %s of the git job to be kept", kind)
+		}
+	}
+
+	gitJob := findGitJob(objs)
+	if gitJob == nil {
+		t.Fatal("expected the git job to be kept")
+	}
+	if gitJob.Spec.Git.Branch != branch {
+		t.Errorf("expected the git job to be kept unchanged with branch %s, got %s", branch, gitJob.Spec.Git.Branch)
+	}
+}
+
+func TestPaused(t *testing.T) {
+	gitrepo := newGitRepo("test")
+	gitrepo.Spec.Paused = true
+	gitrepo.Spec.Branch = "release"
+	existing := newGitJob(gitrepo, "Current", "abc", "abc")
+	existing.Spec.Git.Branch = "main"
+
+	h, _ := newTestHandler(&config.Config{}, existing)
+	objs, status, err := h.OnChange(gitrepo, fleet.GitRepoStatus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gitRepoConditionPaused.IsTrue(&status) {
+		t.Error("expected the paused condition to be set")
+	}
+	assertKept(t, objs, "main")
+
+	// no git job is created for a paused gitrepo
+	h, _ = newTestHandler(&config.Config{})
+	objs, _, err = h.OnChange(gitrepo, fleet.GitRepoStatus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gitJob := findGitJob(objs); gitJob != nil {
+		t.Errorf("expected no git job while paused, got %v", gitJob)
+	}
+
+	// resuming updates the git job again
+	gitrepo.Spec.Paused = false
+	h, _ = newTestHandler(&config.Config{}, existing)
+	objs, status, err = h.OnChange(gitrepo, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gitRepoConditionPaused.IsTrue(&status) {
+		t.Error("expected the paused condition to be cleared when resumed")
+	}
+	if gitJob := findGitJob(objs); gitJob == nil || gitJob.Spec.Git.Branch != "release" {
+		t.Errorf("expected the git job to be updated to branch release when resumed, got %v", gitJob)
+	}
+}
+
+func TestWebhookProvider(t *testing.T) {
+	hourAgo := &metav1.Time{Time: time.Now().Add(-time.Hour)}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "webhook",
+			Namespace: "fleet-local",
+		},
+		Data: map[string][]byte{
+			webhookSecretKey: []byte("s3cr3t"),
+		},
+	}
+
+	tests := []struct {
+		name        string
+		receiverURL string
+		secretName  string
+		fallback    int
+		since       *metav1.Time
+		event       string
+		provider    string
+		token       string
+		accepted    bool
+	}{
+		{
+			name:        "webhook",
+			receiverURL: "https://fleet.example.com/hooks",
+			provider:    githubProvider,
+			accepted:    true,
+		},
+		{
+			name:     "no webhook receiver",
+			provider: pollingProvider,
+		},
+		{
+			name:        "signature secret",
+			receiverURL: "https://fleet.example.com/hooks",
+			secretName:  "webhook",
+			provider:    githubProvider,
+			token:       "s3cr3t",
+			accepted:    true,
+		},
+		{
+			name:        "missing signature secret",
+			receiverURL: "https://fleet.example.com/hooks",
+			secretName:  "missing",
+			provider:    pollingProvider,
+		},
+		{
+			name:        "no webhook received within the fallback interval",
+			receiverURL: "https://fleet.example.com/hooks",
+			secretName:  "webhook",
+			fallback:    60,
+			since:       hourAgo,
+			provider:    pollingProvider,
+			token:       "s3cr3t",
+		},
+		{
+			name:        "webhook received",
+			receiverURL: "https://fleet.example.com/hooks",
+			fallback:    60,
+			since:       hourAgo,
+			event:       "push",
+			provider:    githubProvider,
+			accepted:    true,
+		},
+		{
+			name:        "fallback disabled",
+			receiverURL: "https://fleet.example.com/hooks",
+			fallback:    -1,
+			since:       hourAgo,
+			provider:    githubProvider,
+			accepted:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gitrepo := newGitRepo("test")
+			gitrepo.Spec.Provider = githubProvider
+			gitrepo.Spec.WebhookSecretName = test.secretName
+			gitrepo.Spec.WebhookFallbackSeconds = test.fallback
+
+			existing := newGitJob(gitrepo, "Current", "abc", "abc")
+			existing.Status.Event = test.event
+
+			h, _ := newTestHandler(&config.Config{WebhookReceiverURL: test.receiverURL}, existing)
+			h.secretCache = &fakeSecretCache{secrets: []*corev1.Secret{secret}}
+
+			objs, status, err := h.OnChange(gitrepo, fleet.GitRepoStatus{WebhookSince: test.since})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gitJob := findGitJob(objs)
+			if gitJob == nil {
+				t.Fatal("expected a git job")
+			}
+			if gitJob.Spec.Git.Provider != test.provider {
+				t.Errorf("expected provider %s, got %s", test.provider, gitJob.Spec.Git.Provider)
+			}
+			if gitJob.Spec.Git.Github.Token != test.token {
+				t.Errorf("expected token %q, got %q", test.token, gitJob.Spec.Git.Github.Token)
+			}
+			if accepted := gitRepoConditionProvider.IsTrue(&status); accepted != test.accepted {
+				t.Errorf("expected provider condition %v, got %v: %s", test.accepted, accepted, gitRepoConditionProvider.GetMessage(&status))
+			}
+		})
+	}
+}
+
+func TestWorkingDir(t *testing.T) {
+	tests := []struct {
+		workingDir string
+		expected   string
+	}{
+		{
+			expected: defaultWorkingDir,
+		},
+		{
+			workingDir: "/workspace/source/monorepo",
+			expected:   "/workspace/source/monorepo",
+		},
+	}
+
+	for _, test := range tests {
+		gitrepo := newGitRepo("test")
+		gitrepo.Spec.WorkingDir = test.workingDir
+
+		h, _ := newTestHandler(&config.Config{})
+		objs, _, err := h.OnChange(gitrepo, fleet.GitRepoStatus{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gitJob := findGitJob(objs)
+		if gitJob == nil {
+			t.Fatal("expected a git job")
+		}
+		if dir := gitJob.Spec.JobSpec.Template.Spec.Containers[0].WorkingDir; dir != test.expected {
+			t.Errorf("expected working dir %s, got %s", test.expected, dir)
+		}
+	}
+}
+
+func TestRelativeWorkingDirKeepsGitJob(t *testing.T) {
+	gitrepo := newGitRepo("test")
+	gitrepo.Spec.WorkingDir = "source"
+	existing := newGitJob(gitrepo, "Current", "abc", "abc")
+	existing.Spec.Git.Branch = "main"
+
+	h, _ := newTestHandler(&config.Config{}, existing)
+	objs, status, err := h.OnChange(gitrepo, fleet.GitRepoStatus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !gitRepoConditionAccepted.IsFalse(&status) {
+		t.Error("expected gitrepo with a relative working dir not to be accepted")
+	}
+	assertKept(t, objs, "main")
+}
+
+// observedGitJob returns the git job generated for the gitrepo as gitjob reports it once it has observed
+// the spec and fetched the commit
+func observedGitJob(t *testing.T, objs []runtime.Object, generation int64, commit string) *gitjob.GitJob {
+	t.Helper()
+
+	gitJob := findGitJob(objs)
+	if gitJob == nil {
+		t.Fatal("expected a git job")
+	}
+	gitJob = gitJob.DeepCopy()
+	gitJob.Generation = generation
+	gitJob.Status.ObservedGeneration = generation
+	gitJob.Status.Commit = commit
+	return gitJob
+}
+
+func TestDeployCommitOnly(t *testing.T) {
+	gitrepo := newGitRepo("test")
+	gitrepo.Spec.Branch = "main"
+	gitrepo.Spec.DeployCommitOnly = true
+
+	h, _ := newTestHandler(&config.Config{})
+	objs, status, err := h.OnChange(gitrepo, fleet.GitRepoStatus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gitJob := findGitJob(objs); gitJob.Spec.Git.Branch != "main" || gitJob.Spec.Git.Revision != "" {
+		t.Fatalf("expected the git job to track the branch until it reports a commit, got %+v", gitJob.Spec.Git)
+	}
+
+	h.gitjobCache = &fakeGitJobCache{gitJobs: []*gitjob.GitJob{observedGitJob(t, objs, 1, "c1")}}
+	objs, status, err = h.OnChange(gitrepo, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gitJob := findGitJob(objs); gitJob.Spec.Git.Branch != "" || gitJob.Spec.Git.Revision != "c1" {
+		t.Fatalf("expected the git job to use revision c1 rather than the branch, got %+v", gitJob.Spec.Git)
+	}
+	if status.PinnedCommit != "c1" {
+		t.Errorf("expected pinned commit c1, got %s", status.PinnedCommit)
+	}
+}
+
+func TestDeployCommitOnlyRepinsAfterChanges(t *testing.T) {
+	gitrepo := newGitRepo("test")
+	gitrepo.Spec.Branch = "main"
+	gitrepo.Spec.DeployCommitOnly = true
+
+	pinned := newGitJob(gitrepo, "Current", "c1", "c1")
+	pinned.Generation = 1
+	pinned.Status.ObservedGeneration = 1
+	pinned.Annotations = map[string]string{repoGenerationAnnotation: "1"}
+	pinned.Spec.Git.Revision = "c1"
+	status := fleet.GitRepoStatus{PinnedCommit: "c1", PinnedGeneration: 1}
+
+	h, _ := newTestHandler(&config.Config{}, pinned)
+
+	// the first change unpins the commit, so the git job tracks the branch again
+	gitrepo.Generation = 2
+	gitrepo.Spec.Paths = []string{"app"}
+	objs, status, err := h.OnChange(gitrepo, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gitJob := findGitJob(objs); gitJob.Spec.Git.Revision != "" {
+		t.Fatalf("expected the git job to track the branch after a change, got revision %s", gitJob.Spec.Git.Revision)
+	}
+
+	// gitjob has not observed the updated git job yet and still reports the pinned commit
+	updated := observedGitJob(t, objs, 2, "c1")
+	updated.Status.ObservedGeneration = 1
+	h.gitjobCache = &fakeGitJobCache{gitJobs: []*gitjob.GitJob{updated}}
+	objs, status, err = h.OnChange(gitrepo, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.PinnedCommit != "" {
+		t.Fatalf("expected the commit of the previous spec not to be pinned, got %s", status.PinnedCommit)
+	}
+
+	// a second change before gitjob has observed the first
+	gitrepo.Generation = 3
+	gitrepo.Spec.Paths = []string{"app", "lib"}
+	objs, status, err = h.OnChange(gitrepo, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.PinnedCommit != "" {
+		t.Fatalf("expected the commit of the previous spec not to be pinned, got %s", status.PinnedCommit)
+	}
+
+	// gitjob observed the git job updated for the first change only
+	h.gitjobCache = &fakeGitJobCache{gitJobs: []*gitjob.GitJob{observedGitJob(t, objs, 2, "c2")}}
+	updated = h.gitjobCache.(*fakeGitJobCache).gitJobs[0]
+	updated.Annotations[repoGenerationAnnotation] = "2"
+	objs, status, err = h.OnChange(gitrepo, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.PinnedCommit != "" {
+		t.Fatalf("expected the commit reported for an earlier generation not to be pinned, got %s", status.PinnedCommit)
+	}
+
+	// gitjob observed the git job of the current generation
+	h.gitjobCache = &fakeGitJobCache{gitJobs: []*gitjob.GitJob{observedGitJob(t, objs, 3, "c3")}}
+	objs, status, err = h.OnChange(gitrepo, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.PinnedCommit != "c3" || status.PinnedGeneration != 3 {
+		t.Errorf("expected c3 to be pinned for generation 3, got %s for generation %d", status.PinnedCommit, status.PinnedGeneration)
+	}
+	if gitJob := findGitJob(objs); gitJob.Spec.Git.Revision != "c3" {
+		t.Errorf("expected the git job to use revision c3, got %s", gitJob.Spec.Git.Revision)
+	}
+}
+
+func TestJobDeadlineAndRestartPolicy(t *testing.T) {
+	deadline := int64(600)
+
+	tests := []struct {
+		name          string
+		deadline      *int64
+		restartPolicy string
+		expected      corev1.RestartPolicy
+	}{
+		{
+			name:     "defaults",
+			expected: corev1.RestartPolicyNever,
+		},
+		{
+			name:          "overrides",
+			deadline:      &deadline,
+			restartPolicy: "OnFailure",
+			expected:      corev1.RestartPolicyOnFailure,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gitrepo := newGitRepo("test")
+			gitrepo.Spec.JobActiveDeadlineSeconds = test.deadline
+			gitrepo.Spec.JobRestartPolicy = test.restartPolicy
+
+			h, _ := newTestHandler(&config.Config{})
+			objs, _, err := h.OnChange(gitrepo, fleet.GitRepoStatus{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gitJob := findGitJob(objs)
+			if gitJob == nil {
+				t.Fatal("expected a git job")
+			}
+			jobSpec := gitJob.Spec.JobSpec
+			if (jobSpec.ActiveDeadlineSeconds == nil) != (test.deadline == nil) ||
+				test.deadline != nil && *jobSpec.ActiveDeadlineSeconds != *test.deadline {
+				t.Errorf("expected active deadline %v, got %v", test.deadline, jobSpec.ActiveDeadlineSeconds)
+			}
+			if policy := jobSpec.Template.Spec.RestartPolicy; policy != test.expected {
+				t.Errorf("expected restart policy %s, got %s", test.expected, policy)
+			}
+		})
+	}
+}
+
+func TestInvalidRestartPolicyKeepsGitJob(t *testing.T) {
+	gitrepo := newGitRepo("test")
+	gitrepo.Spec.JobRestartPolicy = "Always"
+	existing := newGitJob(gitrepo, "Current", "abc", "abc")
+	existing.Spec.Git.Branch = "main"
+
+	h, _ := newTestHandler(&config.Config{}, existing)
+	objs, status, err := h.OnChange(gitrepo, fleet.GitRepoStatus{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !gitRepoConditionAccepted.IsFalse(&status) {
+		t.Error("expected gitrepo with restart policy Always not to be accepted")
+	}
+	assertKept(t, objs, "main")
+}
+
+func TestProvider(t *testing.T) {
+	tests := []struct {
+		provider string
+		repo     string
+		expected string
+		accepted bool
+	}{
+		{
+			expected: pollingProvider,
+			accepted: true,
+		},
+		{
+			provider: pollingProvider,
+			expected: pollingProvider,
+			accepted: true,
+		},
+		{
+			provider: githubProvider,
+			expected: githubProvider,
+			accepted: true,
+		},
+		{
+			provider: gitlabProvider,
+			repo:     "git@gitlab.com:rancher/fleet-examples",
+			expected: gitlabProvider,
+			accepted: true,
+		},
+		{
+			provider: webhookProvider,
+			expected: webhookProvider,
+			accepted: true,
+		},
+		{
+			provider: gitlabProvider,
+			repo:     "/srv/git/fleet-examples",
+		},
+		{
+			provider: "bitbucket",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.provider, func(t *testing.T) {
+			gitrepo := newGitRepo("test")
+			gitrepo.Spec.Provider = test.provider
+			if test.repo != "" {
+				gitrepo.Spec.Repo = test.repo
+			}
+			existing := newGitJob(gitrepo, "Current", "abc", "abc")
+			existing.Spec.Git.Branch = "main"
+
+			h, _ := newTestHandler(&config.Config{WebhookReceiverURL: "https://fleet.example.com/hooks"}, existing)
+			objs, status, err := h.OnChange(gitrepo, fleet.GitRepoStatus{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !test.accepted {
+				if !gitRepoConditionAccepted.IsFalse(&status) {
+					t.Errorf("expected provider %s not to be accepted", test.provider)
+				}
+				assertKept(t, objs, "main")
+				return
+			}
+
+			gitJob := findGitJob(objs)
+			if gitJob == nil {
+				t.Fatal("expected a git job")
+			}
+			if gitJob.Spec.Git.Provider != test.expected {
+				t.Errorf("expected provider %s, got %s", test.expected, gitJob.Spec.Git.Provider)
+			}
+		})
+	}
+}
+
+func TestRepoHostname(t *testing.T) {
+	tests := map[string]string{
+		"https://github.com/rancher/fleet":      "github.com",
+		"ssh://git@gitlab.com:22/rancher/fleet": "gitlab.com",
+		"git@gitlab.com:rancher/fleet":          "gitlab.com",
+		"/srv/git/fleet":                        "",
+	}
+
+	for repo, expected := range tests {
+		if hostname := repoHostname(repo); hostname != expected {
+			t.Errorf("%s: expected hostname %q, got %q", repo, expected, hostname)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	for _, plan := range []bool{false, true} {
+		gitrepo := newGitRepo("test")
+		gitrepo.Spec.Plan = plan
+
+		h, _ := newTestHandler(&config.Config{})
+		objs, status, err := h.OnChange(gitrepo, fleet.GitRepoStatus{Plan: []string{"test: update"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		args := command(findGitJob(objs))
+		if value := argValue(args, "--plan-repo"); (value == "test") != plan {
+			t.Errorf("plan %v: expected --plan-repo test %v in %v", plan, plan, args)
+		}
+		if hasArg(args, "--paths-repo") == plan {
+			t.Errorf("plan %v: expected --paths-repo %v in %v", plan, !plan, args)
+		}
+		if hasArg(args, "--dry-run") {
+			t.Errorf("plan %v: expected no --dry-run in %v", plan, args)
+		}
+
+		if s := gitRepoConditionPlan.GetStatus(&status); (s == "True") != plan {
+			t.Errorf("plan %v: expected condition Plan true %v, got %q", plan, plan, s)
+		}
+		if (status.Plan != nil) != plan {
+			t.Errorf("plan %v: expected the planned changes to be kept %v, got %v", plan, plan, status.Plan)
+		}
+
+		var canUpdateStatus bool
+		for _, obj := range objs {
+			if role, ok := obj.(*rbacv1.Role); ok {
+				for _, rule := range role.Rules {
+					if reflect.DeepEqual(rule.Resources, []string{"gitrepos/status"}) && reflect.DeepEqual(rule.Verbs, []string{"update"}) {
+						canUpdateStatus = true
+					}
+				}
+			}
+		}
+		if !canUpdateStatus {
+			t.Errorf("plan %v: expected the role of the job to allow updating the status of gitrepos", plan)
+		}
+	}
+}
+
+func TestImagePullSecrets(t *testing.T) {
+	secret := func(name string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "fleet-local"}}
+	}
+
+	tests := []struct {
+		name     string
+		secrets  []string
+		defaults []string
+		existing []*corev1.Secret
+		expected []corev1.LocalObjectReference
+		message  string
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:     "gitrepo",
+			secrets:  []string{"registry"},
+			defaults: []string{"default"},
+			existing: []*corev1.Secret{secret("registry")},
+			expected: []corev1.LocalObjectReference{{Name: "registry"}},
+		},
+		{
+			name:     "default",
+			defaults: []string{"default"},
+			existing: []*corev1.Secret{secret("default")},
+			expected: []corev1.LocalObjectReference{{Name: "default"}},
+		},
+		{
+			name:     "missing",
+			secrets:  []string{"registry", "mirror"},
+			existing: []*corev1.Secret{secret("registry")},
+			expected: []corev1.LocalObjectReference{{Name: "registry"}, {Name: "mirror"}},
+			message:  "image pull secrets not found: mirror",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gitrepo := newGitRepo("test")
+			gitrepo.Spec.ImagePullSecrets = test.secrets
+
+			h, gitRepos := newTestHandler(&config.Config{GitJobImagePullSecrets: test.defaults})
+			h.secretCache = &fakeSecretCache{secrets: test.existing}
+
+			// a condition left from an earlier missing secret is cleared
+			status := fleet.GitRepoStatus{}
+			gitRepoConditionPullSecrets.SetStatusBool(&status, false)
+			gitRepoConditionPullSecrets.Message(&status, "image pull secrets not found: registry")
+
+			objs, status, err := h.OnChange(gitrepo, status)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gitJob := findGitJob(objs)
+			if gitJob == nil {
+				t.Fatal("expected a git job")
+			}
+			if secrets := gitJob.Spec.JobSpec.Template.Spec.ImagePullSecrets; !reflect.DeepEqual(secrets, test.expected) {
+				t.Errorf("expected image pull secrets %v, got %v", test.expected, secrets)
+			}
+			if m := gitRepoConditionPullSecrets.GetMessage(&status); m != test.message {
+				t.Errorf("expected message %q, got %q", test.message, m)
+			}
+			if s := gitRepoConditionPullSecrets.GetStatus(&status); (s == "True") != (test.message == "") {
+				t.Errorf("expected condition ImagePullSecrets true %v, got %q", test.message == "", s)
+			}
+			if requeued := len(gitRepos.enqueued) > 0; requeued != (test.message != "") {
+				t.Errorf("expected requeue %v, got %v", test.message != "", gitRepos.enqueued)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package crd
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	apiextv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+const jsonSchemaDraft = "http://json-schema.org/draft-04/schema#"
+
+// BundleSpecSchema returns the JSON Schema of the bundle spec, including targets and overlays. It is the
+// spec schema of the Bundle CRD, which is generated from the Go types, so the two never differ.
+func BundleSpecSchema() (*apiextv1beta1.JSONSchemaProps, error) {
+	crd, err := newCRD(&fleet.Bundle{}, nil).ToCustomResourceDefinition()
+	if err != nil {
+		return nil, err
+	}
+
+	if crd.Spec.Validation == nil || crd.Spec.Validation.OpenAPIV3Schema == nil {
+		return nil, errors.New("bundle CRD has no schema")
+	}
+	spec, ok := crd.Spec.Validation.OpenAPIV3Schema.Properties["spec"]
+	if !ok {
+		return nil, errors.New("bundle CRD schema has no spec")
+	}
+
+	spec.Schema = jsonSchemaDraft
+	return &spec, nil
+}
+
+// PrintBundleSpecSchema writes the JSON Schema of the bundle spec, for validating fleet.yaml files in
+// editors and CI
+func PrintBundleSpecSchema(out io.Writer) error {
+	schema, err := BundleSpecSchema()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = out.Write(append(data, '\n'))
+	return err
+}
+
+func WriteBundleSpecSchema(filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return PrintBundleSpecSchema(f)
+}
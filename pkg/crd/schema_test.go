@@ -0,0 +1,172 @@
+package crd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+
+	apiextv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	"sigs.k8s.io/yaml"
+)
+
+// validate returns an error for each value that does not have the type of the schema. Like the Kubernetes
+// API server, properties not in the schema are allowed.
+func validate(schema *apiextv1beta1.JSONSchemaProps, value interface{}, path string) (errs []error) {
+	if value == nil {
+		if !schema.Nullable && schema.Type != "" {
+			errs = append(errs, fmt.Errorf("%s: must not be null", path))
+		}
+		return errs
+	}
+	if schema.XIntOrString {
+		switch value.(type) {
+		case string, float64:
+			return nil
+		}
+		return append(errs, fmt.Errorf("%s: must be an integer or string", path))
+	}
+
+	switch schema.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return append(errs, fmt.Errorf("%s: must be an object", path))
+		}
+		for key, value := range obj {
+			if prop, ok := schema.Properties[key]; ok {
+				errs = append(errs, validate(&prop, value, path+"."+key)...)
+			} else if schema.AdditionalProperties != nil && schema.AdditionalProperties.Schema != nil {
+				errs = append(errs, validate(schema.AdditionalProperties.Schema, value, path+"."+key)...)
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return append(errs, fmt.Errorf("%s: must be an array", path))
+		}
+		if schema.Items != nil && schema.Items.Schema != nil {
+			for i, item := range items {
+				errs = append(errs, validate(schema.Items.Schema, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			errs = append(errs, fmt.Errorf("%s: must be a string", path))
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			errs = append(errs, fmt.Errorf("%s: must be an integer", path))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			errs = append(errs, fmt.Errorf("%s: must be a number", path))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			errs = append(errs, fmt.Errorf("%s: must be a boolean", path))
+		}
+	}
+	return errs
+}
+
+func validateYAML(t *testing.T, schema *apiextv1beta1.JSONSchemaProps, data []byte) []error {
+	t.Helper()
+
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		t.Fatal(err)
+	}
+	return validate(schema, value, "spec")
+}
+
+func TestBundleSpecSchemaExamples(t *testing.T) {
+	schema, err := BundleSpecSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob("../../examples/*/bundle.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("expected example bundles")
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if errs := validateYAML(t, schema, data); len(errs) > 0 {
+			t.Errorf("%s: expected to be valid, got %v", file, errs)
+		}
+	}
+}
+
+func TestBundleSpecSchemaInvalid(t *testing.T) {
+	schema, err := BundleSpecSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		data  string
+		error string
+	}{
+		{
+			name:  "targets not a list",
+			data:  "targets:\n  name: prod\n",
+			error: "spec.targets: must be an array",
+		},
+		{
+			name:  "boolean as a string",
+			data:  "force: \"yes\"\n",
+			error: "spec.force: must be a boolean",
+		},
+		{
+			name:  "match labels not strings",
+			data:  "targets:\n- clusterSelector:\n    matchLabels:\n      env: [prod]\n",
+			error: "spec.targets[0].clusterSelector.matchLabels.env: must be a string",
+		},
+		{
+			name:  "fractional integer",
+			data:  "timeoutSeconds: 1.5\n",
+			error: "spec.timeoutSeconds: must be an integer",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := validateYAML(t, schema, []byte(test.data))
+			if len(errs) != 1 || errs[0].Error() != test.error {
+				t.Errorf("expected error %q, got %v", test.error, errs)
+			}
+		})
+	}
+}
+
+func TestPrintBundleSpecSchema(t *testing.T) {
+	var out bytes.Buffer
+	if err := PrintBundleSpecSchema(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	schema := &apiextv1beta1.JSONSchemaProps{}
+	if err := json.Unmarshal(out.Bytes(), schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.Schema != jsonSchemaDraft {
+		t.Errorf("expected $schema %s, got %q", jsonSchemaDraft, schema.Schema)
+	}
+	for _, name := range []string{"targets", "overlays", "rolloutStrategy"} {
+		if _, ok := schema.Properties[name]; !ok {
+			t.Errorf("expected the schema to have property %s", name)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/rancher/fleet/modules/agent/pkg/deployer"
 	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
 	"github.com/rancher/fleet/pkg/kustomize"
@@ -23,6 +24,8 @@ import (
 	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/release"
 	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
@@ -102,6 +105,11 @@ func (p *postRender) Run(renderedManifests *bytes.Buffer) (modifiedManifests *by
 		return nil, err
 	}
 
+	// helm will not delete resources with this annotation on upgrade or uninstall
+	keep := map[string]string{
+		"helm.sh/resource-policy": "keep",
+	}
+
 	for _, obj := range objs {
 		meta, err := meta.Accessor(obj)
 		if err != nil {
@@ -109,12 +117,26 @@ func (p *postRender) Run(renderedManifests *bytes.Buffer) (modifiedManifests *by
 		}
 		meta.SetLabels(mergeMaps(meta.GetLabels(), labels))
 		meta.SetAnnotations(mergeMaps(meta.GetAnnotations(), annotations))
+		if p.opts.KeepResources || noPrune(p.opts.NoPruneResources, obj.GetObjectKind().GroupVersionKind().Kind, meta.GetName()) {
+			meta.SetAnnotations(mergeMaps(meta.GetAnnotations(), keep))
+		}
 	}
 
 	data, err = yaml.ToBytes(objs)
 	return bytes.NewBuffer(data), err
 }
 
+// noPrune returns true if the object of the kind and name is listed in resources as a kind or kind/name
+func noPrune(resources []string, kind, name string) bool {
+	for _, resource := range resources {
+		k, n := kv.Split(resource, "/")
+		if strings.EqualFold(k, kind) && (n == "" || n == name) {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *helm) Deploy(bundleID string, manifest *manifest.Manifest, options fleet.BundleDeploymentOptions) (*deployer.Resources, error) {
 	tar, err := render.ToChart(bundleID, manifest)
 	if err != nil {
@@ -143,9 +165,55 @@ func (h *helm) Deploy(bundleID string, manifest *manifest.Manifest, options flee
 		return nil, err
 	}
 
+	if err := h.deleteResources(options); err != nil {
+		return nil, err
+	}
+
 	return releaseToResources(release)
 }
 
+// deleteResources deletes the resources listed in the deletions of the options. Resources that do not exist
+// are skipped, so the deletions can stay in the bundle after the resources are gone.
+func (h *helm) deleteResources(options fleet.BundleDeploymentOptions) error {
+	if len(options.Deletions) == 0 {
+		return nil
+	}
+
+	_, _, namespace := h.getOpts(options)
+	cfg, err := h.getCfg(namespace, options.ServiceAccount)
+	if err != nil {
+		return err
+	}
+
+	var objs []runtime.Object
+	for _, deletion := range options.Deletions {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(deletion.APIVersion)
+		obj.SetKind(deletion.Kind)
+		obj.SetName(deletion.Name)
+		obj.SetNamespace(namespace)
+		if deletion.Namespace != "" {
+			obj.SetNamespace(deletion.Namespace)
+		}
+		objs = append(objs, obj)
+	}
+
+	data, err := yaml.ToBytes(objs)
+	if err != nil {
+		return err
+	}
+
+	resources, err := cfg.KubeClient.Build(bytes.NewBuffer(data), false)
+	if err != nil {
+		return errors.Wrap(err, "failed to find resources to delete")
+	}
+
+	if _, errs := cfg.KubeClient.Delete(resources); len(errs) > 0 {
+		return fmt.Errorf("failed to delete resources: %v", errs)
+	}
+	return nil
+}
+
 func (h *helm) mustUninstall(cfg *action.Configuration, bundleID string) (bool, error) {
 	r, err := cfg.Releases.Last(bundleID)
 	if err != nil {
@@ -0,0 +1,25 @@
+package helmdeployer
+
+import "testing"
+
+func TestNoPrune(t *testing.T) {
+	resources := []string{"PersistentVolumeClaim", "ConfigMap/settings"}
+
+	tests := []struct {
+		kind     string
+		name     string
+		expected bool
+	}{
+		{kind: "PersistentVolumeClaim", name: "data", expected: true},
+		{kind: "persistentvolumeclaim", name: "data", expected: true},
+		{kind: "ConfigMap", name: "settings", expected: true},
+		{kind: "ConfigMap", name: "other"},
+		{kind: "Secret", name: "settings"},
+	}
+
+	for _, test := range tests {
+		if actual := noPrune(resources, test.kind, test.name); actual != test.expected {
+			t.Errorf("%s/%s: expected %v, got %v", test.kind, test.name, test.expected, actual)
+		}
+	}
+}
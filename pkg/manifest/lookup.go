@@ -1,6 +1,12 @@
 package manifest
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
 	"github.com/rancher/fleet/pkg/content"
 	fleetcontrollers "github.com/rancher/fleet/pkg/generated/controllers/fleet.cattle.io/v1alpha1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -33,3 +39,39 @@ func (l *lookup) Get(id string) (*Manifest, error) {
 
 	return ReadManifest(bytes, id)
 }
+
+var downloadClient = &http.Client{
+	Timeout: 5 * time.Minute,
+}
+
+// MaxDownloadSize is the max size in bytes of a gzipped manifest downloaded from a content URL, the same
+// as the default max size of a bundle
+var MaxDownloadSize int64 = 1400000
+
+// Download gets the gzipped manifest from the url and checks that it matches the id
+func Download(url, id string) (*Manifest, error) {
+	resp, err := downloadClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download manifest %s from %s: %s", id, url, resp.Status)
+	}
+
+	compressed, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxDownloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(compressed)) > MaxDownloadSize {
+		return nil, fmt.Errorf("failed to download manifest %s from %s: exceeds the max size of %d bytes", id, url, MaxDownloadSize)
+	}
+
+	bytes, err := content.GUnzip(compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	return ReadManifest(bytes, id)
+}
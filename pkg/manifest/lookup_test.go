@@ -0,0 +1,63 @@
+package manifest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/content"
+)
+
+func TestDownload(t *testing.T) {
+	m, err := New(&fleet.BundleSpec{
+		Resources: []fleet.BundleResource{
+			{Name: "manifests/configmap.yaml", Content: "kind: ConfigMap\n" + strings.Repeat("# padding\n", 100)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, id, err := m.Content()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := content.Gzip(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/manifest.gz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(gz)
+	}))
+	defer server.Close()
+
+	downloaded, err := Download(server.URL+"/manifest.gz", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, downloadedID, err := downloaded.Content(); err != nil || downloadedID != id {
+		t.Errorf("expected the manifest of id %s, got %s, %v", id, downloadedID, err)
+	}
+
+	if _, err := Download(server.URL+"/missing.gz", id); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected a missing manifest to fail, got %v", err)
+	}
+
+	defer func(max int64) { MaxDownloadSize = max }(MaxDownloadSize)
+	MaxDownloadSize = int64(len(gz)) - 1
+	_, err = Download(server.URL+"/manifest.gz", id)
+	if err == nil || !strings.Contains(err.Error(), "exceeds the max size") {
+		t.Errorf("expected a manifest over the max size to fail, got %v", err)
+	}
+
+	MaxDownloadSize = int64(len(gz))
+	if _, err := Download(server.URL+"/manifest.gz", id); err != nil {
+		t.Errorf("expected a manifest of exactly the max size to be downloaded, got %v", err)
+	}
+}
@@ -15,12 +15,21 @@ func toSelector(labels *metav1.LabelSelector) (labels.Selector, error) {
 	return metav1.LabelSelectorAsSelector(labels)
 }
 
-func NewClusterMatcher(clusterGroup string, clusterGroupSelector *metav1.LabelSelector, clusterSelector *metav1.LabelSelector) (*ClusterMatcher, error) {
+// ValidateSelector returns an error if the selector can not be parsed. A nil selector is valid.
+func ValidateSelector(selector *metav1.LabelSelector) error {
+	if selector == nil {
+		return nil
+	}
+	_, err := toSelector(selector)
+	return err
+}
+
+func NewClusterMatcher(targetClusterGroup string, clusterGroupSelector *metav1.LabelSelector, clusterSelector *metav1.LabelSelector) (*ClusterMatcher, error) {
 	t := &ClusterMatcher{}
 
-	if clusterGroup != "" {
+	if targetClusterGroup != "" {
 		t.criteria = append(t.criteria, func(clusterGroup string, clusterGroupLabels, clusterLabels map[string]string) bool {
-			return clusterGroup == clusterGroup
+			return clusterGroup == targetClusterGroup
 		})
 	}
 
@@ -47,6 +56,11 @@ func NewClusterMatcher(clusterGroup string, clusterGroupSelector *metav1.LabelSe
 	return t, nil
 }
 
+// Empty returns true if the matcher has no criteria and so matches nothing
+func (t *ClusterMatcher) Empty() bool {
+	return len(t.criteria) == 0
+}
+
 func (t *ClusterMatcher) Match(clusterGroup string, clusterGroupLabels, clusterLabels map[string]string) bool {
 	if len(t.criteria) == 0 {
 		return false
@@ -0,0 +1,50 @@
+package match
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestClusterMatcherClusterGroup(t *testing.T) {
+	tests := []struct {
+		name                 string
+		clusterGroup         string
+		clusterGroupSelector *metav1.LabelSelector
+		clusterSelector      *metav1.LabelSelector
+		match                bool
+	}{
+		{
+			name:         "member of the group",
+			clusterGroup: "prod",
+			match:        true,
+		},
+		{
+			name:         "member of another group",
+			clusterGroup: "dev",
+		},
+		{
+			name:            "member of the group with matching labels",
+			clusterGroup:    "prod",
+			clusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+			match:           true,
+		},
+		{
+			name:                 "member of another group with matching group labels",
+			clusterGroup:         "dev",
+			clusterGroupSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "edge"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matcher, err := NewClusterMatcher(test.clusterGroup, test.clusterGroupSelector, test.clusterSelector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if match := matcher.Match("prod", map[string]string{"tier": "edge"}, map[string]string{"env": "prod"}); match != test.match {
+				t.Errorf("expected match %v, got %v", test.match, match)
+			}
+		})
+	}
+}
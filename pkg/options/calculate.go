@@ -26,7 +26,7 @@ func DeploymentID(manifest *manifest.Manifest, opts fleet.BundleDeploymentOption
 }
 
 func Calculate(spec *fleet.BundleSpec, target *fleet.BundleTarget) (fleet.BundleDeploymentOptions, error) {
-	result := spec.BundleDeploymentOptions
+	result := merge(fleet.BundleDeploymentOptions{}, spec.BundleDeploymentOptions)
 
 	allOverlays, overlays, err := overlay.Resolve(spec, target.Overlays...)
 	if err != nil {
@@ -41,20 +41,16 @@ func Calculate(spec *fleet.BundleSpec, target *fleet.BundleTarget) (fleet.Bundle
 }
 
 func merge(base, next fleet.BundleDeploymentOptions) fleet.BundleDeploymentOptions {
-	if next.DefaultNamespace != "" {
-		base.DefaultNamespace = next.DefaultNamespace
-	} else if next.DefaultNamespace == "-" {
+	// "-" clears the value set at a lower level, such as the bundle default namespace
+	if next.DefaultNamespace == "-" {
 		base.DefaultNamespace = ""
+	} else if next.DefaultNamespace != "" {
+		base.DefaultNamespace = next.DefaultNamespace
 	}
-	if next.ServiceAccount != "" {
-		base.ServiceAccount = next.ServiceAccount
-	} else if next.ServiceAccount == "-" {
+	if next.ServiceAccount == "-" {
 		base.ServiceAccount = ""
-	}
-	if next.ServiceAccount != "" {
+	} else if next.ServiceAccount != "" {
 		base.ServiceAccount = next.ServiceAccount
-	} else if next.ServiceAccount == "-" {
-		base.ServiceAccount = ""
 	}
 	if next.TimeoutSeconds > 0 {
 		base.TimeoutSeconds = next.TimeoutSeconds
@@ -70,5 +66,19 @@ func merge(base, next fleet.BundleDeploymentOptions) fleet.BundleDeploymentOptio
 		base.KustomizeDir = next.KustomizeDir
 	}
 	base.Force = base.Force || next.Force
+	base.KeepResources = base.KeepResources || next.KeepResources
+	base.NoPruneResources = append(base.NoPruneResources, next.NoPruneResources...)
+	if len(next.RequiredConditions) > 0 {
+		base.RequiredConditions = next.RequiredConditions
+	}
+	if next.ContentURL != "" {
+		base.ContentURL = next.ContentURL
+	}
+	base.Deletions = append(base.Deletions, next.Deletions...)
+	if next.ApplyConcurrency > 0 {
+		base.ApplyConcurrency = next.ApplyConcurrency
+	} else if next.ApplyConcurrency < 0 {
+		base.ApplyConcurrency = 0
+	}
 	return base
 }
@@ -0,0 +1,128 @@
+package options
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/bundle"
+)
+
+const namespaceBundle = `namespace: app
+serviceAccount: app
+overlays:
+- name: eu
+  namespace: app-eu
+targets:
+- name: default
+  clusterGroup: default
+- name: target
+  clusterGroup: target
+  namespace: app-target
+- name: cleared
+  clusterGroup: cleared
+  namespace: "-"
+  serviceAccount: "-"
+- name: overlay
+  clusterGroup: overlay
+  overlays: [eu]
+- name: target-over-overlay
+  clusterGroup: target-over-overlay
+  overlays: [eu]
+  namespace: app-target
+`
+
+func TestCalculateNamespace(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "fleet.yaml"), []byte(namespaceBundle), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "manifests"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "manifests", "configmap.yaml"), []byte("kind: ConfigMap\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := bundle.Open(context.Background(), dir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &b.Definition.Spec
+	if spec.DefaultNamespace != "app" {
+		t.Fatalf("expected the bundle namespace to be read, got %q", spec.DefaultNamespace)
+	}
+
+	expected := map[string]struct {
+		namespace      string
+		serviceAccount string
+	}{
+		"default":             {namespace: "app", serviceAccount: "app"},
+		"target":              {namespace: "app-target", serviceAccount: "app"},
+		"cleared":             {},
+		"overlay":             {namespace: "app-eu", serviceAccount: "app"},
+		"target-over-overlay": {namespace: "app-target", serviceAccount: "app"},
+	}
+	for i := range spec.Targets {
+		target := &spec.Targets[i]
+		opts, err := Calculate(spec, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e := expected[target.Name]
+		if opts.DefaultNamespace != e.namespace {
+			t.Errorf("%s: expected namespace %q, got %q", target.Name, e.namespace, opts.DefaultNamespace)
+		}
+		if opts.ServiceAccount != e.serviceAccount {
+			t.Errorf("%s: expected service account %q, got %q", target.Name, e.serviceAccount, opts.ServiceAccount)
+		}
+	}
+}
+
+func TestCalculateDeletions(t *testing.T) {
+	deletion := func(kind, name string) fleet.ResourceDeletion {
+		return fleet.ResourceDeletion{APIVersion: "v1", Kind: kind, Name: name}
+	}
+
+	spec := &fleet.BundleSpec{
+		BundleDeploymentOptions: fleet.BundleDeploymentOptions{
+			Deletions: []fleet.ResourceDeletion{deletion("ConfigMap", "old")},
+		},
+		Overlays: []fleet.BundleOverlay{
+			{
+				Name:                    "eu",
+				BundleDeploymentOptions: fleet.BundleDeploymentOptions{Deletions: []fleet.ResourceDeletion{deletion("Secret", "eu")}},
+			},
+		},
+		Targets: []fleet.BundleTarget{
+			{
+				Name:                    "eu",
+				Overlays:                []string{"eu"},
+				BundleDeploymentOptions: fleet.BundleDeploymentOptions{Deletions: []fleet.ResourceDeletion{deletion("Service", "legacy")}},
+			},
+			{
+				Name: "default",
+			},
+		},
+	}
+
+	expected := map[string][]fleet.ResourceDeletion{
+		"eu":      {deletion("ConfigMap", "old"), deletion("Secret", "eu"), deletion("Service", "legacy")},
+		"default": {deletion("ConfigMap", "old")},
+	}
+	for i := range spec.Targets {
+		target := &spec.Targets[i]
+		opts, err := Calculate(spec, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(opts.Deletions, expected[target.Name]) {
+			t.Errorf("%s: expected deletions %v, got %v", target.Name, expected[target.Name], opts.Deletions)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package options
+
+import (
+	"regexp"
+	"strings"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+)
+
+// clusterLabelPattern matches $(cluster.label:<key>)
+var clusterLabelPattern = regexp.MustCompile(`\$\(cluster\.label:([^)]+)\)`)
+
+// SubstituteClusterLabels replaces $(cluster.label:<key>) in the string values of opts with the value of
+// the cluster label. Only the labels listed in allowed are substituted, references to any other label are
+// left as is. A label missing from the cluster is replaced with an empty string.
+func SubstituteClusterLabels(opts fleet.BundleDeploymentOptions, allowed []string, clusterLabels map[string]string) fleet.BundleDeploymentOptions {
+	if opts.Values == nil || len(allowed) == 0 {
+		return opts
+	}
+
+	values := map[string]string{}
+	for _, key := range allowed {
+		values[key] = clusterLabels[key]
+	}
+
+	opts.Values = opts.Values.DeepCopy()
+	opts.Values.Data = substitute(opts.Values.Data, values).(map[string]interface{})
+	return opts
+}
+
+func substitute(obj interface{}, values map[string]string) interface{} {
+	switch v := obj.(type) {
+	case string:
+		return clusterLabelPattern.ReplaceAllStringFunc(v, func(ref string) string {
+			key := clusterLabelPattern.FindStringSubmatch(ref)[1]
+			if value, ok := values[key]; ok {
+				return value
+			}
+			return ref
+		})
+	case map[string]interface{}:
+		for k, value := range v {
+			v[k] = substitute(value, values)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = substitute(value, values)
+		}
+	}
+	return obj
+}
+
+// InjectClusterLabels sets the Helm values at the dotted paths of labelValues, such as global.region, to the
+// value of the cluster label each path maps to. Only the labels listed in allowed are injected. A label
+// missing from the cluster sets the value to an empty string.
+func InjectClusterLabels(opts fleet.BundleDeploymentOptions, labelValues map[string]string, allowed []string, clusterLabels map[string]string) fleet.BundleDeploymentOptions {
+	if len(labelValues) == 0 || len(allowed) == 0 {
+		return opts
+	}
+
+	isAllowed := map[string]bool{}
+	for _, key := range allowed {
+		isAllowed[key] = true
+	}
+
+	if opts.Values == nil {
+		opts.Values = &fleet.GenericMap{}
+	} else {
+		opts.Values = opts.Values.DeepCopy()
+	}
+	if opts.Values.Data == nil {
+		opts.Values.Data = map[string]interface{}{}
+	}
+
+	for path, label := range labelValues {
+		if isAllowed[label] {
+			setValue(opts.Values.Data, strings.Split(path, "."), clusterLabels[label])
+		}
+	}
+	return opts
+}
+
+// setValue sets the value at the path, replacing any value that is not a map along the way
+func setValue(data map[string]interface{}, path []string, value string) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := data[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			data[key] = next
+		}
+		data = next
+	}
+	data[path[len(path)-1]] = value
+}
@@ -0,0 +1,121 @@
+package options
+
+import (
+	"reflect"
+	"testing"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+)
+
+func TestSubstituteClusterLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   map[string]interface{}
+		allowed  []string
+		expected map[string]interface{}
+	}{
+		{
+			name:     "nested values",
+			values:   map[string]interface{}{"global": map[string]interface{}{"region": "$(cluster.label:region)"}},
+			allowed:  []string{"region"},
+			expected: map[string]interface{}{"global": map[string]interface{}{"region": "eu"}},
+		},
+		{
+			name:     "lists and embedded references",
+			values:   map[string]interface{}{"hosts": []interface{}{"app.$(cluster.label:region).example.com", true}},
+			allowed:  []string{"region"},
+			expected: map[string]interface{}{"hosts": []interface{}{"app.eu.example.com", true}},
+		},
+		{
+			name:     "label not allowed",
+			values:   map[string]interface{}{"zone": "$(cluster.label:zone)"},
+			allowed:  []string{"region"},
+			expected: map[string]interface{}{"zone": "$(cluster.label:zone)"},
+		},
+		{
+			name:     "label missing from the cluster",
+			values:   map[string]interface{}{"tier": "$(cluster.label:tier)"},
+			allowed:  []string{"tier"},
+			expected: map[string]interface{}{"tier": ""},
+		},
+		{
+			name:     "no allowed labels",
+			values:   map[string]interface{}{"region": "$(cluster.label:region)"},
+			expected: map[string]interface{}{"region": "$(cluster.label:region)"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := fleet.BundleDeploymentOptions{Values: &fleet.GenericMap{Data: test.values}}
+			result := SubstituteClusterLabels(opts, test.allowed, map[string]string{"region": "eu", "zone": "a"})
+			if !reflect.DeepEqual(result.Values.Data, test.expected) {
+				t.Errorf("expected values %v, got %v", test.expected, result.Values.Data)
+			}
+		})
+	}
+}
+
+func TestInjectClusterLabels(t *testing.T) {
+	clusterLabels := map[string]string{"region": "eu", "zone": "a"}
+
+	tests := []struct {
+		name        string
+		values      map[string]interface{}
+		labelValues map[string]string
+		allowed     []string
+		expected    map[string]interface{}
+	}{
+		{
+			name:        "nested path",
+			values:      map[string]interface{}{"global": map[string]interface{}{"image": "app"}},
+			labelValues: map[string]string{"global.region": "region"},
+			allowed:     []string{"region"},
+			expected:    map[string]interface{}{"global": map[string]interface{}{"image": "app", "region": "eu"}},
+		},
+		{
+			name:        "no values",
+			labelValues: map[string]string{"region": "region"},
+			allowed:     []string{"region"},
+			expected:    map[string]interface{}{"region": "eu"},
+		},
+		{
+			name:        "replaces a value that is not a map",
+			values:      map[string]interface{}{"global": "app"},
+			labelValues: map[string]string{"global.region": "region"},
+			allowed:     []string{"region"},
+			expected:    map[string]interface{}{"global": map[string]interface{}{"region": "eu"}},
+		},
+		{
+			name:        "label not allowed",
+			values:      map[string]interface{}{"replicas": float64(3)},
+			labelValues: map[string]string{"region": "region", "zone": "zone"},
+			allowed:     []string{"region"},
+			expected:    map[string]interface{}{"replicas": float64(3), "region": "eu"},
+		},
+		{
+			name:        "label missing from the cluster",
+			labelValues: map[string]string{"tier": "tier"},
+			allowed:     []string{"tier"},
+			expected:    map[string]interface{}{"tier": ""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var opts fleet.BundleDeploymentOptions
+			if test.values != nil {
+				opts.Values = &fleet.GenericMap{Data: test.values}
+			}
+			original := opts.Values.DeepCopy()
+
+			result := InjectClusterLabels(opts, test.labelValues, test.allowed, clusterLabels)
+			if !reflect.DeepEqual(result.Values.Data, test.expected) {
+				t.Errorf("expected values %v, got %v", test.expected, result.Values.Data)
+			}
+			if !reflect.DeepEqual(opts.Values, original) {
+				t.Errorf("expected the values of the target not to change, got %v", opts.Values)
+			}
+		})
+	}
+}
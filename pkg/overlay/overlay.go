@@ -2,37 +2,83 @@ package overlay
 
 import (
 	"fmt"
+	"strings"
 
 	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
-	"github.com/rancher/wrangler/pkg/seen"
 )
 
+// Resolve returns all overlays of the spec by name and the ordered list of overlays to apply for the
+// given overlay names. Overlays referenced by an overlay are always ordered after the referencing
+// overlay, so they are applied on top of it. Apart from that the order the overlays are listed in is
+// kept. An error is returned if an overlay is not found or the references form a cycle.
 func Resolve(spec *fleet.BundleSpec, overlays ...string) (map[string]fleet.BundleOverlay, []string, error) {
 	allOverlays := map[string]fleet.BundleOverlay{}
 	for _, overlay := range spec.Overlays {
 		allOverlays[overlay.Name] = overlay
 	}
-	overlaySet := traverse(allOverlays, seen.New(), overlays...)
-	for i := len(overlaySet) - 1; i >= 0; i-- {
-		_, ok := allOverlays[overlaySet[i]]
-		if !ok {
-			return nil, nil, fmt.Errorf("failed to find referenced overlay %s", overlaySet[i])
+
+	s := &sorter{
+		overlays: allOverlays,
+		visited:  map[string]bool{},
+		visiting: map[string]bool{},
+	}
+	if err := s.visit(overlays); err != nil {
+		return nil, nil, err
+	}
+
+	// reverse post order is the topological order
+	overlaySet := make([]string, 0, len(s.order))
+	for i := len(s.order) - 1; i >= 0; i-- {
+		overlaySet = append(overlaySet, s.order[i])
+	}
+
+	for _, name := range overlaySet {
+		if _, ok := allOverlays[name]; !ok {
+			return nil, nil, fmt.Errorf("failed to find referenced overlay %s", name)
 		}
 	}
 	return allOverlays, overlaySet, nil
 }
 
-func traverse(overlays map[string]fleet.BundleOverlay, seen seen.Seen, targets ...string) []string {
-	var result []string
+type sorter struct {
+	overlays map[string]fleet.BundleOverlay
+	visited  map[string]bool
+	visiting map[string]bool
+	path     []string
+	order    []string
+}
 
-	for _, target := range targets {
-		if seen.String(target) {
+func (s *sorter) visit(targets []string) error {
+	// visit in reverse so that the reversed post order keeps the listed order
+	for i := len(targets) - 1; i >= 0; i-- {
+		target := targets[i]
+		if s.visiting[target] {
+			return fmt.Errorf("overlay cycle detected: %s", strings.Join(append(s.cycle(target), target), " -> "))
+		}
+		if s.visited[target] {
 			continue
 		}
 
-		result = append(result, target)
-		result = append(result, traverse(overlays, seen, overlays[target].Overlays...)...)
+		s.visiting[target] = true
+		s.path = append(s.path, target)
+		if err := s.visit(s.overlays[target].Overlays); err != nil {
+			return err
+		}
+		s.path = s.path[:len(s.path)-1]
+		s.visiting[target] = false
+
+		s.visited[target] = true
+		s.order = append(s.order, target)
 	}
 
-	return result
+	return nil
+}
+
+func (s *sorter) cycle(target string) []string {
+	for i, name := range s.path {
+		if name == target {
+			return s.path[i:]
+		}
+	}
+	return s.path
 }
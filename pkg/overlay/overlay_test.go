@@ -0,0 +1,132 @@
+package overlay
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+)
+
+func newSpec(overlays ...fleet.BundleOverlay) *fleet.BundleSpec {
+	return &fleet.BundleSpec{Overlays: overlays}
+}
+
+func TestResolveOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     *fleet.BundleSpec
+		overlays []string
+		expected []string
+	}{
+		{
+			name: "listed order",
+			spec: newSpec(
+				fleet.BundleOverlay{Name: "a"},
+				fleet.BundleOverlay{Name: "b"},
+			),
+			overlays: []string{"b", "a"},
+			expected: []string{"b", "a"},
+		},
+		{
+			name: "three level chain",
+			spec: newSpec(
+				fleet.BundleOverlay{Name: "c"},
+				fleet.BundleOverlay{Name: "b", Overlays: []string{"c"}},
+				fleet.BundleOverlay{Name: "a", Overlays: []string{"b"}},
+			),
+			overlays: []string{"a"},
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			name: "shared reference",
+			spec: newSpec(
+				fleet.BundleOverlay{Name: "common"},
+				fleet.BundleOverlay{Name: "a", Overlays: []string{"common"}},
+				fleet.BundleOverlay{Name: "b", Overlays: []string{"common"}},
+			),
+			overlays: []string{"a", "b"},
+			expected: []string{"a", "b", "common"},
+		},
+		{
+			name: "referenced by the target too",
+			spec: newSpec(
+				fleet.BundleOverlay{Name: "c"},
+				fleet.BundleOverlay{Name: "b", Overlays: []string{"c"}},
+				fleet.BundleOverlay{Name: "a", Overlays: []string{"b"}},
+			),
+			overlays: []string{"c", "a"},
+			expected: []string{"a", "b", "c"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// resolving is deterministic
+			for i := 0; i < 10; i++ {
+				_, order, err := Resolve(test.spec, test.overlays...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(order, test.expected) {
+					t.Fatalf("expected %v, got %v", test.expected, order)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveError(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     *fleet.BundleSpec
+		overlays []string
+		message  string
+	}{
+		{
+			name: "cycle",
+			spec: newSpec(
+				fleet.BundleOverlay{Name: "a", Overlays: []string{"b"}},
+				fleet.BundleOverlay{Name: "b", Overlays: []string{"c"}},
+				fleet.BundleOverlay{Name: "c", Overlays: []string{"a"}},
+			),
+			overlays: []string{"a"},
+			message:  "overlay cycle detected: a -> b -> c -> a",
+		},
+		{
+			name: "cycle below the referenced overlay",
+			spec: newSpec(
+				fleet.BundleOverlay{Name: "a", Overlays: []string{"b"}},
+				fleet.BundleOverlay{Name: "b", Overlays: []string{"c"}},
+				fleet.BundleOverlay{Name: "c", Overlays: []string{"b"}},
+			),
+			overlays: []string{"a"},
+			message:  "overlay cycle detected: b -> c -> b",
+		},
+		{
+			name: "self reference",
+			spec: newSpec(
+				fleet.BundleOverlay{Name: "a", Overlays: []string{"a"}},
+			),
+			overlays: []string{"a"},
+			message:  "overlay cycle detected: a -> a",
+		},
+		{
+			name: "missing",
+			spec: newSpec(
+				fleet.BundleOverlay{Name: "a", Overlays: []string{"b"}},
+			),
+			overlays: []string{"a"},
+			message:  "failed to find referenced overlay b",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := Resolve(test.spec, test.overlays...)
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Errorf("expected an error containing %q, got %v", test.message, err)
+			}
+		})
+	}
+}
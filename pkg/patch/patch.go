@@ -22,11 +22,17 @@ func Process(m *manifest.Manifest) (*manifest.Manifest, error) {
 		return nil, err
 	}
 
+	modes := map[string]int32{}
+	for _, resource := range m.Resources {
+		modes[resource.Name] = resource.Mode
+	}
+
 	newManifest := &manifest.Manifest{}
 	for name, content := range newContent {
 		newManifest.Resources = append(newManifest.Resources, fleet.BundleResource{
 			Name:    name,
 			Content: string(content),
+			Mode:    modes[name],
 		})
 	}
 
@@ -18,6 +18,8 @@ func IncrementState(summary *fleet.BundleSummary, name string, state fleet.Bundl
 		summary.Modified++
 	case fleet.Pending:
 		summary.Pending++
+	case fleet.Updating:
+		summary.Updating++
 	case fleet.NotApplied:
 		summary.NotApplied++
 	case fleet.ErrApplied:
@@ -40,10 +42,72 @@ func IncrementState(summary *fleet.BundleSummary, name string, state fleet.Bundl
 	}
 }
 
+// IncrementResources adds the resources the deployment status reports as modified and not ready
+func IncrementResources(summary *fleet.BundleSummary, status *fleet.BundleDeploymentStatus) {
+	summary.ModifiedResources += len(status.ModifiedStatus)
+	summary.NotReadyResources += len(status.NonReadyStatus)
+}
+
+// DecrementState reverses IncrementState for the named resource in the given state
+func DecrementState(summary *fleet.BundleSummary, name string, state fleet.BundleState) {
+	switch state {
+	case fleet.Modified:
+		summary.Modified--
+	case fleet.Pending:
+		summary.Pending--
+	case fleet.Updating:
+		summary.Updating--
+	case fleet.NotApplied:
+		summary.NotApplied--
+	case fleet.ErrApplied:
+		summary.ErrApplied--
+	case fleet.NotReady:
+		summary.NotReady--
+	case fleet.OutOfSync:
+		summary.OutOfSync--
+	case fleet.Ready:
+		summary.Ready--
+	}
+	for i, resource := range summary.NonReadyResources {
+		if resource.Name == name {
+			summary.NonReadyResources = append(summary.NonReadyResources[:i:i], summary.NonReadyResources[i+1:]...)
+			break
+		}
+	}
+}
+
+// UpdateState updates the summary for a single target changing from oldState to newState without
+// recalculating the whole summary. An empty oldState is a new target and an empty newState is a
+// removed target. The list of non ready resources is limited to 10 so it may differ from a full
+// calculation, but all counts are the same.
+func UpdateState(summary *fleet.BundleSummary, name string, oldState, newState fleet.BundleState, message string) {
+	if oldState == "" {
+		summary.DesiredReady++
+	} else {
+		DecrementState(summary, name, oldState)
+	}
+	if newState == "" {
+		summary.DesiredReady--
+	} else {
+		IncrementState(summary, name, newState, message)
+	}
+	SetReadyPercent(summary)
+}
+
 func IsReady(summary fleet.BundleSummary) bool {
 	return summary.DesiredReady == summary.Ready
 }
 
+// SetReadyPercent sets ReadyPercent from the Ready and DesiredReady counts, rounding down so 100 is only
+// reported when everything is ready. When nothing is desired the summary is considered 100 percent ready.
+func SetReadyPercent(summary *fleet.BundleSummary) {
+	if summary.DesiredReady <= 0 {
+		summary.ReadyPercent = 100
+		return
+	}
+	summary.ReadyPercent = summary.Ready * 100 / summary.DesiredReady
+}
+
 func Increment(left *fleet.BundleSummary, right fleet.BundleSummary) {
 	left.NotReady += right.NotReady
 	left.NotApplied += right.NotApplied
@@ -52,7 +116,17 @@ func Increment(left *fleet.BundleSummary, right fleet.BundleSummary) {
 	left.Modified += right.Modified
 	left.Ready += right.Ready
 	left.Pending += right.Pending
+	left.Updating += right.Updating
+	left.Offline += right.Offline
+	left.WaitingToSchedule += right.WaitingToSchedule
 	left.DesiredReady += right.DesiredReady
+	left.ModifiedResources += right.ModifiedResources
+	left.NotReadyResources += right.NotReadyResources
+	SetReadyPercent(left)
+	if right.LastPartitionCompleted != nil &&
+		(left.LastPartitionCompleted == nil || left.LastPartitionCompleted.Before(right.LastPartitionCompleted)) {
+		left.LastPartitionCompleted = right.LastPartitionCompleted
+	}
 	if len(left.NonReadyResources) < 10 {
 		left.NonReadyResources = append(left.NonReadyResources, right.NonReadyResources...)
 	}
@@ -114,6 +188,7 @@ func ReadyMessage(summary fleet.BundleSummary) string {
 		fleet.NotApplied: summary.NotApplied,
 		fleet.ErrApplied: summary.ErrApplied,
 		fleet.Pending:    summary.Pending,
+		fleet.Updating:   summary.Updating,
 		fleet.Modified:   summary.Modified,
 	} {
 		if count <= 0 {
@@ -0,0 +1,147 @@
+package summary
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+)
+
+// newSummary returns the summary of targets in the states, each desired to be ready
+func newSummary(states ...fleet.BundleState) fleet.BundleSummary {
+	var summary fleet.BundleSummary
+	for _, state := range states {
+		IncrementState(&summary, "", state, "")
+		summary.DesiredReady++
+	}
+	SetReadyPercent(&summary)
+	return summary
+}
+
+func TestSetReadyPercent(t *testing.T) {
+	tests := []struct {
+		name     string
+		states   []fleet.BundleState
+		ready    int
+		expected int
+	}{
+		{
+			name:     "nothing desired",
+			expected: 100,
+		},
+		{
+			name:     "all ready",
+			states:   []fleet.BundleState{fleet.Ready, fleet.Ready},
+			ready:    2,
+			expected: 100,
+		},
+		{
+			name:     "none ready",
+			states:   []fleet.BundleState{fleet.NotReady, fleet.ErrApplied},
+			expected: 0,
+		},
+		{
+			name:     "rounded down",
+			states:   []fleet.BundleState{fleet.Ready, fleet.Ready, fleet.Modified},
+			ready:    2,
+			expected: 66,
+		},
+	}
+
+	for _, test := range tests {
+		summary := newSummary(test.states...)
+		if summary.Ready != test.ready {
+			t.Errorf("%s: expected %d ready, got %d", test.name, test.ready, summary.Ready)
+		}
+		if summary.ReadyPercent != test.expected {
+			t.Errorf("%s: expected %d percent ready, got %d", test.name, test.expected, summary.ReadyPercent)
+		}
+	}
+}
+
+func TestSetReadyPercentOnlyAllReadyIs100(t *testing.T) {
+	states := []fleet.BundleState{fleet.Pending}
+	for i := 0; i < 199; i++ {
+		states = append(states, fleet.Ready)
+	}
+	if summary := newSummary(states...); summary.ReadyPercent != 99 {
+		t.Errorf("expected 99 percent ready with one of 200 targets not ready, got %d", summary.ReadyPercent)
+	}
+}
+
+func TestIncrementReadyPercent(t *testing.T) {
+	total := newSummary()
+	Increment(&total, newSummary(fleet.Ready, fleet.Ready, fleet.Ready))
+	Increment(&total, newSummary(fleet.NotReady))
+
+	if total.Ready != 3 || total.DesiredReady != 4 {
+		t.Errorf("expected 3 of 4 ready, got %d of %d", total.Ready, total.DesiredReady)
+	}
+	if total.ReadyPercent != 75 {
+		t.Errorf("expected the percentage to be computed from the combined counts, got %d", total.ReadyPercent)
+	}
+}
+
+func TestIncrementWaitingToSchedule(t *testing.T) {
+	total := fleet.BundleSummary{WaitingToSchedule: 1}
+	Increment(&total, fleet.BundleSummary{WaitingToSchedule: 2})
+
+	if total.WaitingToSchedule != 3 {
+		t.Errorf("expected 3 targets waiting to be scheduled, got %d", total.WaitingToSchedule)
+	}
+}
+
+func TestUpdateStateAgreesWithFullSummary(t *testing.T) {
+	var (
+		states = []fleet.BundleState{
+			fleet.Ready, fleet.NotReady, fleet.NotApplied, fleet.ErrApplied, fleet.OutOfSync,
+			fleet.Pending, fleet.Modified, fleet.Updating, "",
+		}
+		targets     = map[string]fleet.BundleState{}
+		incremental fleet.BundleSummary
+		random      = rand.New(rand.NewSource(1))
+	)
+	SetReadyPercent(&incremental)
+
+	full := func() fleet.BundleSummary {
+		var summary fleet.BundleSummary
+		names := make([]string, 0, len(targets))
+		for name := range targets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			IncrementState(&summary, name, targets[name], "")
+			summary.DesiredReady++
+		}
+		SetReadyPercent(&summary)
+		return summary
+	}
+
+	for i := 0; i < 1000; i++ {
+		name := fmt.Sprintf("cluster-%d", random.Intn(20))
+		newState := states[random.Intn(len(states))]
+		oldState := targets[name]
+		if oldState == "" && newState == "" {
+			continue
+		}
+
+		UpdateState(&incremental, name, oldState, newState, "")
+		if newState == "" {
+			delete(targets, name)
+		} else {
+			targets[name] = newState
+		}
+
+		expected := full()
+		actual := incremental
+		// the non ready resources are limited so only the counts are compared
+		expected.NonReadyResources, actual.NonReadyResources = nil, nil
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("change %d of %s from %q to %q: expected %+v, got %+v", i, name, oldState, newState, expected, actual)
+		}
+	}
+}
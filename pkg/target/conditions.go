@@ -0,0 +1,31 @@
+package target
+
+import (
+	"fmt"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+)
+
+// ClusterConditionsSatisfied returns true if the cluster has every condition the target requires with the
+// required status, otherwise a message naming the first condition that is not satisfied
+func ClusterConditionsSatisfied(target *fleet.BundleTarget, cluster *fleet.Cluster) (bool, string) {
+	for _, required := range target.ClusterConditions {
+		status := required.Status
+		if status == "" {
+			status = "True"
+		}
+
+		actual := ""
+		for _, cond := range cluster.Status.Conditions {
+			if cond.Type == required.Type {
+				actual = string(cond.Status)
+				break
+			}
+		}
+
+		if actual != status {
+			return false, fmt.Sprintf("waiting for cluster condition %s to be %s", required.Type, status)
+		}
+	}
+	return true, ""
+}
@@ -0,0 +1,123 @@
+package target
+
+import (
+	"context"
+	"testing"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/wrangler/pkg/genericcondition"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// conditionCluster returns a cluster with the status conditions, given as type and status pairs
+func conditionCluster(name string, conditions ...string) *fleet.Cluster {
+	cluster := newCluster(name, map[string]string{"env": "prod"})
+	for i := 0; i+1 < len(conditions); i += 2 {
+		cluster.Status.Conditions = append(cluster.Status.Conditions, genericcondition.GenericCondition{
+			Type:   conditions[i],
+			Status: corev1.ConditionStatus(conditions[i+1]),
+		})
+	}
+	return cluster
+}
+
+func TestClusterConditionsSatisfied(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions []fleet.ClusterCondition
+		cluster    *fleet.Cluster
+		satisfied  bool
+		message    string
+	}{
+		{
+			name:      "no conditions",
+			cluster:   conditionCluster("cluster"),
+			satisfied: true,
+		},
+		{
+			name:       "ready",
+			conditions: []fleet.ClusterCondition{{Type: "Ready"}},
+			cluster:    conditionCluster("cluster", "Ready", "True"),
+			satisfied:  true,
+		},
+		{
+			name:       "not ready",
+			conditions: []fleet.ClusterCondition{{Type: "Ready"}},
+			cluster:    conditionCluster("cluster", "Ready", "False"),
+			message:    "waiting for cluster condition Ready to be True",
+		},
+		{
+			name:       "missing condition",
+			conditions: []fleet.ClusterCondition{{Type: "Ready"}},
+			cluster:    conditionCluster("cluster", "Processed", "True"),
+			message:    "waiting for cluster condition Ready to be True",
+		},
+		{
+			name:       "required status",
+			conditions: []fleet.ClusterCondition{{Type: "Ready"}, {Type: "Upgrading", Status: "False"}},
+			cluster:    conditionCluster("cluster", "Ready", "True", "Upgrading", "False"),
+			satisfied:  true,
+		},
+		{
+			name:       "first condition not satisfied",
+			conditions: []fleet.ClusterCondition{{Type: "Ready"}, {Type: "Upgrading", Status: "False"}},
+			cluster:    conditionCluster("cluster", "Ready", "True", "Upgrading", "True"),
+			message:    "waiting for cluster condition Upgrading to be False",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			satisfied, message := ClusterConditionsSatisfied(&fleet.BundleTarget{ClusterConditions: test.conditions}, test.cluster)
+			if satisfied != test.satisfied {
+				t.Errorf("expected satisfied %v, got %v", test.satisfied, satisfied)
+			}
+			if message != test.message {
+				t.Errorf("expected message %q, got %q", test.message, message)
+			}
+		})
+	}
+}
+
+func TestTargetsClusterConditions(t *testing.T) {
+	m := newTestManager(
+		conditionCluster("ready", "Ready", "True"),
+		conditionCluster("not-ready", "Ready", "False"),
+		conditionCluster("unknown"),
+	)
+	bundle := &fleet.Bundle{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "fleet-default"},
+		Spec: fleet.BundleSpec{
+			Targets: []fleet.BundleTarget{
+				{
+					Name:              "prod",
+					ClusterSelector:   &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+					ClusterConditions: []fleet.ClusterCondition{{Type: "Ready"}},
+				},
+			},
+		},
+	}
+
+	targets, err := m.Targets(context.Background(), bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 3 {
+		t.Fatalf("expected a target for each cluster, got %d", len(targets))
+	}
+
+	for _, target := range targets {
+		ready := target.Cluster.Name == "ready"
+		if target.DependenciesSatisfied != ready {
+			t.Errorf("%s: expected satisfied %v, got %v", target.Cluster.Name, ready, target.DependenciesSatisfied)
+		}
+		message := ""
+		if !ready {
+			message = "waiting for cluster condition Ready to be True"
+		}
+		if m := target.Message(); m != message {
+			t.Errorf("%s: expected message %q, got %q", target.Cluster.Name, message, m)
+		}
+	}
+}
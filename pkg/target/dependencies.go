@@ -0,0 +1,102 @@
+package target
+
+import (
+	"fmt"
+	"strings"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// DependenciesSatisfied returns true if all the bundles the bundle depends on are ready on the cluster.
+// If not satisfied the returned message describes why.
+func (m *Manager) DependenciesSatisfied(bundle *fleet.Bundle, cluster *fleet.Cluster) (bool, string, error) {
+	cycle, err := m.dependencyCycle(bundle)
+	if err != nil {
+		return false, "", err
+	}
+	return m.dependenciesSatisfied(bundle, cycle, cluster)
+}
+
+// dependenciesSatisfied is DependenciesSatisfied with the dependency cycle of the bundle already found, so
+// it is only looked for once for all clusters
+func (m *Manager) dependenciesSatisfied(bundle *fleet.Bundle, cycle []string, cluster *fleet.Cluster) (bool, string, error) {
+	if len(bundle.Spec.DependsOn) == 0 {
+		return true, "", nil
+	}
+
+	if len(cycle) > 0 {
+		return false, "dependency cycle detected: " + strings.Join(cycle, " -> "), nil
+	}
+
+	for _, name := range bundle.Spec.DependsOn {
+		if _, err := m.bundleCache.Get(bundle.Namespace, name); apierrors.IsNotFound(err) {
+			return false, fmt.Sprintf("dependency bundle %s/%s not found", bundle.Namespace, name), nil
+		} else if err != nil {
+			return false, "", err
+		}
+
+		bd, err := m.bundleDeploymentCache.Get(cluster.Status.Namespace, name)
+		if apierrors.IsNotFound(err) {
+			return false, fmt.Sprintf("waiting for dependency bundle %s to be deployed", name), nil
+		} else if err != nil {
+			return false, "", err
+		}
+
+		if bd.Spec.DeploymentID == "" || IsUnavailable(bd) {
+			return false, fmt.Sprintf("waiting for dependency bundle %s to be ready", name), nil
+		}
+	}
+
+	return true, "", nil
+}
+
+// BundlesDependingOn returns the bundles in the namespace that list the named bundle in DependsOn
+func (m *Manager) BundlesDependingOn(namespace, name string) (result []*fleet.Bundle, _ error) {
+	bundles, err := m.bundleCache.List(namespace, labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bundle := range bundles {
+		for _, dep := range bundle.Spec.DependsOn {
+			if dep == name {
+				result = append(result, bundle)
+				break
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// dependencyCycle returns the path of bundle names forming a cycle through the bundle, or nil if there is none
+func (m *Manager) dependencyCycle(bundle *fleet.Bundle) ([]string, error) {
+	return m.findCycle(bundle.Namespace, bundle.Name, bundle.Spec.DependsOn, []string{bundle.Name}, map[string]bool{})
+}
+
+func (m *Manager) findCycle(namespace, start string, deps, path []string, visited map[string]bool) ([]string, error) {
+	for _, dep := range deps {
+		if dep == start {
+			return append(path, dep), nil
+		}
+		if visited[dep] {
+			continue
+		}
+		visited[dep] = true
+
+		bundle, err := m.bundleCache.Get(namespace, dep)
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if cycle, err := m.findCycle(namespace, start, bundle.Spec.DependsOn, append(path, dep), visited); err != nil || cycle != nil {
+			return cycle, err
+		}
+	}
+
+	return nil, nil
+}
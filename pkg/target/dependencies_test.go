@@ -0,0 +1,45 @@
+package target
+
+import (
+	"context"
+	"testing"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTargetsDependencyCycleOncePerBundle(t *testing.T) {
+	m := newTestManager(regionClusters(10)...)
+
+	bundle := prodBundle(true)
+	bundle.Spec.DependsOn = []string{"b"}
+	bundles := &fakeBundleCache{
+		bundles: []*fleet.Bundle{
+			bundle,
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: bundle.Namespace},
+				Spec:       fleet.BundleSpec{DependsOn: []string{bundle.Name}},
+			},
+		},
+	}
+	m.bundleCache = bundles
+
+	targets, err := m.Targets(context.Background(), bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 10 {
+		t.Fatalf("expected a target for each cluster, got %v", targetNames(targets))
+	}
+
+	expected := "dependency cycle detected: " + bundle.Name + " -> b -> " + bundle.Name
+	for _, target := range targets {
+		if target.DependenciesSatisfied || target.DependencyMessage != expected {
+			t.Errorf("%s: expected message %q, got %v %q", target.Cluster.Name, expected,
+				target.DependenciesSatisfied, target.DependencyMessage)
+		}
+	}
+	if bundles.gets != 1 {
+		t.Errorf("expected the cycle to be looked for once for all clusters, got %d bundle lookups", bundles.gets)
+	}
+}
@@ -0,0 +1,67 @@
+package target
+
+// GroupBudget tracks unavailable targets per cluster group so each group is limited by the
+// MaxUnavailablePerGroup of the rollout strategy independently of the other groups.
+type GroupBudget struct {
+	max         map[string]int
+	unavailable map[string]int
+}
+
+// NewGroupBudget returns the budget of each cluster group of the targets, or nil if the rollout strategy
+// does not set MaxUnavailablePerGroup. A nil budget allows every target.
+func NewGroupBudget(targets []*Target) (*GroupBudget, error) {
+	rollout := getRollout(targets)
+	if rollout.MaxUnavailablePerGroup == nil {
+		return nil, nil
+	}
+
+	var (
+		counts = map[string]int{}
+		budget = &GroupBudget{
+			max:         map[string]int{},
+			unavailable: map[string]int{},
+		}
+	)
+
+	for _, target := range targets {
+		for _, cg := range target.ClusterGroups {
+			counts[cg.Name]++
+			if target.Deployment != nil && !target.SkipOffline() && IsUnavailable(target.Deployment) {
+				budget.unavailable[cg.Name]++
+			}
+		}
+	}
+
+	for name, count := range counts {
+		max, err := Limit(count, rollout.MaxUnavailablePerGroup)
+		if err != nil {
+			return nil, err
+		}
+		budget.max[name] = max
+	}
+
+	return budget, nil
+}
+
+// Allows returns true if none of the cluster groups of the target have reached their max unavailable
+func (g *GroupBudget) Allows(t *Target) bool {
+	if g == nil {
+		return true
+	}
+	for _, cg := range t.ClusterGroups {
+		if g.unavailable[cg.Name] >= g.max[cg.Name] {
+			return false
+		}
+	}
+	return true
+}
+
+// Consume counts the target as unavailable in each of its cluster groups
+func (g *GroupBudget) Consume(t *Target) {
+	if g == nil {
+		return
+	}
+	for _, cg := range t.ClusterGroups {
+		g.unavailable[cg.Name]++
+	}
+}
@@ -6,20 +6,149 @@ import (
 	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
 	"github.com/rancher/fleet/pkg/match"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 type Partition struct {
 	Status  fleet.PartitionStatus
 	Targets []*Target
+	// Sequential is set if the partition must be fully available before the next one is rolled out
+	Sequential bool
 }
 
 func Partitions(targets []*Target) ([]Partition, error) {
 	rollout := getRollout(targets)
-	if len(rollout.Partitions) == 0 {
-		return autoPartition(rollout, targets)
+	partitions, err := createPartitions(rollout, targets)
+	if err != nil {
+		return nil, err
+	}
+	return orderPartitions(partitions, rollout.PartitionOrder), nil
+}
+
+func createPartitions(rollout *fleet.RolloutStrategy, targets []*Target) ([]Partition, error) {
+	if len(rollout.Partitions) > 0 {
+		return manualPartition(rollout, targets)
+	}
+	if rollout.PartitionByLabel != nil && rollout.PartitionByLabel.Key != "" {
+		return labelPartition(rollout, targets)
+	}
+	if len(rollout.Steps) > 0 {
+		return stepPartition(rollout, targets)
+	}
+
+	return autoPartition(rollout, targets)
+}
+
+// orderPartitions moves the partitions named in order to the front in that order, followed by the
+// partitions not listed in their original order. Names that match no partition are ignored.
+func orderPartitions(partitions []Partition, order []string) []Partition {
+	if len(order) == 0 {
+		return partitions
+	}
+
+	var (
+		result []Partition
+		listed = sets.NewString(order...)
+	)
+	for _, name := range order {
+		for _, partition := range partitions {
+			if partition.Status.Name == name {
+				result = append(result, partition)
+			}
+		}
+	}
+	for _, partition := range partitions {
+		if !listed.Has(partition.Status.Name) {
+			result = append(result, partition)
+		}
+	}
+	return result
+}
+
+// labelPartition creates a partition for each value of the PartitionByLabel key on the targeted clusters
+func labelPartition(rollout *fleet.RolloutStrategy, targets []*Target) ([]Partition, error) {
+	var (
+		key        = rollout.PartitionByLabel.Key
+		byValue    = map[string][]*Target{}
+		unlabeled  []*Target
+		partitions []Partition
+		err        error
+	)
+
+	for _, target := range targets {
+		value, ok := target.Cluster.Labels[key]
+		if !ok {
+			unlabeled = append(unlabeled, target)
+			continue
+		}
+		byValue[value] = append(byValue[value], target)
+	}
+
+	ordered := sets.NewString(rollout.PartitionByLabel.Order...)
+	values := append([]string{}, rollout.PartitionByLabel.Order...)
+	for _, value := range sets.StringKeySet(byValue).List() {
+		if !ordered.Has(value) {
+			values = append(values, value)
+		}
+	}
+
+	for _, value := range values {
+		partitionTargets, ok := byValue[value]
+		if !ok {
+			continue
+		}
+		delete(byValue, value)
+		partitions, err = appendPartition(partitions, key+"="+value, partitionTargets, rollout.MaxUnavailable)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(unlabeled) > 0 {
+		return appendPartition(partitions, "Unlabeled", unlabeled, rollout.MaxUnavailable)
 	}
 
-	return manualPartition(rollout, targets)
+	return partitions, nil
+}
+
+// stepPartition creates a partition for each step of the rollout. Each step is the cumulative number
+// of targets rolled out once the step is complete, and the next step waits until the targets of the
+// step are available. Any targets not covered by the last step are put in a final partition.
+func stepPartition(rollout *fleet.RolloutStrategy, targets []*Target) ([]Partition, error) {
+	var (
+		partitions []Partition
+		offset     = 0
+	)
+
+	for i := range rollout.Steps {
+		if offset >= len(targets) {
+			break
+		}
+
+		end, err := Limit(len(targets), &rollout.Steps[i])
+		if err != nil {
+			return nil, err
+		}
+		if end > len(targets) {
+			end = len(targets)
+		}
+		if end <= offset {
+			continue
+		}
+
+		partitions, err = appendPartition(partitions, fmt.Sprintf("Step %d", i+1), targets[offset:end], rollout.MaxUnavailable)
+		if err != nil {
+			return nil, err
+		}
+		partitions[len(partitions)-1].Sequential = true
+		offset = end
+	}
+
+	if offset < len(targets) {
+		return appendPartition(partitions, fmt.Sprintf("Step %d", len(rollout.Steps)+1), targets[offset:], rollout.MaxUnavailable)
+	}
+
+	return partitions, nil
 }
 
 func manualPartition(rollout *fleet.RolloutStrategy, targets []*Target) ([]Partition, error) {
@@ -77,7 +206,13 @@ func autoPartition(rollout *fleet.RolloutStrategy, targets []*Target) ([]Partiti
 		return appendPartition(nil, "All", targets, rollout.MaxUnavailable)
 	}
 
-	maxSize, err := Limit(len(targets), rollout.AutoPartitionSize, &defAutoPartitionSize)
+	// small fleets are not partitioned
+	if len(targets) <= rollout.AutoPartitionThreshold {
+		return appendPartition(nil, "All", targets, rollout.MaxUnavailable)
+	}
+
+	maxSize, err := LimitWithBounds(len(targets), Bounds{Max: rollout.MaxAutoPartitionSize},
+		rollout.AutoPartitionSize, &defAutoPartitionSize)
 	if err != nil {
 		return nil, err
 	}
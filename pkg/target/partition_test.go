@@ -0,0 +1,398 @@
+package target
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// rolloutTargets returns a target of a bundle with the rollout strategy for each of the clusters
+func rolloutTargets(rollout *fleet.RolloutStrategy, clusters ...*fleet.Cluster) []*Target {
+	bundle := &fleet.Bundle{
+		Spec: fleet.BundleSpec{
+			RolloutStrategy: rollout,
+		},
+	}
+
+	var targets []*Target
+	for _, cluster := range clusters {
+		targets = append(targets, &Target{
+			Bundle:  bundle,
+			Cluster: cluster,
+		})
+	}
+	return targets
+}
+
+func numberedClusters(count int) (clusters []*fleet.Cluster) {
+	for i := 0; i < count; i++ {
+		clusters = append(clusters, newCluster(fmt.Sprintf("cluster-%d", i), nil))
+	}
+	return clusters
+}
+
+// partitionCounts returns the name and count of the partitions
+func partitionCounts(partitions []Partition) (result []string) {
+	for _, partition := range partitions {
+		result = append(result, fmt.Sprintf("%s: %d", partition.Status.Name, partition.Status.Count))
+	}
+	return result
+}
+
+func TestStepPartitions(t *testing.T) {
+	steps := func(values ...intstr.IntOrString) *fleet.RolloutStrategy {
+		return &fleet.RolloutStrategy{Steps: values}
+	}
+
+	tests := []struct {
+		name     string
+		rollout  *fleet.RolloutStrategy
+		clusters int
+		expected []string
+	}{
+		{
+			name:     "canary, percentage and final step",
+			rollout:  steps(intstr.FromInt(1), intstr.FromString("25%"), intstr.FromString("100%")),
+			clusters: 8,
+			expected: []string{"Step 1: 1", "Step 2: 1", "Step 3: 6"},
+		},
+		{
+			name:     "mixed steps",
+			rollout:  steps(intstr.FromString("10%"), intstr.FromInt(5), intstr.FromString("50%")),
+			clusters: 20,
+			expected: []string{"Step 1: 2", "Step 2: 3", "Step 3: 5", "Step 4: 10"},
+		},
+		{
+			name:     "remaining targets without a final step",
+			rollout:  steps(intstr.FromInt(1), intstr.FromInt(3)),
+			clusters: 10,
+			expected: []string{"Step 1: 1", "Step 2: 2", "Step 3: 7"},
+		},
+		{
+			name:     "steps covering no new targets are skipped",
+			rollout:  steps(intstr.FromInt(2), intstr.FromString("10%"), intstr.FromString("100%")),
+			clusters: 10,
+			expected: []string{"Step 1: 2", "Step 3: 8"},
+		},
+		{
+			name:     "steps beyond the targets",
+			rollout:  steps(intstr.FromInt(1), intstr.FromInt(10), intstr.FromString("100%")),
+			clusters: 3,
+			expected: []string{"Step 1: 1", "Step 2: 2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			targets := rolloutTargets(test.rollout, numberedClusters(test.clusters)...)
+			partitions, err := Partitions(targets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := partitionCounts(partitions); !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected partitions %v, got %v", test.expected, actual)
+			}
+
+			// the steps partition the targets in order
+			var partitioned []*Target
+			for _, partition := range partitions {
+				partitioned = append(partitioned, partition.Targets...)
+			}
+			if !equalNames(targetNames(partitioned), targetNames(targets)) {
+				t.Errorf("expected all targets in order, got %v", targetNames(partitioned))
+			}
+		})
+	}
+}
+
+func TestInvalidStep(t *testing.T) {
+	targets := rolloutTargets(&fleet.RolloutStrategy{
+		Steps: []intstr.IntOrString{intstr.FromString("half")},
+	}, numberedClusters(2)...)
+	if _, err := Partitions(targets); err == nil {
+		t.Error("expected an invalid step to fail")
+	}
+}
+
+func TestLabelPartitions(t *testing.T) {
+	region := func(name, value string) *fleet.Cluster {
+		if value == "" {
+			return newCluster(name, nil)
+		}
+		return newCluster(name, map[string]string{"region": value})
+	}
+	clusters := []*fleet.Cluster{
+		region("us-1", "us"),
+		region("eu-1", "eu"),
+		region("ap-1", "ap"),
+		region("local", ""),
+		region("us-2", "us"),
+		region("sa-1", "sa"),
+		region("eu-2", "eu"),
+	}
+
+	tests := []struct {
+		name     string
+		order    []string
+		expected []string
+		members  map[string][]string
+	}{
+		{
+			name:     "alphabetical",
+			expected: []string{"region=ap: 1", "region=eu: 2", "region=sa: 1", "region=us: 2", "Unlabeled: 1"},
+		},
+		{
+			name:     "ordered values first",
+			order:    []string{"us", "eu"},
+			expected: []string{"region=us: 2", "region=eu: 2", "region=ap: 1", "region=sa: 1", "Unlabeled: 1"},
+			members: map[string][]string{
+				"region=us": {"us-1", "us-2"},
+				"region=eu": {"eu-1", "eu-2"},
+				"Unlabeled": {"local"},
+			},
+		},
+		{
+			name:     "ordered values without clusters",
+			order:    []string{"af", "sa"},
+			expected: []string{"region=sa: 1", "region=ap: 1", "region=eu: 2", "region=us: 2", "Unlabeled: 1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			targets := rolloutTargets(&fleet.RolloutStrategy{
+				PartitionByLabel: &fleet.LabelPartitioning{
+					Key:   "region",
+					Order: test.order,
+				},
+			}, clusters...)
+
+			partitions, err := Partitions(targets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := partitionCounts(partitions); !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected partitions %v, got %v", test.expected, actual)
+			}
+			for _, partition := range partitions {
+				if members, ok := test.members[partition.Status.Name]; ok && !equalNames(targetNames(partition.Targets), members) {
+					t.Errorf("expected partition %s to contain %v, got %v", partition.Status.Name, members, targetNames(partition.Targets))
+				}
+			}
+		})
+	}
+}
+
+func TestPartitionsPreferManualOverLabel(t *testing.T) {
+	targets := rolloutTargets(&fleet.RolloutStrategy{
+		Partitions: []fleet.Partition{
+			{
+				Name:            "eu",
+				ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"region": "eu"}},
+			},
+		},
+		PartitionByLabel: &fleet.LabelPartitioning{Key: "region"},
+	}, newCluster("eu-1", map[string]string{"region": "eu"}))
+	for _, target := range targets {
+		target.ClusterGroups = []*fleet.ClusterGroup{{}}
+	}
+
+	partitions, err := Partitions(targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := partitionCounts(partitions); !reflect.DeepEqual(actual, []string{"eu: 1"}) {
+		t.Errorf("expected only the manual partition, got %v", actual)
+	}
+}
+
+func TestAutoPartitionSize(t *testing.T) {
+	size := func(value intstr.IntOrString) *intstr.IntOrString {
+		return &value
+	}
+
+	tests := []struct {
+		name       string
+		rollout    *fleet.RolloutStrategy
+		partitions int
+		last       int
+	}{
+		{
+			name:       "default",
+			rollout:    &fleet.RolloutStrategy{},
+			partitions: 4,
+			last:       25,
+		},
+		{
+			name:       "percentage",
+			rollout:    &fleet.RolloutStrategy{AutoPartitionSize: size(intstr.FromString("10%"))},
+			partitions: 10,
+			last:       10,
+		},
+		{
+			name:       "count",
+			rollout:    &fleet.RolloutStrategy{AutoPartitionSize: size(intstr.FromInt(30))},
+			partitions: 4,
+			last:       10,
+		},
+		{
+			name:       "disabled",
+			rollout:    &fleet.RolloutStrategy{AutoPartitionSize: size(intstr.FromInt(0))},
+			partitions: 1,
+			last:       100,
+		},
+		{
+			name: "max size",
+			rollout: &fleet.RolloutStrategy{
+				AutoPartitionSize:    size(intstr.FromString("50%")),
+				MaxAutoPartitionSize: 20,
+			},
+			partitions: 5,
+			last:       20,
+		},
+		{
+			name:       "under the threshold",
+			rollout:    &fleet.RolloutStrategy{AutoPartitionThreshold: 100},
+			partitions: 1,
+			last:       100,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			partitions, err := Partitions(rolloutTargets(test.rollout, numberedClusters(100)...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(partitions) != test.partitions {
+				t.Fatalf("expected %d partitions, got %v", test.partitions, partitionCounts(partitions))
+			}
+			if last := partitions[len(partitions)-1].Status.Count; last != test.last {
+				t.Errorf("expected %d targets in the last partition, got %v", test.last, partitionCounts(partitions))
+			}
+		})
+	}
+}
+
+func TestInvalidAutoPartitionSize(t *testing.T) {
+	invalid := intstr.FromString("half")
+	targets := rolloutTargets(&fleet.RolloutStrategy{AutoPartitionSize: &invalid}, numberedClusters(2)...)
+	if _, err := Partitions(targets); err == nil {
+		t.Error("expected an invalid autoPartitionSize to fail")
+	}
+}
+
+func TestPartitionOrder(t *testing.T) {
+	env := func(value string) *metav1.LabelSelector {
+		return &metav1.LabelSelector{MatchLabels: map[string]string{"env": value}}
+	}
+	clusters := []*fleet.Cluster{
+		newCluster("prod-1", map[string]string{"env": "prod", "region": "eu"}),
+		newCluster("staging-1", map[string]string{"env": "staging", "region": "us"}),
+		newCluster("canary-1", map[string]string{"env": "canary", "region": "eu"}),
+	}
+	manual := []fleet.Partition{
+		{Name: "prod", ClusterSelector: env("prod")},
+		{Name: "staging", ClusterSelector: env("staging")},
+		{Name: "canary", ClusterSelector: env("canary")},
+	}
+
+	tests := []struct {
+		name     string
+		rollout  fleet.RolloutStrategy
+		expected []string
+	}{
+		{
+			name:     "natural order",
+			rollout:  fleet.RolloutStrategy{Partitions: manual},
+			expected: []string{"prod", "staging", "canary"},
+		},
+		{
+			name:     "listed partition first",
+			rollout:  fleet.RolloutStrategy{Partitions: manual, PartitionOrder: []string{"staging"}},
+			expected: []string{"staging", "prod", "canary"},
+		},
+		{
+			name:     "listed partitions in order",
+			rollout:  fleet.RolloutStrategy{Partitions: manual, PartitionOrder: []string{"canary", "staging"}},
+			expected: []string{"canary", "staging", "prod"},
+		},
+		{
+			name:     "label partitions",
+			rollout:  fleet.RolloutStrategy{PartitionByLabel: &fleet.LabelPartitioning{Key: "region"}, PartitionOrder: []string{"region=us"}},
+			expected: []string{"region=us", "region=eu"},
+		},
+		{
+			name:     "names without a partition",
+			rollout:  fleet.RolloutStrategy{PartitionByLabel: &fleet.LabelPartitioning{Key: "region"}, PartitionOrder: []string{"region=ap", "region=us"}},
+			expected: []string{"region=us", "region=eu"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rollout := test.rollout
+			targets := rolloutTargets(&rollout, clusters...)
+			for _, target := range targets {
+				target.ClusterGroups = []*fleet.ClusterGroup{{}}
+			}
+
+			partitions, err := Partitions(targets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, partition := range partitions {
+				names = append(names, partition.Status.Name)
+			}
+			if !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("expected partitions %v, got %v", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestAutoPartitionFleetSizes(t *testing.T) {
+	rollout := &fleet.RolloutStrategy{
+		AutoPartitionThreshold: 10,
+		MaxAutoPartitionSize:   50,
+	}
+
+	tests := []struct {
+		clusters   int
+		partitions int
+		largest    int
+	}{
+		{clusters: 1, partitions: 1, largest: 1},
+		{clusters: 10, partitions: 1, largest: 10},
+		{clusters: 11, partitions: 6, largest: 2},
+		{clusters: 100, partitions: 4, largest: 25},
+		{clusters: 1000, partitions: 20, largest: 50},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.clusters), func(t *testing.T) {
+			partitions, err := Partitions(rolloutTargets(rollout, numberedClusters(test.clusters)...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(partitions) != test.partitions {
+				t.Fatalf("expected %d partitions, got %v", test.partitions, partitionCounts(partitions))
+			}
+			total := 0
+			for _, partition := range partitions {
+				if partition.Status.Count > test.largest {
+					t.Errorf("expected at most %d targets in each partition, got %v", test.largest, partitionCounts(partitions))
+				}
+				total += partition.Status.Count
+			}
+			if total != test.clusters {
+				t.Errorf("expected %d targets in all partitions, got %v", test.clusters, partitionCounts(partitions))
+			}
+		})
+	}
+}
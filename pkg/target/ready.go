@@ -0,0 +1,43 @@
+package target
+
+import (
+	"time"
+)
+
+// ReadyDeadline returns the time by which the deployment of the target must be available, or false if
+// the target has no ReadyTimeoutSeconds or its deployment is available. Like StuckTargets the timeout is
+// measured from the most recent transition of any condition of the deployment, or from when it was
+// created if none have transitioned.
+func (t *Target) ReadyDeadline() (time.Time, bool) {
+	if t.Target == nil || t.Target.ReadyTimeoutSeconds <= 0 || t.Deployment == nil || !IsUnavailable(t.Deployment) {
+		return time.Time{}, false
+	}
+	since, ok := lastTransition(t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return since.Add(time.Duration(t.Target.ReadyTimeoutSeconds) * time.Second), true
+}
+
+// ReadyTimedOut returns true if the deployment of the target has not become available by its ready
+// deadline. Such targets are reported as failed rather than waiting to become ready.
+func (t *Target) ReadyTimedOut(now time.Time) bool {
+	deadline, ok := t.ReadyDeadline()
+	return ok && !now.Before(deadline)
+}
+
+// NextReadyDeadline returns the earliest ready deadline of the targets that has not passed yet, so the
+// bundle can be requeued to report the target as failed once it does
+func NextReadyDeadline(targets []*Target, now time.Time) (time.Time, bool) {
+	var next time.Time
+	for _, target := range targets {
+		deadline, ok := target.ReadyDeadline()
+		if !ok || !now.Before(deadline) {
+			continue
+		}
+		if next.IsZero() || deadline.Before(next) {
+			next = deadline
+		}
+	}
+	return next, !next.IsZero()
+}
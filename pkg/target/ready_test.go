@@ -0,0 +1,126 @@
+package target
+
+import (
+	"testing"
+	"time"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/wrangler/pkg/genericcondition"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// readyTimeoutTarget returns a target with a ready timeout of a minute whose deployment is applied but
+// not ready since since
+func readyTimeoutTarget(name string, since time.Time) *Target {
+	return &Target{
+		Bundle:       &fleet.Bundle{},
+		Cluster:      newCluster(name, nil),
+		Target:       &fleet.BundleTarget{Name: "prod", ReadyTimeoutSeconds: 60},
+		DeploymentID: "v2",
+		Deployment: &fleet.BundleDeployment{
+			ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(since.Add(-time.Hour))},
+			Spec:       fleet.BundleDeploymentSpec{StagedDeploymentID: "v2", DeploymentID: "v2"},
+			Status: fleet.BundleDeploymentStatus{
+				AppliedDeploymentID: "v2",
+				NonModified:         true,
+				Conditions: []genericcondition.GenericCondition{
+					{Type: "Ready", Status: corev1.ConditionFalse, LastTransitionTime: since.Format(time.RFC3339)},
+				},
+			},
+		},
+	}
+}
+
+func TestReadyTimeout(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		target   *Target
+		timedOut bool
+	}{
+		{
+			name:   "just under the timeout",
+			target: readyTimeoutTarget("under", now.Add(-50*time.Second)),
+		},
+		{
+			name:     "just over the timeout",
+			target:   readyTimeoutTarget("over", now.Add(-70*time.Second)),
+			timedOut: true,
+		},
+		{
+			name: "ready",
+			target: func() *Target {
+				target := readyTimeoutTarget("ready", now.Add(-time.Hour))
+				target.Deployment.Status.Ready = true
+				return target
+			}(),
+		},
+		{
+			name: "no timeout",
+			target: func() *Target {
+				target := readyTimeoutTarget("none", now.Add(-time.Hour))
+				target.Target.ReadyTimeoutSeconds = 0
+				return target
+			}(),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if timedOut := test.target.ReadyTimedOut(now); timedOut != test.timedOut {
+				t.Errorf("expected timed out %v, got %v", test.timedOut, timedOut)
+			}
+
+			state := test.target.State()
+			if test.timedOut && state != fleet.ErrApplied {
+				t.Errorf("expected a target over the timeout to have failed, got %s", state)
+			} else if !test.timedOut && state == fleet.ErrApplied {
+				t.Errorf("expected a target under the timeout not to have failed, got %s", state)
+			}
+
+			message := test.target.Message()
+			if expected := "not ready within the ready timeout of 60s"; test.timedOut && message != expected {
+				t.Errorf("expected message %q, got %q", expected, message)
+			} else if !test.timedOut && message == expected {
+				t.Errorf("expected no timeout in the message, got %q", message)
+			}
+		})
+	}
+}
+
+func TestReadyTimeoutSummary(t *testing.T) {
+	now := time.Now()
+	summary := Summary([]*Target{
+		readyTimeoutTarget("under", now.Add(-50*time.Second)),
+		readyTimeoutTarget("over", now.Add(-70*time.Second)),
+	})
+
+	if summary.ErrApplied != 1 || summary.NotReady != 1 {
+		t.Errorf("expected one failed and one not ready target, got %+v", summary)
+	}
+	for _, resource := range summary.NonReadyResources {
+		if expected := resource.Name == "fleet-default/over"; (resource.State == fleet.ErrApplied) != expected {
+			t.Errorf("expected only the target over the timeout to be reported as failed, got %+v", summary.NonReadyResources)
+		}
+	}
+}
+
+func TestNextReadyDeadline(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	targets := []*Target{
+		readyTimeoutTarget("over", now.Add(-70*time.Second)),
+		readyTimeoutTarget("later", now.Add(-10*time.Second)),
+		readyTimeoutTarget("sooner", now.Add(-50*time.Second)),
+	}
+
+	deadline, ok := NextReadyDeadline(targets, now)
+	if expected := now.Add(10 * time.Second); !ok || !deadline.Equal(expected) {
+		t.Errorf("expected the next deadline %v, got %v %v", expected, deadline, ok)
+	}
+
+	if _, ok := NextReadyDeadline(targets[:1], now); ok {
+		t.Error("expected no deadline once all have passed")
+	}
+}
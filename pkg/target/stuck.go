@@ -0,0 +1,39 @@
+package target
+
+import (
+	"time"
+)
+
+// StuckTargets returns the targets that have not been up to date and available for longer than the threshold,
+// measured from the most recent transition of any condition of the bundle deployment. A deployment without
+// transition times is measured from when it was created. Targets without a deployment or that are paused are
+// waiting on purpose and are never stuck.
+func (m *Manager) StuckTargets(targets []*Target, threshold time.Duration) (result []*Target) {
+	now := time.Now()
+	for _, target := range targets {
+		if target.Deployment == nil || target.IsPaused() {
+			continue
+		}
+		if UpToDate(target) && !IsUnavailable(target.Deployment) {
+			continue
+		}
+		if since, ok := lastTransition(target); ok && now.Sub(since) > threshold {
+			result = append(result, target)
+		}
+	}
+	return result
+}
+
+func lastTransition(target *Target) (time.Time, bool) {
+	var last time.Time
+	for _, cond := range target.Deployment.Status.Conditions {
+		t, err := time.Parse(time.RFC3339, cond.LastTransitionTime)
+		if err == nil && t.After(last) {
+			last = t
+		}
+	}
+	if last.IsZero() {
+		last = target.Deployment.CreationTimestamp.Time
+	}
+	return last, !last.IsZero()
+}
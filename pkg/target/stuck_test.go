@@ -0,0 +1,75 @@
+package target
+
+import (
+	"testing"
+	"time"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/wrangler/pkg/genericcondition"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStuckTargets(t *testing.T) {
+	var (
+		now    = time.Now()
+		old    = now.Add(-time.Hour)
+		recent = now.Add(-time.Minute)
+	)
+	transitioned := func(at time.Time) []genericcondition.GenericCondition {
+		return []genericcondition.GenericCondition{
+			{Type: "Ready", Status: corev1.ConditionFalse, LastTransitionTime: at.Format(time.RFC3339)},
+			{Type: "Deployed", Status: corev1.ConditionTrue, LastTransitionTime: old.Add(-time.Hour).Format(time.RFC3339)},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		applied    string
+		ready      bool
+		created    time.Time
+		conditions []genericcondition.GenericCondition
+		paused     bool
+		noDeploy   bool
+		stuck      bool
+	}{
+		{name: "old transition", conditions: transitioned(old), stuck: true},
+		{name: "recent transition", conditions: transitioned(recent)},
+		{name: "not ready since an old transition", applied: "v2", conditions: transitioned(old), stuck: true},
+		{name: "up to date and ready", applied: "v2", ready: true, conditions: transitioned(old)},
+		{name: "no transition times created long ago", created: old, stuck: true},
+		{name: "no transition times created recently", created: recent},
+		{name: "no transition or creation time"},
+		{name: "paused", conditions: transitioned(old), paused: true},
+		{name: "no deployment", noDeploy: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := &Target{
+				Bundle:       &fleet.Bundle{Spec: fleet.BundleSpec{Paused: test.paused}},
+				Cluster:      newCluster("prod-1", nil),
+				DeploymentID: "v2",
+			}
+			if !test.noDeploy {
+				target.Deployment = &fleet.BundleDeployment{
+					ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(test.created)},
+					Spec: fleet.BundleDeploymentSpec{
+						StagedDeploymentID: "v2",
+						DeploymentID:       "v2",
+					},
+					Status: fleet.BundleDeploymentStatus{
+						AppliedDeploymentID: test.applied,
+						Ready:               test.ready,
+						Conditions:          test.conditions,
+					},
+				}
+			}
+
+			stuck := (&Manager{}).StuckTargets([]*Target{target}, 10*time.Minute)
+			if (len(stuck) == 1) != test.stuck {
+				t.Errorf("expected stuck %v, got %v", test.stuck, stuck)
+			}
+		})
+	}
+}
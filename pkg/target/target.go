@@ -1,28 +1,48 @@
 package target
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
 	"github.com/rancher/fleet/pkg/bundle"
+	"github.com/rancher/fleet/pkg/config"
 	fleetcontrollers "github.com/rancher/fleet/pkg/generated/controllers/fleet.cattle.io/v1alpha1"
 	"github.com/rancher/fleet/pkg/manifest"
+	"github.com/rancher/fleet/pkg/match"
 	"github.com/rancher/fleet/pkg/options"
 	"github.com/rancher/fleet/pkg/summary"
+	"github.com/rancher/wrangler/pkg/condition"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 var (
 	defLimit                    = intstr.FromString("10%")
 	defAutoPartitionSize        = intstr.FromString("25%")
 	defMaxUnavailablePartitions = intstr.FromInt(0)
+
+	defMaxConcurrentContentStores = 4
+
+	// offlineThreshold is three missed agent check-ins, the agent reports every 5 minutes
+	offlineThreshold = 15 * time.Minute
+
+	storeBackoff = wait.Backoff{
+		Steps:    5,
+		Duration: 50 * time.Millisecond,
+		Factor:   2.0,
+		Jitter:   0.1,
+	}
 )
 
 type Manager struct {
@@ -62,6 +82,41 @@ func ClusterGroupsToLabelMap(cgs []*fleet.ClusterGroup) map[string]map[string]st
 	return result
 }
 
+// InvalidSelectorError is the error of a cluster group with a selector that can not be parsed
+type InvalidSelectorError struct {
+	ClusterGroup *fleet.ClusterGroup
+	Err          error
+}
+
+func (e *InvalidSelectorError) Error() string {
+	return fmt.Sprintf("invalid selector on clusterGroup %s/%s [%v]: %v", e.ClusterGroup.Namespace, e.ClusterGroup.Name,
+		e.ClusterGroup.Spec.Selector, e.Err)
+}
+
+func (e *InvalidSelectorError) Unwrap() error {
+	return e.Err
+}
+
+// InvalidClusterGroups returns an error for each cluster group in the namespace with an invalid selector.
+// These cluster groups are ignored when targeting.
+func (m *Manager) InvalidClusterGroups(namespace string) (result []*InvalidSelectorError, _ error) {
+	cgs, err := m.clusterGroups.List(namespace, labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cg := range cgs {
+		if err := match.ValidateSelector(cg.Spec.Selector); err != nil {
+			result = append(result, &InvalidSelectorError{
+				ClusterGroup: cg,
+				Err:          err,
+			})
+		}
+	}
+
+	return result, nil
+}
+
 func (m *Manager) ClusterGroupsForCluster(cluster *fleet.Cluster) (result []*fleet.ClusterGroup, _ error) {
 	cgs, err := m.clusterGroups.List(cluster.Namespace, labels.Everything())
 	if err != nil {
@@ -74,8 +129,10 @@ func (m *Manager) ClusterGroupsForCluster(cluster *fleet.Cluster) (result []*fle
 		}
 		sel, err := metav1.LabelSelectorAsSelector(cg.Spec.Selector)
 		if err != nil {
-			logrus.Errorf("invalid selector on clusterGroup %s/%s [%v]: %v", cg.Namespace, cg.Name,
-				cg.Spec.Selector, err)
+			logrus.Error(&InvalidSelectorError{
+				ClusterGroup: cg,
+				Err:          err,
+			})
 			continue
 		}
 		if sel.Matches(labels.Set(cluster.Labels)) {
@@ -102,7 +159,7 @@ func (m *Manager) BundlesForCluster(cluster *fleet.Cluster) (result []*fleet.Bun
 		if err != nil {
 			return nil, err
 		}
-		m := bundle.Match(ClusterGroupsToLabelMap(cgs), cluster.Labels)
+		m := bundle.Match(cluster.Name, ClusterGroupsToLabelMap(cgs), cluster.Labels)
 		if m != nil {
 			result = append(result, app)
 		}
@@ -111,7 +168,54 @@ func (m *Manager) BundlesForCluster(cluster *fleet.Cluster) (result []*fleet.Bun
 	return
 }
 
-func (m *Manager) Targets(fleetBundle *fleet.Bundle) (result []*Target, _ error) {
+// BundlesAffectedByLabels returns the bundles that start and stop targeting the cluster if its labels are
+// changed to newLabels, sorted by name. The cluster groups of the cluster are evaluated for both sets of
+// labels. Nothing is changed.
+func (m *Manager) BundlesAffectedByLabels(cluster *fleet.Cluster, newLabels map[string]string) (gained, lost []*fleet.Bundle, err error) {
+	relabeled := cluster.DeepCopy()
+	relabeled.Labels = newLabels
+
+	oldBundles, err := m.BundlesForCluster(cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+	newBundles, err := m.BundlesForCluster(relabeled)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	oldNames := map[string]bool{}
+	for _, bundle := range oldBundles {
+		oldNames[bundle.Name] = true
+	}
+	newNames := map[string]bool{}
+	for _, bundle := range newBundles {
+		newNames[bundle.Name] = true
+		if !oldNames[bundle.Name] {
+			gained = append(gained, bundle)
+		}
+	}
+	for _, bundle := range oldBundles {
+		if !newNames[bundle.Name] {
+			lost = append(lost, bundle)
+		}
+	}
+
+	byName := func(bundles []*fleet.Bundle) {
+		sort.Slice(bundles, func(i, j int) bool {
+			return bundles[i].Name < bundles[j].Name
+		})
+	}
+	byName(gained)
+	byName(lost)
+
+	return gained, lost, nil
+}
+
+// ClustersForBundle returns the clusters the bundle targets without computing the manifests and
+// deployment options for each cluster as Targets does. Like Targets, clusters the bundle has not
+// adopted are not returned.
+func (m *Manager) ClustersForBundle(fleetBundle *fleet.Bundle) (result []*fleet.Cluster, _ error) {
 	bundle, err := bundle.New(fleetBundle)
 	if err != nil {
 		return nil, err
@@ -128,65 +232,435 @@ func (m *Manager) Targets(fleetBundle *fleet.Bundle) (result []*Target, _ error)
 			return nil, err
 		}
 
-		match := bundle.Match(ClusterGroupsToLabelMap(clusterGroups), cluster.Labels)
-		if match == nil {
-			continue
+		if bundle.Match(cluster.Name, ClusterGroupsToLabelMap(clusterGroups), cluster.Labels) != nil &&
+			Adopted(fleetBundle, cluster.Name) {
+			result = append(result, cluster)
 		}
+	}
 
-		manifest, err := match.Manifest()
-		if err != nil {
-			return nil, err
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
+
+// TargetDrift returns the clusters that newly match and the clusters that stop matching when the bundle
+// changes from old to new, sorted by name. Nothing is deployed or stored.
+func (m *Manager) TargetDrift(old, new *fleet.Bundle) (added, removed []*fleet.Cluster, err error) {
+	oldClusters, err := m.ClustersForBundle(old)
+	if err != nil {
+		return nil, nil, err
+	}
+	newClusters, err := m.ClustersForBundle(new)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	oldNames := map[string]bool{}
+	for _, cluster := range oldClusters {
+		oldNames[cluster.Name] = true
+	}
+	newNames := map[string]bool{}
+	for _, cluster := range newClusters {
+		newNames[cluster.Name] = true
+		if !oldNames[cluster.Name] {
+			added = append(added, cluster)
 		}
+	}
+	for _, cluster := range oldClusters {
+		if !newNames[cluster.Name] {
+			removed = append(removed, cluster)
+		}
+	}
+
+	return added, removed, nil
+}
+
+// Targets returns the targets of the bundle, storing the content of their deployments. Cancelling ctx stops
+// storing content that has not been stored yet.
+func (m *Manager) Targets(ctx context.Context, fleetBundle *fleet.Bundle) (result []*Target, _ error) {
+	bundle, err := bundle.New(fleetBundle)
+	if err != nil {
+		return nil, err
+	}
+	deployments := newDeploymentCache(fleetBundle)
+	cycle, err := m.dependencyCycle(fleetBundle)
+	if err != nil {
+		return nil, err
+	}
+
+	clusters, err := m.clusters.List(fleetBundle.Namespace, labels.Everything())
+	if err != nil {
+		return nil, err
+	}
 
-		opts, err := options.Calculate(&fleetBundle.Spec, match.Target)
+	var toStore []*manifest.Manifest
+	for _, cluster := range clusters {
+		target, deployment, err := m.target(bundle, deployments, cycle, cluster)
 		if err != nil {
 			return nil, err
 		}
+		if target == nil {
+			continue
+		}
+
+		// content downloaded by the agents from a URL is not stored
+		if deployment != nil && !deployment.stored && deployment.opts.ContentURL == "" {
+			toStore = append(toStore, deployment.manifest)
+			deployment.stored = true
+		}
+
+		result = append(result, target)
+	}
+
+	if err := m.storeAll(ctx, toStore); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Cluster.Name < result[j].Cluster.Name
+	})
+
+	return result, m.foldInDeployments(fleetBundle, result)
+}
+
+// TargetsStream calls fn with each target of the bundle as it is calculated, in the same order as Targets,
+// so the targets of a large fleet don't all have to be held in memory. The content of each deployment is
+// stored before the first target using it is passed to fn. An error returned by fn or cancelling ctx stops
+// the stream.
+func (m *Manager) TargetsStream(ctx context.Context, fleetBundle *fleet.Bundle, fn func(*Target) error) error {
+	bundle, err := bundle.New(fleetBundle)
+	if err != nil {
+		return err
+	}
+	deployments := newDeploymentCache(fleetBundle)
+	cycle, err := m.dependencyCycle(fleetBundle)
+	if err != nil {
+		return err
+	}
+
+	clusters, err := m.clusters.List(fleetBundle.Namespace, labels.Everything())
+	if err != nil {
+		return err
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Name < clusters[j].Name
+	})
+
+	byNamespace, err := m.deploymentsByNamespace(fleetBundle)
+	if err != nil {
+		return err
+	}
 
-		deploymentID, err := options.DeploymentID(manifest, opts)
+	for _, cluster := range clusters {
+		target, deployment, err := m.target(bundle, deployments, cycle, cluster)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if target == nil {
+			continue
 		}
 
-		if _, err := m.contentStore.Store(manifest); err != nil {
-			return nil, err
+		if deployment != nil && !deployment.stored && deployment.opts.ContentURL == "" {
+			if err := m.store(ctx, deployment.manifest); err != nil {
+				return err
+			}
+			deployment.stored = true
 		}
 
-		result = append(result, &Target{
+		if target.HasNamespace() {
+			target.Deployment = byNamespace[cluster.Status.Namespace]
+		}
+		if err := fn(target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// target returns the target of the bundle for the cluster and its deployment, or nil if the bundle is
+// not deployed to the cluster. The deployment of the target is not set. If the target fails to render
+// and the rollout strategy continues on render errors, the target is returned with RenderError set and
+// no deployment. cycle is the dependency cycle of the bundle, found once for all clusters.
+func (m *Manager) target(bundle *bundle.Bundle, deployments *deploymentCache, cycle []string, cluster *fleet.Cluster) (*Target, *deployment, error) {
+	fleetBundle := bundle.Definition
+
+	clusterGroups, err := m.ClusterGroupsForCluster(cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	match := bundle.Match(cluster.Name, ClusterGroupsToLabelMap(clusterGroups), cluster.Labels)
+	if match == nil || !Adopted(fleetBundle, cluster.Name) {
+		return nil, nil, nil
+	}
+
+	deployment, err := deployments.get(match, cluster.Labels)
+	if err != nil {
+		if rollout := fleetBundle.Spec.RolloutStrategy; rollout == nil || !rollout.ContinueOnRenderError {
+			return nil, nil, err
+		}
+		return &Target{
 			ClusterGroups: clusterGroups,
 			Cluster:       cluster,
 			Target:        match.Target,
 			Bundle:        fleetBundle,
-			Options:       opts,
-			DeploymentID:  deploymentID,
+			RenderError:   err,
+		}, nil, nil
+	}
+
+	satisfied, dependencyMessage, err := m.dependenciesSatisfied(fleetBundle, cycle, cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+	if satisfied {
+		satisfied, dependencyMessage = ClusterConditionsSatisfied(match.Target, cluster)
+	}
+
+	return &Target{
+		ClusterGroups:         clusterGroups,
+		Cluster:               cluster,
+		Target:                match.Target,
+		Bundle:                fleetBundle,
+		Options:               deployment.opts,
+		DeploymentID:          deployment.id,
+		DependenciesSatisfied: satisfied,
+		DependencyMessage:     dependencyMessage,
+	}, deployment, nil
+}
+
+// storeAll saves the manifests to the content store, at most MaxConcurrentContentStores at a time.
+// The first error or cancelling ctx stops any stores that have not started yet.
+func (m *Manager) storeAll(ctx context.Context, manifests []*manifest.Manifest) error {
+	limit := config.Get().MaxConcurrentContentStores
+	if limit <= 0 {
+		limit = defMaxConcurrentContentStores
+	}
+
+	var (
+		sem          = semaphore.NewWeighted(int64(limit))
+		eg, storeCtx = errgroup.WithContext(ctx)
+		acquireErr   error
+	)
+
+	for _, manifest := range manifests {
+		if acquireErr = sem.Acquire(storeCtx, 1); acquireErr != nil {
+			break
+		}
+		manifest := manifest
+		eg.Go(func() error {
+			defer sem.Release(1)
+			return m.store(storeCtx, manifest)
 		})
 	}
 
-	sort.Slice(result, func(i, j int) bool {
-		return result[i].Cluster.Name < result[j].Cluster.Name
-	})
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	return acquireErr
+}
 
-	return result, m.foldInDeployments(fleetBundle, result)
+// store saves the manifest to the content store, retrying with backoff on failure so a transient
+// error doesn't fail targeting of the whole bundle. Cancelling ctx stops the retries.
+func (m *Manager) store(ctx context.Context, manifest *manifest.Manifest) error {
+	start := time.Now()
+	defer func() {
+		logrus.Debugf("stored bundle content in %v", time.Since(start))
+	}()
+
+	backoff := storeBackoff
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_, err := m.contentStore.Store(manifest)
+		if err == nil || backoff.Steps <= 1 {
+			return err
+		}
+
+		timer := time.NewTimer(backoff.Step())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrap(err, "stopped retrying to store bundle content")
+		case <-timer.C:
+		}
+	}
 }
 
-func (m *Manager) foldInDeployments(app *fleet.Bundle, targets []*Target) error {
+// AutoAdopt returns true if the bundle is deployed to clusters that start matching its targets
+func AutoAdopt(bundle *fleet.Bundle) bool {
+	return bundle.Spec.AutoAdopt == nil || *bundle.Spec.AutoAdopt
+}
+
+// Adopted returns true if the bundle may be deployed to the cluster. If the bundle does not auto adopt
+// clusters only those recorded in the status are adopted, unless the bundle changed since they were
+// recorded, in which case every matching cluster is adopted again.
+func Adopted(bundle *fleet.Bundle, clusterName string) bool {
+	if AutoAdopt(bundle) || bundle.Status.AdoptedGeneration != bundle.Generation {
+		return true
+	}
+	for _, name := range bundle.Status.AdoptedClusters {
+		if name == clusterName {
+			return true
+		}
+	}
+	return false
+}
+
+// PreviewDeploymentIDs returns the deployment ID the bundle would have on each targeted cluster, keyed by
+// cluster name. Unlike Targets the content of the bundle is not stored.
+func (m *Manager) PreviewDeploymentIDs(fleetBundle *fleet.Bundle) (map[string]string, error) {
+	bundle, err := bundle.New(fleetBundle)
+	if err != nil {
+		return nil, err
+	}
+	deployments := newDeploymentCache(fleetBundle)
+
+	clusters, err := m.clusters.List(fleetBundle.Namespace, labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]string{}
+	for _, cluster := range clusters {
+		clusterGroups, err := m.ClusterGroupsForCluster(cluster)
+		if err != nil {
+			return nil, err
+		}
+
+		match := bundle.Match(cluster.Name, ClusterGroupsToLabelMap(clusterGroups), cluster.Labels)
+		if match == nil || !Adopted(fleetBundle, cluster.Name) {
+			continue
+		}
+
+		deployment, err := deployments.get(match, cluster.Labels)
+		if err != nil {
+			return nil, err
+		}
+		result[cluster.Name] = deployment.id
+	}
+
+	return result, nil
+}
+
+type deployment struct {
+	manifest *manifest.Manifest
+	opts     fleet.BundleDeploymentOptions
+	id       string
+	stored   bool
+}
+
+// deploymentCache calculates the manifest, options and resulting deployment ID once per target and
+// values of the cluster labels the target references. These only depend on the matched target and
+// those labels, so all clusters matching the same target with the same label values share the result.
+type deploymentCache struct {
+	bundle      *fleet.Bundle
+	deployments map[deploymentKey]*deployment
+}
+
+type deploymentKey struct {
+	target *fleet.BundleTarget
+	labels string
+}
+
+func newDeploymentCache(bundle *fleet.Bundle) *deploymentCache {
+	return &deploymentCache{
+		bundle:      bundle,
+		deployments: map[deploymentKey]*deployment{},
+	}
+}
+
+func (d *deploymentCache) get(match *bundle.Match, clusterLabels map[string]string) (*deployment, error) {
+	var labelValues []string
+	for _, key := range match.Target.ClusterLabels {
+		labelValues = append(labelValues, key+"="+clusterLabels[key])
+	}
+
+	// label values can not contain a comma, so the joined values are unique
+	key := deploymentKey{
+		target: match.Target,
+		labels: strings.Join(labelValues, ","),
+	}
+	if result, ok := d.deployments[key]; ok {
+		return result, nil
+	}
+
+	manifest, err := match.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := options.Calculate(&d.bundle.Spec, match.Target)
+	if err != nil {
+		return nil, err
+	}
+	opts = options.SubstituteClusterLabels(opts, match.Target.ClusterLabels, clusterLabels)
+	opts = options.InjectClusterLabels(opts, match.Target.ClusterLabelValues, match.Target.ClusterLabels, clusterLabels)
+
+	deploymentID, err := options.DeploymentID(manifest, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &deployment{
+		manifest: manifest,
+		opts:     opts,
+		id:       deploymentID,
+	}
+	d.deployments[key] = result
+	return result, nil
+}
+
+// deploymentsByNamespace returns a copy of each deployment of the bundle keyed by namespace
+func (m *Manager) deploymentsByNamespace(app *fleet.Bundle) (map[string]*fleet.BundleDeployment, error) {
 	bundleDeployments, err := m.bundleDeploymentCache.List("", labels.SelectorFromSet(DeploymentLabels(app)))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	byNamespace := map[string]*fleet.BundleDeployment{}
 	for _, appDep := range bundleDeployments {
 		byNamespace[appDep.Namespace] = appDep.DeepCopy()
 	}
+	return byNamespace, nil
+}
+
+func (m *Manager) foldInDeployments(app *fleet.Bundle, targets []*Target) error {
+	byNamespace, err := m.deploymentsByNamespace(app)
+	if err != nil {
+		return err
+	}
 
 	for _, target := range targets {
+		if !target.HasNamespace() {
+			continue
+		}
 		target.Deployment = byNamespace[target.Cluster.Status.Namespace]
 	}
 
 	return nil
 }
 
+// DeploymentAnnotations returns the annotations of the bundle with the DeploymentAnnotationGroup prefix,
+// which are copied to each bundle deployment. They are not part of the deployment ID, so changing them
+// does not cause a rollout.
+func DeploymentAnnotations(app *fleet.Bundle) map[string]string {
+	var result map[string]string
+	for k, v := range app.Annotations {
+		if !strings.HasPrefix(k, fleet.DeploymentAnnotationGroup) {
+			continue
+		}
+		if result == nil {
+			result = map[string]string{}
+		}
+		result[k] = v
+	}
+	return result
+}
+
 func DeploymentLabels(app *fleet.Bundle) map[string]string {
 	return map[string]string{
 		"fleet.cattle.io/bundle-name":      app.Name,
@@ -195,26 +669,57 @@ func DeploymentLabels(app *fleet.Bundle) map[string]string {
 }
 
 type Target struct {
-	Deployment    *fleet.BundleDeployment
-	ClusterGroups []*fleet.ClusterGroup
-	Cluster       *fleet.Cluster
-	Bundle        *fleet.Bundle
-	Target        *fleet.BundleTarget
-	Options       fleet.BundleDeploymentOptions
-	DeploymentID  string
+	Deployment            *fleet.BundleDeployment
+	ClusterGroups         []*fleet.ClusterGroup
+	Cluster               *fleet.Cluster
+	Bundle                *fleet.Bundle
+	Target                *fleet.BundleTarget
+	Options               fleet.BundleDeploymentOptions
+	DeploymentID          string
+	DependenciesSatisfied bool
+	DependencyMessage     string
+	// RenderError is the error rendering the target, the target is not updated if set
+	RenderError error
 }
 
 func (t *Target) IsPaused() bool {
 	return t.Cluster.Spec.Paused ||
-		t.Bundle.Spec.Paused
+		t.Bundle.Spec.Paused ||
+		IsPausedUntil(t.Bundle, time.Now())
+}
+
+// IsOffline returns true if the agent of the cluster has not checked in within the offline threshold.
+// Clusters that have never reported are not considered offline.
+func (t *Target) IsOffline(now time.Time) bool {
+	lastSeen := t.Cluster.Status.Agent.LastSeen
+	return !lastSeen.IsZero() && now.Sub(lastSeen.Time) > offlineThreshold
+}
+
+// SkipOffline returns true if the cluster is offline and the rollout strategy excludes offline clusters
+// from unavailable accounting
+func (t *Target) SkipOffline() bool {
+	rollout := t.Bundle.Spec.RolloutStrategy
+	return rollout != nil && rollout.SkipOfflineClusters && t.IsOffline(time.Now())
+}
+
+// IsPausedUntil returns true if the bundle has a PausedUntil time that is after now
+func IsPausedUntil(bundle *fleet.Bundle, now time.Time) bool {
+	return bundle.Spec.PausedUntil != nil && now.Before(bundle.Spec.PausedUntil.Time)
+}
+
+// HasNamespace returns false if the cluster has not been assigned a namespace for its bundle
+// deployments yet. Such targets stay Pending until it is assigned.
+func (t *Target) HasNamespace() bool {
+	return t.Cluster.Status.Namespace != ""
 }
 
 func (t *Target) AssignNewDeployment() {
 	t.Deployment = &fleet.BundleDeployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      t.Bundle.Name,
-			Namespace: t.Cluster.Status.Namespace,
-			Labels:    DeploymentLabels(t.Bundle),
+			Name:        t.Bundle.Name,
+			Namespace:   t.Cluster.Status.Namespace,
+			Labels:      DeploymentLabels(t.Bundle),
+			Annotations: DeploymentAnnotations(t.Bundle),
 		},
 	}
 }
@@ -230,6 +735,34 @@ func getRollout(targets []*Target) *fleet.RolloutStrategy {
 	return rollout
 }
 
+// Bounds clamp the result of a limit computed from a count or percentage. A zero Min or Max is unset.
+type Bounds struct {
+	Min int
+	Max int
+}
+
+// clamp applies Min and then Max, so Max wins if the bounds overlap
+func (b Bounds) clamp(i int) int {
+	if b.Min > 0 && i < b.Min {
+		i = b.Min
+	}
+	if b.Max > 0 && i > b.Max {
+		i = b.Max
+	}
+	return i
+}
+
+// LimitWithBounds is Limit with the result clamped to bounds, such as 10% but at least 2 and at most 20.
+// The bounds are applied after the minimum of 1 that Limit uses for percentages, so a Max takes precedence
+// over that minimum.
+func LimitWithBounds(count int, bounds Bounds, val ...*intstr.IntOrString) (int, error) {
+	i, err := Limit(count, val...)
+	if err != nil {
+		return 0, err
+	}
+	return bounds.clamp(i), nil
+}
+
 func Limit(count int, val ...*intstr.IntOrString) (int, error) {
 	if count == 0 {
 		return 1, nil
@@ -280,7 +813,10 @@ func Limit(count int, val ...*intstr.IntOrString) (int, error) {
 
 func MaxUnavailable(targets []*Target) (int, error) {
 	rollout := getRollout(targets)
-	return Limit(len(targets), rollout.MaxUnavailable)
+	return LimitWithBounds(len(targets), Bounds{
+		Min: rollout.MinMaxUnavailable,
+		Max: rollout.MaxMaxUnavailable,
+	}, rollout.MaxUnavailable)
 }
 
 func MaxUnavailablePartitions(partitions []Partition, targets []*Target) (int, error) {
@@ -293,6 +829,9 @@ func IsPartitionUnavailable(status *fleet.PartitionStatus, targets []*Target) bo
 	// For a partition a target must be available and update to date.
 	status.Unavailable = 0
 	for _, target := range targets {
+		if target.SkipOffline() {
+			continue
+		}
 		if !UpToDate(target) || IsUnavailable(target.Deployment) {
 			status.Unavailable++
 		}
@@ -301,6 +840,44 @@ func IsPartitionUnavailable(status *fleet.PartitionStatus, targets []*Target) bo
 	return status.Unavailable > status.MaxUnavailable
 }
 
+// SetPartitionCompleted sets LastCompleted of the partition to now if it has become fully up to date and
+// available since the previous status, otherwise the previous LastCompleted is kept. The status must have
+// been updated by IsPartitionUnavailable.
+func SetPartitionCompleted(status *fleet.PartitionStatus, previous *fleet.PartitionStatus, now time.Time) {
+	var (
+		lastCompleted *metav1.Time
+		wasComplete   bool
+	)
+	if previous != nil {
+		lastCompleted = previous.LastCompleted
+		wasComplete = previous.LastCompleted != nil && previous.Unavailable == 0
+	}
+
+	if status.Unavailable == 0 && status.Count > 0 && !wasComplete {
+		lastCompleted = &metav1.Time{Time: now}
+	}
+	status.LastCompleted = lastCompleted
+}
+
+// SetPartitionSoaking sets SoakingUntil of the partition if it is up to date and available and completed
+// less than the soak time of the rollout strategy before now, and returns true if it is soaking. The
+// status must have been updated by SetPartitionCompleted.
+func SetPartitionSoaking(status *fleet.PartitionStatus, targets []*Target, now time.Time) bool {
+	status.SoakingUntil = nil
+
+	soak := time.Duration(getRollout(targets).PartitionSoakSeconds) * time.Second
+	if soak <= 0 || status.Unavailable > 0 || status.LastCompleted == nil {
+		return false
+	}
+
+	until := status.LastCompleted.Add(soak)
+	if !now.Before(until) {
+		return false
+	}
+	status.SoakingUntil = &metav1.Time{Time: until}
+	return true
+}
+
 func UpToDate(target *Target) bool {
 	if target.Deployment == nil ||
 		target.Deployment.Spec.StagedDeploymentID != target.DeploymentID ||
@@ -314,7 +891,7 @@ func UpToDate(target *Target) bool {
 
 func Unavailable(targets []*Target) (count int) {
 	for _, target := range targets {
-		if target.Deployment == nil {
+		if target.Deployment == nil || target.SkipOffline() {
 			continue
 		}
 		if IsUnavailable(target.Deployment) {
@@ -329,19 +906,68 @@ func IsUnavailable(target *fleet.BundleDeployment) bool {
 		return false
 	}
 	return target.Status.AppliedDeploymentID != target.Spec.DeploymentID ||
-		!target.Status.Ready
+		!target.Status.Ready ||
+		!RequiredConditionsMet(target)
+}
+
+// RequiredConditionsMet returns true if all the required conditions of the deployed options are True
+func RequiredConditionsMet(bd *fleet.BundleDeployment) bool {
+	for _, required := range bd.Spec.Options.RequiredConditions {
+		if !condition.Cond(required).IsTrue(bd) {
+			return false
+		}
+	}
+	return true
 }
 
 func (t *Target) State() fleet.BundleState {
 	switch {
+	case t.RenderError != nil:
+		return fleet.ErrApplied
 	case t.Deployment == nil:
 		return fleet.Pending
+	case t.ReadyTimedOut(time.Now()):
+		return fleet.ErrApplied
+	case t.IsUpdating():
+		return fleet.Updating
 	default:
 		return summary.GetDeploymentState(t.Deployment)
 	}
 }
 
+// IsUpdating returns true if the current deployment ID of the target has been staged and released to the
+// cluster, but the agent has not applied it yet nor failed to apply it
+func (t *Target) IsUpdating() bool {
+	return t.Deployment.Spec.StagedDeploymentID == t.DeploymentID &&
+		t.Deployment.Spec.DeploymentID == t.DeploymentID &&
+		t.Deployment.Status.AppliedDeploymentID != t.DeploymentID &&
+		summary.GetDeploymentState(t.Deployment) == fleet.NotApplied
+}
+
 func (t *Target) Message() string {
+	if t.RenderError != nil {
+		return "failed to render: " + t.RenderError.Error()
+	}
+	if t.ReadyTimedOut(time.Now()) {
+		message := fmt.Sprintf("not ready within the ready timeout of %ds", t.Target.ReadyTimeoutSeconds)
+		if deployment := summary.MessageFromDeployment(t.Deployment); deployment != "" {
+			message += ": " + deployment
+		}
+		return message
+	}
+	if !t.HasNamespace() {
+		return "waiting for cluster namespace to be assigned"
+	}
+	if !t.DependenciesSatisfied && !UpToDate(t) {
+		return t.DependencyMessage
+	}
+	if !UpToDate(t) {
+		if ok, err := t.InMaintenanceWindow(time.Now()); err != nil {
+			return err.Error()
+		} else if !ok {
+			return "waiting for maintenance window"
+		}
+	}
 	return summary.MessageFromDeployment(t.Deployment)
 }
 
@@ -351,6 +977,15 @@ func Summary(targets []*Target) fleet.BundleSummary {
 		cluster := currentTarget.Cluster.Namespace + "/" + currentTarget.Cluster.Name
 		summary.IncrementState(&bundleSummary, cluster, currentTarget.State(), currentTarget.Message())
 		bundleSummary.DesiredReady++
+		if currentTarget.Deployment != nil {
+			summary.IncrementResources(&bundleSummary, &currentTarget.Deployment.Status)
+		} else if currentTarget.RenderError == nil {
+			bundleSummary.WaitingToSchedule++
+		}
+		if currentTarget.SkipOffline() {
+			bundleSummary.Offline++
+		}
 	}
+	summary.SetReadyPercent(&bundleSummary)
 	return bundleSummary
 }
@@ -0,0 +1,1554 @@
+package target
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/bundle"
+	"github.com/rancher/fleet/pkg/config"
+	fleetcontrollers "github.com/rancher/fleet/pkg/generated/controllers/fleet.cattle.io/v1alpha1"
+	"github.com/rancher/fleet/pkg/manifest"
+	"github.com/rancher/wrangler/pkg/genericcondition"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+type fakeClusterCache struct {
+	fleetcontrollers.ClusterCache
+	clusters []*fleet.Cluster
+}
+
+func (f *fakeClusterCache) List(namespace string, selector labels.Selector) (result []*fleet.Cluster, _ error) {
+	for _, cluster := range f.clusters {
+		if cluster.Namespace == namespace && selector.Matches(labels.Set(cluster.Labels)) {
+			result = append(result, cluster)
+		}
+	}
+	return result, nil
+}
+
+type fakeClusterGroupCache struct {
+	fleetcontrollers.ClusterGroupCache
+	groups []*fleet.ClusterGroup
+}
+
+func (f *fakeClusterGroupCache) List(namespace string, selector labels.Selector) (result []*fleet.ClusterGroup, _ error) {
+	for _, group := range f.groups {
+		if group.Namespace == namespace && selector.Matches(labels.Set(group.Labels)) {
+			result = append(result, group)
+		}
+	}
+	return result, nil
+}
+
+type fakeBundleCache struct {
+	fleetcontrollers.BundleCache
+	bundles []*fleet.Bundle
+	gets    int
+}
+
+func (f *fakeBundleCache) Get(namespace, name string) (*fleet.Bundle, error) {
+	f.gets++
+	for _, bundle := range f.bundles {
+		if bundle.Namespace == namespace && bundle.Name == name {
+			return bundle, nil
+		}
+	}
+	return nil, apierrors.NewNotFound(schema.GroupResource{Group: "fleet.cattle.io", Resource: "bundles"}, name)
+}
+
+func (f *fakeBundleCache) List(namespace string, selector labels.Selector) (result []*fleet.Bundle, _ error) {
+	for _, bundle := range f.bundles {
+		if bundle.Namespace == namespace && selector.Matches(labels.Set(bundle.Labels)) {
+			result = append(result, bundle)
+		}
+	}
+	return result, nil
+}
+
+type fakeBundleDeploymentCache struct {
+	fleetcontrollers.BundleDeploymentCache
+	deployments []*fleet.BundleDeployment
+}
+
+func (f *fakeBundleDeploymentCache) Get(namespace, name string) (*fleet.BundleDeployment, error) {
+	for _, bd := range f.deployments {
+		if bd.Namespace == namespace && bd.Name == name {
+			return bd, nil
+		}
+	}
+	return nil, apierrors.NewNotFound(schema.GroupResource{Group: "fleet.cattle.io", Resource: "bundledeployments"}, name)
+}
+
+func (f *fakeBundleDeploymentCache) List(namespace string, selector labels.Selector) (result []*fleet.BundleDeployment, _ error) {
+	for _, bd := range f.deployments {
+		if (namespace == "" || bd.Namespace == namespace) && selector.Matches(labels.Set(bd.Labels)) {
+			result = append(result, bd)
+		}
+	}
+	return result, nil
+}
+
+type fakeStore struct {
+	stored int
+}
+
+func (f *fakeStore) Store(m *manifest.Manifest) (string, error) {
+	f.stored++
+	_, id, err := m.Content()
+	return id, err
+}
+
+// flakyStore fails the first failures attempts to store content, calling onFailure after each of them
+type flakyStore struct {
+	lock      sync.Mutex
+	failures  int
+	attempts  int
+	onFailure func()
+}
+
+func (f *flakyStore) Store(m *manifest.Manifest) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.attempts++
+	if f.attempts <= f.failures {
+		if f.onFailure != nil {
+			f.onFailure()
+		}
+		return "", errors.New("connection refused")
+	}
+	_, id, err := m.Content()
+	return id, err
+}
+
+func newTestManager(clusters ...*fleet.Cluster) *Manager {
+	if err := config.Set(&config.Config{}); err != nil {
+		panic(err)
+	}
+	return New(&fakeClusterCache{clusters: clusters}, &fakeClusterGroupCache{}, nil, &fakeStore{}, &fakeBundleDeploymentCache{})
+}
+
+func newCluster(name string, clusterLabels map[string]string) *fleet.Cluster {
+	return &fleet.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "fleet-default",
+			Labels:    clusterLabels,
+		},
+		Status: fleet.ClusterStatus{
+			Namespace: "cluster-fleet-default-" + name,
+		},
+	}
+}
+
+// prodBundle returns a bundle targeting the clusters labeled env=prod, which has adopted only the
+// cluster prod-1 at its current generation
+func prodBundle(autoAdopt bool) *fleet.Bundle {
+	return &fleet.Bundle{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "app",
+			Namespace:  "fleet-default",
+			Generation: 2,
+		},
+		Spec: fleet.BundleSpec{
+			AutoAdopt: &autoAdopt,
+			Targets: []fleet.BundleTarget{
+				{
+					Name: "prod",
+					ClusterSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"env": "prod"},
+					},
+				},
+			},
+		},
+		Status: fleet.BundleStatus{
+			AdoptedClusters:   []string{"prod-1"},
+			AdoptedGeneration: 2,
+		},
+	}
+}
+
+// manifestLines returns n lines of yaml that are not all the same
+func manifestLines(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "key-%d: value-%d\n", i, i%100)
+	}
+	return b.String()
+}
+
+func clusterNames(clusters []*fleet.Cluster) (result []string) {
+	for _, cluster := range clusters {
+		result = append(result, cluster.Name)
+	}
+	return result
+}
+
+func targetNames(targets []*Target) (result []string) {
+	for _, target := range targets {
+		result = append(result, target.Cluster.Name)
+	}
+	return result
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAutoAdoptNewCluster(t *testing.T) {
+	m := newTestManager(
+		newCluster("prod-1", map[string]string{"env": "prod"}),
+		// a new cluster matching the targets of the bundle after it adopted its clusters
+		newCluster("prod-2", map[string]string{"env": "prod"}),
+		newCluster("dev-1", map[string]string{"env": "dev"}),
+	)
+
+	tests := []struct {
+		name      string
+		autoAdopt bool
+		expected  []string
+	}{
+		{
+			name:      "auto adopt",
+			autoAdopt: true,
+			expected:  []string{"prod-1", "prod-2"},
+		},
+		{
+			name:     "pinned to the adopted clusters",
+			expected: []string{"prod-1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clusters, err := m.ClustersForBundle(prodBundle(test.autoAdopt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names := clusterNames(clusters); !equalNames(names, test.expected) {
+				t.Errorf("expected clusters %v, got %v", test.expected, names)
+			}
+
+			targets, err := m.Targets(context.Background(), prodBundle(test.autoAdopt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names := targetNames(targets); !equalNames(names, test.expected) {
+				t.Errorf("expected targets %v, got %v", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestAdoptAfterChange(t *testing.T) {
+	m := newTestManager(
+		newCluster("prod-1", map[string]string{"env": "prod"}),
+		newCluster("prod-2", map[string]string{"env": "prod"}),
+	)
+
+	changed := prodBundle(false)
+	changed.Generation = 3
+
+	clusters, err := m.ClustersForBundle(changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := clusterNames(clusters); !equalNames(names, []string{"prod-1", "prod-2"}) {
+		t.Errorf("expected a changed bundle to adopt every matching cluster, got %v", names)
+	}
+}
+
+func TestContentURLNotStored(t *testing.T) {
+	tests := []struct {
+		name       string
+		contentURL string
+		stored     int
+	}{
+		{
+			name:   "stored inline",
+			stored: 1,
+		},
+		{
+			name:       "content url",
+			contentURL: "https://example.com/app.gz",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestManager(
+				newCluster("prod-1", map[string]string{"env": "prod"}),
+				newCluster("prod-2", map[string]string{"env": "prod"}),
+			)
+			store := &fakeStore{}
+			m.contentStore = store
+
+			bundle := prodBundle(true)
+			bundle.Spec.ContentURL = test.contentURL
+			bundle.Spec.Resources = []fleet.BundleResource{{Name: "manifests/configmap.yaml", Content: "kind: ConfigMap\n"}}
+
+			targets, err := m.Targets(context.Background(), bundle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(targets) != 2 {
+				t.Fatalf("expected 2 targets, got %v", targetNames(targets))
+			}
+			if store.stored != test.stored {
+				t.Errorf("expected the content to be stored %d times, got %d", test.stored, store.stored)
+			}
+			for _, target := range targets {
+				if target.Options.ContentURL != test.contentURL {
+					t.Errorf("%s: expected content url %q, got %q", target.Cluster.Name, test.contentURL, target.Options.ContentURL)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreRetries(t *testing.T) {
+	defer func(backoff wait.Backoff) { storeBackoff = backoff }(storeBackoff)
+	storeBackoff.Duration = time.Millisecond
+
+	tests := []struct {
+		name     string
+		failures int
+		attempts int
+		err      bool
+	}{
+		{
+			name:     "first attempt",
+			attempts: 1,
+		},
+		{
+			name:     "second attempt",
+			failures: 1,
+			attempts: 2,
+		},
+		{
+			name:     "retries exhausted",
+			failures: storeBackoff.Steps,
+			attempts: storeBackoff.Steps,
+			err:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestManager(newCluster("prod-1", map[string]string{"env": "prod"}))
+			store := &flakyStore{failures: test.failures}
+			m.contentStore = store
+
+			bundle := prodBundle(true)
+			bundle.Spec.Resources = []fleet.BundleResource{{Name: "manifests/configmap.yaml", Content: "kind: ConfigMap\n"}}
+
+			targets, err := m.Targets(context.Background(), bundle)
+			if test.err {
+				if err == nil || !strings.Contains(err.Error(), "connection refused") {
+					t.Errorf("expected the store error once the retries are exhausted, got %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("expected the content to be stored, got %v", err)
+			} else if len(targets) != 1 {
+				t.Errorf("expected 1 target, got %v", targetNames(targets))
+			}
+			if store.attempts != test.attempts {
+				t.Errorf("expected %d attempts, got %d", test.attempts, store.attempts)
+			}
+		})
+	}
+}
+
+func TestStoreRetriesCancelled(t *testing.T) {
+	m := newTestManager(newCluster("prod", map[string]string{"env": "prod"}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := &flakyStore{failures: 100, onFailure: cancel}
+	m.contentStore = store
+
+	bundle := prodBundle(true)
+	bundle.Spec.Resources = []fleet.BundleResource{{Name: "manifests/configmap.yaml", Content: "kind: ConfigMap\n"}}
+
+	_, err := m.Targets(ctx, bundle)
+	if err == nil || !strings.Contains(err.Error(), "stopped retrying") || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("expected the retries to stop with the store error, got %v", err)
+	}
+	if store.attempts != 1 {
+		t.Errorf("expected no attempts once the context is cancelled, got %d", store.attempts)
+	}
+
+	store.attempts = 0
+	if _, err := m.Targets(ctx, bundle); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancelled context to fail, got %v", err)
+	}
+	if store.attempts != 0 {
+		t.Errorf("expected no attempts with a cancelled context, got %d", store.attempts)
+	}
+}
+
+func TestDeploymentsSharedByIdenticalClusters(t *testing.T) {
+	m := newTestManager(
+		newCluster("eu-1", map[string]string{"env": "prod", "region": "eu"}),
+		newCluster("eu-2", map[string]string{"env": "prod", "region": "eu", "zone": "a"}),
+		newCluster("us-1", map[string]string{"env": "prod", "region": "us"}),
+	)
+	store := &fakeStore{}
+	m.contentStore = store
+
+	bundle := prodBundle(true)
+	bundle.Status = fleet.BundleStatus{}
+	bundle.Spec.Resources = []fleet.BundleResource{{Name: "manifests/configmap.yaml", Content: "kind: ConfigMap\n"}}
+	bundle.Spec.Targets[0].ClusterLabels = []string{"region"}
+	bundle.Spec.Targets[0].ClusterLabelValues = map[string]string{"global.region": "region"}
+
+	targets, err := m.Targets(context.Background(), bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byCluster := map[string]*Target{}
+	for _, target := range targets {
+		byCluster[target.Cluster.Name] = target
+	}
+	if len(byCluster) != 3 {
+		t.Fatalf("expected 3 targets, got %v", targetNames(targets))
+	}
+
+	// a label the options don't depend on doesn't change the deployment
+	if byCluster["eu-1"].DeploymentID != byCluster["eu-2"].DeploymentID {
+		t.Errorf("expected clusters with the same region to share the deployment, got %s and %s",
+			byCluster["eu-1"].DeploymentID, byCluster["eu-2"].DeploymentID)
+	}
+	if byCluster["eu-1"].DeploymentID == byCluster["us-1"].DeploymentID {
+		t.Errorf("expected clusters in different regions to have different deployments, got %s", byCluster["us-1"].DeploymentID)
+	}
+	for name, region := range map[string]string{"eu-1": "eu", "eu-2": "eu", "us-1": "us"} {
+		values := byCluster[name].Options.Values.Data
+		if global, _ := values["global"].(map[string]interface{}); global["region"] != region {
+			t.Errorf("%s: expected the region value %s, got %v", name, region, values)
+		}
+	}
+
+	// the manifest is the same for all clusters, so it is stored once per distinct deployment
+	if store.stored != 2 {
+		t.Errorf("expected the content to be stored once per distinct deployment, got %d", store.stored)
+	}
+}
+
+func TestDeploymentsByClusterLabel(t *testing.T) {
+	m := newTestManager(
+		newCluster("eu-1", map[string]string{"env": "prod", "region": "eu", "zone": "a"}),
+		newCluster("us-1", map[string]string{"env": "prod", "region": "us", "zone": "a"}),
+	)
+
+	bundle := prodBundle(true)
+	bundle.Status = fleet.BundleStatus{}
+	bundle.Spec.Resources = []fleet.BundleResource{{Name: "manifests/configmap.yaml", Content: "kind: ConfigMap\n"}}
+	bundle.Spec.Targets[0].ClusterLabels = []string{"region"}
+	bundle.Spec.Targets[0].Values = &fleet.GenericMap{
+		Data: map[string]interface{}{
+			"region": "$(cluster.label:region)",
+			"zone":   "$(cluster.label:zone)",
+		},
+	}
+
+	targets, err := m.Targets(context.Background(), bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byCluster := map[string]*Target{}
+	for _, target := range targets {
+		byCluster[target.Cluster.Name] = target
+	}
+	if len(byCluster) != 2 {
+		t.Fatalf("expected 2 targets, got %v", targetNames(targets))
+	}
+	if byCluster["eu-1"].DeploymentID == byCluster["us-1"].DeploymentID {
+		t.Errorf("expected clusters with different region labels to have different deployments, got %s", byCluster["eu-1"].DeploymentID)
+	}
+	for name, region := range map[string]string{"eu-1": "eu", "us-1": "us"} {
+		values := byCluster[name].Options.Values.Data
+		if values["region"] != region {
+			t.Errorf("%s: expected the region value %s, got %v", name, region, values["region"])
+		}
+		// zone is not an allowed label, so it isn't substituted
+		if values["zone"] != "$(cluster.label:zone)" {
+			t.Errorf("%s: expected the zone reference to be kept, got %v", name, values["zone"])
+		}
+	}
+	if bundle.Spec.Targets[0].Values.Data["region"] != "$(cluster.label:region)" {
+		t.Errorf("expected the bundle values to be unchanged, got %v", bundle.Spec.Targets[0].Values.Data)
+	}
+}
+
+func TestClustersForBundleMatchesTargets(t *testing.T) {
+	m := newTestManager(
+		newCluster("prod-1", map[string]string{"env": "prod"}),
+		newCluster("prod-2", map[string]string{"env": "prod", "region": "eu"}),
+		newCluster("dev-1", map[string]string{"env": "dev"}),
+		newCluster("edge-1", nil),
+	)
+	m.clusterGroups = &fakeClusterGroupCache{
+		groups: []*fleet.ClusterGroup{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "eu", Namespace: "fleet-default"},
+				Spec: fleet.ClusterGroupSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"region": "eu"}},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		targets  []fleet.BundleTarget
+		expected []string
+	}{
+		{
+			name: "cluster selector",
+			targets: []fleet.BundleTarget{
+				{Name: "prod", ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}},
+			},
+			expected: []string{"prod-1", "prod-2"},
+		},
+		{
+			name:     "cluster group",
+			targets:  []fleet.BundleTarget{{Name: "eu", ClusterGroup: "eu"}},
+			expected: []string{"prod-2"},
+		},
+		{
+			name:     "cluster name regex",
+			targets:  []fleet.BundleTarget{{Name: "dev", ClusterNameRegex: "^dev-"}},
+			expected: []string{"dev-1"},
+		},
+		{
+			name: "overlapping targets",
+			targets: []fleet.BundleTarget{
+				{Name: "eu", ClusterGroup: "eu"},
+				{Name: "prod", ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}},
+				{Name: "edge", ClusterNameRegex: "^edge-"},
+			},
+			expected: []string{"edge-1", "prod-1", "prod-2"},
+		},
+		{
+			name:     "all",
+			targets:  []fleet.BundleTarget{{Name: "all", All: true}},
+			expected: []string{"dev-1", "edge-1", "prod-1", "prod-2"},
+		},
+		{
+			name: "no match",
+			targets: []fleet.BundleTarget{
+				{Name: "staging", ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "staging"}}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bundle := prodBundle(true)
+			bundle.Spec.Targets = test.targets
+
+			clusters, err := m.ClustersForBundle(bundle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			targets, err := m.Targets(context.Background(), bundle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names := targetNames(targets); !equalNames(names, test.expected) {
+				t.Errorf("expected targets %v, got %v", test.expected, names)
+			}
+			if clusters, targets := clusterNames(clusters), targetNames(targets); !equalNames(clusters, targets) {
+				t.Errorf("expected the clusters %v to match the targets %v", clusters, targets)
+			}
+		})
+	}
+}
+
+func TestTargetDrift(t *testing.T) {
+	m := newTestManager(
+		newCluster("prod-2", map[string]string{"env": "prod", "region": "us"}),
+		newCluster("prod-1", map[string]string{"env": "prod", "region": "eu"}),
+		newCluster("staging-1", map[string]string{"env": "staging", "region": "eu"}),
+		newCluster("dev-1", map[string]string{"env": "dev"}),
+	)
+	store := &fakeStore{}
+	m.contentStore = store
+
+	old := prodBundle(true)
+	old.Status = fleet.BundleStatus{}
+	changed := old.DeepCopy()
+	changed.Spec.Targets[0].ClusterSelector = &metav1.LabelSelector{
+		MatchLabels: map[string]string{"region": "eu"},
+	}
+
+	added, removed, err := m.TargetDrift(old, changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := clusterNames(added); !equalNames(names, []string{"staging-1"}) {
+		t.Errorf("expected staging-1 to be added, got %v", names)
+	}
+	if names := clusterNames(removed); !equalNames(names, []string{"prod-2"}) {
+		t.Errorf("expected prod-2 to be removed, got %v", names)
+	}
+	if store.stored != 0 {
+		t.Errorf("expected no content to be stored, got %d", store.stored)
+	}
+
+	added, removed, err = m.TargetDrift(old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 0 || len(removed) != 0 {
+		t.Errorf("expected no drift for an unchanged bundle, got added %v and removed %v", clusterNames(added), clusterNames(removed))
+	}
+}
+
+func TestIsPausedUntil(t *testing.T) {
+	resume := time.Date(2020, 10, 3, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		pausedUntil *metav1.Time
+		now         time.Time
+		expected    bool
+	}{
+		{
+			name: "not set",
+			now:  resume,
+		},
+		{
+			name:        "before the resume time",
+			pausedUntil: &metav1.Time{Time: resume},
+			now:         resume.Add(-time.Nanosecond),
+			expected:    true,
+		},
+		{
+			name:        "exactly at the resume time",
+			pausedUntil: &metav1.Time{Time: resume},
+			now:         resume,
+		},
+		{
+			name:        "after the resume time",
+			pausedUntil: &metav1.Time{Time: resume},
+			now:         resume.Add(time.Second),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bundle := prodBundle(true)
+			bundle.Spec.PausedUntil = test.pausedUntil
+			if actual := IsPausedUntil(bundle, test.now); actual != test.expected {
+				t.Errorf("expected paused %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestIsPaused(t *testing.T) {
+	tests := []struct {
+		name          string
+		pausedUntil   time.Duration
+		bundlePaused  bool
+		clusterPaused bool
+		expected      bool
+	}{
+		{name: "not paused"},
+		{name: "bundle paused", bundlePaused: true, expected: true},
+		{name: "cluster paused", clusterPaused: true, expected: true},
+		{name: "paused until later", pausedUntil: time.Hour, expected: true},
+		{name: "resumed", pausedUntil: -time.Hour},
+		{name: "resumed but paused", pausedUntil: -time.Hour, bundlePaused: true, expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := &Target{
+				Bundle:  prodBundle(true),
+				Cluster: newCluster("prod-1", nil),
+			}
+			target.Bundle.Spec.Paused = test.bundlePaused
+			target.Cluster.Spec.Paused = test.clusterPaused
+			if test.pausedUntil != 0 {
+				target.Bundle.Spec.PausedUntil = &metav1.Time{Time: time.Now().Add(test.pausedUntil)}
+			}
+			if actual := target.IsPaused(); actual != test.expected {
+				t.Errorf("expected paused %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+// benchmarkDeployments calculates the deployment of 500 identical clusters matching the same target,
+// with a cache shared by all clusters as Targets does or a new cache for each cluster
+func benchmarkDeployments(b *testing.B, shared bool) {
+	fleetBundle := prodBundle(true)
+	fleetBundle.Spec.Resources = []fleet.BundleResource{{Name: "manifests/deployment.yaml", Content: manifestLines(1000)}}
+	fleetBundle.Spec.Values = &fleet.GenericMap{Data: map[string]interface{}{"replicas": 3}}
+
+	app, err := bundle.New(fleetBundle)
+	if err != nil {
+		b.Fatal(err)
+	}
+	clusterLabels := map[string]string{"env": "prod"}
+	match := app.Match("prod", nil, clusterLabels)
+	if match == nil {
+		b.Fatal("expected the clusters to match")
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		deployments := newDeploymentCache(fleetBundle)
+		for j := 0; j < 500; j++ {
+			if !shared {
+				deployments = newDeploymentCache(fleetBundle)
+			}
+			if _, err := deployments.get(match, clusterLabels); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkDeploymentsCached(b *testing.B) {
+	benchmarkDeployments(b, true)
+}
+
+func BenchmarkDeploymentsUncached(b *testing.B) {
+	benchmarkDeployments(b, false)
+}
+
+func TestSkipOfflineClusters(t *testing.T) {
+	var (
+		now     = time.Now()
+		online  = metav1.NewTime(now.Add(-time.Minute))
+		offline = metav1.NewTime(now.Add(-time.Hour))
+	)
+
+	newTargets := func(skipOffline bool) []*Target {
+		bundle := &fleet.Bundle{
+			Spec: fleet.BundleSpec{
+				RolloutStrategy: &fleet.RolloutStrategy{SkipOfflineClusters: skipOffline},
+			},
+		}
+
+		var targets []*Target
+		for _, c := range []struct {
+			name     string
+			lastSeen metav1.Time
+			ready    bool
+		}{
+			{name: "healthy", lastSeen: online, ready: true},
+			{name: "failing", lastSeen: online},
+			{name: "offline-1", lastSeen: offline},
+			{name: "offline-2", lastSeen: offline},
+			{name: "never-seen"},
+		} {
+			cluster := newCluster(c.name, nil)
+			cluster.Status.Agent.LastSeen = c.lastSeen
+			targets = append(targets, &Target{
+				Bundle:       bundle,
+				Cluster:      cluster,
+				DeploymentID: "v1",
+				Deployment: &fleet.BundleDeployment{
+					Spec: fleet.BundleDeploymentSpec{
+						DeploymentID:       "v1",
+						StagedDeploymentID: "v1",
+					},
+					Status: fleet.BundleDeploymentStatus{
+						AppliedDeploymentID: "v1",
+						Ready:               c.ready,
+					},
+				},
+			})
+		}
+		return targets
+	}
+
+	tests := []struct {
+		skipOffline bool
+		unavailable int
+		offline     int
+	}{
+		{
+			unavailable: 4,
+		},
+		{
+			skipOffline: true,
+			// a cluster that has never been seen is not offline
+			unavailable: 2,
+			offline:     2,
+		},
+	}
+
+	for _, test := range tests {
+		targets := newTargets(test.skipOffline)
+
+		if unavailable := Unavailable(targets); unavailable != test.unavailable {
+			t.Errorf("skipOffline %v: expected %d unavailable, got %d", test.skipOffline, test.unavailable, unavailable)
+		}
+		if summary := Summary(targets); summary.Offline != test.offline {
+			t.Errorf("skipOffline %v: expected %d offline in the summary, got %d", test.skipOffline, test.offline, summary.Offline)
+		}
+
+		status := &fleet.PartitionStatus{MaxUnavailable: 2}
+		if IsPartitionUnavailable(status, targets) == test.skipOffline || status.Unavailable != test.unavailable {
+			t.Errorf("skipOffline %v: expected %d unavailable in the partition, got %d", test.skipOffline, test.unavailable, status.Unavailable)
+		}
+	}
+}
+
+func TestRequiredConditions(t *testing.T) {
+	newDeployment := func(conditions ...genericcondition.GenericCondition) *fleet.BundleDeployment {
+		return &fleet.BundleDeployment{
+			Spec: fleet.BundleDeploymentSpec{
+				DeploymentID: "v1",
+				Options: fleet.BundleDeploymentOptions{
+					RequiredConditions: []string{"Smoke", "Migrated"},
+				},
+			},
+			Status: fleet.BundleDeploymentStatus{
+				AppliedDeploymentID: "v1",
+				Ready:               true,
+				Conditions:          conditions,
+			},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		deployment  *fleet.BundleDeployment
+		unavailable bool
+	}{
+		{
+			name: "conditions true",
+			deployment: newDeployment(
+				genericcondition.GenericCondition{Type: "Smoke", Status: corev1.ConditionTrue},
+				genericcondition.GenericCondition{Type: "Migrated", Status: corev1.ConditionTrue},
+			),
+		},
+		{
+			name: "condition false",
+			deployment: newDeployment(
+				genericcondition.GenericCondition{Type: "Smoke", Status: corev1.ConditionTrue},
+				genericcondition.GenericCondition{Type: "Migrated", Status: corev1.ConditionFalse},
+			),
+			unavailable: true,
+		},
+		{
+			name: "condition absent",
+			deployment: newDeployment(
+				genericcondition.GenericCondition{Type: "Smoke", Status: corev1.ConditionTrue},
+			),
+			unavailable: true,
+		},
+		{
+			name: "no required conditions",
+			deployment: func() *fleet.BundleDeployment {
+				bd := newDeployment()
+				bd.Spec.Options.RequiredConditions = nil
+				return bd
+			}(),
+		},
+	}
+
+	for _, test := range tests {
+		if unavailable := IsUnavailable(test.deployment); unavailable != test.unavailable {
+			t.Errorf("%s: expected unavailable %v, got %v", test.name, test.unavailable, unavailable)
+		}
+	}
+}
+
+func TestRequiredConditionsOfTarget(t *testing.T) {
+	m := newTestManager(newCluster("prod-1", map[string]string{"env": "prod"}))
+
+	bundle := prodBundle(true)
+	bundle.Spec.Resources = []fleet.BundleResource{{Name: "manifests/configmap.yaml", Content: "kind: ConfigMap\n"}}
+	bundle.Spec.RequiredConditions = []string{"Smoke"}
+	bundle.Spec.Targets[0].RequiredConditions = []string{"Migrated"}
+
+	targets, err := m.Targets(context.Background(), bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 {
+		t.Fatalf("expected 1 target, got %v", targetNames(targets))
+	}
+	if conditions := targets[0].Options.RequiredConditions; !equalNames(conditions, []string{"Migrated"}) {
+		t.Errorf("expected the required conditions of the target to override the bundle, got %v", conditions)
+	}
+}
+
+// slowStore takes latency to store content and records the most stores in flight at once, calling
+// onStore after each store
+type slowStore struct {
+	lock        sync.Mutex
+	latency     time.Duration
+	inFlight    int
+	maxInFlight int
+	stored      int
+	onStore     func()
+}
+
+func (s *slowStore) Store(m *manifest.Manifest) (string, error) {
+	s.lock.Lock()
+	s.inFlight++
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	s.lock.Unlock()
+
+	time.Sleep(s.latency)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.inFlight--
+	s.stored++
+	if s.onStore != nil {
+		s.onStore()
+	}
+	_, id, err := m.Content()
+	return id, err
+}
+
+// regionClusters returns count clusters labeled env=prod, each in its own region
+func regionClusters(count int) (clusters []*fleet.Cluster) {
+	for i := 0; i < count; i++ {
+		clusters = append(clusters, newCluster(fmt.Sprintf("prod-%d", i), map[string]string{
+			"env":    "prod",
+			"region": fmt.Sprintf("region-%d", i),
+		}))
+	}
+	return clusters
+}
+
+// regionBundle returns a bundle with a distinct deployment for every region
+func regionBundle() *fleet.Bundle {
+	bundle := prodBundle(true)
+	bundle.Status = fleet.BundleStatus{}
+	bundle.Spec.Resources = []fleet.BundleResource{{Name: "manifests/configmap.yaml", Content: "kind: ConfigMap\n"}}
+	bundle.Spec.Targets[0].ClusterLabels = []string{"region"}
+	bundle.Spec.Targets[0].ClusterLabelValues = map[string]string{"global.region": "region"}
+	return bundle
+}
+
+func TestMaxConcurrentContentStores(t *testing.T) {
+	for _, limit := range []int{0, 1, 3} {
+		m := newTestManager(regionClusters(20)...)
+		if err := config.Set(&config.Config{MaxConcurrentContentStores: limit}); err != nil {
+			t.Fatal(err)
+		}
+		store := &slowStore{latency: 5 * time.Millisecond}
+		m.contentStore = store
+
+		targets, err := m.Targets(context.Background(), regionBundle())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(targets) != 20 || store.stored != 20 {
+			t.Fatalf("limit %d: expected 20 targets and stores, got %d and %d", limit, len(targets), store.stored)
+		}
+
+		expected := limit
+		if expected == 0 {
+			expected = defMaxConcurrentContentStores
+		}
+		if store.maxInFlight > expected {
+			t.Errorf("limit %d: expected at most %d stores at once, got %d", limit, expected, store.maxInFlight)
+		}
+		if expected > 1 && store.maxInFlight < 2 {
+			t.Errorf("limit %d: expected stores to run concurrently, got %d at once", limit, store.maxInFlight)
+		}
+	}
+}
+
+func TestStoreAllCancelled(t *testing.T) {
+	m := newTestManager(regionClusters(10)...)
+	if err := config.Set(&config.Config{MaxConcurrentContentStores: 1}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := &slowStore{onStore: cancel}
+	m.contentStore = store
+
+	if _, err := m.Targets(ctx, regionBundle()); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancelling the context to fail, got %v", err)
+	}
+	if store.stored != 1 {
+		t.Errorf("expected no stores to start once the context is cancelled, got %d stored", store.stored)
+	}
+}
+
+func TestStoreAllError(t *testing.T) {
+	defer func(backoff wait.Backoff) { storeBackoff = backoff }(storeBackoff)
+	storeBackoff.Duration = time.Millisecond
+
+	m := newTestManager(regionClusters(10)...)
+	m.contentStore = &flakyStore{failures: 100}
+
+	if _, err := m.Targets(context.Background(), regionBundle()); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("expected the error of a failed store, got %v", err)
+	}
+}
+
+// benchmarkTargetsStore calculates the targets of a bundle with a distinct deployment for each of 2,000
+// clusters, storing the content with at most limit stores at a time
+func benchmarkTargetsStore(b *testing.B, limit int) {
+	m := newTestManager(regionClusters(2000)...)
+	if err := config.Set(&config.Config{MaxConcurrentContentStores: limit}); err != nil {
+		b.Fatal(err)
+	}
+	m.contentStore = &slowStore{latency: 100 * time.Microsecond}
+	bundle := regionBundle()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := m.Targets(context.Background(), bundle); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTargetsStoreSequential(b *testing.B) {
+	benchmarkTargetsStore(b, 1)
+}
+
+func BenchmarkTargetsStoreConcurrent(b *testing.B) {
+	benchmarkTargetsStore(b, 0)
+}
+
+func TestLimitWithBounds(t *testing.T) {
+	percent := intstr.FromString("10%")
+	fixed := intstr.FromInt(5)
+	zeroPercent := intstr.FromString("0%")
+
+	tests := []struct {
+		name     string
+		count    int
+		bounds   Bounds
+		val      *intstr.IntOrString
+		expected int
+	}{
+		{name: "no bounds", count: 100, val: &percent, expected: 10},
+		{name: "raised to min", count: 10, bounds: Bounds{Min: 2}, val: &percent, expected: 2},
+		{name: "lowered to max", count: 1000, bounds: Bounds{Min: 2, Max: 20}, val: &percent, expected: 20},
+		{name: "within bounds", count: 100, bounds: Bounds{Min: 2, Max: 20}, val: &percent, expected: 10},
+		{name: "fixed count clamped", count: 100, bounds: Bounds{Max: 3}, val: &fixed, expected: 3},
+		{name: "minimum of one", count: 5, val: &percent, expected: 1},
+		{name: "minimum of one for zero percent", count: 100, val: &zeroPercent, expected: 1},
+		{name: "min above minimum of one", count: 5, bounds: Bounds{Min: 3}, val: &percent, expected: 3},
+		{name: "max wins over min", count: 100, bounds: Bounds{Min: 30, Max: 20}, val: &percent, expected: 20},
+		{name: "default limit", count: 10, bounds: Bounds{Min: 4}, expected: 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limit, err := LimitWithBounds(test.count, test.bounds, nil, test.val)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if limit != test.expected {
+				t.Errorf("expected limit %d, got %d", test.expected, limit)
+			}
+		})
+	}
+
+	invalid := intstr.FromString("ten")
+	if _, err := LimitWithBounds(10, Bounds{Min: 2}, &invalid); err == nil {
+		t.Error("expected an error for an invalid limit")
+	}
+}
+
+func TestTargetsStream(t *testing.T) {
+	clusters := []*fleet.Cluster{
+		newCluster("prod-2", map[string]string{"env": "prod", "region": "us"}),
+		newCluster("dev-1", map[string]string{"env": "dev"}),
+		newCluster("prod-1", map[string]string{"env": "prod", "region": "eu"}),
+	}
+	bundle := regionBundle()
+	existing := &fleet.BundleDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      bundle.Name,
+			Namespace: clusters[2].Status.Namespace,
+			Labels:    DeploymentLabels(bundle),
+		},
+	}
+
+	m := newTestManager(clusters...)
+	m.bundleDeploymentCache = &fakeBundleDeploymentCache{deployments: []*fleet.BundleDeployment{existing}}
+	expected, err := m.Targets(context.Background(), bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &fakeStore{}
+	m.contentStore = store
+	var streamed []*Target
+	if err := m.TargetsStream(context.Background(), bundle, func(target *Target) error {
+		streamed = append(streamed, target)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !equalNames(targetNames(streamed), targetNames(expected)) {
+		t.Fatalf("expected the targets %v in the same order, got %v", targetNames(expected), targetNames(streamed))
+	}
+	for i, target := range streamed {
+		if target.DeploymentID != expected[i].DeploymentID {
+			t.Errorf("%s: expected deployment ID %s, got %s", target.Cluster.Name, expected[i].DeploymentID, target.DeploymentID)
+		}
+		if (target.Deployment == nil) != (expected[i].Deployment == nil) {
+			t.Errorf("%s: expected deployment %v, got %v", target.Cluster.Name, expected[i].Deployment, target.Deployment)
+		}
+	}
+	if streamed[0].Deployment == nil || streamed[0].Deployment.Namespace != existing.Namespace {
+		t.Errorf("expected the existing deployment to be folded into the target of prod-1, got %v", streamed[0].Deployment)
+	}
+	if store.stored != 2 {
+		t.Errorf("expected the content of each deployment to be stored once, got %d", store.stored)
+	}
+
+	// an error returned by the callback stops the stream
+	stop := errors.New("stop")
+	calls := 0
+	err = m.TargetsStream(context.Background(), bundle, func(*Target) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("expected the stream to stop after the first error, got %v after %d calls", err, calls)
+	}
+}
+
+func benchmarkTargets(b *testing.B, stream bool) {
+	var clusters []*fleet.Cluster
+	for i := 0; i < 10000; i++ {
+		clusters = append(clusters, newCluster(fmt.Sprintf("prod-%05d", i), map[string]string{"env": "prod"}))
+	}
+	m := newTestManager(clusters...)
+	bundle := prodBundle(true)
+	bundle.Status = fleet.BundleStatus{}
+	bundle.Spec.Resources = []fleet.BundleResource{{Name: "manifests/configmap.yaml", Content: "kind: ConfigMap\n"}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		count := 0
+		if stream {
+			if err := m.TargetsStream(context.Background(), bundle, func(*Target) error {
+				count++
+				return nil
+			}); err != nil {
+				b.Fatal(err)
+			}
+		} else {
+			targets, err := m.Targets(context.Background(), bundle)
+			if err != nil {
+				b.Fatal(err)
+			}
+			count = len(targets)
+		}
+		if count != len(clusters) {
+			b.Fatalf("expected %d targets, got %d", len(clusters), count)
+		}
+	}
+}
+
+func BenchmarkTargetsSlice(b *testing.B) {
+	benchmarkTargets(b, false)
+}
+
+func BenchmarkTargetsStream(b *testing.B) {
+	benchmarkTargets(b, true)
+}
+
+func TestTargetState(t *testing.T) {
+	deployment := func(staged, released, applied string, ready bool, conditions ...genericcondition.GenericCondition) *fleet.BundleDeployment {
+		return &fleet.BundleDeployment{
+			Spec: fleet.BundleDeploymentSpec{
+				StagedDeploymentID: staged,
+				DeploymentID:       released,
+			},
+			Status: fleet.BundleDeploymentStatus{
+				AppliedDeploymentID: applied,
+				Ready:               ready,
+				NonModified:         true,
+				Conditions:          conditions,
+			},
+		}
+	}
+	deployFailed := genericcondition.GenericCondition{
+		Type:   string(fleet.BundleDeploymentConditionDeployed),
+		Status: corev1.ConditionFalse,
+	}
+
+	tests := []struct {
+		name       string
+		deployment *fleet.BundleDeployment
+		expected   fleet.BundleState
+	}{
+		{name: "no deployment", expected: fleet.Pending},
+		{name: "staged but not released", deployment: deployment("v2", "v1", "v1", true), expected: fleet.OutOfSync},
+		{name: "released but not applied", deployment: deployment("v2", "v2", "v1", true), expected: fleet.Updating},
+		{name: "released and failed to apply", deployment: deployment("v2", "v2", "v1", true, deployFailed), expected: fleet.ErrApplied},
+		{name: "older deployment released", deployment: deployment("v1", "v1", "v0", true), expected: fleet.NotApplied},
+		{name: "applied but not ready", deployment: deployment("v2", "v2", "v2", false), expected: fleet.NotReady},
+		{name: "applied and ready", deployment: deployment("v2", "v2", "v2", true), expected: fleet.Ready},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := &Target{
+				Cluster:      newCluster("prod-1", nil),
+				Deployment:   test.deployment,
+				DeploymentID: "v2",
+			}
+			if state := target.State(); state != test.expected {
+				t.Errorf("expected state %s, got %s", test.expected, state)
+			}
+		})
+	}
+}
+
+func TestSetPartitionCompleted(t *testing.T) {
+	start := time.Date(2020, 10, 3, 12, 0, 0, 0, time.UTC)
+	// each step is a minute after the previous one, completed is the minute the partition is expected to
+	// have last completed, or -1 if it hasn't
+	steps := []struct {
+		unavailable int
+		completed   int
+	}{
+		{unavailable: 2, completed: -1},
+		{unavailable: 0, completed: 1},
+		// staying available doesn't advance the timestamp
+		{unavailable: 0, completed: 1},
+		{unavailable: 1, completed: 1},
+		{unavailable: 0, completed: 4},
+	}
+
+	var previous *fleet.PartitionStatus
+	for i, step := range steps {
+		now := start.Add(time.Duration(i) * time.Minute)
+		status := &fleet.PartitionStatus{Name: "canary", Count: 3, Unavailable: step.unavailable}
+		SetPartitionCompleted(status, previous, now)
+
+		if step.completed < 0 {
+			if status.LastCompleted != nil {
+				t.Errorf("step %d: expected no completion time, got %v", i, status.LastCompleted)
+			}
+		} else if expected := start.Add(time.Duration(step.completed) * time.Minute); status.LastCompleted == nil || !status.LastCompleted.Time.Equal(expected) {
+			t.Errorf("step %d: expected completion time %v, got %v", i, expected, status.LastCompleted)
+		}
+		previous = status
+	}
+
+	// a partition without clusters is never completed
+	empty := &fleet.PartitionStatus{Name: "empty"}
+	SetPartitionCompleted(empty, nil, start)
+	if empty.LastCompleted != nil {
+		t.Errorf("expected an empty partition not to be completed, got %v", empty.LastCompleted)
+	}
+}
+
+func TestSetPartitionSoaking(t *testing.T) {
+	completed := time.Date(2020, 10, 3, 12, 0, 0, 0, time.UTC)
+	targets := rolloutTargets(&fleet.RolloutStrategy{PartitionSoakSeconds: 600}, newCluster("canary", nil))
+
+	tests := []struct {
+		name         string
+		status       fleet.PartitionStatus
+		targets      []*Target
+		now          time.Time
+		soakingUntil time.Time
+	}{
+		{
+			name:         "soaking",
+			status:       fleet.PartitionStatus{LastCompleted: &metav1.Time{Time: completed}},
+			targets:      targets,
+			now:          completed.Add(5 * time.Minute),
+			soakingUntil: completed.Add(10 * time.Minute),
+		},
+		{
+			name:    "soaked",
+			status:  fleet.PartitionStatus{LastCompleted: &metav1.Time{Time: completed}},
+			targets: targets,
+			now:     completed.Add(10 * time.Minute),
+		},
+		{
+			name:    "unavailable",
+			status:  fleet.PartitionStatus{Unavailable: 1, LastCompleted: &metav1.Time{Time: completed}},
+			targets: targets,
+			now:     completed,
+		},
+		{
+			name:    "not completed",
+			targets: targets,
+			now:     completed,
+		},
+		{
+			name:    "no soak time",
+			status:  fleet.PartitionStatus{LastCompleted: &metav1.Time{Time: completed}},
+			targets: rolloutTargets(&fleet.RolloutStrategy{}, newCluster("canary", nil)),
+			now:     completed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// a soak time left from an earlier update is cleared
+			test.status.SoakingUntil = &metav1.Time{Time: completed}
+
+			soaking := SetPartitionSoaking(&test.status, test.targets, test.now)
+			if soaking != !test.soakingUntil.IsZero() {
+				t.Errorf("expected soaking %v, got %v", !test.soakingUntil.IsZero(), soaking)
+			}
+			if test.soakingUntil.IsZero() {
+				if test.status.SoakingUntil != nil {
+					t.Errorf("expected no soak time, got %v", test.status.SoakingUntil)
+				}
+			} else if test.status.SoakingUntil == nil || !test.status.SoakingUntil.Time.Equal(test.soakingUntil) {
+				t.Errorf("expected to soak until %v, got %v", test.soakingUntil, test.status.SoakingUntil)
+			}
+		})
+	}
+}
+
+func TestSummaryResourceCounts(t *testing.T) {
+	deployed := func(name string, modified, notReady int) *Target {
+		status := fleet.BundleDeploymentStatus{AppliedDeploymentID: "v1"}
+		for i := 0; i < modified; i++ {
+			status.ModifiedStatus = append(status.ModifiedStatus, fleet.ModifiedStatus{Kind: "ConfigMap", Name: fmt.Sprintf("cm-%d", i)})
+		}
+		for i := 0; i < notReady; i++ {
+			status.NonReadyStatus = append(status.NonReadyStatus, fleet.NonReadyStatus{Kind: "Deployment", Name: fmt.Sprintf("app-%d", i)})
+		}
+		status.Ready = notReady == 0
+		status.NonModified = modified == 0
+		return &Target{
+			Bundle:       &fleet.Bundle{},
+			Cluster:      newCluster(name, nil),
+			DeploymentID: "v1",
+			Deployment: &fleet.BundleDeployment{
+				Spec:   fleet.BundleDeploymentSpec{DeploymentID: "v1", StagedDeploymentID: "v1"},
+				Status: status,
+			},
+		}
+	}
+
+	bundleSummary := Summary([]*Target{
+		deployed("ready", 0, 0),
+		deployed("partially-ready", 1, 2),
+		deployed("modified", 3, 0),
+		{Bundle: &fleet.Bundle{}, Cluster: newCluster("pending", nil)},
+	})
+
+	if bundleSummary.ModifiedResources != 4 || bundleSummary.NotReadyResources != 2 {
+		t.Errorf("expected 4 modified and 2 not ready resources, got %d and %d",
+			bundleSummary.ModifiedResources, bundleSummary.NotReadyResources)
+	}
+	if bundleSummary.Ready != 1 || bundleSummary.NotReady != 1 || bundleSummary.Modified != 1 || bundleSummary.Pending != 1 {
+		t.Errorf("expected one target in each state, got %+v", bundleSummary)
+	}
+}
+
+// bundleNames returns the names of the bundles
+func bundleNames(bundles []*fleet.Bundle) (result []string) {
+	for _, bundle := range bundles {
+		result = append(result, bundle.Name)
+	}
+	return result
+}
+
+func TestBundlesAffectedByLabels(t *testing.T) {
+	target := func(name string, target fleet.BundleTarget) *fleet.Bundle {
+		target.Name = name
+		return &fleet.Bundle{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "fleet-default"},
+			Spec:       fleet.BundleSpec{Targets: []fleet.BundleTarget{target}},
+		}
+	}
+
+	m := newTestManager()
+	m.bundleCache = &fakeBundleCache{
+		bundles: []*fleet.Bundle{
+			target("prod", fleet.BundleTarget{ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}}),
+			target("dev", fleet.BundleTarget{ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "dev"}}}),
+			target("eu", fleet.BundleTarget{ClusterGroup: "eu"}),
+			target("all", fleet.BundleTarget{ClusterSelector: &metav1.LabelSelector{}}),
+		},
+	}
+	m.clusterGroups = &fakeClusterGroupCache{
+		groups: []*fleet.ClusterGroup{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "eu", Namespace: "fleet-default"},
+				Spec: fleet.ClusterGroupSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"region": "eu"}},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		labels    map[string]string
+		newLabels map[string]string
+		gained    []string
+		lost      []string
+	}{
+		{
+			name:      "moved into bundles",
+			labels:    map[string]string{"env": "dev"},
+			newLabels: map[string]string{"env": "prod", "region": "eu"},
+			gained:    []string{"eu", "prod"},
+			lost:      []string{"dev"},
+		},
+		{
+			name:      "moved out of bundles",
+			labels:    map[string]string{"env": "prod", "region": "eu"},
+			newLabels: nil,
+			lost:      []string{"eu", "prod"},
+		},
+		{
+			name:      "unchanged",
+			labels:    map[string]string{"env": "prod"},
+			newLabels: map[string]string{"env": "prod", "team": "a"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cluster := newCluster("cluster", test.labels)
+			gained, lost, err := m.BundlesAffectedByLabels(cluster, test.newLabels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names := bundleNames(gained); !reflect.DeepEqual(names, test.gained) {
+				t.Errorf("expected gained bundles %v, got %v", test.gained, names)
+			}
+			if names := bundleNames(lost); !reflect.DeepEqual(names, test.lost) {
+				t.Errorf("expected lost bundles %v, got %v", test.lost, names)
+			}
+			if !reflect.DeepEqual(cluster.Labels, test.labels) {
+				t.Errorf("expected the labels of the cluster to be unchanged, got %v", cluster.Labels)
+			}
+		})
+	}
+}
+
+func TestDeletionsChangeDeploymentID(t *testing.T) {
+	m := newTestManager(newCluster("prod-1", map[string]string{"env": "prod"}))
+
+	deploymentID := func(deletions []fleet.ResourceDeletion) string {
+		bundle := prodBundle(true)
+		bundle.Spec.Resources = []fleet.BundleResource{{Name: "manifests/configmap.yaml", Content: "kind: ConfigMap\n"}}
+		bundle.Spec.Deletions = deletions
+
+		targets, err := m.Targets(context.Background(), bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(targets) != 1 {
+			t.Fatalf("expected a target, got %v", targetNames(targets))
+		}
+		if !reflect.DeepEqual(targets[0].Options.Deletions, deletions) {
+			t.Errorf("expected deletions %v in the options of the target, got %v", deletions, targets[0].Options.Deletions)
+		}
+		return targets[0].DeploymentID
+	}
+
+	deletions := []fleet.ResourceDeletion{{APIVersion: "v1", Kind: "ConfigMap", Name: "old"}}
+	if without, with := deploymentID(nil), deploymentID(deletions); without == with {
+		t.Errorf("expected deletions to change the deployment ID, got %s", with)
+	}
+	if first, second := deploymentID(deletions), deploymentID(deletions); first != second {
+		t.Errorf("expected the same deletions to keep the deployment ID, got %s and %s", first, second)
+	}
+}
+
+func TestSummaryWaitingToSchedule(t *testing.T) {
+	notStarted := &Target{Bundle: &fleet.Bundle{}, Cluster: newCluster("not-started", nil), DeploymentID: "v1"}
+	started := &Target{
+		Bundle:       &fleet.Bundle{},
+		Cluster:      newCluster("started", nil),
+		DeploymentID: "v1",
+		Deployment: &fleet.BundleDeployment{
+			Spec: fleet.BundleDeploymentSpec{DeploymentID: "v1", StagedDeploymentID: "v1"},
+		},
+	}
+	failing := &Target{
+		Bundle:       &fleet.Bundle{},
+		Cluster:      newCluster("failing", nil),
+		DeploymentID: "v1",
+		Deployment: &fleet.BundleDeployment{
+			Spec: fleet.BundleDeploymentSpec{DeploymentID: "v1", StagedDeploymentID: "v1"},
+			Status: fleet.BundleDeploymentStatus{
+				AppliedDeploymentID: "v1",
+				NonModified:         true,
+				NonReadyStatus:      []fleet.NonReadyStatus{{Kind: "Deployment", Name: "app"}},
+			},
+		},
+	}
+	renderError := &Target{Bundle: &fleet.Bundle{}, Cluster: newCluster("render-error", nil), RenderError: errors.New("broken")}
+
+	tests := []struct {
+		name     string
+		targets  []*Target
+		waiting  int
+		pending  int
+		updating int
+		notReady int
+	}{
+		{name: "not started", targets: []*Target{notStarted}, waiting: 1, pending: 1},
+		{name: "started", targets: []*Target{started}, updating: 1},
+		{name: "started but failing", targets: []*Target{failing}, notReady: 1},
+		{name: "failed to render", targets: []*Target{renderError}},
+		{name: "all", targets: []*Target{notStarted, started, failing, renderError}, waiting: 1, pending: 1, updating: 1, notReady: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			summary := Summary(test.targets)
+			if summary.WaitingToSchedule != test.waiting {
+				t.Errorf("expected %d waiting to schedule, got %+v", test.waiting, summary)
+			}
+			if summary.Pending != test.pending {
+				t.Errorf("expected %d pending, got %+v", test.pending, summary)
+			}
+			if summary.Updating != test.updating {
+				t.Errorf("expected %d updating, got %+v", test.updating, summary)
+			}
+			if summary.NotReady != test.notReady {
+				t.Errorf("expected %d not ready, got %+v", test.notReady, summary)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package target
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+)
+
+// InMaintenanceWindow returns true if the target may be updated at the given time. Both the windows
+// of the rollout strategy and the maintenance window label of the cluster must allow it.
+func (t *Target) InMaintenanceWindow(now time.Time) (bool, error) {
+	now = now.UTC()
+
+	if rollout := t.Bundle.Spec.RolloutStrategy; rollout != nil && len(rollout.Windows) > 0 {
+		open := false
+		for _, window := range rollout.Windows {
+			ok, err := inWindow(window, now)
+			if err != nil {
+				return false, err
+			}
+			open = open || ok
+		}
+		if !open {
+			return false, nil
+		}
+	}
+
+	if label := t.Cluster.Labels[fleet.MaintenanceWindowLabel]; label != "" {
+		parts := strings.Split(label, "-")
+		if len(parts) != 2 || len(parts[0]) != 4 || len(parts[1]) != 4 {
+			return false, fmt.Errorf("invalid %s label on cluster %s/%s, must be HHMM-HHMM: %s",
+				fleet.MaintenanceWindowLabel, t.Cluster.Namespace, t.Cluster.Name, label)
+		}
+		return inWindow(fleet.MaintenanceWindow{
+			Start: parts[0][:2] + ":" + parts[0][2:],
+			End:   parts[1][:2] + ":" + parts[1][2:],
+		}, now)
+	}
+
+	return true, nil
+}
+
+func inWindow(window fleet.MaintenanceWindow, now time.Time) (bool, error) {
+	start, err := time.Parse("15:04", window.Start)
+	if err != nil {
+		return false, fmt.Errorf("invalid maintenance window start %s: %w", window.Start, err)
+	}
+	end, err := time.Parse("15:04", window.End)
+	if err != nil {
+		return false, fmt.Errorf("invalid maintenance window end %s: %w", window.End, err)
+	}
+
+	var (
+		startOffset = time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute
+		endOffset   = time.Duration(end.Hour())*time.Hour + time.Duration(end.Minute())*time.Minute
+		midnight    = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	)
+
+	// check the window opening today and, for windows that cross midnight, the one opening yesterday
+	for _, day := range []time.Time{midnight, midnight.AddDate(0, 0, -1)} {
+		if !matchesDay(window.Days, day.Weekday()) {
+			continue
+		}
+		opens := day.Add(startOffset)
+		closes := day.Add(endOffset)
+		if endOffset <= startOffset {
+			closes = closes.AddDate(0, 0, 1)
+		}
+		if !now.Before(opens) && now.Before(closes) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func matchesDay(days []string, weekday time.Weekday) bool {
+	if len(days) == 0 {
+		return true
+	}
+	for _, day := range days {
+		if strings.EqualFold(day, weekday.String()) || strings.EqualFold(day, weekday.String()[:3]) {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitingForMaintenanceWindow returns true if any target is out of date but may not be updated until a
+// maintenance window opens
+func WaitingForMaintenanceWindow(targets []*Target, now time.Time) bool {
+	for _, target := range targets {
+		if UpToDate(target) {
+			continue
+		}
+		if ok, err := target.InMaintenanceWindow(now); err == nil && !ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package target
+
+import (
+	"testing"
+	"time"
+
+	fleet "github.com/rancher/fleet/pkg/apis/fleet.cattle.io/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func windowTarget(windows []fleet.MaintenanceWindow, label string) *Target {
+	t := &Target{
+		Bundle: &fleet.Bundle{
+			Spec: fleet.BundleSpec{
+				RolloutStrategy: &fleet.RolloutStrategy{
+					Windows: windows,
+				},
+			},
+		},
+		Cluster: &fleet.Cluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cluster",
+				Namespace: "fleet-default",
+			},
+		},
+	}
+	if label != "" {
+		t.Cluster.Labels = map[string]string{fleet.MaintenanceWindowLabel: label}
+	}
+	return t
+}
+
+func TestInMaintenanceWindow(t *testing.T) {
+	// a Saturday
+	at := func(hour, minute int) time.Time {
+		return time.Date(2020, 10, 3, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name    string
+		windows []fleet.MaintenanceWindow
+		label   string
+		now     time.Time
+		open    bool
+		err     bool
+	}{
+		{
+			name: "no windows",
+			now:  at(12, 0),
+			open: true,
+		},
+		{
+			name:    "inside window",
+			windows: []fleet.MaintenanceWindow{{Start: "02:00", End: "04:00"}},
+			now:     at(3, 0),
+			open:    true,
+		},
+		{
+			name:    "outside window",
+			windows: []fleet.MaintenanceWindow{{Start: "02:00", End: "04:00"}},
+			now:     at(4, 0),
+		},
+		{
+			name:    "window crossing midnight",
+			windows: []fleet.MaintenanceWindow{{Start: "22:00", End: "02:00"}},
+			now:     at(1, 30),
+			open:    true,
+		},
+		{
+			name:    "window on another day",
+			windows: []fleet.MaintenanceWindow{{Start: "02:00", End: "04:00", Days: []string{"Sun"}}},
+			now:     at(3, 0),
+		},
+		{
+			name:    "window on the day",
+			windows: []fleet.MaintenanceWindow{{Start: "02:00", End: "04:00", Days: []string{"saturday"}}},
+			now:     at(3, 0),
+			open:    true,
+		},
+		{
+			name:  "inside cluster label window",
+			label: "0100-0500",
+			now:   at(3, 0),
+			open:  true,
+		},
+		{
+			name:    "outside cluster label window",
+			windows: []fleet.MaintenanceWindow{{Start: "02:00", End: "04:00"}},
+			label:   "0100-0230",
+			now:     at(3, 0),
+		},
+		{
+			name:  "invalid cluster label",
+			label: "01:00-05:00",
+			now:   at(3, 0),
+			err:   true,
+		},
+		{
+			name:    "invalid window",
+			windows: []fleet.MaintenanceWindow{{Start: "2am", End: "04:00"}},
+			now:     at(3, 0),
+			err:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			open, err := windowTarget(test.windows, test.label).InMaintenanceWindow(test.now)
+			if (err != nil) != test.err {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if open != test.open {
+				t.Errorf("expected open %v, got %v", test.open, open)
+			}
+		})
+	}
+}